@@ -9,7 +9,12 @@ angular
     'angular-loading-bar',
     'ngAnimate'
   ])
-  .config(function ($routeProvider) {
+  .config(function ($routeProvider, $httpProvider) {
+    // The API speaks JSON API (http://jsonapi.org)
+    $httpProvider.defaults.headers.common.Accept = 'application/vnd.api+json';
+    $httpProvider.defaults.headers.post['Content-Type'] = 'application/vnd.api+json';
+    $httpProvider.defaults.headers.put['Content-Type'] = 'application/vnd.api+json';
+    $httpProvider.defaults.headers.patch['Content-Type'] = 'application/vnd.api+json';
     $routeProvider
       .when('/', {
         templateUrl: 'static/views/main.html',
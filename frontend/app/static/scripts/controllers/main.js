@@ -18,30 +18,92 @@ angular.module('postyApp')
       $scope.errorPostMsg = message;
       $('#errorPostMsg').fadeIn(600).delay(3000).fadeOut(600);
     };
+    // resolveAuthors replaces the author and attachment references of each post with the resources embedded in included
+    $scope.resolveAuthors = function(posts, included) {
+        var resources = {};
+        angular.forEach(included || [], function(res) {
+            resources[res.type + '/' + res.id] = res;
+        });
+        angular.forEach(posts, function(post) {
+            var id = post.relationships.author.data.id;
+            post.author = resources['users/' + id] || {'id': id, 'attributes': {'username': 'unknown'}};
+            post.attachments = [];
+            angular.forEach(post.relationships.attachments.data, function(ref) {
+                if (resources['attachments/' + ref.id]) {
+                    post.attachments.push(resources['attachments/' + ref.id]);
+                }
+            });
+        });
+        return posts;
+    };
+    $scope.attachments = [];
+    $scope.uploadAttachment = function(file) {
+      var form = new FormData();
+      form.append('file', file);
+      $http.post('/api/attachments', form, {
+        'transformRequest': angular.identity,
+        'headers': {'Content-Type': undefined},
+      }).success(function(data) {
+        $scope.attachments.push(data.data);
+      }).error(function(data,status) {
+        var title = 'Could not upload your file :(';
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].detail || data.errors[0].title;
+        }
+        $scope.showPostErrorMsg(title);
+      });
+    };
     $scope.loadPosts = function() {
-        $http.get('/api/posts').success(function(data) {
-            $scope.posts = data['data']
+        var params = {};
+        if ($scope.searchText) {
+            params.q = $scope.searchText;
+        }
+        $http.get('/api/posts', {'params': params}).success(function(data) {
+            $scope.posts = $scope.resolveAuthors(data['data'], data['included']);
             $scope.showListMsg('Posts loaded!');
         }).error(function(data,status,headers,config) {
             console.log("Status", status);
             $scope.showListErrorMsg("Could not fetch posts :( but i'm not giving up");
         });
     };
+    $scope.unixTime = function(value) {
+      var ts = value ? new Date(value).getTime() : NaN;
+      return isNaN(ts) ? 0 : Math.floor(ts / 1000);
+    };
+    $scope.scheduled = function(post) {
+      return post.attributes.publish_at && post.attributes.publish_at * 1000 > Date.now();
+    };
     $scope.createPost= function(msg) {
       var postdata = {
         'data': {
-          'message': msg,
+          'type': 'posts',
+          'attributes': {
+            'message': msg,
+            'format': $scope.markdown ? 'markdown' : 'plain',
+            'publish_at': $scope.unixTime($scope.publishAt),
+            'expires_at': $scope.unixTime($scope.expiresAt),
+          },
+          'relationships': {
+            'attachments': {
+              'data': $scope.attachments.map(function(a) {
+                return {'type': 'attachments', 'id': a.id};
+              }),
+            },
+          },
         },
       };
       $http.post('/api/posts',postdata).success(function(data) {
         $scope.msg = "";
-        $scope.posts.unshift(data.data);
+        $scope.attachments = [];
+        $scope.publishAt = null;
+        $scope.expiresAt = null;
+        $scope.posts.unshift($scope.resolveAuthors([data.data], data.included)[0]);
         $timeout($scope.loadPosts, 5000);
         $scope.showPostMsg();
       }).error(function(data,status) {
         var title = 'Could not send your message :(';
-        if (status === 400 && data.errors) {
-          title = data.errors[0].title;
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].detail || data.errors[0].title;
         }
         $scope.showPostErrorMsg(title);
       });
@@ -51,6 +113,7 @@ angular.module('postyApp')
       $http.delete('/api/posts/'+post.id).success(function(data) {
         var index = $scope.posts.indexOf(post);
         $scope.posts.splice(index, 1);
+        $scope.removed = post;
         $scope.showListMsg('Message removed!');
         $scope.loadPosts();
       }).error(function(data,status) {
@@ -62,5 +125,78 @@ angular.module('postyApp')
       });
 
     };
+    $scope.restorePost = function(post) {
+      $http.post('/api/posts/'+post.id+'/restore').success(function() {
+        $scope.removed = null;
+        $scope.showListMsg('Message restored!');
+        $scope.loadPosts();
+      }).error(function(data,status) {
+        var title = 'Could not restore this message :(';
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].title;
+        }
+        $scope.showListErrorMsg(title);
+      });
+    };
+    $scope.togglePin = function(post) {
+      var req = post.attributes.pinned ? $http.delete('/api/posts/'+post.id+'/pin') : $http.put('/api/posts/'+post.id+'/pin');
+      req.success(function() {
+        $scope.loadPosts();
+      }).error(function(data,status) {
+        var title = 'Could not pin this message :(';
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].detail || data.errors[0].title;
+        }
+        $scope.showListErrorMsg(title);
+      });
+    };
+    $scope.vote = function(post, option) {
+      var vote = {'data': {'type': 'votes', 'attributes': {'option': option}}};
+      $http.post('/api/posts/'+post.id+'/votes', vote).success(function(data) {
+        post.attributes.poll = data.data.attributes.poll;
+      }).error(function(data,status) {
+        var title = 'Could not vote :(';
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].detail || data.errors[0].title;
+        }
+        $scope.showListErrorMsg(title);
+      });
+    };
+    $scope.reportPost = function(post) {
+      var reason = window.prompt('Why should this message be removed?');
+      if (!reason) {
+        return;
+      }
+      var report = {'data': {'type': 'reports', 'attributes': {'reason': reason}}};
+      $http.post('/api/posts/'+post.id+'/reports', report).success(function() {
+        $scope.showListMsg('Thanks, a moderator will have a look.');
+      }).error(function(data,status) {
+        var title = 'Could not report this message :(';
+        if (status >= 400 && status < 500 && data.errors) {
+          title = data.errors[0].detail || data.errors[0].title;
+        }
+        $scope.showListErrorMsg(title);
+      });
+    };
+    $scope.loadNotifications = function() {
+      $http.get('/api/notifications').success(function(data) {
+        $scope.unreadNotifications = data.meta.unread;
+      });
+    };
+    $scope.readNotifications = function() {
+      $http.post('/api/notifications/read').success(function() {
+        $scope.unreadNotifications = 0;
+      });
+    };
+    // search on the server as the user types
+    var searchTimeout;
+    $scope.$watch('searchText', function(newValue, oldValue) {
+        if (newValue === oldValue) {
+            return;
+        }
+        $timeout.cancel(searchTimeout);
+        searchTimeout = $timeout($scope.loadPosts, 300);
+    });
     $scope.loadPosts();
+    $scope.loadNotifications();
   });
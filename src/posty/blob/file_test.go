@@ -0,0 +1,59 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidKey(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(ValidKey("attachments/abc-123/data"))
+	assert.True(ValidKey("file_1.png"))
+	for _, key := range []string{"", "/abs", "a//b", "a/", "../a", "a/../b", "a/./b", "a b", `a\b`, "ä"} {
+		assert.False(ValidKey(key), key)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "posty-blob")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Could not create store: %s", err)
+	}
+
+	_, err = s.Get("a/b")
+	assert.Equal(ErrNotFound, err)
+	assert.NoError(s.Put("a/b", strings.NewReader("content"), "text/plain"))
+	assert.NoError(s.Put("a/b", strings.NewReader("replaced"), "text/plain"))
+	r, err := s.Get("a/b")
+	if assert.NoError(err) {
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		assert.Equal("replaced", string(b))
+	}
+	assert.NoError(s.Put("a/c/d", strings.NewReader("nested"), ""))
+	assert.NoError(s.Put("b", strings.NewReader("other"), ""))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "a", ".upload123"), []byte("partial"), 0644))
+	keys, err := s.List("a/")
+	assert.NoError(err)
+	assert.Equal([]string{"a/b", "a/c/d"}, keys, "Blobs being written are not listed")
+	assert.NoError(s.Delete("a/b"))
+	assert.NoError(s.Delete("a/b"), "Deleting a missing blob must not fail")
+	_, err = s.Get("a/b")
+	assert.Equal(ErrNotFound, err)
+
+	assert.Equal(ErrInvalidKey, s.Put("../escape", strings.NewReader("x"), ""))
+	_, err = s.Get("../escape")
+	assert.Equal(ErrInvalidKey, err)
+	assert.Equal(ErrInvalidKey, s.Delete("../escape"))
+}
@@ -0,0 +1,2 @@
+// Package integrationtest provides tests interacting with a S3 compatible endpoint, e.g. a local minio or fake-s3 server.
+package integrationtest
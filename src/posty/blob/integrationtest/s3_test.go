@@ -0,0 +1,64 @@
+package integrationtest
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"posty/blob"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+var integration = flag.Bool("integration", false, "Enable integration tests")
+var endpoint = flag.String("s3-endpoint", "http://localhost:9000", "S3 compatible endpoint")
+var bucket = flag.String("s3-bucket", "posty-integration", "Bucket used for tests, it is created if it does not exist")
+var sess *session.Session
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !*integration {
+		fmt.Fprintln(os.Stderr, "Skipping integration tests")
+		os.Exit(0)
+	}
+	sess = session.New(&aws.Config{
+		Region:           aws.String("us-west-2"),
+		Endpoint:         aws.String(*endpoint),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewEnvCredentials(),
+	})
+	_, err := s3.New(sess).CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(*bucket),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create bucket, assuming it exists: %s\n", err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestS3Store(t *testing.T) {
+	assert := assert.New(t)
+	s := blob.NewS3StoreFromSession(sess, *bucket)
+
+	_, err := s.Get("integration/missing")
+	assert.Equal(blob.ErrNotFound, err)
+	assert.NoError(s.Put("integration/blob", strings.NewReader("content"), "text/plain"))
+	r, err := s.Get("integration/blob")
+	if assert.NoError(err) {
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		assert.Equal("content", string(b))
+	}
+	keys, err := s.List("integration/")
+	assert.NoError(err)
+	assert.Contains(keys, "integration/blob")
+	assert.NoError(s.Delete("integration/blob"))
+	_, err = s.Get("integration/blob")
+	assert.Equal(blob.ErrNotFound, err)
+}
@@ -0,0 +1,98 @@
+package blob
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Store stores blobs as objects of a S3 bucket. Any S3 compatible service can be used by configuring the endpoint of the session.
+type S3Store struct {
+	client s3iface.S3API
+	bucket string
+}
+
+// NewS3StoreFromSession creates a store using the bucket.
+func NewS3StoreFromSession(sess *session.Session, bucket string) *S3Store {
+	return NewS3Store(s3.New(sess), bucket)
+}
+
+// NewS3Store creates a store using the client and bucket.
+func NewS3Store(client s3iface.S3API, bucket string) *S3Store {
+	return &S3Store{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+// Put uploads the blob as object. The content is buffered in memory, blobs are expected to be small.
+func (s *S3Store) Put(key string, r io.Reader, contentType string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	params := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(b),
+	}
+	if contentType != "" {
+		params.ContentType = aws.String(contentType)
+	}
+	_, err = s.client.PutObject(params)
+	return err
+}
+
+// Get downloads the object of the blob.
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	resp, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object of the blob.
+func (s *S3Store) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// List lists the objects of the bucket with the prefix, page by page.
+func (s *S3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.client.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, last bool) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	return keys, err
+}
@@ -0,0 +1,86 @@
+package blob
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockS3 stores objects in memory, unused methods of the interface panic.
+type mockS3 struct {
+	s3iface.S3API
+	objects map[string]string
+	types   map[string]string
+}
+
+func (m *mockS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, _ := ioutil.ReadAll(in.Body)
+	m.objects[*in.Bucket+"/"+*in.Key] = string(b)
+	m.types[*in.Bucket+"/"+*in.Key] = aws.StringValue(in.ContentType)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mockS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	o, ok := m.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, awserr.New("NoSuchKey", "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(o))}, nil
+}
+
+func (m *mockS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, *in.Bucket+"/"+*in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3) ListObjectsPages(in *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool) error {
+	var keys []string
+	for k := range m.objects {
+		if key := strings.TrimPrefix(k, *in.Bucket+"/"); key != k && strings.HasPrefix(key, *in.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		// a page per object
+		if !fn(&s3.ListObjectsOutput{Contents: []*s3.Object{{Key: aws.String(key)}}}, i == len(keys)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestS3Store(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockS3{objects: make(map[string]string), types: make(map[string]string)}
+	s := NewS3Store(m, "bucket")
+
+	_, err := s.Get("a/b")
+	assert.Equal(ErrNotFound, err)
+	assert.NoError(s.Put("a/b", strings.NewReader("content"), "text/plain"))
+	assert.Equal("content", m.objects["bucket/a/b"])
+	assert.Equal("text/plain", m.types["bucket/a/b"])
+	r, err := s.Get("a/b")
+	if assert.NoError(err) {
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		assert.Equal("content", string(b))
+	}
+	assert.NoError(s.Put("a/c", strings.NewReader("other"), ""))
+	assert.NoError(s.Put("b/a", strings.NewReader("other"), ""))
+	keys, err := s.List("a/")
+	assert.NoError(err)
+	assert.Equal([]string{"a/b", "a/c"}, keys)
+	assert.NoError(s.Delete("a/b"))
+	assert.NoError(s.Delete("a/c"))
+	assert.NoError(s.Delete("b/a"))
+	assert.Empty(m.objects)
+	assert.Equal(ErrInvalidKey, s.Put("../a", strings.NewReader(""), ""))
+}
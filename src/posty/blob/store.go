@@ -0,0 +1,45 @@
+// Package blob provides storage of binary objects like attachments.
+package blob
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned if a blob does not exist.
+var ErrNotFound = errors.New("Blob not found")
+
+// ErrInvalidKey is returned if a key is not valid, see ValidKey.
+var ErrInvalidKey = errors.New("Invalid blob key")
+
+// Store defines the interactions with a blob storage.
+type Store interface {
+	// Put stores the content read from r under the key, an existing blob is replaced.
+	Put(key string, r io.Reader, contentType string) error
+	// Get returns the content of the blob, the reader must be closed. If the blob does not exist ErrNotFound is returned.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob. Removing a blob which does not exist is not an error.
+	Delete(key string) error
+	// List returns the keys of all blobs starting with the prefix, e.g. `attachments/`.
+	List(prefix string) ([]string, error)
+}
+
+// ValidKey returns true if the key consists of `/` separated segments of letters, digits, `.`, `-` and `_`.
+// Segments must not be empty, `.` or `..`.
+func ValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, c := range seg {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
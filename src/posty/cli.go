@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of posty. Subcommands share the configuration of the global flags and environment
+// variables, their own flags follow the name.
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []*command{
+	{"serve", "", "Serve the API and the frontend, the default command", runServe},
+	{"migrate", "[-read-capacity n] [-write-capacity n] [-timeout d]", "Create the dynamodb tables and apply pending migrations", runMigrate},
+	{"export", "[-o file]", "Write all users and posts as newline-delimited JSON", func(args []string) int { return runDump("export", args) }},
+	{"import", "[-i file]", "Read users and posts written by export, existing ones are skipped", func(args []string) int { return runDump("import", args) }},
+	{"user add-role", "<user-id> <role>", "Grant the role moderator or admin to an user", runUserAddRole},
+	{"config check", "", "Validate the configuration of the server without starting it", runConfigCheck},
+}
+
+// run runs the command named by the arguments left after the global flags, serve if there are none.
+func run(args []string) int {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c.run(args[len(words):])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command '%s', run 'posty -h' for usage\n", strings.Join(args, " "))
+	return 2
+}
+
+// usage prints the commands and the global flags.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: posty [flags] [command] [command flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", c.name, c.summary)
+		if c.args != "" {
+			fmt.Fprintf(os.Stderr, "  %-14s   %s %s\n", "", c.name, c.args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags, also set by environment variables with the prefix %s:\n", envprefix)
+	flag.PrintDefaults()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"posty/config"
+	"posty/controller"
+	"posty/model"
+	"time"
+)
+
+// Config is the configuration of posty, see the flags for the meaning of the options.
+type Config struct {
+	Listen                 string        `config:"http" env:"LISTEN" usage:"Listen on"`
+	FrontendPath           string        `config:"frontend-path" env:"FRONTEND_PATH" usage:"Path to frontend"`
+	DynamodbEndpoint       string        `config:"dynamodb-endpoint" env:"DYNAMODB_ENDPOINT" usage:"Dynamodb endpoint, leave blank in production, e.g. http://127.0.0.1:8000"`
+	Debug                  bool          `config:"debug" usage:"Enable debugging"`
+	OIDCGoogleClientID     string        `config:"oidc-google-client-id" env:"OIDC_GOOGLE_CLIENT_ID" usage:"Google OpenID Connect Client ID"`
+	OIDCGoogleClientSecret string        `config:"oidc-google-client-secret" env:"OIDC_GOOGLE_CLIENT_SECRET" usage:"Google OpenID Connect Client Secret" secret:"true"`
+	OIDCPaypalClientID     string        `config:"oidc-paypal-client-id" env:"OIDC_PAYPAL_CLIENT_ID" usage:"Paypal OpenID Connect Client ID"`
+	OIDCPaypalClientSecret string        `config:"oidc-paypal-client-secret" env:"OIDC_PAYPAL_CLIENT_SECRET" usage:"Paypal OpenID Connect Client Secret" secret:"true"`
+	PublicURL              string        `config:"public-url" env:"PUBLIC_URL" usage:"http://[host]"`
+	SessionHashKey         string        `config:"session-hash-key" env:"SESSION_HASH_KEY" usage:"Session hash key, 32/64 Byte" secret:"true"`
+	SessionBlockKey        string        `config:"session-block-key" env:"SESSION_BLOCK_KEY" usage:"Session block encryption key, valid lengths are 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256" secret:"true"`
+	TrendingWindow         time.Duration `config:"trending-window" env:"TRENDING_WINDOW" usage:"Sliding window trending tags are computed over, e.g. 24h"`
+	AttachmentsDir         string        `config:"attachments-dir" env:"ATTACHMENTS_DIR" usage:"Directory attachments are stored in, unused if a S3 bucket is configured"`
+	AttachmentsS3Bucket    string        `config:"attachments-s3-bucket" env:"ATTACHMENTS_S3_BUCKET" usage:"S3 bucket attachments are stored in"`
+	S3Endpoint             string        `config:"s3-endpoint" env:"S3_ENDPOINT" usage:"S3 endpoint, leave blank in production, e.g. http://127.0.0.1:9000"`
+	AttachmentsMaxSize     int64         `config:"attachments-max-size" env:"ATTACHMENTS_MAX_SIZE" usage:"Maximum size of uploaded attachments in bytes"`
+	AttachmentsTypes       string        `config:"attachments-types" env:"ATTACHMENTS_TYPES" usage:"Comma separated content types allowed for attachments"`
+	AttachmentsMaxPixels   int64         `config:"attachments-max-pixels" env:"ATTACHMENTS_MAX_PIXELS" usage:"Maximum width times height of uploaded images"`
+	AttachmentsUploadTTL   time.Duration `config:"attachments-upload-ttl" env:"ATTACHMENTS_UPLOAD_TTL" usage:"Time after which uploads which were not attached to a post are purged"`
+	LinkPreviews           bool          `config:"link-previews" env:"LINK_PREVIEWS" usage:"Fetch previews of links in posts"`
+	LinkPreviewTimeout     time.Duration `config:"link-preview-timeout" env:"LINK_PREVIEW_TIMEOUT" usage:"Timeout of fetching a link preview"`
+	ImageOrigins           string        `config:"image-origins" env:"IMAGE_ORIGINS" usage:"Comma separated origins images in markdown posts may be embedded from, e.g. https://i.imgur.com"`
+	UserCacheTTL           time.Duration `config:"user-cache-ttl" env:"USER_CACHE_TTL" usage:"Time users are cached in-process, e.g. 1m"`
+	UserCacheSize          int64         `config:"user-cache-size" env:"USER_CACHE_SIZE" usage:"Maximum number of users cached in-process"`
+	MessageMinLength       int64         `config:"message-min-length" env:"MESSAGE_MIN_LENGTH" usage:"Minimum length of messages in characters"`
+	MessageMaxLength       int64         `config:"message-max-length" env:"MESSAGE_MAX_LENGTH" usage:"Maximum length of messages in characters, 0 is unlimited"`
+	MaxBodySize            int64         `config:"max-body-size" env:"MAX_BODY_SIZE" usage:"Maximum size of post requests in bytes"`
+	BannedWords            string        `config:"banned-words" env:"BANNED_WORDS" usage:"Comma separated words messages must not contain"`
+	DuplicateWindow        time.Duration `config:"duplicate-window" env:"DUPLICATE_WINDOW" usage:"Time a message is remembered by the duplicate check after it was last posted"`
+	DuplicateMaxPerUser    int64         `config:"duplicate-max-per-user" env:"DUPLICATE_MAX_PER_USER" usage:"Identical messages a user may post within the duplicate window, 0 is unlimited"`
+	DuplicateMaxPerWall    int64         `config:"duplicate-max-per-wall" env:"DUPLICATE_MAX_PER_WALL" usage:"Identical messages all users may post within the duplicate window, 0 is unlimited"`
+	DuplicateAction        string        `config:"duplicate-action" env:"DUPLICATE_ACTION" usage:"Action taken on duplicate messages: reject or quarantine"`
+	DuplicateTable         string        `config:"duplicate-table" env:"DUPLICATE_TABLE" usage:"Dynamodb table message fingerprints are shared in by multiple instances, in-memory if blank"`
+	RestoreWindow          time.Duration `config:"restore-window" env:"RESTORE_WINDOW" usage:"Time removed posts can be restored by their author before they are purged"`
+	PurgeInterval          time.Duration `config:"purge-interval" env:"PURGE_INTERVAL" usage:"Interval removed posts are purged in after the restore window expired"`
+	PublishInterval        time.Duration `config:"publish-interval" env:"PUBLISH_INTERVAL" usage:"Interval scheduled posts are checked for publication in"`
+	PublishAnnounce        bool          `config:"publish-announce" env:"PUBLISH_ANNOUNCE" usage:"Notify about scheduled posts when they are published, each post is announced by one of the instances"`
+	MaxPinned              int64         `config:"max-pinned" env:"MAX_PINNED" usage:"Posts moderators can pin to the top of the wall at the same time"`
+	ReportHideThreshold    int64         `config:"report-hide-threshold" env:"REPORT_HIDE_THRESHOLD" usage:"Distinct reports after which a post is hidden until a moderator decides, 0 disables hiding"`
+	RateLimitTable         string        `config:"rate-limit-table" env:"RATE_LIMIT_TABLE" usage:"Dynamodb table rate limits are shared in by multiple instances, in-memory if blank"`
+	RateLimitCreate        string        `config:"rate-limit-create" env:"RATE_LIMIT_CREATE" usage:"Posts a user may create per period, e.g. 10/1m, 0 is unlimited"`
+	RateLimitCreateIP      string        `config:"rate-limit-create-ip" env:"RATE_LIMIT_CREATE_IP" usage:"Posts which may be created per period from a single ip address"`
+	RateLimitDelete        string        `config:"rate-limit-delete" env:"RATE_LIMIT_DELETE" usage:"Posts a user may delete per period"`
+	RateLimitDeleteIP      string        `config:"rate-limit-delete-ip" env:"RATE_LIMIT_DELETE_IP" usage:"Posts which may be deleted per period from a single ip address"`
+	Mailer                 string        `config:"mailer" env:"MAILER" usage:"Mailer sending emails: none, smtp, file or log"`
+	MailFrom               string        `config:"mail-from" env:"MAIL_FROM" usage:"Sender address of emails"`
+	MailSMTPAddr           string        `config:"mail-smtp-addr" env:"MAIL_SMTP_ADDR" usage:"SMTP server emails are delivered to, e.g. smtp.example.com:587"`
+	MailSMTPUser           string        `config:"mail-smtp-user" env:"MAIL_SMTP_USER" usage:"SMTP username, authentication is disabled if blank"`
+	MailSMTPPassword       string        `config:"mail-smtp-password" env:"MAIL_SMTP_PASSWORD" usage:"SMTP password" secret:"true"`
+	MailDir                string        `config:"mail-dir" env:"MAIL_DIR" usage:"Directory emails are written to by the file mailer"`
+	DigestInterval         time.Duration `config:"digest-interval" env:"DIGEST_INTERVAL" usage:"Interval digests of new posts are emailed in, 0 disables digests"`
+	FeedLimit              int64         `config:"feed-limit" env:"FEED_LIMIT" usage:"Latest posts in the Atom and RSS feeds"`
+	Webhooks               bool          `config:"webhooks" env:"WEBHOOKS" usage:"Deliver events to webhooks configured by admins"`
+	WebhookInterval        time.Duration `config:"webhook-interval" env:"WEBHOOK_INTERVAL" usage:"Interval due webhook deliveries are sent in"`
+	WebhookTimeout         time.Duration `config:"webhook-timeout" env:"WEBHOOK_TIMEOUT" usage:"Timeout of a single webhook delivery attempt"`
+	WebhookMaxAttempts     int64         `config:"webhook-max-attempts" env:"WEBHOOK_MAX_ATTEMPTS" usage:"Attempts after which a webhook delivery is given up"`
+	RateLimitIncoming      string        `config:"rate-limit-incoming-webhook" env:"RATE_LIMIT_INCOMING_WEBHOOK" usage:"Posts an incoming webhook may create per period"`
+	RateLimitIncomingIP    string        `config:"rate-limit-incoming-webhook-ip" env:"RATE_LIMIT_INCOMING_WEBHOOK_IP" usage:"Posts which may be created by incoming webhooks per period from a single ip address"`
+	RateLimitUpload        string        `config:"rate-limit-upload" env:"RATE_LIMIT_UPLOAD" usage:"Attachments a user may upload per period"`
+	RateLimitUploadIP      string        `config:"rate-limit-upload-ip" env:"RATE_LIMIT_UPLOAD_IP" usage:"Attachments which may be uploaded per period from a single ip address"`
+	RateLimitLoginIP       string        `config:"rate-limit-login-ip" env:"RATE_LIMIT_LOGIN_IP" usage:"Login attempts per period from a single ip address"`
+}
+
+// defaultConfig returns the configuration used unless set by the config file, environment variables or flags.
+func defaultConfig() *Config {
+	return &Config{
+		Listen:               ":8080",
+		FrontendPath:         "./frontend",
+		PublicURL:            "http://127.0.0.1:8080",
+		TrendingWindow:       24 * time.Hour,
+		AttachmentsDir:       "./attachments",
+		AttachmentsMaxSize:   5 << 20,
+		AttachmentsTypes:     "image/gif,image/jpeg,image/png,application/pdf",
+		AttachmentsUploadTTL: 24 * time.Hour,
+		AttachmentsMaxPixels: controller.DefaultMaxPixels,
+		LinkPreviews:         true,
+		LinkPreviewTimeout:   5 * time.Second,
+		UserCacheTTL:         time.Minute,
+		UserCacheSize:        model.DefaultUserCacheSize,
+		MessageMinLength:     6,
+		MessageMaxLength:     1000,
+		MaxBodySize:          controller.DefaultMaxBodySize,
+		DuplicateWindow:      10 * time.Minute,
+		DuplicateMaxPerUser:  1,
+		DuplicateMaxPerWall:  5,
+		DuplicateAction:      "reject",
+		RestoreWindow:        controller.DefaultRestoreWindow,
+		PurgeInterval:        time.Hour,
+		PublishInterval:      time.Minute,
+		PublishAnnounce:      true,
+		MaxPinned:            controller.DefaultMaxPinned,
+		ReportHideThreshold:  3,
+		RateLimitCreate:      "10/1m",
+		RateLimitCreateIP:    "30/1m",
+		RateLimitDelete:      "30/1m",
+		RateLimitDeleteIP:    "60/1m",
+		Mailer:               "none",
+		MailFrom:             "posty@localhost",
+		MailSMTPAddr:         "127.0.0.1:25",
+		MailDir:              "./mail",
+		DigestInterval:       24 * time.Hour,
+		FeedLimit:            controller.DefaultFeedLimit,
+		WebhookInterval:      10 * time.Second,
+		WebhookTimeout:       10 * time.Second,
+		WebhookMaxAttempts:   8,
+		RateLimitIncoming:    "30/1m",
+		RateLimitIncomingIP:  "60/1m",
+		RateLimitUpload:      "10/1m",
+		RateLimitUploadIP:    "30/1m",
+		RateLimitLoginIP:     "20/1m",
+	}
+}
+
+var (
+	// conf is the configuration of all commands, loaded by main.
+	conf = defaultConfig()
+	// configLoader loaded conf and knows the source of every option.
+	configLoader *config.Loader
+	// configErrs are the problems found loading conf, e.g. invalid values of environment variables.
+	configErrs []error
+)
+
+// configLoaded prints the problems of loading the configuration and returns false if there were any.
+// Commands not validating the whole configuration using checkConfig call it before using conf.
+func configLoaded() bool {
+	for _, err := range configErrs {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+	return len(configErrs) == 0
+}
+
+// runConfigCheck runs the `config check` subcommand validating the configuration like serve does on start.
+// The effective configuration is printed to stdout with secrets redacted, problems to stderr.
+// The exit code is 1 if the server would not start.
+func runConfigCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := configLoader.Write(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write configuration: %s\n", err)
+		return 1
+	}
+	for _, w := range configWarnings() {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+	errs := checkConfig()
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Configuration is valid")
+	return 0
+}
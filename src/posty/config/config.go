@@ -0,0 +1,234 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Redacted replaces the values of secret options in the output of Write.
+const Redacted = "<redacted>"
+
+// Loader loads the options of a configuration struct.
+type Loader struct {
+	prefix  string
+	options []*option
+}
+
+type option struct {
+	name   string
+	env    string
+	usage  string
+	secret bool
+	value  *value
+	source string
+}
+
+// New creates a loader of the options of cfg, a pointer to a struct holding the defaults.
+// Environment variables of the options are prefixed by prefix, e.g. `POSTY_`.
+// Options can be of type string, bool, int64 or time.Duration.
+func New(cfg interface{}, prefix string) (*Loader, error) {
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("Configuration must be a pointer to a struct")
+	}
+	l := &Loader{prefix: prefix}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Type().Field(i)
+		name := f.Tag.Get("config")
+		if name == "" {
+			continue
+		}
+		switch f.Type {
+		case reflect.TypeOf(""), reflect.TypeOf(false), reflect.TypeOf(int64(0)), reflect.TypeOf(time.Duration(0)):
+		default:
+			return nil, fmt.Errorf("Option '%s' has the unsupported type %s", name, f.Type)
+		}
+		l.options = append(l.options, &option{
+			name:   name,
+			env:    f.Tag.Get("env"),
+			usage:  f.Tag.Get("usage"),
+			secret: f.Tag.Get("secret") == "true",
+			value:  &value{field: rv.Field(i)},
+			source: "default",
+		})
+	}
+	return l, nil
+}
+
+// Register defines the flags of the options on the flag set. Flags are bound to the fields of the
+// configuration struct, their defaults are the values of the fields.
+func (l *Loader) Register(fs *flag.FlagSet) {
+	for _, o := range l.options {
+		usage := o.usage
+		if o.env != "" {
+			usage = fmt.Sprintf("%s (env %s%s)", usage, l.prefix, o.env)
+		}
+		fs.Var(o.value, o.name, usage)
+	}
+}
+
+// Load sets the options which were not set by flags of the parsed flag set from the environment and, if file is not
+// empty, the config file. All problems are returned, options with invalid values keep their previous value.
+func (l *Loader) Load(fs *flag.FlagSet, file string) []error {
+	var errs []error
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var values map[string]string
+	if file != "" {
+		var err error
+		if values, err = ReadFile(file); err != nil {
+			errs = append(errs, err)
+		}
+		var unknown []string
+		for key := range values {
+			if l.option(key) == nil {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			errs = append(errs, fmt.Errorf("Unknown option '%s' in %s", key, file))
+		}
+	}
+	for _, o := range l.options {
+		if set[o.name] {
+			o.source = "flag -" + o.name
+			continue
+		}
+		s, source, err := l.lookupEnv(o)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if source == "" {
+			var ok bool
+			if s, ok = values[o.name]; !ok {
+				continue
+			}
+			source = "file " + file
+		}
+		if err := o.value.Set(s); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid value of option '%s' from %s: %s", o.name, source, err))
+			continue
+		}
+		o.source = source
+	}
+	return errs
+}
+
+// lookupEnv returns the value of the environment variable of the option, or the content of the file named by the
+// variable with the suffix `_FILE`, and the source. The source is empty if neither is set.
+func (l *Loader) lookupEnv(o *option) (string, string, error) {
+	if o.env == "" {
+		return "", "", nil
+	}
+	name := l.prefix + o.env
+	v, file := os.Getenv(name), os.Getenv(name+"_FILE")
+	switch {
+	case v != "" && file != "":
+		return "", "", fmt.Errorf("Only one of %s and %s_FILE may be set", name, name)
+	case v != "":
+		return v, "env " + name, nil
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", "", fmt.Errorf("Could not read %s_FILE: %s", name, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), "env " + name + "_FILE", nil
+	}
+	return "", "", nil
+}
+
+func (l *Loader) option(name string) *option {
+	for _, o := range l.options {
+		if o.name == name {
+			return o
+		}
+	}
+	return nil
+}
+
+// Write writes the effective configuration to w as YAML, every value is commented with its source.
+// Values of secret options are replaced by Redacted unless they are empty.
+func (l *Loader) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 1, ' ', 0)
+	for _, o := range l.options {
+		s := o.value.String()
+		if o.secret && s != "" {
+			s = Redacted
+		}
+		switch o.value.field.Interface().(type) {
+		case string, time.Duration:
+			s = strconv.Quote(s)
+		}
+		fmt.Fprintf(tw, "%s:\t%s\t# %s\n", o.name, s, o.source)
+	}
+	return tw.Flush()
+}
+
+// value is the flag.Value of an option, bound to the field of the configuration struct.
+type value struct {
+	field reflect.Value
+}
+
+func (v *value) String() string {
+	// the flag package calls String on a zero value to detect zero defaults
+	if !v.field.IsValid() {
+		return ""
+	}
+	switch f := v.field.Interface().(type) {
+	case string:
+		return f
+	case bool:
+		return strconv.FormatBool(f)
+	case time.Duration:
+		return f.String()
+	case int64:
+		return strconv.FormatInt(f, 10)
+	}
+	return ""
+}
+
+func (v *value) Set(s string) error {
+	switch v.field.Interface().(type) {
+	case string:
+		v.field.SetString(s)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", s)
+		}
+		v.field.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a duration, e.g. 1m30s", s)
+		}
+		v.field.SetInt(int64(d))
+	case int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", s)
+		}
+		v.field.SetInt(n)
+	}
+	return nil
+}
+
+// IsBoolFlag allows boolean flags without value, e.g. `-debug`.
+func (v *value) IsBoolFlag() bool {
+	return v.field.IsValid() && v.field.Kind() == reflect.Bool
+}
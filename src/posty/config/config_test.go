@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testConfig struct {
+	Listen   string        `config:"http" env:"LISTEN" usage:"Listen on"`
+	Debug    bool          `config:"debug" usage:"Enable debugging"`
+	Window   time.Duration `config:"window" env:"WINDOW" usage:"Window"`
+	Limit    int64         `config:"limit" env:"LIMIT" usage:"Limit"`
+	Secret   string        `config:"secret" env:"SECRET" usage:"Secret" secret:"true"`
+	Password string        `config:"password" env:"PASSWORD" usage:"Password" secret:"true"`
+	internal string
+}
+
+func newTestLoader(t *testing.T, args ...string) (*testConfig, *Loader, *flag.FlagSet) {
+	cfg := &testConfig{Listen: ":8080", Window: time.Minute, Limit: 10}
+	l, err := New(cfg, "TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	l.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, l, fs
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func setenv(t *testing.T, env map[string]string) func() {
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	_, err := New(testConfig{}, "")
+	assert.Error(err)
+	_, err = New(&struct {
+		Ports []int `config:"ports"`
+	}{}, "")
+	if assert.Error(err) {
+		assert.Equal("Option 'ports' has the unsupported type []int", err.Error())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	assert := assert.New(t)
+	_, _, fs := newTestLoader(t)
+	f := fs.Lookup("http")
+	if assert.NotNil(f) {
+		assert.Equal("Listen on (env TEST_LISTEN)", f.Usage)
+		assert.Equal(":8080", f.DefValue)
+	}
+	assert.Equal("Enable debugging", fs.Lookup("debug").Usage)
+	assert.Nil(fs.Lookup("internal"), "Untagged fields are no options")
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "posty-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "posty.yaml", "http: \":9000\"\nwindow: 2m\nlimit: 20\nsecret: fromfile\n")
+	secretFile := writeFile(t, dir, "secret", "fromsecretfile\n")
+	defer setenv(t, map[string]string{"TEST_WINDOW": "3m", "TEST_SECRET_FILE": secretFile})()
+
+	cfg, l, fs := newTestLoader(t, "-limit", "30", "-debug")
+	assert.Empty(l.Load(fs, file))
+	assert.Equal(":9000", cfg.Listen, "Config files override defaults")
+	assert.Equal(3*time.Minute, cfg.Window, "Environment variables override config files")
+	assert.Equal(int64(30), cfg.Limit, "Flags override everything")
+	assert.True(cfg.Debug)
+	assert.Equal("fromsecretfile", cfg.Secret, "Values are read from files named by _FILE variables")
+	assert.Equal("", cfg.Password)
+
+	var b bytes.Buffer
+	assert.NoError(l.Write(&b))
+	assert.Equal(`http:     ":9000"      # file `+file+`
+debug:    true         # flag -debug
+window:   "3m0s"       # env TEST_WINDOW
+limit:    30           # flag -limit
+secret:   "<redacted>" # env TEST_SECRET_FILE
+password: ""           # default
+`, b.String())
+}
+
+func TestLoadErrors(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "posty-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "posty.toml", "limit = \"many\"\nlistn = \":9000\"\n")
+	defer setenv(t, map[string]string{"TEST_WINDOW": "soon", "TEST_SECRET": "a", "TEST_SECRET_FILE": "b", "TEST_PASSWORD_FILE": filepath.Join(dir, "missing")})()
+
+	cfg, l, fs := newTestLoader(t)
+	errs := l.Load(fs, file)
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.Equal([]string{
+		"Unknown option 'listn' in " + file,
+		"Invalid value of option 'window' from env TEST_WINDOW: 'soon' is not a duration, e.g. 1m30s",
+		"Invalid value of option 'limit' from file " + file + ": 'many' is not an integer",
+		"Only one of TEST_SECRET and TEST_SECRET_FILE may be set",
+		"Could not read TEST_PASSWORD_FILE: open " + filepath.Join(dir, "missing") + ": no such file or directory",
+	}, msgs, "All problems are reported at once")
+	assert.Equal(time.Minute, cfg.Window, "Invalid values keep the default")
+	assert.Equal(int64(10), cfg.Limit)
+}
@@ -0,0 +1,15 @@
+// Package config loads a configuration struct from defaults, a YAML or TOML file, environment variables and flags.
+//
+// Fields of the struct are options if they are tagged with the name of their flag, which is also their key in
+// config files. Later sources override earlier ones: the defaults set in the struct, the config file, the
+// environment variable and finally the flag. The value of an environment variable can be read from the file named
+// by the variable with the suffix `_FILE`, so secrets do not have to be passed in the environment.
+//
+//	type Config struct {
+//		Listen string `config:"http" env:"LISTEN" usage:"Listen on"`
+//		Secret string `config:"secret" env:"SECRET" usage:"Signing key" secret:"true"`
+//	}
+//
+// Load reports all invalid values at once instead of stopping at the first. Write prints the effective
+// configuration with the source of every value, secrets are redacted.
+package config
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// ReadFile reads the options of the YAML or TOML file, the format is chosen by the extension `.yaml`, `.yml` or
+// `.toml`. Options are the top-level keys, their values are returned as strings, lists are joined by commas.
+func ReadFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config file: %s", err)
+	}
+	raw := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(b), &raw)
+	default:
+		return nil, fmt.Errorf("Config file %s must have the extension .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		s, err := format(v)
+		if err != nil {
+			return nil, fmt.Errorf("Option '%s' in %s %s", key, path, err)
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// format returns the scalar or list of scalars as string.
+func format(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := format(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("must be a value or a list of values, got %T", v)
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadFile(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "posty-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := map[string]string{"http": ":8080", "debug": "true", "limit": "5242880", "window": "24h", "types": "image/png,image/gif"}
+
+	values, err := ReadFile(writeFile(t, dir, "posty.yml", "http: \":8080\"\ndebug: true\nlimit: 5242880\nwindow: 24h\ntypes:\n  - image/png\n  - image/gif\n"))
+	assert.NoError(err)
+	assert.Equal(want, values)
+
+	values, err = ReadFile(writeFile(t, dir, "posty.toml", "http = \":8080\"\ndebug = true\nlimit = 5242880\nwindow = \"24h\"\ntypes = [\"image/png\", \"image/gif\"]\n"))
+	assert.NoError(err)
+	assert.Equal(want, values)
+
+	for name, content := range map[string]string{
+		"posty.json":   "{}",
+		"invalid.yaml": "http: [",
+		"nested.yaml":  "mail:\n  from: posty@example.com\n",
+		"nested.toml":  "[mail]\nfrom = \"posty@example.com\"\n",
+	} {
+		_, err := ReadFile(writeFile(t, dir, name, content))
+		assert.Error(err, name)
+	}
+	_, err = ReadFile(writeFile(t, dir, "nested.yaml", "mail:\n  from: posty@example.com\n"))
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Option 'mail' in ")
+		assert.Contains(err.Error(), "must be a value or a list of values")
+	}
+	_, err = ReadFile(dir + "/missing.yaml")
+	assert.Error(err)
+}
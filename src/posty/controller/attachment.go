@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"posty/blob"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/thumbnail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+)
+
+const (
+	// thumbnailSize is the maximum width and height of thumbnails
+	thumbnailSize = 320
+	// multipartOverhead is the space allowed for multipart headers and boundaries of an upload
+	multipartOverhead = 64 << 10
+	// maxAttachments limits the number of attachments of a single post
+	maxAttachments = 4
+)
+
+// DefaultMaxPixels is the maximum number of pixels of uploaded images if none is configured, e.g. 4000x3000 photos.
+// Images are decoded to generate their thumbnails, which takes up to 8 bytes per pixel.
+const DefaultMaxPixels = 12 * 1000 * 1000
+
+// thumbnailTypes lists the content types thumbnails are generated for.
+var thumbnailTypes = []string{"image/gif", "image/jpeg", "image/png"}
+
+// AttachmentController handles uploads and downloads of attachments.
+// Uploaded attachments are kept in the blob store, their metadata is copied to the post they are attached to.
+type AttachmentController struct {
+	Store        blob.Store
+	MaxSize      int64    // maximum size of an uploaded file in bytes
+	ContentTypes []string // allowed content types, detected by sniffing the uploaded content
+	// MaxPixels is the maximum width times height of uploaded images, DefaultMaxPixels is used if it is not set
+	MaxPixels int
+	// UnattachedTTL is the time after which uploads which were not attached to a post are purged
+	UnattachedTTL time.Duration
+}
+
+// attachmentMeta is stored next to the content of an attachment.
+type attachmentMeta struct {
+	model.Attachment
+	UID    string // user who uploaded the attachment
+	PostID string // post the attachment is attached to, empty until it is used
+	// CreatedAt is the time of the upload, zero for attachments uploaded before it was recorded
+	CreatedAt time.Time
+}
+
+// attachmentKey returns the blob key of the content, thumbnail or metadata of an attachment.
+func attachmentKey(id, name string) string {
+	return "attachments/" + id + "/" + name
+}
+
+// loadAttachmentMeta loads the metadata of an uploaded attachment.
+func loadAttachmentMeta(store blob.Store, id string) (*attachmentMeta, error) {
+	rc, err := store.Get(attachmentKey(id, "meta"))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var meta attachmentMeta
+	if err := json.NewDecoder(rc).Decode(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// saveAttachmentMeta stores the metadata of an attachment.
+func saveAttachmentMeta(store blob.Store, meta *attachmentMeta) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return store.Put(attachmentKey(meta.ID, "meta"), bytes.NewReader(b), "application/json")
+}
+
+// removeAttachment removes the content, thumbnail and metadata of an attachment.
+func removeAttachment(store blob.Store, a model.Attachment) {
+	for _, name := range []string{"data", "thumbnail", "meta"} {
+		if err := store.Delete(attachmentKey(a.ID, name)); err != nil {
+			log.Warnf("Could not remove %s of attachment %s: %s", name, a.ID, err)
+		}
+	}
+}
+
+// attachmentResource converts an attachment to its JSON API representation.
+// The content is available at `url`, the thumbnail at `thumbnail_url` if one was generated.
+func attachmentResource(a model.Attachment) *jsonapi.Resource {
+	attrs := map[string]interface{}{
+		"name":         a.Name,
+		"content_type": a.ContentType,
+		"size":         a.Size,
+		"url":          "/api/attachments/" + a.ID,
+	}
+	if a.Width > 0 && a.Height > 0 {
+		attrs["width"] = a.Width
+		attrs["height"] = a.Height
+	}
+	if a.Thumbnail {
+		attrs["thumbnail_url"] = "/api/attachments/" + a.ID + "/thumbnail"
+	}
+	return &jsonapi.Resource{
+		Type:       "attachments",
+		ID:         a.ID,
+		Attributes: attrs,
+	}
+}
+
+// sniffContentType detects the content type of the data without parameters.
+func sniffContentType(data []byte) string {
+	ct, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return ct
+}
+
+// cleanFilename strips directories and control characters from the file name given by the client.
+func cleanFilename(name string) string {
+	name = path.Base(strings.Replace(name, `\`, "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	if len(name) > 255 {
+		n := 255
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	return name
+}
+
+func contains(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Upload handles a multipart/form-data upload of the form field `file`.
+//
+// The content type is detected by sniffing the content, the type declared by the client is ignored.
+// Thumbnails are generated for gif, jpeg and png images.
+// On success the attachment resource is returned with status code `http.StatusCreated`, its id can be used to attach it to a new post.
+// Uploads larger than MaxSize are rejected with `http.StatusRequestEntityTooLarge`,
+// content types which are not allowed with `http.StatusUnsupportedMediaType`.
+func (a *AttachmentController) Upload(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, a.MaxSize+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_upload",
+			Title:  "Invalid upload",
+			Detail: "Request must be multipart/form-data",
+		})
+		return
+	}
+	tooLarge := &jsonapi.Error{
+		Code:   "attachment_too_large",
+		Title:  "Attachment too large",
+		Detail: "Attachments must not be larger than " + strconv.FormatInt(a.MaxSize, 10) + " bytes",
+	}
+	var data []byte
+	var name string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			jsonErrors(w, r, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		name = cleanFilename(part.FileName())
+		data, err = ioutil.ReadAll(io.LimitReader(part, a.MaxSize+1))
+		if err != nil || int64(len(data)) > a.MaxSize {
+			jsonErrors(w, r, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		break
+	}
+	if len(data) == 0 {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "missing_file",
+			Title:  "Missing file",
+			Detail: "Form field 'file' must contain a non empty file",
+		})
+		return
+	}
+	meta := &attachmentMeta{
+		Attachment: model.Attachment{
+			ID:          uuid.NewV4().String(),
+			Name:        name,
+			ContentType: sniffContentType(data),
+			Size:        int64(len(data)),
+		},
+		UID:       user,
+		CreatedAt: time.Now(),
+	}
+	if !contains(a.ContentTypes, meta.ContentType) {
+		jsonErrors(w, r, http.StatusUnsupportedMediaType, &jsonapi.Error{
+			Code:   "unsupported_content_type",
+			Title:  "Unsupported content type",
+			Detail: "Content type " + meta.ContentType + " is not allowed",
+		})
+		return
+	}
+	var thumb bytes.Buffer
+	if contains(thumbnailTypes, meta.ContentType) {
+		maxPixels := a.MaxPixels
+		if maxPixels <= 0 {
+			maxPixels = DefaultMaxPixels
+		}
+		img, err := thumbnail.Decode(bytes.NewReader(data), maxPixels)
+		if err != nil {
+			jsonErrors(w, r, cErrClient, &jsonapi.Error{
+				Code:   "invalid_image",
+				Title:  "Invalid image",
+				Detail: err.Error(),
+			})
+			return
+		}
+		meta.Width, meta.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		if err := thumbnail.Encode(&thumb, thumbnail.Scale(img, thumbnailSize)); err != nil {
+			log.Warnf("Could not create thumbnail: %s", err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+		meta.Thumbnail = true
+	}
+	err = a.Store.Put(attachmentKey(meta.ID, "data"), bytes.NewReader(data), meta.ContentType)
+	if err == nil && meta.Thumbnail {
+		err = a.Store.Put(attachmentKey(meta.ID, "thumbnail"), &thumb, "image/png")
+	}
+	if err == nil {
+		err = saveAttachmentMeta(a.Store, meta)
+	}
+	if err != nil {
+		log.Warnf("Could not store attachment: %s", err)
+		removeAttachment(a.Store, meta.Attachment)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	w.Header().Set("Location", "/api/attachments/"+meta.ID)
+	err = jsonapi.Write(w, http.StatusCreated, &jsonapi.Document{
+		Data: attachmentResource(meta.Attachment),
+	})
+	if err != nil {
+		log.Warnf("Could not write attachment: %s", err)
+	}
+}
+
+// PurgeUnattached removes uploads which were not attached to a post within UnattachedTTL and returns their number.
+// Uploads without upload time are purged as well.
+func (a *AttachmentController) PurgeUnattached(now time.Time) (int, error) {
+	keys, err := a.Store.List("attachments/")
+	if err != nil {
+		return 0, err
+	}
+	before := now.Add(-a.UnattachedTTL)
+	n := 0
+	for _, key := range keys {
+		if path.Base(key) != "meta" {
+			continue
+		}
+		meta, err := loadAttachmentMeta(a.Store, path.Base(path.Dir(key)))
+		if err != nil {
+			log.Warnf("Could not load attachment metadata %s: %s", key, err)
+			continue
+		}
+		if meta.PostID != "" || !meta.CreatedAt.Before(before) {
+			continue
+		}
+		removeAttachment(a.Store, meta.Attachment)
+		n++
+	}
+	return n, nil
+}
+
+// Attachment serves the content of the attachment identified by the id url parameter.
+// Images are displayed inline, all other files are served as download.
+func (a *AttachmentController) Attachment(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	a.serve(ctx, w, r, false)
+}
+
+// Thumbnail serves the png thumbnail of the attachment identified by the id url parameter.
+func (a *AttachmentController) Thumbnail(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	a.serve(ctx, w, r, true)
+}
+
+func (a *AttachmentController) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, thumb bool) {
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	meta, err := loadAttachmentMeta(a.Store, id)
+	if err != nil || (thumb && !meta.Thumbnail) {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	key, ctype := attachmentKey(meta.ID, "data"), meta.ContentType
+	if thumb {
+		key, ctype = attachmentKey(meta.ID, "thumbnail"), "image/png"
+	}
+	rc, err := a.Store.Get(key)
+	if err != nil {
+		log.Warnf("Could not load attachment %s: %s", key, err)
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	defer rc.Close()
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	h.Set("Cache-Control", "private, max-age=31536000")
+	if !thumb {
+		h.Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+		disposition := "inline"
+		if !strings.HasPrefix(meta.ContentType, "image/") {
+			disposition = "attachment"
+		}
+		if meta.Name != "" {
+			if d := mime.FormatMediaType(disposition, map[string]string{"filename": meta.Name}); d != "" {
+				disposition = d
+			}
+		}
+		h.Set("Content-Disposition", disposition)
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Warnf("Could not write attachment %s: %s", key, err)
+	}
+}
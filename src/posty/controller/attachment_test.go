@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"posty/blob"
+	"posty/jsonapi"
+	"posty/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// newTestBlobStore creates a file store in a new temporary directory, the directory must be removed by the caller.
+func newTestBlobStore(t *testing.T) (*blob.FileStore, string) {
+	dir, err := ioutil.TempDir("", "posty-attachments")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	s, err := blob.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Could not create blob store: %s", err)
+	}
+	return s, dir
+}
+
+func newUploadRequest(t *testing.T, field, filename string, content []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatalf("Could not create form file: %s", err)
+	}
+	fw.Write(content)
+	mw.Close()
+	r, _ := http.NewRequest("POST", "http://upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func testPNG(w, h int) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h)))
+	return buf.Bytes()
+}
+
+func TestUpload(t *testing.T) {
+	assert := assert.New(t)
+	store, dir := newTestBlobStore(t)
+	defer os.RemoveAll(dir)
+	c := &AttachmentController{
+		Store:        store,
+		MaxSize:      1 << 20,
+		ContentTypes: []string{"image/png", "application/pdf"},
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	w := httptest.NewRecorder()
+	c.Upload(ctx, w, newUploadRequest(t, "file", `C:\photos\cat".png`, testPNG(640, 480)))
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	var doc struct {
+		Data struct {
+			Type       string                 `json:"type"`
+			ID         string                 `json:"id"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"data"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	id := doc.Data.ID
+	assert.Equal("attachments", doc.Data.Type)
+	assert.Equal("cat.png", doc.Data.Attributes["name"])
+	assert.Equal("image/png", doc.Data.Attributes["content_type"])
+	assert.Equal(float64(640), doc.Data.Attributes["width"])
+	assert.Equal(float64(480), doc.Data.Attributes["height"])
+	assert.Equal("/api/attachments/"+id+"/thumbnail", doc.Data.Attributes["thumbnail_url"])
+	assert.Equal("/api/attachments/"+id, w.Header().Get("Location"))
+
+	meta, err := loadAttachmentMeta(store, id)
+	if assert.NoError(err) {
+		assert.Equal("uid123", meta.UID)
+		assert.Equal("", meta.PostID)
+		assert.WithinDuration(time.Now(), meta.CreatedAt, time.Minute)
+	}
+
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+	r, _ := http.NewRequest("GET", "http://attachment", nil)
+	w = httptest.NewRecorder()
+	c.Attachment(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("image/png", w.Header().Get("Content-Type"))
+	assert.Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(`inline; filename=cat.png`, w.Header().Get("Content-Disposition"))
+	assert.Equal(testPNG(640, 480), w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	c.Thumbnail(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	th, err := png.Decode(w.Body)
+	if assert.NoError(err) {
+		assert.Equal(image.Rect(0, 0, 320, 240), th.Bounds())
+	}
+
+	// Files are downloaded and have no thumbnail
+	w = httptest.NewRecorder()
+	c.Upload(ctx, w, newUploadRequest(t, "file", "doc.pdf", []byte("%PDF-1.4 content")))
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	doc.Data.Attributes = nil
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	_, ok := doc.Data.Attributes["thumbnail_url"]
+	assert.False(ok)
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": doc.Data.ID})
+	w = httptest.NewRecorder()
+	c.Attachment(ctx, w, r)
+	assert.Equal(`attachment; filename=doc.pdf`, w.Header().Get("Content-Disposition"))
+	w = httptest.NewRecorder()
+	c.Thumbnail(ctx, w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "../../etc"})
+	w = httptest.NewRecorder()
+	c.Attachment(ctx, w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestUploadInvalid(t *testing.T) {
+	assert := assert.New(t)
+	store, dir := newTestBlobStore(t)
+	defer os.RemoveAll(dir)
+	c := &AttachmentController{
+		Store:        store,
+		MaxSize:      1 << 10,
+		ContentTypes: []string{"image/png"},
+		MaxPixels:    100,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	notMultipart, _ := http.NewRequest("POST", "http://upload", strings.NewReader("data"))
+	tests := []struct {
+		r    *http.Request
+		code int
+		err  string
+	}{
+		{notMultipart, http.StatusBadRequest, "invalid_upload"},
+		{newUploadRequest(t, "other", "a.png", testPNG(1, 1)), http.StatusBadRequest, "missing_file"},
+		{newUploadRequest(t, "file", "a.png", nil), http.StatusBadRequest, "missing_file"},
+		{newUploadRequest(t, "file", "a.png", append(testPNG(1, 1), make([]byte, 2<<10)...)), http.StatusRequestEntityTooLarge, "attachment_too_large"},
+		{newUploadRequest(t, "file", "a.png", []byte("<html><script>alert(1)</script>")), http.StatusUnsupportedMediaType, "unsupported_content_type"},
+		{newUploadRequest(t, "file", "a.png", []byte("\x89PNG\r\n\x1a\nbroken")), http.StatusBadRequest, "invalid_image"},
+		{newUploadRequest(t, "file", "a.png", testPNG(11, 10)), http.StatusBadRequest, "invalid_image"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c.Upload(ctx, w, test.r)
+		assert.Equal(test.code, w.Code, test.err)
+		assert.Contains(w.Body.String(), `"code":"`+test.err+`"`)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(files, "Rejected uploads must not be stored")
+}
+
+func TestCreateRemoveAttachments(t *testing.T) {
+	assert := assert.New(t)
+	store, dir := newTestBlobStore(t)
+	defer os.RemoveAll(dir)
+	for _, meta := range []*attachmentMeta{
+		{Attachment: model.Attachment{ID: "a1", ContentType: "image/png", Size: 3}, UID: "uid123"},
+		{Attachment: model.Attachment{ID: "a2", ContentType: "image/png", Size: 3}, UID: "uid456"},
+		{Attachment: model.Attachment{ID: "a3", ContentType: "image/png", Size: 3}, UID: "uid123", PostID: "other"},
+	} {
+		store.Put(attachmentKey(meta.ID, "data"), strings.NewReader("png"), meta.ContentType)
+		saveAttachmentMeta(store, meta)
+	}
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			return post, nil
+		},
+		removeFn: func(p *model.Post) error {
+			p.DeletedAt = time.Now()
+			return nil
+		},
+		purgeFn: func(p *model.Post) error {
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	c := &PostController{
+		Model:       mockModel,
+		Attachments: store,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+
+	const invalid = `{"data":{"type":"posts","attributes":{"message":"test message"},"relationships":{"attachments":{"data":[` +
+		`{"type":"attachments","id":"a2"},{"type":"attachments","id":"a3"},{"type":"attachments","id":"missing"},{"type":"posts","id":"a1"}]}}}}`
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create", strings.NewReader(invalid))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	for _, pointer := range []string{"/data/relationships/attachments/data/0/id", "/data/relationships/attachments/data/1/id", "/data/relationships/attachments/data/2/id", "/data/relationships/attachments/data/3/type"} {
+		assert.Contains(w.Body.String(), `"pointer":"`+pointer+`"`)
+	}
+	assert.Nil(post)
+
+	const input = `{"data":{"type":"posts","attributes":{"message":"test message"},"relationships":{"attachments":{"data":[{"type":"attachments","id":"a1"}]}}}}`
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://create", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"relationships":{"attachments":{"data":[{"type":"attachments","id":"a1"}]}`)
+	assert.Contains(w.Body.String(), `"included":[{"type":"attachments","id":"a1"`)
+	if assert.NotNil(post) {
+		assert.Equal([]model.Attachment{{ID: "a1", ContentType: "image/png", Size: 3}}, post.Attachments)
+	}
+	meta, err := loadAttachmentMeta(store, "a1")
+	if assert.NoError(err) {
+		assert.Equal("id", meta.PostID, "Attachment must be marked as attached")
+	}
+	assert.Equal(map[string]string{"a1": "id"}, mockModel.claimed)
+
+	// Attachments can not be attached twice
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://create", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+
+	// Posts racing for the same attachment pass the check of the metadata, only the first claim succeeds
+	saveAttachmentMeta(store, &attachmentMeta{Attachment: model.Attachment{ID: "a4", ContentType: "image/png", Size: 3}, UID: "uid123"})
+	saveAttachmentMeta(store, &attachmentMeta{Attachment: model.Attachment{ID: "a5", ContentType: "image/png", Size: 3}, UID: "uid123"})
+	mockModel.claimed["a5"] = "racing"
+	saved := post
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://create", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"test message"},"relationships":{"attachments":{"data":[`+
+		`{"type":"attachments","id":"a4"},{"type":"attachments","id":"a5"}]}}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"pointer":"/data/relationships/attachments/data/1/id"`)
+	assert.Equal(saved, post, "Posts must not be saved if an attachment can not be claimed")
+	_, ok := mockModel.claimed["a4"]
+	assert.False(ok, "Claims must be released if another attachment can not be claimed")
+	meta, _ = loadAttachmentMeta(store, "a4")
+	assert.Empty(meta.PostID)
+
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "id"})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "http://remove", nil)
+	c.Remove(ctx, w, r)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	_, err = store.Get(attachmentKey("a1", "data"))
+	assert.NoError(err, "Attachments must be kept until the post is purged")
+
+	mockModel.deletedPosts = []*model.Post{post}
+	n, err := c.Purge(time.Now().Add(DefaultRestoreWindow + time.Minute))
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, err = store.Get(attachmentKey("a1", "data"))
+	assert.Equal(blob.ErrNotFound, err, "Attachments must be removed with the purged post")
+	_, err = loadAttachmentMeta(store, "a1")
+	assert.Equal(blob.ErrNotFound, err)
+}
+
+func TestPurgeUnattached(t *testing.T) {
+	assert := assert.New(t)
+	store, dir := newTestBlobStore(t)
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	for _, meta := range []*attachmentMeta{
+		{Attachment: model.Attachment{ID: "old"}, CreatedAt: now.Add(-2 * time.Hour)},
+		{Attachment: model.Attachment{ID: "legacy"}},
+		{Attachment: model.Attachment{ID: "new"}, CreatedAt: now.Add(-time.Minute)},
+		{Attachment: model.Attachment{ID: "attached"}, CreatedAt: now.Add(-2 * time.Hour), PostID: "id"},
+	} {
+		store.Put(attachmentKey(meta.ID, "data"), strings.NewReader("png"), "image/png")
+		saveAttachmentMeta(store, meta)
+	}
+	c := &AttachmentController{Store: store, UnattachedTTL: time.Hour}
+	n, err := c.PurgeUnattached(now)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	keys, err := store.List("attachments/")
+	assert.NoError(err)
+	assert.Equal([]string{
+		"attachments/attached/data", "attachments/attached/meta",
+		"attachments/new/data", "attachments/new/meta",
+	}, keys, "Only unattached uploads older than the TTL are purged")
+}
@@ -21,10 +21,12 @@ type AuthDataProvider interface {
 }
 
 // AuthController handles login using oidc and logout.
+// If Webhooks is set, new users are published to webhooks.
 type AuthController struct {
 	Data         AuthDataProvider
 	Provider     oidc.Provider
 	ProviderName string
+	Webhooks     WebhookPublisher
 }
 
 // NewAuthController creates a new instance associated with an oidc provider.
@@ -105,6 +107,7 @@ func (c *AuthController) loginUser(uuid, username string) (*model.User, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Could not save new user: %s", err)
 		}
+		publish(c.Webhooks, model.EventUserCreated, userResource(u))
 	}
 	err = c.Data.UpdateLastLogin(u.ID)
 	if err != nil {
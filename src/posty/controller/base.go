@@ -2,9 +2,13 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"posty/jsonapi"
+	"strconv"
 
 	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -14,32 +18,68 @@ const (
 	cErrServer = http.StatusInternalServerError
 )
 
-type errorResponse struct {
-	Errors []controllerError `json:"errors"`
-}
-
 // jsonError writes a json error object with a message and status code to the the responsewriter.
 func jsonError(w http.ResponseWriter, r *http.Request, code int, msg string) {
-	log.Warnf("JSON Error: %d %s", code, msg)
+	jsonErrors(w, r, code, &jsonapi.Error{
+		Title: msg,
+	})
+}
 
-	cerr := controllerError{
-		Status: code,
-		Title:  msg,
+// jsonErrors writes a json error document containing all given errors to the responsewriter.
+// Use it to report errors with a code, detail or source.
+func jsonErrors(w http.ResponseWriter, r *http.Request, code int, errs ...*jsonapi.Error) {
+	for _, e := range errs {
+		log.Warnf("JSON Error: %d %s %s", code, e.Title, e.Detail)
 	}
-	errResp := errorResponse{
-		Errors: []controllerError{
-			cerr,
-		},
+	jsonapi.WriteErrors(w, code, errs...)
+}
+
+// urlParam returns the url parameter identified by name.
+func urlParam(ctx context.Context, name string) (string, bool) {
+	urlParams, ok := ctx.Value("urlparams").(map[string]string)
+	if !ok {
+		return "", false
 	}
-	b, err := json.Marshal(errResp)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	v, ok := urlParams[name]
+	return v, ok
+}
+
+// limitBody limits the body of the request to max bytes. Reads beyond max fail with an *http.MaxBytesError,
+// report them with tooLarge.
+func limitBody(w http.ResponseWriter, r *http.Request, max int64) {
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+}
+
+// tooLarge writes a json error with status code `http.StatusRequestEntityTooLarge` if err reports a body
+// exceeding the limit set by limitBody. It returns false for any other error.
+func tooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var mbe *http.MaxBytesError
+	if !errors.As(err, &mbe) {
+		return false
 	}
-	w.WriteHeader(code)
-	w.Write(b)
+	jsonErrors(w, r, http.StatusRequestEntityTooLarge, &jsonapi.Error{
+		Code:   "request_too_large",
+		Title:  "Request too large",
+		Detail: "Request must not be larger than " + strconv.FormatInt(mbe.Limit, 10) + " bytes",
+	})
+	return true
 }
 
-type controllerError struct {
-	Status int    `json:"status,string"`
-	Title  string `json:"title"`
+// decodeBody decodes the json body of the request, limited to max bytes, into v.
+// Otherwise it writes a json error, `request_too_large` or `invalid_document`, and returns false.
+func decodeBody(w http.ResponseWriter, r *http.Request, max int64, v interface{}) bool {
+	limitBody(w, r, max)
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	if !tooLarge(w, r, err) {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_document",
+			Title:  "Invalid document",
+			Detail: err.Error(),
+		})
+	}
+	return false
 }
@@ -3,6 +3,7 @@ package controller
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,3 +17,25 @@ func TestJsonError(t *testing.T) {
 	assert.Equal(output, w.Body.String(), "Invalid response")
 	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
 }
+
+func TestDecodeBody(t *testing.T) {
+	assert := assert.New(t)
+	for _, test := range []struct {
+		body string
+		ok   bool
+		code int
+		err  string
+	}{
+		{`{"a":1}`, true, http.StatusOK, ""},
+		{`{"a":12}`, false, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{`{"a":"` + strings.Repeat("b", 100) + `"}`, false, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{`{"a"`, false, http.StatusBadRequest, "invalid_document"},
+	} {
+		r, _ := http.NewRequest("POST", "http://limit", strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		var v struct{ A interface{} }
+		assert.Equal(test.ok, decodeBody(w, r, 7, &v), test.body)
+		assert.Equal(test.code, w.Code, test.body)
+		assert.Contains(w.Body.String(), test.err, test.body)
+	}
+}
@@ -0,0 +1,418 @@
+package controller
+
+import (
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/validation"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ConversationDataProvider defines the needed model interactions.
+type ConversationDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	OpenConversation(uid, other string) (*model.Conversation, bool, error)
+	GetConversation(id, uid string) (*model.Conversation, error)
+	GetConversations(uid string) ([]*model.Conversation, error)
+	NewMessage(c *model.Conversation, text string) *model.Message
+	SaveMessage(c *model.Conversation, m *model.Message) error
+	GetMessages(conversationID string) ([]*model.Message, error)
+	MarkRead(c *model.Conversation) error
+}
+
+// ConversationController handles private one-to-one conversations. Only the two participants can read and send messages.
+// MessageRules restrict sent messages, DefaultDirectMessageRules are used if they are not set.
+type ConversationController struct {
+	Model        ConversationDataProvider
+	MessageRules *validation.Rules
+}
+
+// DefaultDirectMessageRules are the rules of direct messages if no rules are configured.
+var DefaultDirectMessageRules = &validation.Rules{
+	MinLength: 1,
+	MaxLength: 1000,
+}
+
+// conversationResource converts a conversation to its JSON API representation as seen by its viewing participant.
+func conversationResource(c *model.Conversation) *jsonapi.Resource {
+	return &jsonapi.Resource{
+		Type: "conversations",
+		ID:   c.ID,
+		Attributes: map[string]interface{}{
+			"unread":          c.Unread,
+			"last_message_at": timeAttribute(c.LastMessageAt),
+			"created_at":      c.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"participant": {
+				Links: &jsonapi.Links{
+					Related: "/api/users/" + c.Other,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   c.Other,
+				},
+			},
+			"messages": {
+				Links: &jsonapi.Links{
+					Related: "/api/conversations/" + c.ID + "/messages",
+				},
+			},
+		},
+		Links: &jsonapi.Links{
+			Self: "/api/conversations/" + c.ID,
+		},
+	}
+}
+
+// messageResource converts a direct message to its JSON API representation.
+func messageResource(m *model.Message) *jsonapi.Resource {
+	return &jsonapi.Resource{
+		Type: "messages",
+		ID:   m.ID,
+		Attributes: map[string]interface{}{
+			"text":       m.Text,
+			"created_at": m.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"author": {
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   m.UID,
+				},
+			},
+			"conversation": {
+				Links: &jsonapi.Links{
+					Related: "/api/conversations/" + m.ConversationID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "conversations",
+					ID:   m.ConversationID,
+				},
+			},
+		},
+	}
+}
+
+// includedUsers returns the resources of the users which could be found, each user once.
+func includedUsers(lookup userLookup, ids []string, fs jsonapi.Fieldsets) ([]*jsonapi.Resource, error) {
+	included := []*jsonapi.Resource{}
+	if len(ids) == 0 {
+		return included, nil
+	}
+	users, err := lookup.GetUsersByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		u, ok := users[id]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		res := userResource(u)
+		fs.Apply(res)
+		included = append(included, res)
+	}
+	return included, nil
+}
+
+// writeConversation writes a document containing the conversation as primary data.
+func (c *ConversationController) writeConversation(w http.ResponseWriter, r *http.Request, code int, conv *model.Conversation, include []string, fs jsonapi.Fieldsets) {
+	res := conversationResource(conv)
+	fs.Apply(res)
+	var ids []string
+	if jsonapi.Includes(include, "participant") {
+		ids = append(ids, conv.Other)
+	}
+	included, err := includedUsers(c.Model, ids, fs)
+	if err != nil {
+		log.Warnf("Could not lookup participant: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err = jsonapi.Write(w, code, &jsonapi.Document{
+		Data:     res,
+		Included: included,
+	})
+	if err != nil {
+		log.Warnf("Could not write conversation: %s", err)
+	}
+}
+
+// conversation loads the conversation identified by the id url parameter as seen by the logged in user.
+// Conversations the user does not participate in are reported as not found, otherwise an error is written.
+func (c *ConversationController) conversation(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.Conversation, bool) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return nil, false
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return nil, false
+	}
+	conv, err := c.Model.GetConversation(id, user)
+	if err == model.ErrNotParticipant {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return nil, false
+	}
+	if err != nil {
+		log.Warnf("Could not get conversation %s: %s", id, err)
+		jsonError(w, r, cErrServer, "")
+		return nil, false
+	}
+	return conv, true
+}
+
+// Conversations returns the conversations of the logged in user, the most recently active first.
+// The other participants are included unless requested otherwise using the `include` parameter.
+func (c *ConversationController) Conversations(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, []string{"participant"})
+	if !ok {
+		return
+	}
+	convs, err := c.Model.GetConversations(user)
+	if err != nil {
+		log.Warnf("Could not get conversations of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	data := make([]*jsonapi.Resource, len(convs))
+	var ids []string
+	for i, conv := range convs {
+		data[i] = conversationResource(conv)
+		fs.Apply(data[i])
+		if jsonapi.Includes(include, "participant") {
+			ids = append(ids, conv.Other)
+		}
+	}
+	included, err := includedUsers(c.Model, ids, fs)
+	if err != nil {
+		log.Warnf("Could not lookup participants: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data:     data,
+		Included: included,
+		Links: &jsonapi.Links{
+			Self: "/api/conversations",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write conversations: %s", err)
+	}
+}
+
+// Conversation returns the conversation identified by the id url parameter.
+func (c *ConversationController) Conversation(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	include, fs, ok := parseQuery(w, r, []string{"participant"})
+	if !ok {
+		return
+	}
+	conv, ok := c.conversation(ctx, w, r)
+	if !ok {
+		return
+	}
+	c.writeConversation(w, r, http.StatusOK, conv, include, fs)
+}
+
+type conversationCreateReq struct {
+	Data struct {
+		Type          string `json:"type"`
+		Relationships struct {
+			Participant struct {
+				Data *jsonapi.Identifier `json:"data"`
+			} `json:"participant"`
+		} `json:"relationships"`
+	} `json:"data"`
+}
+
+// Open handles requests to open a conversation with another user.
+//
+// Example request: `{"data":{"type":"conversations","relationships":{"participant":{"data":{"type":"users","id":"uid456"}}}}}`
+//
+// A new conversation is returned with status code http.StatusCreated.
+// If the users already have a conversation, it is returned with status code http.StatusOK.
+func (c *ConversationController) Open(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, []string{"participant"})
+	if !ok {
+		return
+	}
+	var req conversationCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "conversations" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'conversations'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	participantErr := &jsonapi.Error{
+		Code:   "invalid_participant",
+		Title:  "Invalid participant",
+		Detail: "Participant must be another existing user",
+		Source: &jsonapi.ErrorSource{
+			Pointer: "/data/relationships/participant/data",
+		},
+	}
+	other := req.Data.Relationships.Participant.Data
+	if other == nil || other.Type != "users" || other.ID == "" || other.ID == user {
+		jsonErrors(w, r, cErrClient, participantErr)
+		return
+	}
+	users, err := c.Model.GetUsersByIDs([]string{other.ID})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if _, ok := users[other.ID]; !ok {
+		jsonErrors(w, r, cErrClient, participantErr)
+		return
+	}
+	conv, created, err := c.Model.OpenConversation(user, other.ID)
+	if err != nil {
+		log.Warnf("Could not open conversation: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+		w.Header().Set("Location", "/api/conversations/"+conv.ID)
+	}
+	c.writeConversation(w, r, code, conv, include, fs)
+}
+
+// Messages returns the messages of the conversation identified by the id url parameter, oldest first.
+// The unread counter of the logged in user is reset.
+func (c *ConversationController) Messages(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	include, fs, ok := parseQuery(w, r, []string{"author"})
+	if !ok {
+		return
+	}
+	conv, ok := c.conversation(ctx, w, r)
+	if !ok {
+		return
+	}
+	messages, err := c.Model.GetMessages(conv.ID)
+	if err != nil {
+		log.Warnf("Could not get messages of conversation %s: %s", conv.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if conv.Unread > 0 {
+		// messages received in the meantime stay unread
+		if err := c.Model.MarkRead(conv); err != nil && err != model.ErrConversationChanged {
+			log.Warnf("Could not mark conversation %s as read: %s", conv.ID, err)
+		}
+	}
+	data := make([]*jsonapi.Resource, len(messages))
+	var ids []string
+	for i, m := range messages {
+		data[i] = messageResource(m)
+		fs.Apply(data[i])
+		if jsonapi.Includes(include, "author") {
+			ids = append(ids, m.UID)
+		}
+	}
+	included, err := includedUsers(c.Model, ids, fs)
+	if err != nil {
+		log.Warnf("Could not lookup participants: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data:     data,
+		Included: included,
+		Links: &jsonapi.Links{
+			Self: "/api/conversations/" + conv.ID + "/messages",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write messages: %s", err)
+	}
+}
+
+type messageCreateReq struct {
+	Data struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			Text string `json:"text"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Send handles requests to send a message to the conversation identified by the id url parameter.
+//
+// Example request: `{"data":{"type":"messages","attributes":{"text":"hello"}}}`
+//
+// The text is normalised and checked against the MessageRules.
+// On success the message is returned with status code http.StatusCreated and counts as unread for the other participant.
+func (c *ConversationController) Send(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	conv, ok := c.conversation(ctx, w, r)
+	if !ok {
+		return
+	}
+	var req messageCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "messages" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'messages'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	rules := c.MessageRules
+	if rules == nil {
+		rules = DefaultDirectMessageRules
+	}
+	text, verrs := rules.Check(req.Data.Attributes.Text)
+	if len(verrs) > 0 {
+		jsonErrors(w, r, cErrClient, fieldErrors("text", verrs)...)
+		return
+	}
+	m := c.Model.NewMessage(conv, text)
+	if err := c.Model.SaveMessage(conv, m); err != nil {
+		log.Warnf("Could not save message to conversation %s: %s", conv.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err := jsonapi.Write(w, http.StatusCreated, &jsonapi.Document{
+		Data: messageResource(m),
+	})
+	if err != nil {
+		log.Warnf("Could not write message: %s", err)
+	}
+}
@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockConversationModel keeps conversations in memory, keyed by participant and conversation id.
+type mockConversationModel struct {
+	users    map[string]*model.User
+	convs    map[string]*model.Conversation
+	messages []*model.Message
+	// changed simulates messages arriving after the conversation was fetched
+	changed bool
+}
+
+func newMockConversationModel() *mockConversationModel {
+	return &mockConversationModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one"},
+			"uid2": {ID: "uid2", Username: "two"},
+			"uid3": {ID: "uid3", Username: "three"},
+		},
+		convs: map[string]*model.Conversation{},
+	}
+}
+
+func (m *mockConversationModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockConversationModel) OpenConversation(uid, other string) (*model.Conversation, bool, error) {
+	id := model.ConversationID(uid, other)
+	if c, ok := m.convs[uid+"/"+id]; ok {
+		return c, false, nil
+	}
+	ts := time.Unix(1448272067, 0)
+	m.convs[uid+"/"+id] = &model.Conversation{ID: id, UID: uid, Other: other, CreatedAt: ts}
+	m.convs[other+"/"+id] = &model.Conversation{ID: id, UID: other, Other: uid, CreatedAt: ts}
+	return m.convs[uid+"/"+id], true, nil
+}
+
+func (m *mockConversationModel) GetConversation(id, uid string) (*model.Conversation, error) {
+	c, ok := m.convs[uid+"/"+id]
+	if !ok {
+		return nil, model.ErrNotParticipant
+	}
+	return c, nil
+}
+
+func (m *mockConversationModel) GetConversations(uid string) ([]*model.Conversation, error) {
+	var res []*model.Conversation
+	for _, c := range m.convs {
+		if c.UID == uid {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockConversationModel) NewMessage(c *model.Conversation, text string) *model.Message {
+	return &model.Message{
+		ID:             "mid" + strconv.Itoa(len(m.messages)+1),
+		ConversationID: c.ID,
+		UID:            c.UID,
+		Text:           text,
+		CreatedAt:      time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockConversationModel) SaveMessage(c *model.Conversation, msg *model.Message) error {
+	m.messages = append(m.messages, msg)
+	m.convs[c.Other+"/"+c.ID].Unread++
+	return nil
+}
+
+func (m *mockConversationModel) GetMessages(conversationID string) ([]*model.Message, error) {
+	var res []*model.Message
+	for _, msg := range m.messages {
+		if msg.ConversationID == conversationID {
+			res = append(res, msg)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockConversationModel) MarkRead(c *model.Conversation) error {
+	if m.changed {
+		return model.ErrConversationChanged
+	}
+	c.Unread = 0
+	return nil
+}
+
+func conversationRequest(user, id, body string) (context.Context, *http.Request) {
+	ctx := context.WithValue(context.Background(), "user", user)
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+	method := "GET"
+	if body != "" {
+		method = "POST"
+	}
+	r, _ := http.NewRequest(method, "http://conversations", strings.NewReader(body))
+	return ctx, r
+}
+
+func TestOpenConversation(t *testing.T) {
+	assert := assert.New(t)
+	m := newMockConversationModel()
+	c := &ConversationController{Model: m}
+	open := func(user, other string) *httptest.ResponseRecorder {
+		ctx, r := conversationRequest(user, "", `{"data":{"type":"conversations","relationships":{"participant":{"data":{"type":"users","id":"`+other+`"}}}}}`)
+		w := httptest.NewRecorder()
+		c.Open(ctx, w, r)
+		return w
+	}
+
+	for _, other := range []string{"uid1", "missing", ""} {
+		w := open("uid1", other)
+		assert.Equal(http.StatusBadRequest, w.Code, other)
+		assert.Contains(w.Body.String(), `"code":"invalid_participant"`)
+	}
+
+	w := open("uid1", "uid2")
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal("/api/conversations/uid1:uid2", w.Header().Get("Location"))
+	assert.Contains(w.Body.String(), `"relationships":{"messages":{"links":{"related":"/api/conversations/uid1:uid2/messages"},"data":null},"participant":{"links":{"related":"/api/users/uid2"},"data":{"type":"users","id":"uid2"}}}`)
+	assert.Contains(w.Body.String(), `"included":[{"type":"users","id":"uid2"`)
+
+	w = open("uid2", "uid1")
+	assert.Equal(http.StatusOK, w.Code, "Existing conversations are returned")
+	assert.Contains(w.Body.String(), `"id":"uid1:uid2"`)
+
+	w = open("uid1", "uid3"+strings.Repeat(" ", DefaultMaxBodySize))
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"request_too_large"`)
+}
+
+func TestConversationMessages(t *testing.T) {
+	assert := assert.New(t)
+	m := newMockConversationModel()
+	m.OpenConversation("uid1", "uid2")
+	c := &ConversationController{Model: m}
+
+	// Only participants can read and send messages
+	for _, user := range []string{"uid3", "uid1"} {
+		ctx, r := conversationRequest(user, "uid1:uid3", "")
+		w := httptest.NewRecorder()
+		c.Messages(ctx, w, r)
+		assert.Equal(http.StatusNotFound, w.Code, user)
+	}
+	ctx, r := conversationRequest("uid3", "uid1:uid2", `{"data":{"type":"messages","attributes":{"text":"hello"}}}`)
+	w := httptest.NewRecorder()
+	c.Send(ctx, w, r)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+	assert.Empty(m.messages)
+
+	ctx, r = conversationRequest("uid1", "uid1:uid2", `{"data":{"type":"messages","attributes":{"text":"  "}}}`)
+	w = httptest.NewRecorder()
+	c.Send(ctx, w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"text_too_short"`)
+
+	ctx, r = conversationRequest("uid1", "uid1:uid2", `{"data":{"type":"messages","attributes":{"text":" hello "}}}`)
+	w = httptest.NewRecorder()
+	c.Send(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"attributes":{"created_at":1448272067,"text":"hello"}`)
+
+	ctx, r = conversationRequest("uid2", "", "")
+	w = httptest.NewRecorder()
+	c.Conversations(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"unread":1`)
+
+	ctx, r = conversationRequest("uid2", "uid1:uid2", "")
+	w = httptest.NewRecorder()
+	c.Messages(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"id":"mid1"`)
+	assert.Contains(w.Body.String(), `"included":[{"type":"users","id":"uid1"`)
+	assert.Equal(0, m.convs["uid2/uid1:uid2"].Unread, "Reading messages resets the unread counter")
+	assert.Equal(0, m.convs["uid1/uid1:uid2"].Unread)
+
+	ctx, r = conversationRequest("uid1", "uid1:uid2", `{"data":{"type":"messages","attributes":{"text":"again"}}}`)
+	c.Send(ctx, httptest.NewRecorder(), r)
+	m.changed = true
+	ctx, r = conversationRequest("uid2", "uid1:uid2", "")
+	w = httptest.NewRecorder()
+	c.Messages(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(1, m.convs["uid2/uid1:uid2"].Unread, "Messages arriving meanwhile stay unread")
+}
@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"posty/mail"
+	"posty/model"
+	"posty/token"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// EmailDataProvider defines the model interactions needed to send digests.
+type EmailDataProvider interface {
+	GetUsersPage(after string, limit int) ([]*model.User, string, error)
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	QueryPosts(q model.PostQuery) ([]*model.Post, error)
+	GetPublished(since, until time.Time) ([]*model.Post, error)
+}
+
+// Emailer emails users about posts mentioning them and sends digests of new posts.
+// Links point to the frontend at BaseURL, every email contains an unsubscribe link signed by Signer.
+// Digests list up to MaxDigestPosts posts, DefaultMaxDigestPosts is used if it is not set.
+// Digests are sent by DigestMailer, Mailer is used if it is not set. As digests are sent in the background,
+// DigestMailer should deliver them directly instead of dropping them if a queue is full.
+// The runs of digests are recorded by Jobs, so digests are sent once per interval by all instances together.
+type Emailer struct {
+	Model          EmailDataProvider
+	Mailer         mail.Mailer
+	DigestMailer   mail.Mailer
+	Signer         *token.Signer
+	Jobs           model.JobPeer
+	BaseURL        string
+	MaxDigestPosts int
+}
+
+// DefaultMaxDigestPosts is the number of posts listed by a digest if none is configured.
+const DefaultMaxDigestPosts = 20
+
+// UnsubscribeTokenPurpose is the purpose of the signer of unsubscribe links.
+const UnsubscribeTokenPurpose = "unsubscribe"
+
+// DigestJob is the name of the job sending digests.
+const DigestJob = "digest"
+
+// digestPageSize is the number of users loaded at once while sending digests.
+const digestPageSize = 100
+
+// wallURL returns the url of the frontend.
+func (e *Emailer) wallURL() string {
+	return strings.TrimRight(e.BaseURL, "/") + "/"
+}
+
+// unsubscribeURL returns the link muting the kind of email for the user.
+func (e *Emailer) unsubscribeURL(uid, kind string) string {
+	return e.wallURL() + "unsubscribe?token=" + url.QueryEscape(e.Signer.Token(uid, kind))
+}
+
+// mentions emails the users mentioned in the post of the author who want to receive emails about mentions.
+// Failures are logged, emails never fail the creation of the post.
+func (e *Emailer) mentions(recipients map[string]*model.User, author *model.User, post *model.Post) {
+	for _, u := range recipients {
+		if u.ID == author.ID || u.Email == "" || !u.Notifies(model.NotificationMentionEmail) {
+			continue
+		}
+		m, err := mail.MentionMessage(u.Email, &mail.MentionData{
+			Username:       u.Username,
+			Author:         author.Username,
+			Message:        post.Message,
+			WallURL:        e.wallURL(),
+			UnsubscribeURL: e.unsubscribeURL(u.ID, model.NotificationMentionEmail),
+		})
+		if err == nil {
+			err = e.Mailer.Send(m)
+		}
+		if err != nil {
+			log.Warnf("Could not email %s about post %s: %s", u.ID, post.ID, err)
+		}
+	}
+}
+
+// SendDueDigests sends the digests of the posts published since the last run recorded by Jobs if it is at least interval ago.
+// The run is claimed before sending, so only one of the instances calling SendDueDigests sends the digests of an interval.
+// The first call only records the run, as there is no start of the interval yet.
+// Digests which could not be sent are not sent again by the next run.
+// The number of sent digests is returned.
+func (e *Emailer) SendDueDigests(interval time.Duration, now time.Time) (int, error) {
+	last, err := e.Jobs.LastRun(DigestJob)
+	if err != nil {
+		return 0, err
+	}
+	if !last.IsZero() && now.Sub(last) < interval {
+		return 0, nil
+	}
+	if err := e.Jobs.ClaimRun(DigestJob, last, now); err == model.ErrJobClaimed {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if last.IsZero() {
+		return 0, nil
+	}
+	return e.SendDigests(last, now)
+}
+
+// digestPosts returns the posts visible to everyone which were published since the given time, newest first.
+// Scheduled posts may be created before since, they are part of the digest following their publication.
+func (e *Emailer) digestPosts(since, now time.Time) ([]*model.Post, error) {
+	ps, err := e.Model.QueryPosts(model.PostQuery{Since: since, Until: now})
+	if err != nil {
+		return nil, err
+	}
+	scheduled, err := e.Model.GetPublished(since, now)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(ps)+len(scheduled))
+	public := make([]*model.Post, 0, len(ps)+len(scheduled))
+	for _, post := range append(ps, scheduled...) {
+		if seen[post.ID] {
+			continue
+		}
+		seen[post.ID] = true
+		if visible(post, "") && !post.PublishedAt().Before(since) && !post.PublishedAt().After(now) {
+			public = append(public, post)
+		}
+	}
+	sort.Sort(model.ByPublishedAtDESC(public))
+	return public, nil
+}
+
+// SendDigests emails the posts published since the given time to every user who wants to receive the digest.
+// Posts of the recipient and posts not visible to everyone are left out, users without new posts get no digest.
+// Users are loaded page by page, failures of single digests are logged.
+// The number of sent digests is returned.
+func (e *Emailer) SendDigests(since, now time.Time) (int, error) {
+	public, err := e.digestPosts(since, now)
+	if err != nil || len(public) == 0 {
+		return 0, err
+	}
+	authors := make([]string, 0, len(public))
+	for _, post := range public {
+		authors = append(authors, post.UID)
+	}
+	users, err := e.Model.GetUsersByIDs(authors)
+	if err != nil {
+		return 0, err
+	}
+	names := make(map[string]string, len(users))
+	for id, u := range users {
+		names[id] = u.Username
+	}
+	sent := 0
+	after := ""
+	for {
+		page, next, err := e.Model.GetUsersPage(after, digestPageSize)
+		if err != nil {
+			return sent, err
+		}
+		sent += e.sendDigestPage(page, public, names, since)
+		if next == "" {
+			return sent, nil
+		}
+		after = next
+	}
+}
+
+// sendDigestPage emails the digests of the posts to the users of a page and returns the number of sent digests.
+func (e *Emailer) sendDigestPage(users []*model.User, public []*model.Post, names map[string]string, since time.Time) int {
+	max := e.MaxDigestPosts
+	if max <= 0 {
+		max = DefaultMaxDigestPosts
+	}
+	mailer := e.DigestMailer
+	if mailer == nil {
+		mailer = e.Mailer
+	}
+	sent := 0
+	for _, u := range users {
+		if u.Email == "" || !u.Notifies(model.NotificationDigest) {
+			continue
+		}
+		d := &mail.DigestData{
+			Username:       u.Username,
+			Since:          since,
+			WallURL:        e.wallURL(),
+			UnsubscribeURL: e.unsubscribeURL(u.ID, model.NotificationDigest),
+		}
+		for _, post := range public {
+			if post.UID == u.ID {
+				continue
+			}
+			if len(d.Posts) == max {
+				d.More++
+				continue
+			}
+			d.Posts = append(d.Posts, mail.DigestPost{
+				Author:    names[post.UID],
+				Message:   post.Message,
+				CreatedAt: post.PublishedAt(),
+			})
+		}
+		if len(d.Posts) == 0 {
+			continue
+		}
+		m, err := mail.DigestMessage(u.Email, d)
+		if err == nil {
+			err = mailer.Send(m)
+		}
+		if err != nil {
+			log.Warnf("Could not send digest to %s: %s", u.ID, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// UnsubscribeDataProvider defines the needed model interactions.
+type UnsubscribeDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	UpdateMutedNotifications(uid string, kinds []string) error
+}
+
+// UnsubscribeController handles the unsubscribe links of emails, they do not require a session.
+// The token of a link is signed by Signer for UnsubscribeTokenPurpose and names the user and the kind of email.
+type UnsubscribeController struct {
+	Model  UnsubscribeDataProvider
+	Signer *token.Signer
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!doctype html>
+<html><head><meta charset="utf-8"><title>Unsubscribe - posty</title></head>
+<body>
+{{if .Error}}<p>{{.Error}}</p>
+{{else if .Done}}<p>You will no longer receive these emails. You can turn them on again in your notification preferences.</p>
+{{else}}<form method="post"><p>Stop receiving these emails from posty?</p><button type="submit">Unsubscribe</button></form>
+{{end}}</body></html>
+`))
+
+type unsubscribePageData struct {
+	Error string
+	Done  bool
+}
+
+func writeUnsubscribePage(w http.ResponseWriter, code int, data *unsubscribePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := unsubscribePage.Execute(w, data); err != nil {
+		log.Warnf("Could not write unsubscribe page: %s", err)
+	}
+}
+
+// Unsubscribe handles unsubscribe links. The `token` query parameter is required.
+//
+// GET requests show a confirmation form, so link scanners of mail providers do not unsubscribe users.
+// POST requests, sent by the form or by mail clients supporting one-click unsubscribe (RFC 8058), mute the kind of email.
+// Invalid tokens are rejected with status code http.StatusBadRequest.
+func (c *UnsubscribeController) Unsubscribe(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	fields, err := c.Signer.Verify(r.URL.Query().Get("token"))
+	if err != nil || len(fields) != 2 || !model.ValidNotificationKind(fields[1]) {
+		writeUnsubscribePage(w, cErrClient, &unsubscribePageData{Error: "This unsubscribe link is not valid."})
+		return
+	}
+	uid, kind := fields[0], fields[1]
+	if r.Method != "POST" {
+		writeUnsubscribePage(w, http.StatusOK, &unsubscribePageData{})
+		return
+	}
+	users, err := c.Model.GetUsersByIDs([]string{uid})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		writeUnsubscribePage(w, cErrServer, &unsubscribePageData{Error: "Something went wrong, please try again later."})
+		return
+	}
+	u, ok := users[uid]
+	if !ok {
+		writeUnsubscribePage(w, cErrClient, &unsubscribePageData{Error: "This unsubscribe link is not valid."})
+		return
+	}
+	if u.Notifies(kind) {
+		muted := append(append([]string{}, u.Muted...), kind)
+		if err := c.Model.UpdateMutedNotifications(u.ID, muted); err != nil {
+			log.Warnf("Could not unsubscribe %s from %s: %s", u.ID, kind, err)
+			writeUnsubscribePage(w, cErrServer, &unsubscribePageData{Error: "Something went wrong, please try again later."})
+			return
+		}
+	}
+	writeUnsubscribePage(w, http.StatusOK, &unsubscribePageData{Done: true})
+}
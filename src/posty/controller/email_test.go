@@ -0,0 +1,339 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"posty/mail"
+	"posty/model"
+	"posty/token"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockMailer records sent emails.
+type mockMailer struct {
+	sent []*mail.Message
+}
+
+func (m *mockMailer) Send(msg *mail.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// mockEmailModel returns at most two users per page like a scan stopping early.
+type mockEmailModel struct {
+	users []*model.User
+	posts []*model.Post
+	query model.PostQuery
+	pages int
+}
+
+func (m *mockEmailModel) GetUsersPage(after string, limit int) ([]*model.User, string, error) {
+	m.pages++
+	start := 0
+	for i, u := range m.users {
+		if u.ID == after {
+			start = i + 1
+		}
+	}
+	end := start + 2
+	if end > len(m.users) {
+		end = len(m.users)
+	}
+	next := ""
+	if end < len(m.users) {
+		next = m.users[end-1].ID
+	}
+	return m.users[start:end], next, nil
+}
+
+func (m *mockEmailModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, u := range m.users {
+		for _, id := range ids {
+			if u.ID == id {
+				res[id] = u
+			}
+		}
+	}
+	return res, nil
+}
+
+func (m *mockEmailModel) QueryPosts(q model.PostQuery) ([]*model.Post, error) {
+	m.query = q
+	var res []*model.Post
+	for _, p := range m.posts {
+		if !p.CreatedAt.Before(q.Since) && !p.CreatedAt.After(q.Until) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockEmailModel) GetPublished(since, until time.Time) ([]*model.Post, error) {
+	var res []*model.Post
+	for _, p := range m.posts {
+		if !p.PublishAt.Before(since) && !p.PublishAt.After(until) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// mockJobPeer records the runs of jobs in memory. LastRun returns stale if it is set,
+// like an instance reading the last run before another instance claimed the next one.
+type mockJobPeer struct {
+	runs  map[string]time.Time
+	stale time.Time
+}
+
+func (m *mockJobPeer) LastRun(name string) (time.Time, error) {
+	if !m.stale.IsZero() {
+		return m.stale, nil
+	}
+	return m.runs[name], nil
+}
+
+func (m *mockJobPeer) ClaimRun(name string, last, now time.Time) error {
+	if !m.runs[name].Equal(last) {
+		return model.ErrJobClaimed
+	}
+	m.runs[name] = now
+	return nil
+}
+
+func TestEmailMentions(t *testing.T) {
+	assert := assert.New(t)
+	users := map[string]*model.User{
+		"uid1": {ID: "uid1", Username: "one", Email: "one@example.com"},
+		"uid2": {ID: "uid2", Username: "two", Email: "two@example.com"},
+		"uid3": {ID: "uid3", Username: "three", Email: "three@example.com", Muted: []string{model.NotificationMentionEmail}},
+		"uid4": {ID: "uid4", Username: "four"},
+	}
+	mockModel := &mockPostPeer{
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{ids[0]: users[ids[0]]}, nil
+		},
+		handlesFn: func(handles []string) (map[string]*model.User, error) {
+			res := make(map[string]*model.User)
+			for _, u := range users {
+				for _, h := range handles {
+					if h == u.Username {
+						res[u.ID] = u
+					}
+				}
+			}
+			return res, nil
+		},
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "pid1", UID: uid}
+		},
+	}
+	var saved *model.Post
+	mockModel.saveFn = func(p *model.Post) error {
+		saved = p
+		return nil
+	}
+	mockModel.postsFn = func(q model.PostQuery) ([]*model.Post, error) {
+		return []*model.Post{saved}, nil
+	}
+	mailer := &mockMailer{}
+	c := &PostController{
+		Model: mockModel,
+		Emails: &Emailer{
+			Mailer:  mailer,
+			Signer:  token.NewSigner([]byte("secret"), UnsubscribeTokenPurpose),
+			BaseURL: "https://posty.example.com",
+		},
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid1")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"Hello @one @two @three @four"}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	if assert.Len(mailer.sent, 1, "Only users with an email address who did not mute mention emails get an email") {
+		m := mailer.sent[0]
+		assert.Equal("two@example.com", m.To)
+		assert.Equal("one mentioned you on posty", m.Subject)
+		assert.Contains(m.Body, "Hello @one @two @three @four")
+		assert.Contains(m.Headers["List-Unsubscribe"], "<https://posty.example.com/unsubscribe?token=")
+	}
+
+	// Scheduled posts email once they are published
+	mailer.sent = nil
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://create?include=", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"Later @two","publish_at":`+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+`}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Empty(mailer.sent)
+	since := time.Now().Add(-time.Minute)
+	saved.PublishAt = since.Add(time.Second)
+	n, err := c.Publish(since, time.Now())
+	assert.NoError(err)
+	assert.Equal(1, n)
+	if assert.Len(mailer.sent, 1) {
+		assert.Equal("two@example.com", mailer.sent[0].To)
+		assert.Contains(mailer.sent[0].Body, "Later @two")
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	since := now.Add(-24 * time.Hour)
+	m := &mockEmailModel{
+		users: []*model.User{
+			{ID: "uid1", Username: "one", Email: "one@example.com"},
+			{ID: "uid2", Username: "two", Email: "two@example.com"},
+			{ID: "uid3", Username: "three", Email: "three@example.com", Muted: []string{model.NotificationDigest}},
+			{ID: "uid4", Username: "four"},
+		},
+		posts: []*model.Post{
+			{ID: "pid1", UID: "uid1", Message: "First", CreatedAt: now.Add(-3 * time.Hour)},
+			{ID: "pid2", UID: "uid2", Message: "Second", CreatedAt: now.Add(-2 * time.Hour)},
+			{ID: "pid3", UID: "uid2", Message: "Hidden", CreatedAt: now.Add(-time.Hour), Hidden: true},
+			{ID: "pid4", UID: "uid2", Message: "Third", CreatedAt: now.Add(-time.Hour)},
+			{ID: "pid5", UID: "uid2", Message: "Published", CreatedAt: now.Add(-48 * time.Hour), PublishAt: now.Add(-90 * time.Minute)},
+			{ID: "pid6", UID: "uid2", Message: "Old", CreatedAt: now.Add(-72 * time.Hour), PublishAt: now.Add(-48 * time.Hour)},
+		},
+	}
+	mailer := &mockMailer{}
+	e := &Emailer{
+		Model:          m,
+		Mailer:         mailer,
+		Signer:         token.NewSigner([]byte("secret"), UnsubscribeTokenPurpose),
+		BaseURL:        "https://posty.example.com/",
+		MaxDigestPosts: 1,
+	}
+	n, err := e.SendDigests(since, now)
+	assert.NoError(err)
+	assert.Equal(model.PostQuery{Since: since, Until: now}, m.query)
+	assert.Equal(2, n, "Scheduled posts created before the digest period are found by their publication")
+	assert.Equal(2, m.pages, "Users are loaded page by page")
+	if assert.Len(mailer.sent, 2) {
+		sent := map[string]*mail.Message{}
+		for _, msg := range mailer.sent {
+			sent[msg.To] = msg
+		}
+		one := sent["one@example.com"]
+		if assert.NotNil(one) {
+			assert.Equal("3 new posts on posty", one.Subject, "Scheduled posts are part of the digest following their publication")
+			assert.Contains(one.Body, "two (")
+			assert.Contains(one.Body, "Third\n")
+			assert.NotContains(one.Body, "Published\n", "Digests are limited to MaxDigestPosts, newest first")
+			assert.NotContains(one.Body, "Hidden")
+			assert.Contains(one.Body, "... and 2 more posts.")
+		}
+		two := sent["two@example.com"]
+		if assert.NotNil(two) {
+			assert.Equal("1 new post on posty", two.Subject, "Own posts are not part of the digest")
+			assert.Contains(two.Body, "First\n")
+		}
+	}
+
+	m.posts = nil
+	n, err = e.SendDigests(since, now)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
+
+func TestSendDueDigests(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Unix(1448272067, 0)
+	m := &mockEmailModel{
+		users: []*model.User{
+			{ID: "uid1", Username: "one", Email: "one@example.com"},
+			{ID: "uid2", Username: "two", Email: "two@example.com"},
+		},
+		posts: []*model.Post{
+			{ID: "pid1", UID: "uid1", Message: "Before", CreatedAt: start.Add(-time.Hour)},
+			{ID: "pid2", UID: "uid1", Message: "First", CreatedAt: start.Add(time.Hour)},
+		},
+	}
+	jobs := &mockJobPeer{runs: map[string]time.Time{}}
+	mailer := &mockMailer{}
+	digests := &mockMailer{}
+	e := &Emailer{
+		Model:        m,
+		Mailer:       mailer,
+		DigestMailer: digests,
+		Signer:       token.NewSigner([]byte("secret"), UnsubscribeTokenPurpose),
+		Jobs:         jobs,
+		BaseURL:      "https://posty.example.com/",
+	}
+	n, err := e.SendDueDigests(24*time.Hour, start)
+	assert.NoError(err)
+	assert.Equal(0, n, "The first run only records the start of the interval")
+	assert.Equal(start, jobs.runs[DigestJob])
+
+	n, err = e.SendDueDigests(24*time.Hour, start.Add(23*time.Hour))
+	assert.NoError(err)
+	assert.Equal(0, n, "Digests are not due before the interval passed")
+	assert.Equal(start, jobs.runs[DigestJob])
+
+	now := start.Add(25 * time.Hour)
+	n, err = e.SendDueDigests(24*time.Hour, now)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal(now, jobs.runs[DigestJob])
+	assert.Empty(mailer.sent, "Digests are sent by the DigestMailer")
+	if assert.Len(digests.sent, 1) {
+		assert.Equal("two@example.com", digests.sent[0].To)
+		assert.Contains(digests.sent[0].Body, "First\n")
+		assert.NotContains(digests.sent[0].Body, "Before", "Posts of the previous interval are not sent again")
+	}
+
+	digests.sent = nil
+	jobs.stale = start
+	n, err = e.SendDueDigests(24*time.Hour, now.Add(time.Minute))
+	assert.NoError(err, "Runs claimed by another instance are no error")
+	assert.Equal(0, n)
+	assert.Empty(digests.sent, "Digests are sent once by all instances together")
+	assert.Equal(now, jobs.runs[DigestJob])
+}
+
+func TestUnsubscribe(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockNotificationModel{
+		mockNotificationPeer: &mockNotificationPeer{},
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one", Muted: []string{model.NotificationVote}},
+		},
+	}
+	signer := token.NewSigner([]byte("secret"), UnsubscribeTokenPurpose)
+	c := &UnsubscribeController{Model: m, Signer: signer}
+	request := func(method, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://posty/unsubscribe?token="+url.QueryEscape(token), strings.NewReader("List-Unsubscribe=One-Click"))
+		c.Unsubscribe(context.Background(), w, r)
+		return w
+	}
+
+	for _, token := range []string{"", "invalid", token.NewSigner([]byte("other"), UnsubscribeTokenPurpose).Token("uid1", model.NotificationDigest), signer.Token("uid1", "unknown"), signer.Token("uid9", model.NotificationDigest)} {
+		w := request("POST", token)
+		assert.Equal(http.StatusBadRequest, w.Code, token)
+		assert.Contains(w.Body.String(), "not valid")
+	}
+	assert.Equal([]string{model.NotificationVote}, m.users["uid1"].Muted)
+
+	token := signer.Token("uid1", model.NotificationDigest)
+	w := request("GET", token)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), `<form method="post">`)
+	assert.Equal([]string{model.NotificationVote}, m.users["uid1"].Muted, "GET requests must not unsubscribe")
+
+	w = request("POST", token)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), "You will no longer receive these emails")
+	assert.Equal([]string{model.NotificationVote, model.NotificationDigest}, m.users["uid1"].Muted)
+	w = request("POST", token)
+	assert.Equal(http.StatusOK, w.Code, "Unsubscribing twice is no error")
+	assert.Len(m.users["uid1"].Muted, 2)
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"posty/feed"
+	"posty/jsonapi"
+	"posty/markdown"
+	"posty/model"
+	"posty/token"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// FeedDataProvider defines the needed model interactions.
+// GetFeedVersion must not be cached, as feed urls are revoked by RotateFeedVersion.
+type FeedDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetFeedVersion(uid string) (int, error)
+	RotateFeedVersion(uid string) (int, error)
+	QueryPosts(q model.PostQuery) ([]*model.Post, error)
+}
+
+// FeedController serves the wall as Atom and RSS feed, messages are rendered to html like by PostController using Renderer.
+// Feeds are private, they are authenticated by tokens of Signer instead of the session so feed readers can fetch them.
+// Tokens contain the feed version of the user, users revoke leaked feed urls by rotating the version.
+// Feeds contain the latest Limit posts, DefaultFeedLimit is used if it is not set. Links point to the frontend at BaseURL.
+type FeedController struct {
+	Model    FeedDataProvider
+	Renderer *markdown.Renderer
+	Signer   *token.Signer
+	BaseURL  string
+	Limit    int
+}
+
+// DefaultFeedLimit is the number of posts in a feed if none is configured.
+const DefaultFeedLimit = 50
+
+// FeedTokenPurpose is the purpose of the signer of feed urls.
+const FeedTokenPurpose = "feed"
+
+// postsFeed names the feed of the wall in tokens.
+const postsFeed = "posts"
+
+// feedEpoch is the date of the tag URI of the feed of the wall.
+var feedEpoch = time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+
+// wallURL returns the url of the frontend.
+func (c *FeedController) wallURL() string {
+	return strings.TrimRight(c.BaseURL, "/") + "/"
+}
+
+// feedToken returns the token of the feed of the wall for the user at the feed version.
+// The version is left out until the user rotated it, so tokens issued before versions were introduced stay valid.
+func (c *FeedController) feedToken(uid string, version int) string {
+	if version == 0 {
+		return c.Signer.Token(uid, postsFeed)
+	}
+	return c.Signer.Token(uid, postsFeed, strconv.Itoa(version))
+}
+
+// verifyToken returns the user and the feed version of a token of the feed of the wall.
+func (c *FeedController) verifyToken(t string) (string, int, bool) {
+	fields, err := c.Signer.Verify(t)
+	if err != nil || len(fields) < 2 || len(fields) > 3 || fields[1] != postsFeed {
+		return "", 0, false
+	}
+	if len(fields) == 2 {
+		return fields[0], 0, true
+	}
+	version, err := strconv.Atoi(fields[2])
+	if err != nil || version <= 0 {
+		return "", 0, false
+	}
+	return fields[0], version, true
+}
+
+// feedURL returns the url of the feed of the wall in the format for the user at the feed version.
+func (c *FeedController) feedURL(uid string, version int, format string) string {
+	return c.wallURL() + "feeds/posts." + format + "?token=" + url.QueryEscape(c.feedToken(uid, version))
+}
+
+// Feeds returns the urls of the private feeds of the user.
+func (c *FeedController) Feeds(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.feeds(ctx, w, r, false)
+}
+
+// Rotate revokes the feed urls issued to the user and returns the new urls like Feeds.
+func (c *FeedController) Rotate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.feeds(ctx, w, r, true)
+}
+
+func (c *FeedController) feeds(ctx context.Context, w http.ResponseWriter, r *http.Request, rotate bool) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	var version int
+	var err error
+	if rotate {
+		version, err = c.Model.RotateFeedVersion(user)
+	} else {
+		version, err = c.Model.GetFeedVersion(user)
+	}
+	if err != nil {
+		log.Warnf("Could not get feed version of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	res := &jsonapi.Resource{
+		Type: "feeds",
+		ID:   postsFeed,
+		Attributes: map[string]interface{}{
+			"atom": c.feedURL(user, version, "atom"),
+			"rss":  c.feedURL(user, version, "rss"),
+		},
+	}
+	fs.Apply(res)
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: []*jsonapi.Resource{res},
+		Links: &jsonapi.Links{
+			Self: "/api/feeds",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write feeds: %s", err)
+	}
+}
+
+// Atom serves the feed of the wall as Atom document, see RSS.
+func (c *FeedController) Atom(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, "atom")
+}
+
+// RSS serves the feed of the wall as RSS document.
+// The user is identified by the token query parameter, requests without valid token are rejected with http.StatusNotFound.
+// Tokens of an outdated feed version of the user are rejected as well.
+// Conditional requests are answered using the `ETag` and `Last-Modified` headers.
+func (c *FeedController) RSS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.serve(w, r, "rss")
+}
+
+// updated returns when the post was last changed, scheduled posts change on publication.
+func updated(post *model.Post) time.Time {
+	if post.PublishAt.After(post.CreatedAt) {
+		return post.PublishAt
+	}
+	return post.CreatedAt
+}
+
+// byUpdatedDESC sorts posts last updated first.
+type byUpdatedDESC []*model.Post
+
+func (o byUpdatedDESC) Len() int           { return len(o) }
+func (o byUpdatedDESC) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byUpdatedDESC) Less(i, j int) bool { return updated(o[i]).After(updated(o[j])) }
+
+// entryTitle returns the first line of the message, shortened to 60 characters.
+func entryTitle(message string) string {
+	title := strings.TrimSpace(strings.SplitN(strings.TrimSpace(message), "\n", 2)[0])
+	if utf8.RuneCountInString(title) <= 60 {
+		return title
+	}
+	return string([]rune(title)[:59]) + "…"
+}
+
+func (c *FeedController) serve(w http.ResponseWriter, r *http.Request, format string) {
+	uid, version, ok := c.verifyToken(r.URL.Query().Get("token"))
+	if !ok {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+	current, err := c.Model.GetFeedVersion(uid)
+	if err != nil {
+		log.Warnf("Could not get feed version of %s: %s", uid, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if version != current {
+		// rotated tokens are revoked
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+	ps, err := c.Model.QueryPosts(model.PostQuery{})
+	if err != nil {
+		log.Warnf("Could not get posts of feed: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	ps = visiblePosts(ps, uid)
+	sort.Sort(byUpdatedDESC(ps))
+	limit := c.Limit
+	if limit <= 0 {
+		limit = DefaultFeedLimit
+	}
+	if len(ps) > limit {
+		ps = ps[:limit]
+	}
+	ids := []string{uid}
+	for _, post := range ps {
+		ids = append(ids, post.UID)
+	}
+	users, err := c.Model.GetUsersByIDs(ids)
+	if err != nil {
+		log.Warnf("Could not get users of feed: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if users[uid] == nil {
+		// tokens of removed users are revoked
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+	host := r.Host
+	if u, err := url.Parse(c.BaseURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	f := &feed.Feed{
+		ID:      feed.TagURI(host, feedEpoch, postsFeed),
+		Title:   "posty",
+		Link:    c.wallURL(),
+		Self:    c.feedURL(uid, version, format),
+		Updated: feedEpoch,
+	}
+	if len(ps) > 0 {
+		f.Updated = updated(ps[0])
+	}
+	for _, post := range ps {
+		e := &feed.Entry{
+			ID:        feed.TagURI(host, post.CreatedAt, "posts/"+post.ID),
+			Title:     entryTitle(post.Message),
+			Link:      c.wallURL(),
+			Published: updated(post),
+			Updated:   updated(post),
+			Content:   renderMessage(c.Renderer, post),
+			HTML:      true,
+		}
+		if u := users[post.UID]; u != nil {
+			e.Author = u.Username
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	var b bytes.Buffer
+	contentType := feed.AtomContentType
+	if format == "rss" {
+		contentType = feed.RSSContentType
+		err = f.WriteRSS(&b)
+	} else {
+		err = f.WriteAtom(&b)
+	}
+	if err != nil {
+		log.Warnf("Could not render feed: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(b.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(b.Bytes()))
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"posty/feed"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/token"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type mockFeedModel struct {
+	users map[string]*model.User
+	posts []*model.Post
+}
+
+func (m *mockFeedModel) GetFeedVersion(uid string) (int, error) {
+	if u, ok := m.users[uid]; ok {
+		return u.FeedVersion, nil
+	}
+	return 0, nil
+}
+
+func (m *mockFeedModel) RotateFeedVersion(uid string) (int, error) {
+	u, ok := m.users[uid]
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	u.FeedVersion++
+	return u.FeedVersion, nil
+}
+
+func (m *mockFeedModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockFeedModel) QueryPosts(q model.PostQuery) ([]*model.Post, error) {
+	return m.posts, nil
+}
+
+func TestFeeds(t *testing.T) {
+	assert := assert.New(t)
+	signer := token.NewSigner([]byte("secret"), FeedTokenPurpose)
+	c := &FeedController{Model: &mockFeedModel{}, Signer: signer, BaseURL: "https://posty.example.com"}
+	ctx := context.WithValue(context.Background(), "user", "uid1")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://feeds", nil)
+	c.Feeds(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	token := url.QueryEscape(signer.Token("uid1", "posts"))
+	assert.Contains(w.Body.String(), `{"type":"feeds","id":"posts","attributes":{"atom":"https://posty.example.com/feeds/posts.atom?token=`+token+`","rss":"https://posty.example.com/feeds/posts.rss?token=`+token+`"}}`)
+}
+
+func TestRotateFeeds(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockFeedModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one"},
+		},
+	}
+	signer := token.NewSigner([]byte("secret"), FeedTokenPurpose)
+	c := &FeedController{Model: m, Signer: signer, BaseURL: "https://posty.example.com"}
+	fetch := func(t string) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://feeds?token="+url.QueryEscape(t), nil)
+		c.Atom(context.Background(), w, r)
+		return w.Code
+	}
+	old := signer.Token("uid1", "posts")
+	assert.Equal(http.StatusOK, fetch(old), "Tokens without version are valid until the user rotates them")
+
+	ctx := context.WithValue(context.Background(), "user", "uid1")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://feeds/rotate", nil)
+	c.Rotate(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal(1, m.users["uid1"].FeedVersion)
+	rotated := signer.Token("uid1", "posts", "1")
+	assert.Contains(w.Body.String(), url.QueryEscape(rotated))
+	assert.Equal(http.StatusNotFound, fetch(old), "Rotated tokens are revoked")
+	assert.Equal(http.StatusOK, fetch(rotated))
+	for _, invalid := range []string{signer.Token("uid1", "posts", "2"), signer.Token("uid1", "posts", "x"), signer.Token("uid1", "posts", "0"), signer.Token("uid1", "posts", "1", "1")} {
+		assert.Equal(http.StatusNotFound, fetch(invalid), invalid)
+	}
+
+	w = httptest.NewRecorder()
+	c.Feeds(ctx, w, r)
+	assert.Contains(w.Body.String(), url.QueryEscape(rotated), "Feeds returns the urls of the current version")
+
+	w = httptest.NewRecorder()
+	c.Rotate(context.WithValue(context.Background(), "user", "uid9"), w, r)
+	assert.Equal(http.StatusInternalServerError, w.Code, "Invalid statuscode")
+}
+
+func TestFeed(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now().Truncate(time.Second)
+	m := &mockFeedModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one"},
+			"uid2": {ID: "uid2", Username: "two"},
+		},
+		posts: []*model.Post{
+			{ID: "pid1", UID: "uid1", Message: "First post", CreatedAt: now.Add(-3 * time.Hour)},
+			{ID: "pid2", UID: "uid2", Message: "Second *post*\nwith a second line", Format: model.FormatMarkdown, CreatedAt: now.Add(-2 * time.Hour)},
+			{ID: "pid3", UID: "uid2", Message: "Hidden post", CreatedAt: now.Add(-time.Hour), Hidden: true},
+			{ID: "pid4", UID: "uid1", Message: "Scheduled post", CreatedAt: now.Add(-4 * time.Hour), PublishAt: now.Add(-time.Hour)},
+			{ID: "pid5", UID: "uid1", Message: "Not yet published", CreatedAt: now.Add(-time.Hour), PublishAt: now.Add(time.Hour)},
+		},
+	}
+	signer := token.NewSigner([]byte("secret"), FeedTokenPurpose)
+	c := &FeedController{Model: m, Signer: signer, BaseURL: "https://posty.example.com/", Limit: 2}
+	request := func(token string, handler func(context.Context, http.ResponseWriter, *http.Request), header map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://feeds?token="+url.QueryEscape(token), nil)
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		handler(context.Background(), w, r)
+		return w
+	}
+
+	for _, token := range []string{"", "invalid", signer.Token("uid1", "tags"), token.NewSigner([]byte("other"), FeedTokenPurpose).Token("uid1", "posts"), signer.Token("uid9", "posts")} {
+		w := request(token, c.Atom, nil)
+		assert.Equal(http.StatusNotFound, w.Code, token)
+	}
+
+	token := signer.Token("uid2", "posts")
+	w := request(token, c.Atom, nil)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(feed.AtomContentType, w.Header().Get("Content-Type"))
+	assert.Equal(now.Add(-time.Hour).UTC().Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(etag)
+	body := w.Body.String()
+	assert.Contains(body, `<id>tag:posty.example.com,2015-11-01:posts</id>`)
+	assert.Contains(body, `<updated>`+now.Add(-time.Hour).UTC().Format(time.RFC3339)+`</updated>`)
+	assert.Contains(body, `<id>tag:posty.example.com,`+now.Add(-time.Hour).UTC().Format("2006-01-02")+`:posts/pid3</id>`, "Hidden posts are part of the feed of their author")
+	assert.Contains(body, `<id>tag:posty.example.com,`+now.Add(-4*time.Hour).UTC().Format("2006-01-02")+`:posts/pid4</id>`, "Scheduled posts are updated on publication")
+	assert.NotContains(body, "pid2", "Feeds are limited to the latest posts")
+	assert.NotContains(body, "pid5")
+
+	w = request(token, c.Atom, map[string]string{"If-None-Match": etag})
+	assert.Equal(http.StatusNotModified, w.Code, "Invalid statuscode")
+	assert.Empty(w.Body.String())
+	w = request(token, c.Atom, map[string]string{"If-Modified-Since": now.UTC().Format(http.TimeFormat)})
+	assert.Equal(http.StatusNotModified, w.Code, "Invalid statuscode")
+	w = request(token, c.Atom, map[string]string{"If-Modified-Since": now.Add(-2 * time.Hour).UTC().Format(http.TimeFormat)})
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+
+	c.Limit = 0
+	w = request(signer.Token("uid1", "posts"), c.RSS, nil)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(feed.RSSContentType, w.Header().Get("Content-Type"))
+	assert.NotEqual(etag, w.Header().Get("ETag"))
+	body = w.Body.String()
+	assert.NotContains(body, "pid3", "Hidden posts are only part of the feed of their author")
+	assert.Contains(body, "pid5", "Scheduled posts are part of the feed of their author")
+	assert.Contains(body, `<title>Second *post*</title>`, "Titles are the first line of the message")
+	assert.Contains(body, `&lt;em&gt;post&lt;/em&gt;`, "Markdown is rendered")
+	assert.Contains(body, `<dc:creator>two</dc:creator>`)
+	assert.Contains(body, `<atom:link rel="self" type="application/rss+xml" href="https://posty.example.com/feeds/posts.rss?token=`)
+	assert.True(strings.Index(body, "pid5") < strings.Index(body, "pid1"), "Feeds are sorted newest first")
+}
+
+func TestEntryTitle(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("Hello", entryTitle("  Hello \nWorld"))
+	assert.Equal(strings.Repeat("ä", 59)+"…", entryTitle(strings.Repeat("ä", 61)))
+	assert.Equal(strings.Repeat("ä", 60), entryTitle(strings.Repeat("ä", 60)))
+}
@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// IncomingWebhookDataProvider defines the needed model interactions.
+type IncomingWebhookDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetIncomingWebhooks() ([]*model.IncomingWebhook, error)
+	GetIncomingWebhook(id string) (*model.IncomingWebhook, error)
+	NewIncomingWebhook(name, botID, tokenHash string) *model.IncomingWebhook
+	SaveNewIncomingWebhook(h *model.IncomingWebhook) error
+	RemoveIncomingWebhook(h *model.IncomingWebhook) error
+	NewUser() *model.User
+	SaveNewUser(u *model.User) error
+}
+
+// IncomingWebhookController lets admins create incoming webhooks and external systems post to the wall with them.
+// Every incoming webhook posts as its own bot user, the posts are created by Posts like posts of users.
+// The urls of the webhooks returned on creation start with BaseURL.
+type IncomingWebhookController struct {
+	Model   IncomingWebhookDataProvider
+	Posts   *PostController
+	BaseURL string
+}
+
+// hashToken returns the hex encoded SHA-256 of the token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// incomingWebhookResource converts an incoming webhook to its JSON API representation. The token is not exposed.
+func incomingWebhookResource(h *model.IncomingWebhook) *jsonapi.Resource {
+	return &jsonapi.Resource{
+		Type: "incoming-webhooks",
+		ID:   h.ID,
+		Attributes: map[string]interface{}{
+			"name":       h.Name,
+			"created_at": h.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"bot": {
+				Links: &jsonapi.Links{
+					Related: "/api/users/" + h.BotID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   h.BotID,
+				},
+			},
+		},
+		Links: &jsonapi.Links{
+			Self: "/api/incoming-webhooks/" + h.ID,
+		},
+	}
+}
+
+// byIncomingCreatedAt sorts incoming webhooks oldest first.
+type byIncomingCreatedAt []*model.IncomingWebhook
+
+func (o byIncomingCreatedAt) Len() int           { return len(o) }
+func (o byIncomingCreatedAt) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byIncomingCreatedAt) Less(i, j int) bool { return o[i].CreatedAt.Before(o[j].CreatedAt) }
+
+// Webhooks returns all incoming webhooks, oldest first, to admins.
+func (c *IncomingWebhookController) Webhooks(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required"); !ok {
+		return
+	}
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	hooks, err := c.Model.GetIncomingWebhooks()
+	if err != nil {
+		log.Warnf("Could not get incoming webhooks: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	sort.Sort(byIncomingCreatedAt(hooks))
+	data := make([]*jsonapi.Resource, len(hooks))
+	for i, h := range hooks {
+		data[i] = incomingWebhookResource(h)
+		fs.Apply(data[i])
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: data,
+		Links: &jsonapi.Links{
+			Self: "/api/incoming-webhooks",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write incoming webhooks: %s", err)
+	}
+}
+
+type incomingWebhookCreateReq struct {
+	Data struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			Name string `json:"name"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Create handles requests of admins to create an incoming webhook.
+//
+// Example request: `{"data":{"type":"incoming-webhooks","attributes":{"name":"CI"}}}`
+//
+// A bot user with the name as username is created, posts of the webhook are attributed to it.
+// On success the webhook is returned with status code http.StatusCreated. Its `url` attribute contains the secret token,
+// it is only returned by this response.
+func (c *IncomingWebhookController) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required")
+	if !ok {
+		return
+	}
+	var req incomingWebhookCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "incoming-webhooks" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'incoming-webhooks'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	name := strings.TrimSpace(req.Data.Attributes.Name)
+	if name == "" || len(name) > 50 {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_name",
+			Title:  "Invalid name",
+			Detail: "Name must be between 1 and 50 characters long",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/name",
+			},
+		})
+		return
+	}
+	token, err := newSecret()
+	if err != nil {
+		log.Warnf("Could not generate incoming webhook token: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	bot := c.Model.NewUser()
+	bot.Username = name
+	bot.Roles = []string{model.RoleBot}
+	h := c.Model.NewIncomingWebhook(name, bot.ID, hashToken(token))
+	h.CreatedBy = user
+	// bots can not log in, their oauth id belongs to no identity provider
+	bot.OAuthID = "webhook:" + h.ID
+	if err := c.Model.SaveNewUser(bot); err != nil {
+		log.Warnf("Could not save bot user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if err := c.Model.SaveNewIncomingWebhook(h); err != nil {
+		log.Warnf("Could not save incoming webhook: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	res := incomingWebhookResource(h)
+	res.Attributes["url"] = strings.TrimRight(c.BaseURL, "/") + "/hooks/" + h.ID + "/" + token
+	w.Header().Set("Location", "/api/incoming-webhooks/"+h.ID)
+	if err := jsonapi.Write(w, http.StatusCreated, &jsonapi.Document{Data: res}); err != nil {
+		log.Warnf("Could not write incoming webhook: %s", err)
+	}
+}
+
+// Remove handles requests of admins to revoke the incoming webhook identified by the id url parameter.
+// The bot user and its posts are kept. On success an empty response with status http.StatusNoContent is written.
+func (c *IncomingWebhookController) Remove(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required"); !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	h, err := c.Model.GetIncomingWebhook(id)
+	if err != nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	if err := c.Model.RemoveIncomingWebhook(h); err != nil {
+		log.Warnf("Could not remove incoming webhook %s: %s", h.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// incomingPayload is the body of requests to incoming webhooks.
+// Message and Format are the simple payload, Text, Mrkdwn and Attachments the Slack-compatible payload.
+type incomingPayload struct {
+	Message     string            `json:"message"`
+	Format      string            `json:"format"`
+	Text        string            `json:"text"`
+	Mrkdwn      *bool             `json:"mrkdwn"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback  string `json:"fallback"`
+	Pretext   string `json:"pretext"`
+	Title     string `json:"title"`
+	TitleLink string `json:"title_link"`
+	Text      string `json:"text"`
+}
+
+// slack returns true if the payload uses the Slack-compatible shape.
+func (pl *incomingPayload) slack() bool {
+	return pl.Message == "" && (pl.Text != "" || len(pl.Attachments) > 0)
+}
+
+// slackRe matches the control sequences of Slack messages, e.g. `<https://example.com|label>` or `<!here>`.
+var slackRe = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+
+var slackEntities = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+// slackText converts the links and mentions of a Slack message to markdown or, if markdown is false, plain text.
+func slackText(s string, markdown bool) string {
+	s = slackRe.ReplaceAllStringFunc(s, func(seq string) string {
+		m := slackRe.FindStringSubmatch(seq)
+		target, label := m[1], m[2]
+		switch {
+		case strings.HasPrefix(target, "!"):
+			return "@" + strings.TrimPrefix(target, "!")
+		case strings.HasPrefix(target, "#") || strings.HasPrefix(target, "@"):
+			if label != "" {
+				return target[:1] + label
+			}
+			return target
+		case label == "":
+			return target
+		case markdown:
+			return "[" + label + "](" + target + ")"
+		default:
+			return label + " (" + target + ")"
+		}
+	})
+	return slackEntities.Replace(s)
+}
+
+// slackAttributes converts a Slack-compatible payload to the attributes of a post.
+// The text and the pretext, title and text of every attachment become paragraphs of the message.
+func (pl *incomingPayload) slackAttributes() *postAttributes {
+	markdown := pl.Mrkdwn == nil || *pl.Mrkdwn
+	var parts []string
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			parts = append(parts, slackText(s, markdown))
+		}
+	}
+	add(pl.Text)
+	for _, a := range pl.Attachments {
+		n := len(parts)
+		add(a.Pretext)
+		if a.Title != "" && a.TitleLink != "" {
+			add("<" + a.TitleLink + "|" + a.Title + ">")
+		} else {
+			add(a.Title)
+		}
+		add(a.Text)
+		if len(parts) == n {
+			add(a.Fallback)
+		}
+	}
+	attrs := &postAttributes{
+		Message: strings.Join(parts, "\n\n"),
+		Format:  model.FormatPlain,
+	}
+	if markdown {
+		attrs.Format = model.FormatMarkdown
+	}
+	return attrs
+}
+
+// Post handles requests of external systems to incoming webhooks, the webhook is identified by the id url parameter
+// and authenticated by the token url parameter. Unknown webhooks and invalid tokens are rejected with http.StatusNotFound.
+//
+// The simple payload `{"message":"Build #42 failed","format":"markdown"}` creates a post like Create,
+// the created post is returned with status code http.StatusCreated.
+// Slack-compatible payloads like `{"text":"Build <https://ci.example.com/42|#42> failed","attachments":[{"title":"Logs","text":"..."}]}`
+// are converted to markdown posts, as expected by Slack clients the response is `ok` with status code http.StatusOK.
+// They are also accepted as form field `payload` of form-encoded requests.
+func (c *IncomingWebhookController) Post(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, _ := urlParam(ctx, "id")
+	token, _ := urlParam(ctx, "token")
+	h, err := c.Model.GetIncomingWebhook(id)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(h.TokenHash)) != 1 {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	maxBody := c.Posts.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	limitBody(w, r, maxBody)
+	defer r.Body.Close()
+	var pl incomingPayload
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err = r.ParseForm(); err == nil {
+			err = json.Unmarshal([]byte(r.PostFormValue("payload")), &pl)
+		}
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&pl)
+	}
+	if tooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_payload",
+			Title:  "Invalid payload",
+			Detail: err.Error(),
+		})
+		return
+	}
+	attrs := &postAttributes{Message: pl.Message, Format: pl.Format}
+	if pl.slack() {
+		attrs = pl.slackAttributes()
+	}
+	post, code, errs := c.Posts.create(h.BotID, attrs, nil)
+	if post == nil {
+		jsonErrors(w, r, code, errs...)
+		return
+	}
+	if pl.slack() {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+		return
+	}
+	w.Header().Set("Location", "/api/posts/"+post.ID)
+	c.Posts.writePost(w, r, http.StatusCreated, post, h.BotID, nil, nil)
+}
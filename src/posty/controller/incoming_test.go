@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"posty/jsonapi"
+	"posty/model"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type mockIncomingWebhookModel struct {
+	users map[string]*model.User
+	hooks []*model.IncomingWebhook
+}
+
+func (m *mockIncomingWebhookModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockIncomingWebhookModel) GetIncomingWebhooks() ([]*model.IncomingWebhook, error) {
+	return m.hooks, nil
+}
+
+func (m *mockIncomingWebhookModel) GetIncomingWebhook(id string) (*model.IncomingWebhook, error) {
+	for _, h := range m.hooks {
+		if h.ID == id {
+			return h, nil
+		}
+	}
+	return nil, errors.New("Not found")
+}
+
+func (m *mockIncomingWebhookModel) NewIncomingWebhook(name, botID, tokenHash string) *model.IncomingWebhook {
+	return &model.IncomingWebhook{
+		ID:        "iid" + strconv.Itoa(len(m.hooks)+1),
+		Name:      name,
+		BotID:     botID,
+		TokenHash: tokenHash,
+		CreatedAt: time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockIncomingWebhookModel) SaveNewIncomingWebhook(h *model.IncomingWebhook) error {
+	m.hooks = append(m.hooks, h)
+	return nil
+}
+
+func (m *mockIncomingWebhookModel) RemoveIncomingWebhook(h *model.IncomingWebhook) error {
+	var kept []*model.IncomingWebhook
+	for _, e := range m.hooks {
+		if e.ID != h.ID {
+			kept = append(kept, e)
+		}
+	}
+	m.hooks = kept
+	return nil
+}
+
+func (m *mockIncomingWebhookModel) NewUser() *model.User {
+	return &model.User{ID: "uid" + strconv.Itoa(len(m.users)+1), CreatedAt: time.Unix(1448272067, 0)}
+}
+
+func (m *mockIncomingWebhookModel) SaveNewUser(u *model.User) error {
+	m.users[u.ID] = u
+	return nil
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockIncomingWebhookModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "admin", Roles: []string{model.RoleAdmin}},
+			"uid2": {ID: "uid2", Username: "moderator", Roles: []string{model.RoleModerator}},
+		},
+	}
+	c := &IncomingWebhookController{Model: m, BaseURL: "https://posty.example.com/"}
+	request := func(user, method, id, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://incoming-webhooks", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	w := request("uid2", "GET", "", "", c.Webhooks)
+	assert.Equal(http.StatusForbidden, w.Code, "Incoming webhooks are restricted to admins")
+	w = request("uid2", "POST", "", `{"data":{"type":"incoming-webhooks","attributes":{"name":"CI"}}}`, c.Create)
+	assert.Equal(http.StatusForbidden, w.Code, "Incoming webhooks are restricted to admins")
+	w = request("uid1", "POST", "", `{"data":{"type":"incoming-webhooks","attributes":{"name":"  "}}}`, c.Create)
+	assert.Equal(http.StatusBadRequest, w.Code, "Names must not be empty")
+	w = request("uid1", "POST", "", `{"data":{"type":"webhooks","attributes":{"name":"CI"}}}`, c.Create)
+	assert.Equal(http.StatusConflict, w.Code, "Invalid statuscode")
+	w = request("uid1", "POST", "", `{"data":{"type":"incoming-webhooks","attributes":{"name":"`+strings.Repeat("a", DefaultMaxBodySize)+`"}}}`, c.Create)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Bodies are limited")
+	assert.Empty(m.hooks)
+
+	w = request("uid1", "POST", "", `{"data":{"type":"incoming-webhooks","attributes":{"name":"CI"}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal("/api/incoming-webhooks/iid1", w.Header().Get("Location"))
+	assert.Contains(w.Body.String(), `"url":"https://posty.example.com/hooks/iid1/`, "The url with the token is returned on creation")
+	if assert.Len(m.hooks, 1) {
+		h := m.hooks[0]
+		assert.Equal("uid1", h.CreatedBy)
+		assert.Equal("uid3", h.BotID)
+		assert.Len(h.TokenHash, 64)
+		assert.NotContains(w.Body.String(), h.TokenHash)
+	}
+	if bot := m.users["uid3"]; assert.NotNil(bot) {
+		assert.Equal("CI", bot.Username)
+		assert.Equal("webhook:iid1", bot.OAuthID)
+		assert.Equal([]string{model.RoleBot}, bot.Roles)
+	}
+
+	w = request("uid1", "GET", "", "", c.Webhooks)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `{"type":"incoming-webhooks","id":"iid1","attributes":{"created_at":1448272067,"name":"CI"},"relationships":{"bot":{"links":{"related":"/api/users/uid3"},"data":{"type":"users","id":"uid3"}}},"links":{"self":"/api/incoming-webhooks/iid1"}}`)
+	assert.NotContains(w.Body.String(), "url", "Urls are only returned on creation")
+
+	w = request("uid2", "DELETE", "iid1", "", c.Remove)
+	assert.Equal(http.StatusForbidden, w.Code, "Invalid statuscode")
+	w = request("uid1", "DELETE", "iid9", "", c.Remove)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+	w = request("uid1", "DELETE", "iid1", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	assert.Empty(m.hooks)
+	assert.NotNil(m.users["uid3"], "Bot users are kept")
+}
+
+func TestPostIncomingWebhook(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockIncomingWebhookModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "CI", Roles: []string{model.RoleBot}},
+		},
+		hooks: []*model.IncomingWebhook{{ID: "iid1", Name: "CI", BotID: "uid1", TokenHash: hashToken("token")}},
+	}
+	var posts []*model.Post
+	hooks := &mockPublisher{}
+	c := &IncomingWebhookController{
+		Model: m,
+		Posts: &PostController{
+			Model: &mockPostPeer{
+				usersFn: m.GetUsersByIDs,
+				newFn: func(uid string) *model.Post {
+					return &model.Post{ID: "pid" + strconv.Itoa(len(posts)+1), UID: uid, CreatedAt: time.Unix(1448272067, 0)}
+				},
+				saveFn: func(p *model.Post) error {
+					posts = append(posts, p)
+					return nil
+				},
+			},
+			Webhooks: hooks,
+		},
+	}
+	request := func(id, token, contentType, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "urlparams", map[string]string{"id": id, "token": token})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://hooks", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		c.Post(ctx, w, r)
+		return w
+	}
+
+	for _, hook := range [][2]string{{"iid1", "invalid"}, {"iid1", ""}, {"iid9", "token"}} {
+		w := request(hook[0], hook[1], "application/json", `{"message":"Hello"}`)
+		assert.Equal(http.StatusNotFound, w.Code, hook[0]+"/"+hook[1])
+	}
+	assert.Empty(posts)
+
+	w := request("iid1", "token", "application/json", `{"message":""}`)
+	assert.Equal(http.StatusBadRequest, w.Code, "Posts of webhooks are validated like posts of users")
+	w = request("iid1", "token", "application/json", `{"message":"Hello","format":"html"}`)
+	assert.Equal(http.StatusBadRequest, w.Code, "Posts of webhooks are validated like posts of users")
+	w = request("iid1", "token", "application/json", `{"message":`)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Empty(posts)
+
+	w = request("iid1", "token", "application/json", `{"message":"Build *42* failed","format":"markdown"}`)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal("/api/posts/pid1", w.Header().Get("Location"))
+	if assert.Len(posts, 1) {
+		assert.Equal("uid1", posts[0].UID, "Posts are attributed to the bot user")
+		assert.Equal("Build *42* failed", posts[0].Message)
+		assert.Equal(model.FormatMarkdown, posts[0].Format)
+	}
+	assert.Equal([]string{model.EventPostCreated}, hooks.events, "Posts of webhooks are published like posts of users")
+
+	w = request("iid1", "token", "application/json", `{"text":"Build <https://ci.example.com/42|#42> of <!channel> failed: 1 &lt; 2","attachments":[{"title":"Logs","title_link":"https://ci.example.com/42/logs","text":"exit status 1"},{"fallback":"Retry"}]}`)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal("ok", w.Body.String(), "Slack-compatible payloads are answered like Slack does")
+	if assert.Len(posts, 2) {
+		assert.Equal("Build [#42](https://ci.example.com/42) of @channel failed: 1 < 2\n\n[Logs](https://ci.example.com/42/logs)\n\nexit status 1\n\nRetry", posts[1].Message)
+		assert.Equal(model.FormatMarkdown, posts[1].Format)
+	}
+
+	form := url.Values{"payload": {`{"text":"Deployed <https://example.com|example>","mrkdwn":false}`}}
+	w = request("iid1", "token", "application/x-www-form-urlencoded", form.Encode())
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	if assert.Len(posts, 3) {
+		assert.Equal("Deployed example (https://example.com)", posts[2].Message)
+		assert.Equal(model.FormatPlain, posts[2].Format)
+	}
+
+	large := strings.Repeat("a", DefaultMaxBodySize)
+	w = request("iid1", "token", "application/json", `{"message":"`+large+`"}`)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Invalid statuscode")
+	form = url.Values{"payload": {`{"text":"` + large + `"}`}}
+	w = request("iid1", "token", "application/x-www-form-urlencoded", form.Encode())
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Invalid statuscode")
+	assert.Len(posts, 3)
+}
@@ -0,0 +1,362 @@
+package controller
+
+import (
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// NotificationDataProvider defines the needed model interactions.
+type NotificationDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetNotifications(uid string, limit int) ([]*model.Notification, error)
+	CountUnreadNotifications(uid string) (int, error)
+	MarkNotificationRead(n *model.Notification) error
+	UpdateMutedNotifications(uid string, kinds []string) error
+}
+
+// NotificationController handles the notifications of the logged in user and which kinds of notifications they receive.
+// Limit is the number of most recent notifications returned, DefaultNotificationLimit is used if it is not set.
+type NotificationController struct {
+	Model NotificationDataProvider
+	Limit int
+}
+
+// DefaultNotificationLimit is the number of notifications returned if no limit is configured.
+const DefaultNotificationLimit = 50
+
+// limit returns the configured or default limit.
+func (c *NotificationController) limit() int {
+	if c.Limit > 0 {
+		return c.Limit
+	}
+	return DefaultNotificationLimit
+}
+
+// notify saves a notification of the kind for every recipient except the actor who wants to receive it.
+// Failures are logged, notifications never fail the action causing them.
+func notify(peer model.NotificationPeer, recipients map[string]*model.User, kind, actorID, postID string) {
+	for _, u := range recipients {
+		if u.ID == actorID || !u.Notifies(kind) {
+			continue
+		}
+		n := peer.NewNotification(u.ID, kind, actorID, postID)
+		if err := peer.SaveNew(n); err != nil {
+			log.Warnf("Could not save %s notification of %s: %s", kind, u.ID, err)
+		}
+	}
+}
+
+// notifyMentions notifies the users mentioned in the published post of the author and, if Emails is set, emails them.
+func (p *PostController) notifyMentions(author string, post *model.Post) {
+	if p.Notifications == nil && p.Emails == nil {
+		return
+	}
+	mentioned, err := p.Model.GetUsersByHandles(post.Mentions)
+	if err != nil {
+		log.Warnf("Could not lookup users mentioned in post %s: %s", post.ID, err)
+		return
+	}
+	if p.Notifications != nil {
+		notify(p.Notifications, mentioned, model.NotificationMention, author, post.ID)
+	}
+	if p.Emails != nil && len(mentioned) > 0 {
+		authors, err := p.Model.GetUsersByIDs([]string{author})
+		if err != nil || authors[author] == nil {
+			log.Warnf("Could not lookup author of post %s: %v", post.ID, err)
+			return
+		}
+		p.Emails.mentions(mentioned, authors[author], post)
+	}
+}
+
+// notificationResource converts a notification to its JSON API representation.
+func notificationResource(n *model.Notification) *jsonapi.Resource {
+	res := &jsonapi.Resource{
+		Type: "notifications",
+		ID:   n.ID,
+		Attributes: map[string]interface{}{
+			"kind":       n.Kind,
+			"read":       !n.Unread(),
+			"created_at": n.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"actor": {
+				Links: &jsonapi.Links{
+					Related: "/api/users/" + n.ActorID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   n.ActorID,
+				},
+			},
+		},
+	}
+	if n.PostID != "" {
+		res.Relationships["post"] = &jsonapi.Relationship{
+			Links: &jsonapi.Links{
+				Related: "/api/posts/" + n.PostID,
+			},
+			Data: &jsonapi.Identifier{
+				Type: "posts",
+				ID:   n.PostID,
+			},
+		}
+	}
+	return res
+}
+
+// Notifications returns the most recent notifications of the logged in user, newest first.
+// The number of all unread notifications, including older ones, is returned as `unread` in the meta object of the document.
+// The actors are included unless requested otherwise using the `include` parameter.
+func (c *NotificationController) Notifications(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, []string{"actor"})
+	if !ok {
+		return
+	}
+	ns, err := c.Model.GetNotifications(user, c.limit())
+	if err != nil {
+		log.Warnf("Could not get notifications of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	unread, err := c.Model.CountUnreadNotifications(user)
+	if err != nil {
+		log.Warnf("Could not count unread notifications of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	data := make([]*jsonapi.Resource, len(ns))
+	var ids []string
+	for i, n := range ns {
+		data[i] = notificationResource(n)
+		fs.Apply(data[i])
+		if jsonapi.Includes(include, "actor") {
+			ids = append(ids, n.ActorID)
+		}
+	}
+	included, err := includedUsers(c.Model, ids, fs)
+	if err != nil {
+		log.Warnf("Could not lookup actors: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data:     data,
+		Included: included,
+		Links: &jsonapi.Links{
+			Self: "/api/notifications",
+		},
+		Meta: map[string]interface{}{
+			"unread": unread,
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write notifications: %s", err)
+	}
+}
+
+// Read marks the notification identified by the id url parameter as read.
+// On success an empty response with status http.StatusNoContent is written.
+// If the notification is not one of the most recent notifications of the logged in user http.StatusNotFound is returned.
+func (c *NotificationController) Read(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	ns, err := c.Model.GetNotifications(user, c.limit())
+	if err != nil {
+		log.Warnf("Could not get notifications of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	for _, n := range ns {
+		if n.ID != id {
+			continue
+		}
+		if n.Unread() {
+			if err := c.Model.MarkNotificationRead(n); err != nil {
+				log.Warnf("Could not mark notification %s as read: %s", n.ID, err)
+				jsonError(w, r, cErrServer, "")
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	jsonError(w, r, http.StatusNotFound, "Resource not found")
+}
+
+// ReadAll marks all recent notifications of the logged in user as read.
+// On success an empty response with status http.StatusNoContent is written.
+func (c *NotificationController) ReadAll(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	ns, err := c.Model.GetNotifications(user, c.limit())
+	if err != nil {
+		log.Warnf("Could not get notifications of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	for _, n := range ns {
+		if !n.Unread() {
+			continue
+		}
+		if err := c.Model.MarkNotificationRead(n); err != nil {
+			log.Warnf("Could not mark notification %s as read: %s", n.ID, err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// preferencesResource converts the notification preferences of the user to their JSON API representation.
+// Every kind of notification is an attribute which is true if the user receives notifications of the kind.
+func preferencesResource(u *model.User) *jsonapi.Resource {
+	attrs := make(map[string]interface{}, len(model.NotificationKinds))
+	for _, kind := range model.NotificationKinds {
+		attrs[kind] = u.Notifies(kind)
+	}
+	return &jsonapi.Resource{
+		Type:       "notification-preferences",
+		ID:         u.ID,
+		Attributes: attrs,
+		Links: &jsonapi.Links{
+			Self: "/api/notifications/preferences",
+		},
+	}
+}
+
+// currentUser looks up the logged in user. On error a json error is written and ok is false.
+func (c *NotificationController) currentUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return nil, false
+	}
+	users, err := c.Model.GetUsersByIDs([]string{user})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return nil, false
+	}
+	u, ok := users[user]
+	if !ok {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return nil, false
+	}
+	return u, true
+}
+
+// Preferences returns which kinds of notifications the logged in user receives.
+func (c *NotificationController) Preferences(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	u, ok := c.currentUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	res := preferencesResource(u)
+	fs.Apply(res)
+	if err := jsonapi.Write(w, http.StatusOK, &jsonapi.Document{Data: res}); err != nil {
+		log.Warnf("Could not write notification preferences: %s", err)
+	}
+}
+
+type preferencesUpdateReq struct {
+	Data struct {
+		Type       string                 `json:"type"`
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"data"`
+}
+
+// UpdatePreferences handles requests to change which kinds of notifications the logged in user receives.
+//
+// Example request: `{"data":{"type":"notification-preferences","attributes":{"vote":false}}}`
+//
+// Kinds not part of the request are left unchanged. On success the updated preferences are returned with status code http.StatusOK.
+func (c *NotificationController) UpdatePreferences(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	u, ok := c.currentUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	var req preferencesUpdateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "notification-preferences" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'notification-preferences'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	wanted := make(map[string]bool, len(model.NotificationKinds))
+	for _, kind := range model.NotificationKinds {
+		wanted[kind] = u.Notifies(kind)
+	}
+	var errs []*jsonapi.Error
+	for kind, v := range req.Data.Attributes {
+		b, ok := v.(bool)
+		if !ok || !model.ValidNotificationKind(kind) {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_preference",
+				Title:  "Invalid notification preference",
+				Detail: "Preferences must be known kinds of notifications set to true or false",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/" + kind,
+				},
+			})
+			continue
+		}
+		wanted[kind] = b
+	}
+	if len(errs) > 0 {
+		jsonErrors(w, r, cErrClient, errs...)
+		return
+	}
+	muted := []string{}
+	for _, kind := range model.NotificationKinds {
+		if !wanted[kind] {
+			muted = append(muted, kind)
+		}
+	}
+	if err := c.Model.UpdateMutedNotifications(u.ID, muted); err != nil {
+		log.Warnf("Could not update notification preferences of %s: %s", u.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	updated := *u
+	updated.Muted = muted
+	if err := jsonapi.Write(w, http.StatusOK, &jsonapi.Document{Data: preferencesResource(&updated)}); err != nil {
+		log.Warnf("Could not write notification preferences: %s", err)
+	}
+}
@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockNotificationPeer keeps notifications in memory, newest last.
+type mockNotificationPeer struct {
+	notifications []*model.Notification
+}
+
+func (m *mockNotificationPeer) NewNotification(uid, kind, actorID, postID string) *model.Notification {
+	return &model.Notification{
+		ID:        "nid" + strconv.Itoa(len(m.notifications)+1),
+		UID:       uid,
+		Kind:      kind,
+		ActorID:   actorID,
+		PostID:    postID,
+		CreatedAt: time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockNotificationPeer) SaveNew(n *model.Notification) error {
+	m.notifications = append(m.notifications, n)
+	return nil
+}
+
+func (m *mockNotificationPeer) GetByUser(uid string, limit int) ([]*model.Notification, error) {
+	var res []*model.Notification
+	for i := len(m.notifications) - 1; i >= 0 && len(res) < limit; i-- {
+		if m.notifications[i].UID == uid {
+			res = append(res, m.notifications[i])
+		}
+	}
+	return res, nil
+}
+
+func (m *mockNotificationPeer) CountUnread(uid string) (int, error) {
+	count := 0
+	for _, n := range m.notifications {
+		if n.UID == uid && n.Unread() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *mockNotificationPeer) MarkRead(n *model.Notification) error {
+	n.ReadAt = time.Unix(1448272068, 0)
+	return nil
+}
+
+func (m *mockNotificationPeer) RemoveByPost(postID string) error {
+	var kept []*model.Notification
+	for _, n := range m.notifications {
+		if n.PostID != postID {
+			kept = append(kept, n)
+		}
+	}
+	m.notifications = kept
+	return nil
+}
+
+// mockNotificationModel implements the NotificationDataProvider on top of a mockNotificationPeer.
+type mockNotificationModel struct {
+	*mockNotificationPeer
+	users map[string]*model.User
+}
+
+func (m *mockNotificationModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockNotificationModel) GetNotifications(uid string, limit int) ([]*model.Notification, error) {
+	return m.GetByUser(uid, limit)
+}
+
+func (m *mockNotificationModel) CountUnreadNotifications(uid string) (int, error) {
+	return m.CountUnread(uid)
+}
+
+func (m *mockNotificationModel) MarkNotificationRead(n *model.Notification) error {
+	return m.MarkRead(n)
+}
+
+func (m *mockNotificationModel) UpdateMutedNotifications(uid string, kinds []string) error {
+	m.users[uid].Muted = kinds
+	return nil
+}
+
+func TestPostNotifications(t *testing.T) {
+	assert := assert.New(t)
+	users := map[string]*model.User{
+		"uid1": {ID: "uid1", Username: "one"},
+		"uid2": {ID: "uid2", Username: "two"},
+		"uid3": {ID: "uid3", Username: "three", Muted: []string{model.NotificationMention}},
+	}
+	byID := func(ids []string) (map[string]*model.User, error) {
+		res := make(map[string]*model.User)
+		for _, id := range ids {
+			if u, ok := users[id]; ok {
+				res[id] = u
+			}
+		}
+		return res, nil
+	}
+	posts := map[string]*model.Post{}
+	mockModel := &mockPostPeer{
+		usersFn: byID,
+		handlesFn: func(handles []string) (map[string]*model.User, error) {
+			res := make(map[string]*model.User)
+			for _, u := range users {
+				for _, h := range handles {
+					if h == u.Username {
+						res[u.ID] = u
+					}
+				}
+			}
+			return res, nil
+		},
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "pid" + strconv.Itoa(len(posts)+1), UID: uid, CreatedAt: time.Unix(1448272067, 0)}
+		},
+		saveFn: func(p *model.Post) error {
+			posts[p.ID] = p
+			return nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			return posts[id], nil
+		},
+		removeFn: func(p *model.Post) error {
+			p.DeletedAt = time.Now()
+			return nil
+		},
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			var res []*model.Post
+			for _, p := range posts {
+				if q.Matches(p) {
+					res = append(res, p)
+				}
+			}
+			return res, nil
+		},
+	}
+	notifications := &mockNotificationPeer{}
+	c := &PostController{Model: mockModel, Votes: &mockVotePeer{}, Notifications: notifications}
+	request := func(user, id, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://posts?include=", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	// Mentioned users are notified unless they muted mentions, authors never notify themselves
+	w := request("uid1", "", `{"data":{"type":"posts","attributes":{"message":"Hello @one @two @three @nobody","poll":{"options":["yes","no"]}}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	if assert.Len(notifications.notifications, 1) {
+		n := notifications.notifications[0]
+		assert.Equal("uid2", n.UID)
+		assert.Equal(model.NotificationMention, n.Kind)
+		assert.Equal("uid1", n.ActorID)
+		assert.Equal("pid1", n.PostID)
+	}
+
+	// Scheduled posts notify once they are published
+	w = request("uid2", "", `{"data":{"type":"posts","attributes":{"message":"Later @one","publish_at":`+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+`}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Len(notifications.notifications, 1)
+	since := time.Now().Add(-time.Minute)
+	posts["pid2"].PublishAt = since.Add(time.Second)
+	c.QuietPublish = true
+	n, err := c.Publish(since, time.Now())
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Len(notifications.notifications, 1, "Quiet instances do not notify")
+	c.QuietPublish = false
+	n, err = c.Publish(since, time.Now())
+	assert.NoError(err)
+	assert.Equal(1, n)
+	if assert.Len(notifications.notifications, 2) {
+		n := notifications.notifications[1]
+		assert.Equal("uid1", n.UID)
+		assert.Equal(model.NotificationMention, n.Kind)
+		assert.Equal("uid2", n.ActorID)
+		assert.Equal("pid2", n.PostID)
+	}
+
+	// Authors of polls are notified about votes of other users
+	const vote = `{"data":{"type":"votes","attributes":{"option":0}}}`
+	w = request("uid1", "pid1", vote, c.Vote)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Len(notifications.notifications, 2)
+	w = request("uid3", "pid1", vote, c.Vote)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	if assert.Len(notifications.notifications, 3) {
+		n := notifications.notifications[2]
+		assert.Equal("uid1", n.UID)
+		assert.Equal(model.NotificationVote, n.Kind)
+		assert.Equal("uid3", n.ActorID)
+	}
+
+	// Notifications are kept while the post can be restored and purged together with it
+	w = request("uid1", "pid1", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	assert.Len(notifications.notifications, 3)
+	mockModel.deletedPosts = []*model.Post{posts["pid1"]}
+	mockModel.purgeFn = func(p *model.Post) error {
+		return nil
+	}
+	n, err = c.Purge(time.Now().Add(DefaultRestoreWindow + time.Minute))
+	assert.NoError(err)
+	assert.Equal(1, n)
+	if assert.Len(notifications.notifications, 1) {
+		assert.Equal("pid2", notifications.notifications[0].PostID)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	assert := assert.New(t)
+	peer := &mockNotificationPeer{}
+	m := &mockNotificationModel{
+		mockNotificationPeer: peer,
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one"},
+			"uid2": {ID: "uid2", Username: "two"},
+		},
+	}
+	peer.SaveNew(peer.NewNotification("uid1", model.NotificationMention, "uid2", "pid1"))
+	peer.SaveNew(peer.NewNotification("uid1", model.NotificationVote, "uid2", "pid2"))
+	peer.SaveNew(peer.NewNotification("uid2", model.NotificationVote, "uid1", "pid3"))
+	c := &NotificationController{Model: m}
+	request := func(method, id, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", "uid1")
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://notifications", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	w := request("GET", "", "", c.Notifications)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `{"type":"notifications","id":"nid2","attributes":{"created_at":1448272067,"kind":"vote","read":false},"relationships":{"actor":{"links":{"related":"/api/users/uid2"},"data":{"type":"users","id":"uid2"}},"post":{"links":{"related":"/api/posts/pid2"},"data":{"type":"posts","id":"pid2"}}}}`)
+	assert.NotContains(w.Body.String(), `"id":"nid3"`, "Only notifications of the user are listed")
+	assert.Contains(w.Body.String(), `"included":[{"type":"users","id":"uid2"`)
+	assert.Contains(w.Body.String(), `"meta":{"unread":2}`)
+	c.Limit = 1
+	w = request("GET", "", "", c.Notifications)
+	assert.NotContains(w.Body.String(), `"id":"nid1"`)
+	assert.Contains(w.Body.String(), `"meta":{"unread":2}`, "Unread notifications beyond the limit are counted")
+	c.Limit = 0
+
+	w = request("POST", "nid3", "", c.Read)
+	assert.Equal(http.StatusNotFound, w.Code, "Notifications of other users can not be read")
+	assert.True(peer.notifications[2].Unread())
+	w = request("POST", "nid1", "", c.Read)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	w = request("GET", "", "", c.Notifications)
+	assert.Contains(w.Body.String(), `"meta":{"unread":1}`)
+	w = request("POST", "", "", c.ReadAll)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	w = request("GET", "", "", c.Notifications)
+	assert.Contains(w.Body.String(), `"meta":{"unread":0}`)
+	assert.True(peer.notifications[2].Unread())
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockNotificationModel{
+		mockNotificationPeer: &mockNotificationPeer{},
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "one"},
+		},
+	}
+	c := &NotificationController{Model: m}
+	request := func(method, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", "uid1")
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://notifications/preferences", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	w := request("GET", "", c.Preferences)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"attributes":{"digest":true,"mention":true,"mention_email":true,"vote":true}`)
+
+	for _, attrs := range []string{`{"reply":false}`, `{"vote":"no"}`} {
+		w = request("PATCH", `{"data":{"type":"notification-preferences","attributes":`+attrs+`}}`, c.UpdatePreferences)
+		assert.Equal(http.StatusBadRequest, w.Code, attrs)
+		assert.Contains(w.Body.String(), `"code":"invalid_preference"`)
+	}
+	w = request("PATCH", `{"data":{"type":"notification-preferences","attributes":{"vote":false}},"padding":"`+strings.Repeat("a", DefaultMaxBodySize)+`"}`, c.UpdatePreferences)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Bodies are limited")
+	assert.Empty(m.users["uid1"].Muted)
+
+	w = request("PATCH", `{"data":{"type":"notification-preferences","attributes":{"vote":false}}}`, c.UpdatePreferences)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"attributes":{"digest":true,"mention":true,"mention_email":true,"vote":false}`)
+	assert.Equal([]string{model.NotificationVote}, m.users["uid1"].Muted)
+
+	w = request("PATCH", `{"data":{"type":"notification-preferences","attributes":{"mention":false}}}`, c.UpdatePreferences)
+	assert.Contains(w.Body.String(), `"attributes":{"digest":true,"mention":false,"mention_email":true,"vote":false}`, "Kinds not part of the request are unchanged")
+}
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/validation"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// maxPollOptions is the maximum number of options of a poll.
+	maxPollOptions = 10
+	// maxPollOptionLength is the maximum length of an option in characters.
+	maxPollOptionLength = 100
+)
+
+type pollCreateReq struct {
+	Options     []string `json:"options"`
+	ClosesAt    int64    `json:"closes_at"`
+	HideResults bool     `json:"hide_results"`
+}
+
+// parsePoll validates the poll of a new post published at publishAt, zero if it is published now.
+// A poll has 2 to maxPollOptions distinct options and closes after it is published.
+func parsePoll(req *pollCreateReq, publishAt, now time.Time) (*model.Poll, []*jsonapi.Error) {
+	var errs []*jsonapi.Error
+	poll := &model.Poll{
+		HideResults: req.HideResults,
+	}
+	if len(req.Options) < 2 || len(req.Options) > maxPollOptions {
+		errs = append(errs, &jsonapi.Error{
+			Code:   "invalid_poll_options",
+			Title:  "Invalid poll options",
+			Detail: "Polls must have between 2 and " + strconv.Itoa(maxPollOptions) + " options",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/poll/options",
+			},
+		})
+	}
+	seen := make(map[string]bool, len(req.Options))
+	for i, o := range req.Options {
+		o = validation.Normalize(o)
+		key := strings.ToLower(o)
+		if o == "" || utf8.RuneCountInString(o) > maxPollOptionLength || seen[key] {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_poll_option",
+				Title:  "Invalid poll option",
+				Detail: "Options must be distinct and between 1 and " + strconv.Itoa(maxPollOptionLength) + " characters long",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/poll/options/" + strconv.Itoa(i),
+				},
+			})
+		}
+		seen[key] = true
+		poll.Options = append(poll.Options, o)
+	}
+	if req.ClosesAt != 0 {
+		poll.ClosesAt = time.Unix(req.ClosesAt, 0)
+		start := now
+		if publishAt.After(now) {
+			start = publishAt
+		}
+		if !poll.ClosesAt.After(start) {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_poll_closes_at",
+				Title:  "Invalid poll closing time",
+				Detail: "Polls must close after they are published",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/poll/closes_at",
+				},
+			})
+		}
+	}
+	return poll, errs
+}
+
+// pollAttribute converts the poll to its attribute value without results, nil if the post is no poll.
+func pollAttribute(poll *model.Poll, now time.Time) interface{} {
+	if poll == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"options":      poll.Options,
+		"closes_at":    timeAttribute(poll.ClosesAt),
+		"closed":       poll.Closed(now),
+		"hide_results": poll.HideResults,
+		"results":      nil,
+		"voted":        nil,
+	}
+}
+
+// addPollResults adds the option the user voted for and the number of votes per option to the poll attribute.
+// If the poll hides its results, they are only added after the user voted or the poll closed.
+func addPollResults(attr map[string]interface{}, poll *model.Poll, votes []*model.Vote, user string, now time.Time) {
+	voted := false
+	for _, v := range votes {
+		if v.UID == user {
+			attr["voted"] = v.Option
+			voted = true
+		}
+	}
+	if !poll.HideResults || voted || poll.Closed(now) {
+		attr["results"] = model.Tally(votes, len(poll.Options))
+	}
+}
+
+type voteCreateReq struct {
+	Data struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			Option *int `json:"option"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Vote handles requests to vote in the poll of the post identified by the id url parameter.
+//
+// Example request: `{"data":{"type":"votes","attributes":{"option":1}}}`
+//
+// The option is the index of the chosen option. Every user can vote only once, further votes are rejected with status code http.StatusConflict.
+// Votes in closed polls are rejected with status code http.StatusForbidden.
+// On success the post including the results of the poll is returned with status code http.StatusOK.
+func (p *PostController) Vote(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	post, err := p.Model.GetByID(id)
+	if err != nil || !visible(post, user) {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	if post.Poll == nil || p.Votes == nil {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "not_a_poll",
+			Title:  "Post is not a poll",
+			Detail: "Votes are only accepted for polls",
+		})
+		return
+	}
+	var req voteCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "votes" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'votes'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	option := req.Data.Attributes.Option
+	if option == nil || *option < 0 || *option >= len(post.Poll.Options) {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_option",
+			Title:  "Invalid option",
+			Detail: "Option must be the index of an option of the poll",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/option",
+			},
+		})
+		return
+	}
+	now := time.Now()
+	if post.Poll.Closed(now) {
+		jsonErrors(w, r, http.StatusForbidden, &jsonapi.Error{
+			Code:  "poll_closed",
+			Title: "Poll closed",
+		})
+		return
+	}
+	err = p.Votes.SaveVote(&model.Vote{
+		PostID:    post.ID,
+		UID:       user,
+		Option:    *option,
+		CreatedAt: now,
+	})
+	if err == model.ErrAlreadyVoted {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:  "already_voted",
+			Title: "Already voted",
+		})
+		return
+	}
+	if err != nil {
+		log.Warnf("Could not save vote in poll %s: %s", post.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if p.Notifications != nil {
+		authors, err := p.Model.GetUsersByIDs([]string{post.UID})
+		if err != nil {
+			log.Warnf("Could not lookup author of poll %s: %s", post.ID, err)
+		}
+		notify(p.Notifications, authors, model.NotificationVote, user, post.ID)
+	}
+	p.writePost(w, r, http.StatusOK, post, user, include, fs)
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockVotePeer keeps votes in memory and allows a single vote per user and poll.
+type mockVotePeer struct {
+	votes []*model.Vote
+	// err is returned by GetVotesByPosts if set
+	err error
+}
+
+func (m *mockVotePeer) SaveVote(v *model.Vote) error {
+	for _, o := range m.votes {
+		if o.PostID == v.PostID && o.UID == v.UID {
+			return model.ErrAlreadyVoted
+		}
+	}
+	m.votes = append(m.votes, v)
+	return nil
+}
+
+func (m *mockVotePeer) GetVotes(postID string) ([]*model.Vote, error) {
+	var res []*model.Vote
+	for _, v := range m.votes {
+		if v.PostID == postID {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockVotePeer) GetVotesByPosts(postIDs []string) (map[string][]*model.Vote, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	res := make(map[string][]*model.Vote)
+	for _, id := range postIDs {
+		votes, _ := m.GetVotes(id)
+		res[id] = votes
+	}
+	return res, nil
+}
+
+func TestCreatePoll(t *testing.T) {
+	assert := assert.New(t)
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+	}
+	c := &PostController{Model: mockModel}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	create := func(poll string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"Lunch today?","poll":`+poll+`}}}`))
+		c.Create(ctx, w, r)
+		return w
+	}
+
+	w := create(`{"options":["yes","no"]}`)
+	assert.Equal(http.StatusBadRequest, w.Code, "Polls require a vote store")
+	assert.Contains(w.Body.String(), `"code":"polls_unsupported"`)
+
+	c.Votes = &mockVotePeer{}
+	now := time.Now().Unix()
+	tests := []struct {
+		poll string
+		errs []string
+	}{
+		{`{"options":["yes"]}`, []string{"invalid_poll_options"}},
+		{`{"options":["1","2","3","4","5","6","7","8","9","10","11"]}`, []string{"invalid_poll_options"}},
+		{`{"options":["yes"," YES ",""]}`, []string{`"pointer":"/data/attributes/poll/options/1"`, `"pointer":"/data/attributes/poll/options/2"`}},
+		{fmt.Sprintf(`{"options":["yes","no"],"closes_at":%d}`, now-60), []string{"invalid_poll_closes_at"}},
+	}
+	for _, test := range tests {
+		w := create(test.poll)
+		assert.Equal(http.StatusBadRequest, w.Code, test.poll)
+		for _, e := range test.errs {
+			assert.Contains(w.Body.String(), e, test.poll)
+		}
+	}
+	assert.Nil(post)
+
+	w = create(fmt.Sprintf(`{"options":[" yes ","no"],"closes_at":%d,"hide_results":true}`, now+3600))
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), fmt.Sprintf(`"poll":{"closed":false,"closes_at":%d,"hide_results":true,"options":["yes","no"],"results":null,"voted":null}`, now+3600))
+	if assert.NotNil(post) && assert.NotNil(post.Poll) {
+		assert.Equal([]string{"yes", "no"}, post.Poll.Options)
+		assert.True(post.Poll.HideResults)
+	}
+}
+
+func TestVote(t *testing.T) {
+	assert := assert.New(t)
+	posts := map[string]*model.Post{
+		"poll":   {ID: "poll", UID: "uid123", Message: "Lunch?", Poll: &model.Poll{Options: []string{"yes", "no"}, HideResults: true}},
+		"closed": {ID: "closed", UID: "uid123", Message: "Dinner?", Poll: &model.Poll{Options: []string{"yes", "no"}, ClosesAt: time.Now().Add(-time.Minute)}},
+		"plain":  {ID: "plain", UID: "uid123", Message: "No poll"},
+	}
+	mockModel := &mockPostPeer{
+		getidFn: func(id string) (*model.Post, error) {
+			if p, ok := posts[id]; ok {
+				return p, nil
+			}
+			return nil, fmt.Errorf("Not found")
+		},
+	}
+	votes := &mockVotePeer{}
+	c := &PostController{Model: mockModel, Votes: votes}
+	vote := func(user, id, body string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://vote?include=", strings.NewReader(body))
+		c.Vote(ctx, w, r)
+		return w
+	}
+	const yes = `{"data":{"type":"votes","attributes":{"option":0}}}`
+
+	w := vote("uid456", "missing", yes)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+	w = vote("uid456", "plain", yes)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"not_a_poll"`)
+	for _, body := range []string{`{"data":{"type":"votes","attributes":{"option":2}}}`, `{"data":{"type":"votes","attributes":{}}}`} {
+		w = vote("uid456", "poll", body)
+		assert.Equal(http.StatusBadRequest, w.Code, body)
+		assert.Contains(w.Body.String(), `"code":"invalid_option"`)
+	}
+	w = vote("uid456", "poll", `{"data":{"type":"votes","attributes":{"option":0}},"padding":"`+strings.Repeat("a", DefaultMaxBodySize)+`"}`)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Bodies are limited")
+	assert.Contains(w.Body.String(), `"code":"request_too_large"`)
+	w = vote("uid456", "closed", yes)
+	assert.Equal(http.StatusForbidden, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"poll_closed"`)
+	assert.Empty(votes.votes)
+
+	// Results are hidden until the user voted
+	votes.SaveVote(&model.Vote{PostID: "poll", UID: "uid789", Option: 1})
+	ctx := context.WithValue(context.Background(), "user", "uid456")
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "poll"})
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://post?include=", nil)
+	c.Post(ctx, w, r)
+	assert.Contains(w.Body.String(), `"results":null,"voted":null`)
+
+	w = vote("uid456", "poll", yes)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"results":[1,1],"voted":0`)
+
+	w = vote("uid456", "poll", `{"data":{"type":"votes","attributes":{"option":1}}}`)
+	assert.Equal(http.StatusConflict, w.Code, "Users must vote only once")
+	assert.Contains(w.Body.String(), `"code":"already_voted"`)
+	assert.Len(votes.votes, 2)
+
+	// Polls are rendered without results if the votes can not be loaded
+	votes.err = errors.New("Unavailable")
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://post?include=", nil)
+	c.Post(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"results":null,"voted":null`)
+}
@@ -1,9 +1,20 @@
 package controller
 
 import (
-	"encoding/json"
 	"net/http"
+	"net/url"
+	"posty/blob"
+	"posty/dedup"
+	"posty/jsonapi"
+	"posty/markdown"
 	"posty/model"
+	"posty/search"
+	"posty/tagging"
+	"posty/unfurl"
+	"posty/validation"
+	"sort"
+	"strconv"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"golang.org/x/net/context"
@@ -11,75 +22,750 @@ import (
 
 // PostDataProvider defines the needed model interactions.
 type PostDataProvider interface {
-	GetUserByID(id string) (*model.User, error)
-	GetPosts() ([]*model.Post, error)
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetUsersByHandles(handles []string) (map[string]*model.User, error)
+	QueryPosts(q model.PostQuery) ([]*model.Post, error)
+	GetPublished(since, until time.Time) ([]*model.Post, error)
+	GetDue(now time.Time) ([]*model.Post, error)
+	MarkAnnounced(p *model.Post) error
+	GetPostsByTag(tag string) ([]*model.Post, error)
+	GetPostsByMention(handle string) ([]*model.Post, error)
 	NewPost(uid string) *model.Post
 	SaveNew(p *model.Post) error
 	GetByID(id string) (*model.Post, error)
+	Pin(p *model.Post, max int) error
+	Unpin(p *model.Post) error
+	ClaimAttachment(id, postID string) error
+	ReleaseAttachment(id, postID string) error
 	Remove(p *model.Post) error
+	Restore(p *model.Post) error
+	GetDeletedByID(id string) (*model.Post, error)
+	GetDeletedPosts(before time.Time) ([]*model.Post, error)
+	Purge(p *model.Post) error
+}
+
+// PreviewQueue fetches link previews of posts in the background.
+type PreviewQueue interface {
+	// Cached returns the preview of the url if it is already known.
+	Cached(url string) (*unfurl.Preview, bool)
+	// Enqueue schedules fetching the preview of the url for the post, false is returned if the job was dropped.
+	Enqueue(postID, url string) bool
 }
 
 // PostController handles post related requests.
+// If Index is set, it is kept up to date with created and removed posts and used for full-text queries.
+// If Trending is set, it counts the tags of published posts.
+// Renderer renders markdown posts, if it is nil images are never embedded.
+// If Attachments is set, new posts may reference uploaded attachments which are removed together with the post.
+// If Previews is set, previews of the first link of new posts are fetched.
+// MessageRules and MaxBodySize restrict new posts, DefaultMessageRules and DefaultMaxBodySize are used if they are not set.
+// If Duplicates is set, messages repeated too often are rejected or, if QuarantineDuplicates is set, quarantined.
+// Removed posts can be restored by their author within RestoreWindow, DefaultRestoreWindow is used if it is not set.
+// Moderators can pin up to MaxPinned posts to the top of the wall, DefaultMaxPinned is used if it is not set.
+// If Votes is set, posts can be polls users vote in.
+// If Notifications is set, users mentioned in published posts and authors of polls are notified, the notifications of purged posts are removed.
+// If Emails is set, mentioned users are also notified by email.
+// If Webhooks is set, published posts visible to everyone are published to webhooks, also once they are removed.
+// Scheduled posts are handled by Publish once they are published. If QuietPublish is set, it only counts their tags
+// and leaves notifying and webhooks to another instance.
 type PostController struct {
-	Model PostDataProvider
+	Model        PostDataProvider
+	Index        search.Index
+	Trending     *tagging.Trending
+	Renderer     *markdown.Renderer
+	Attachments  blob.Store
+	Previews     PreviewQueue
+	MessageRules *validation.Rules
+	MaxBodySize  int64
+
+	Duplicates           *dedup.Checker
+	QuarantineDuplicates bool
+	RestoreWindow        time.Duration
+	MaxPinned            int
+	Votes                model.VotePeer
+	Notifications        model.NotificationPeer
+	Emails               *Emailer
+	Webhooks             WebhookPublisher
+	QuietPublish         bool
+}
+
+// DefaultMessageRules are the rules of messages if no rules are configured.
+var DefaultMessageRules = &validation.Rules{
+	MinLength: 6,
+	MaxLength: 1000,
+}
+
+// DefaultMaxBodySize is the maximum size of a request creating a post if none is configured.
+const DefaultMaxBodySize = 64 << 10
+
+// DefaultRestoreWindow is the time removed posts can be restored if none is configured.
+const DefaultRestoreWindow = 24 * time.Hour
+
+// DefaultMaxPinned is the number of posts which can be pinned at the same time if none is configured.
+const DefaultMaxPinned = 3
+
+// restoreWindow returns the configured or default restore window.
+func (p *PostController) restoreWindow() time.Duration {
+	if p.RestoreWindow > 0 {
+		return p.RestoreWindow
+	}
+	return DefaultRestoreWindow
 }
 
-type postsResponse struct {
-	Data []*jsonPost `json:"data"`
+// visible returns true if the user may see the post.
+// Quarantined, hidden, scheduled and expired posts are only visible to their author.
+func visible(post *model.Post, user string) bool {
+	return !post.Quarantined && !post.Hidden && post.Live(time.Now()) || post.UID == user
 }
 
-type jsonPost struct {
-	ID        string `json:"id"`
-	UID       string `json:"user_id"`
-	Username  string `json:"username"`
-	Message   string `json:"message"`
-	CreatedAt int64  `json:"created_at"`
+// trends returns true if the tags of the post are counted for trending at now:
+// it is published and neither quarantined nor hidden. The tags are counted at the time it was published.
+func trends(post *model.Post, now time.Time) bool {
+	return !post.Quarantined && !post.Hidden && !post.Scheduled(now)
+}
+
+// visiblePosts returns the posts the user may see.
+func visiblePosts(ps []*model.Post, user string) []*model.Post {
+	res := make([]*model.Post, 0, len(ps))
+	for _, post := range ps {
+		if visible(post, user) {
+			res = append(res, post)
+		}
+	}
+	return res
+}
+
+// fieldErrors converts validation errors to JSON API errors of the attribute.
+func fieldErrors(attribute string, errs []*validation.Error) []*jsonapi.Error {
+	res := make([]*jsonapi.Error, len(errs))
+	for i, e := range errs {
+		res[i] = &jsonapi.Error{
+			Code:   attribute + "_" + e.Code,
+			Title:  e.Title,
+			Detail: e.Detail,
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/" + attribute,
+			},
+		}
+	}
+	return res
+}
+
+// postIncludes lists the relationships of posts which can be included, all of them are included by default.
+var postIncludes = []string{"author", "attachments"}
+
+// postResource converts a post to its JSON API representation.
+func (p *PostController) postResource(post *model.Post) *jsonapi.Resource {
+	format := post.Format
+	if format == "" {
+		format = model.FormatPlain
+	}
+	attachments := make([]*jsonapi.Identifier, len(post.Attachments))
+	for i, a := range post.Attachments {
+		attachments[i] = &jsonapi.Identifier{
+			Type: "attachments",
+			ID:   a.ID,
+		}
+	}
+	return &jsonapi.Resource{
+		Type: "posts",
+		ID:   post.ID,
+		Attributes: map[string]interface{}{
+			"message":      post.Message,
+			"format":       format,
+			"hidden":       post.Hidden,
+			"message_html": p.messageHTML(post),
+			"preview":      p.previewAttribute(post.Preview),
+			"quarantined":  post.Quarantined,
+			"tags":         nonNil(post.Tags),
+			"mentions":     nonNil(post.Mentions),
+			"pinned":       !post.PinnedAt.IsZero(),
+			"poll":         pollAttribute(post.Poll, time.Now()),
+			"created_at":   post.CreatedAt.Unix(),
+			"publish_at":   timeAttribute(post.PublishAt),
+			"expires_at":   timeAttribute(post.ExpiresAt),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"author": {
+				Links: &jsonapi.Links{
+					Related: "/api/users/" + post.UID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   post.UID,
+				},
+			},
+			"attachments": {
+				Data: attachments,
+			},
+		},
+		Links: &jsonapi.Links{
+			Self: "/api/posts/" + post.ID,
+		},
+	}
+}
+
+// timeAttribute converts the timestamp to its unix timestamp attribute value, nil if it is not set.
+func timeAttribute(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Unix()
+}
+
+// previewAttribute converts the link preview to its attribute value, nil if there is no preview.
+// Like images of markdown posts, the image is only returned if the Renderer allows its origin.
+func (p *PostController) previewAttribute(pv *model.Preview) interface{} {
+	if pv == nil {
+		return nil
+	}
+	imageURL := ""
+	if p.Renderer != nil && p.Renderer.AllowedImage(pv.ImageURL) {
+		imageURL = pv.ImageURL
+	}
+	return map[string]string{
+		"url":         pv.URL,
+		"title":       pv.Title,
+		"description": pv.Description,
+		"image_url":   imageURL,
+	}
+}
+
+// messageHTML renders the message of the post to sanitised html according to its format.
+func (p *PostController) messageHTML(post *model.Post) string {
+	return renderMessage(p.Renderer, post)
+}
+
+// renderMessage renders the message of the post to sanitised html using the renderer, images are never embedded if it is nil.
+func renderMessage(r *markdown.Renderer, post *model.Post) string {
+	if post.Format != model.FormatMarkdown {
+		return markdown.RenderPlain(post.Message)
+	}
+	if r == nil {
+		r = &markdown.Renderer{}
+	}
+	return r.Render(post.Message)
+}
+
+// nonNil returns an empty slice instead of nil, so it is encoded as empty json array.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
 }
 
-// Posts gets all posts from the database and returns valid json, otherwise a json error.
+// pollVotes returns the votes of the polls among the posts keyed by post id, nil if Votes is not set or the votes could not be loaded.
+func (p *PostController) pollVotes(ps []*model.Post) map[string][]*model.Vote {
+	if p.Votes == nil {
+		return nil
+	}
+	var ids []string
+	for _, post := range ps {
+		if post.Poll != nil {
+			ids = append(ids, post.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	votes, err := p.Votes.GetVotesByPosts(ids)
+	if err != nil {
+		log.Warnf("Could not get votes of %d polls: %s", len(ids), err)
+		return nil
+	}
+	return votes
+}
+
+// render converts the posts to resources and resolves the requested included resources.
+// Authors are resolved using a single lookup, authors which could not be found are omitted.
+// Attachments are built from the metadata stored on the posts.
+// If Votes is set, the results of polls are added as far as the user may see them. The votes of all polls are loaded at once,
+// if they can not be loaded the polls are rendered without results.
+func (p *PostController) render(ps []*model.Post, user string, include []string, fs jsonapi.Fieldsets) ([]*jsonapi.Resource, []*jsonapi.Resource, error) {
+	data := make([]*jsonapi.Resource, len(ps))
+	var authorIDs []string
+	var attachments []model.Attachment
+	now := time.Now()
+	votes := p.pollVotes(ps)
+	for i, post := range ps {
+		data[i] = p.postResource(post)
+		if pv, ok := votes[post.ID]; ok {
+			addPollResults(data[i].Attributes["poll"].(map[string]interface{}), post.Poll, pv, user, now)
+		}
+		fs.Apply(data[i])
+		if _, ok := data[i].Relationships["author"]; ok {
+			authorIDs = append(authorIDs, post.UID)
+		}
+		if _, ok := data[i].Relationships["attachments"]; ok {
+			attachments = append(attachments, post.Attachments...)
+		}
+	}
+	included := []*jsonapi.Resource{}
+	if jsonapi.Includes(include, "author") && len(authorIDs) > 0 {
+		users, err := p.Model.GetUsersByIDs(authorIDs)
+		if err != nil {
+			return nil, nil, err
+		}
+		seen := make(map[string]bool, len(users))
+		for _, id := range authorIDs {
+			u, ok := users[id]
+			if !ok || seen[id] {
+				continue
+			}
+			seen[id] = true
+			res := userResource(u)
+			fs.Apply(res)
+			included = append(included, res)
+		}
+	}
+	if jsonapi.Includes(include, "attachments") {
+		for _, a := range attachments {
+			res := attachmentResource(a)
+			fs.Apply(res)
+			included = append(included, res)
+		}
+	}
+	return data, included, nil
+}
+
+// parseQuery parses the include and fieldset parameters of the request.
+// On error a json error is written and ok is false.
+func parseQuery(w http.ResponseWriter, r *http.Request, supported []string) (include []string, fs jsonapi.Fieldsets, ok bool) {
+	query := r.URL.Query()
+	include, err := jsonapi.ParseInclude(query, supported, supported)
+	if err != nil {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:   "invalid_include",
+			Title:  "Invalid include parameter",
+			Detail: err.Error(),
+			Source: &jsonapi.ErrorSource{
+				Parameter: "include",
+			},
+		})
+		return nil, nil, false
+	}
+	return include, jsonapi.ParseFieldsets(query), true
+}
+
+// parseTime parses a timestamp given as unix timestamp in seconds or RFC3339.
+func parseTime(s string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parsePostFilter parses the filter parameters `filter[author]`, `filter[since]` and `filter[until]`.
+func parsePostFilter(query url.Values) (model.PostQuery, *jsonapi.Error) {
+	q := model.PostQuery{
+		Author: query.Get("filter[author]"),
+	}
+	params := []struct {
+		name string
+		ts   *time.Time
+	}{
+		{"filter[since]", &q.Since},
+		{"filter[until]", &q.Until},
+	}
+	for _, param := range params {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return q, &jsonapi.Error{
+				Code:   "invalid_filter",
+				Title:  "Invalid filter parameter",
+				Detail: "Timestamp must be a unix timestamp or RFC3339",
+				Source: &jsonapi.ErrorSource{
+					Parameter: param.name,
+				},
+			}
+		}
+		*param.ts = t
+	}
+	return q, nil
+}
+
+// searchPosts reduces the posts to those matching the full-text query.
+func (p *PostController) searchPosts(ps []*model.Post, q string) ([]*model.Post, error) {
+	ids, err := p.Index.Search(q)
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		matches[id] = true
+	}
+	result := make([]*model.Post, 0, len(ids))
+	for _, post := range ps {
+		if matches[post.ID] {
+			result = append(result, post)
+		}
+	}
+	return result, nil
+}
+
+// Posts gets all posts from the database and returns a JSON API document, otherwise a json error.
+// Pinned posts are returned first, the most recently pinned first, followed by the other posts newest first.
+// The authors of the posts are included unless requested otherwise using the `include` parameter.
+// Sparse fieldsets are supported using `fields[posts]` and `fields[users]`.
+//
+// Posts can be filtered by their author using `filter[author]=USERID` and by their creation time using
+// `filter[since]` and `filter[until]` as unix timestamp or RFC3339. The parameter `q` restricts the result to posts
+// matching the full-text query.
 func (p *PostController) Posts(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	ps, err := p.Model.GetPosts()
+	user, _ := ctx.Value("user").(string)
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	filter, ferr := parsePostFilter(query)
+	if ferr != nil {
+		jsonErrors(w, r, cErrClient, ferr)
+		return
+	}
+	q := query.Get("q")
+	if q != "" && p.Index == nil {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:  "search_unsupported",
+			Title: "Full-text search is not supported",
+			Source: &jsonapi.ErrorSource{
+				Parameter: "q",
+			},
+		})
+		return
+	}
+	ps, err := p.Model.QueryPosts(filter)
 	if err != nil {
 		jsonError(w, r, cErrServer, "")
 		return
 	}
-	jsonPosts := make([]*jsonPost, len(ps))
-	for i, p := range ps {
-		jsonPosts[i] = &jsonPost{
-			ID:        p.ID,
-			UID:       p.UID,
-			Username:  p.Username,
-			Message:   p.Message,
-			CreatedAt: p.CreatedAt.Unix(),
+	if q != "" {
+		ps, err = p.searchPosts(ps, q)
+		if err != nil {
+			log.Warnf("Could not search posts: %s", err)
+			jsonError(w, r, cErrServer, "")
+			return
 		}
 	}
-	resp := postsResponse{
-		Data: jsonPosts,
+	sort.Stable(model.ByPinnedFirst(ps))
+	p.writePosts(w, r, visiblePosts(ps, user), user, include, fs, "/api/posts")
+}
+
+// writePosts writes a document containing the posts as primary data.
+// The results of polls are rendered for the user.
+func (p *PostController) writePosts(w http.ResponseWriter, r *http.Request, ps []*model.Post, user string, include []string, fs jsonapi.Fieldsets, self string) {
+	data, included, err := p.render(ps, user, include, fs)
+	if err != nil {
+		log.Warnf("Could not render posts: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data:     data,
+		Included: included,
+		Links: &jsonapi.Links{
+			Self: self,
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write posts: %s", err)
+	}
+}
+
+// TagPosts returns all posts tagged with the tag given as url parameter, newest first.
+func (p *PostController) TagPosts(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, _ := ctx.Value("user").(string)
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	tag, ok := urlParam(ctx, "tag")
+	if !ok || tagging.NormalizeTag(tag) == "" {
+		jsonError(w, r, cErrClient, "Missing tag parameter")
+		return
+	}
+	tag = tagging.NormalizeTag(tag)
+	ps, err := p.Model.GetPostsByTag(tag)
+	if err != nil {
+		log.Warnf("Could not get posts by tag %q: %s", tag, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	p.writePosts(w, r, visiblePosts(ps, user), user, include, fs, "/api/tags/"+url.QueryEscape(tag)+"/posts")
+}
+
+// Scheduled returns the posts of the logged in user which are scheduled for later publication, next first.
+func (p *PostController) Scheduled(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	ps, err := p.Model.QueryPosts(model.PostQuery{Author: user, Scheduled: true})
+	if err != nil {
+		log.Warnf("Could not get scheduled posts of %s: %s", user, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	sort.Sort(model.ByPublishAtASC(ps))
+	p.writePosts(w, r, ps, user, include, fs, "/api/scheduled")
+}
+
+// Mentions returns all posts mentioning the logged in user, newest first.
+// Users are mentioned using the handle derived from their username, see `tagging.Handle`.
+func (p *PostController) Mentions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	users, err := p.Model.GetUsersByIDs([]string{user})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	u, ok := users[user]
+	if !ok {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	ps := []*model.Post{}
+	if handle := tagging.Handle(u.Username); handle != "" {
+		ps, err = p.Model.GetPostsByMention(handle)
+		if err != nil {
+			log.Warnf("Could not get posts by mention %q: %s", handle, err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
+	p.writePosts(w, r, visiblePosts(ps, user), user, include, fs, "/api/mentions")
+}
+
+// maxTrendingTags limits the amount of trending tags returned.
+const maxTrendingTags = 50
+
+// TrendingTags returns the tags used most within the trending window, limited by the `limit` parameter (default 10).
+func (p *PostController) TrendingTags(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if p.Trending == nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxTrendingTags {
+			jsonErrors(w, r, cErrClient, &jsonapi.Error{
+				Code:   "invalid_limit",
+				Title:  "Invalid limit parameter",
+				Detail: "Limit must be between 1 and " + strconv.Itoa(maxTrendingTags),
+				Source: &jsonapi.ErrorSource{
+					Parameter: "limit",
+				},
+			})
+			return
+		}
+		limit = l
+	}
+	top := p.Trending.Top(limit, time.Now())
+	data := make([]*jsonapi.Resource, len(top))
+	for i, tc := range top {
+		data[i] = &jsonapi.Resource{
+			Type: "tags",
+			ID:   tc.Tag,
+			Attributes: map[string]interface{}{
+				"count": tc.Count,
+			},
+			Links: &jsonapi.Links{
+				Related: "/api/tags/" + url.QueryEscape(tc.Tag) + "/posts",
+			},
+		}
+	}
+	err := jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: data,
+		Links: &jsonapi.Links{
+			Self: "/api/tags/trending",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write trending tags: %s", err)
+	}
+}
+
+// Post returns the single post identified by the id url parameter.
+func (p *PostController) Post(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, _ := ctx.Value("user").(string)
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	post, err := p.Model.GetByID(id)
+	if err != nil || !visible(post, user) {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
 	}
-	enc := json.NewEncoder(w)
-	err = enc.Encode(&resp)
+	p.writePost(w, r, http.StatusOK, post, user, include, fs)
+}
+
+// writePost writes a document containing a single post as primary data.
+// The results of polls are rendered for the user.
+func (p *PostController) writePost(w http.ResponseWriter, r *http.Request, code int, post *model.Post, user string, include []string, fs jsonapi.Fieldsets) {
+	data, included, err := p.render([]*model.Post{post}, user, include, fs)
 	if err != nil {
+		log.Warnf("Could not render post: %s", err)
 		jsonError(w, r, cErrServer, "")
 		return
 	}
+	err = jsonapi.Write(w, code, &jsonapi.Document{
+		Data:     data[0],
+		Included: included,
+	})
+	if err != nil {
+		log.Warnf("Could not write post: %s", err)
+	}
+}
+
+// postAttributes are the attributes of a new post.
+type postAttributes struct {
+	Message   string         `json:"message"`
+	Format    string         `json:"format"`
+	PublishAt int64          `json:"publish_at"`
+	ExpiresAt int64          `json:"expires_at"`
+	Poll      *pollCreateReq `json:"poll"`
 }
 
 type postCreateReq struct {
 	Data struct {
-		Message string `json:"message"`
+		Type          string         `json:"type"`
+		ID            string         `json:"id"`
+		Attributes    postAttributes `json:"attributes"`
+		Relationships struct {
+			Attachments struct {
+				Data []jsonapi.Identifier `json:"data"`
+			} `json:"attachments"`
+		} `json:"relationships"`
 	} `json:"data"`
 }
 
-type postCreateResp struct {
-	Data *jsonPost `json:"data"`
+// attachmentsForPost loads the uploaded attachments referenced by a new post.
+// Attachments must have been uploaded by the user and must not be attached to another post.
+func (p *PostController) attachmentsForPost(user string, ids []jsonapi.Identifier) ([]*attachmentMeta, []*jsonapi.Error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if p.Attachments == nil {
+		return nil, []*jsonapi.Error{{
+			Code:   "attachments_unsupported",
+			Title:  "Attachments are not supported",
+			Source: &jsonapi.ErrorSource{Pointer: "/data/relationships/attachments"},
+		}}
+	}
+	if len(ids) > maxAttachments {
+		return nil, []*jsonapi.Error{{
+			Code:   "too_many_attachments",
+			Title:  "Too many attachments",
+			Detail: "Posts must not have more than " + strconv.Itoa(maxAttachments) + " attachments",
+			Source: &jsonapi.ErrorSource{Pointer: "/data/relationships/attachments/data"},
+		}}
+	}
+	var metas []*attachmentMeta
+	var errs []*jsonapi.Error
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		pointer := "/data/relationships/attachments/data/" + strconv.Itoa(i)
+		if id.Type != "attachments" {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_type",
+				Title:  "Invalid resource type",
+				Detail: "Resource type must be 'attachments'",
+				Source: &jsonapi.ErrorSource{Pointer: pointer + "/type"},
+			})
+			continue
+		}
+		meta, err := loadAttachmentMeta(p.Attachments, id.ID)
+		if err != nil || meta.UID != user || meta.PostID != "" || seen[id.ID] {
+			if err != nil && err != blob.ErrNotFound && err != blob.ErrInvalidKey {
+				log.Warnf("Could not load attachment %s: %s", id.ID, err)
+			}
+			errs = append(errs, invalidAttachment(i))
+			continue
+		}
+		seen[id.ID] = true
+		metas = append(metas, meta)
+	}
+	return metas, errs
+}
+
+// invalidAttachment returns the error of the i-th referenced attachment which can not be attached.
+func invalidAttachment(i int) *jsonapi.Error {
+	return &jsonapi.Error{
+		Code:   "invalid_attachment",
+		Title:  "Invalid attachment",
+		Detail: "Attachment does not exist or can not be attached",
+		Source: &jsonapi.ErrorSource{Pointer: "/data/relationships/attachments/data/" + strconv.Itoa(i) + "/id"},
+	}
+}
+
+// claimAttachments claims the attachments loaded by attachmentsForPost for the post,
+// so concurrent posts can not attach the same upload. If an attachment can not be claimed, the claims made so far are released.
+func (p *PostController) claimAttachments(postID string, metas []*attachmentMeta) (int, []*jsonapi.Error) {
+	for i, meta := range metas {
+		err := p.Model.ClaimAttachment(meta.ID, postID)
+		if err == nil {
+			continue
+		}
+		p.releaseAttachments(postID, metas[:i])
+		if err == model.ErrAttachmentClaimed {
+			return cErrClient, []*jsonapi.Error{invalidAttachment(i)}
+		}
+		log.Warnf("Could not claim attachment %s: %s", meta.ID, err)
+		return cErrServer, []*jsonapi.Error{{}}
+	}
+	return 0, nil
+}
+
+// releaseAttachments releases the claims of the post, e.g. if it could not be saved.
+func (p *PostController) releaseAttachments(postID string, metas []*attachmentMeta) {
+	for _, meta := range metas {
+		if err := p.Model.ReleaseAttachment(meta.ID, postID); err != nil {
+			log.Warnf("Could not release attachment %s: %s", meta.ID, err)
+		}
+	}
 }
 
 // Create handles a request to create a new post.
 //
-// Example request: `{"data":{"message":"test message"}}`
+// Example request: `{"data":{"type":"posts","attributes":{"message":"test message","format":"markdown"}}}`
 //
-// It checks for a non empty message with a length of at least 6 characters.
-// On success it inserts an new post into the model and returns the created object as json with status code `http.StatusCreated`.
+// The message is normalised and checked against the MessageRules.
+// Messages the user or all users posted too often recently are rejected with status code `http.StatusConflict`,
+// or saved as quarantined post only visible to the author if QuarantineDuplicates is set.
+// The format is optional and defaults to plain text.
+// Posts can be scheduled using `publish_at` and expire at `expires_at`, both unix timestamps in the future.
+// If Votes is set, a post becomes a poll asking the message using the `poll` attribute, e.g. `{"options":["yes","no"],"closes_at":1448272067,"hide_results":true}`.
+// Until they are published and after they expired posts are only visible to their author.
+// Uploaded attachments are referenced by the `attachments` relationship.
+// On success it inserts an new post into the model and returns the created resource with status code `http.StatusCreated`.
 // Otherwise a json error is returned.
 func (p *PostController) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	user, ok := ctx.Value("user").(string)
@@ -88,52 +774,230 @@ func (p *PostController) Create(ctx context.Context, w http.ResponseWriter, r *h
 		jsonError(w, r, cErrServer, "")
 		return
 	}
-	dec := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	maxBody := p.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = DefaultMaxBodySize
+	}
 	var req postCreateReq
-	err := dec.Decode(&req)
-	if err != nil {
-		jsonError(w, r, cErrClient, "")
+	if !decodeBody(w, r, maxBody, &req) {
 		return
 	}
-	if req.Data.Message == "" || len(req.Data.Message) < 6 {
-		jsonError(w, r, cErrClient, "Message too short")
+	if req.Data.Type == "" {
+		jsonErrors(w, r, cErrClient, &jsonapi.Error{
+			Code:  "missing_type",
+			Title: "Missing resource type",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
 		return
 	}
-	userdata, err := p.Model.GetUserByID(user)
-	if err != nil {
-		jsonError(w, r, cErrServer, "")
+	if req.Data.Type != "posts" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'posts'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	if req.Data.ID != "" {
+		jsonErrors(w, r, http.StatusForbidden, &jsonapi.Error{
+			Code:  "client_id_unsupported",
+			Title: "Client generated ids are not supported",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/id",
+			},
+		})
 		return
 	}
+	post, code, errs := p.create(user, &req.Data.Attributes, req.Data.Relationships.Attachments.Data)
+	if post == nil {
+		jsonErrors(w, r, code, errs...)
+		return
+	}
+	w.Header().Set("Location", "/api/posts/"+post.ID)
+	p.writePost(w, r, http.StatusCreated, post, user, include, fs)
+}
+
+// create validates the attributes of a new post of the user and saves it, see Create.
+// On success the post is returned, otherwise the status code and errors of the response.
+func (p *PostController) create(user string, attrs *postAttributes, attachmentIDs []jsonapi.Identifier) (*model.Post, int, []*jsonapi.Error) {
+	rules := p.MessageRules
+	if rules == nil {
+		rules = DefaultMessageRules
+	}
+	message, verrs := rules.Check(attrs.Message)
+	if len(verrs) > 0 {
+		return nil, cErrClient, fieldErrors("message", verrs)
+	}
+	format := attrs.Format
+	if format == "" {
+		format = model.FormatPlain
+	}
+	if !model.ValidFormat(format) {
+		return nil, cErrClient, []*jsonapi.Error{{
+			Code:   "invalid_format",
+			Title:  "Invalid format",
+			Detail: "Format must be 'plain' or 'markdown'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/format",
+			},
+		}}
+	}
+	now := time.Now()
+	publishAt, expiresAt, errs := parseSchedule(attrs.PublishAt, attrs.ExpiresAt, now)
+	if len(errs) > 0 {
+		return nil, cErrClient, errs
+	}
+	var poll *model.Poll
+	if attrs.Poll != nil {
+		if p.Votes == nil {
+			return nil, cErrClient, []*jsonapi.Error{{
+				Code:  "polls_unsupported",
+				Title: "Polls are not supported",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/poll",
+				},
+			}}
+		}
+		poll, errs = parsePoll(attrs.Poll, publishAt, now)
+		if len(errs) > 0 {
+			return nil, cErrClient, errs
+		}
+	}
+	attachments, errs := p.attachmentsForPost(user, attachmentIDs)
+	if len(errs) > 0 {
+		return nil, cErrClient, errs
+	}
+	quarantined := false
+	if p.Duplicates != nil {
+		verdict, err := p.Duplicates.Check(user, message)
+		if err != nil {
+			log.Warnf("Could not check for duplicate messages: %s", err)
+		}
+		if verdict != dedup.Unique && !p.QuarantineDuplicates {
+			return nil, http.StatusConflict, []*jsonapi.Error{duplicateError(verdict)}
+		}
+		quarantined = verdict != dedup.Unique
+	}
 	post := p.Model.NewPost(user)
-	post.Message = req.Data.Message
-	post.Username = userdata.Username
-	err = p.Model.SaveNew(post)
-	if err != nil {
-		log.Warnf("Could not save post: %s", err)
-		jsonError(w, r, cErrServer, "")
+	if code, errs := p.claimAttachments(post.ID, attachments); len(errs) > 0 {
+		return nil, code, errs
 	}
-	jsonPost := &jsonPost{
-		ID:        post.ID,
-		UID:       post.UID,
-		Username:  post.Username,
-		Message:   post.Message,
-		CreatedAt: post.CreatedAt.Unix(),
+	post.Message = message
+	post.Quarantined = quarantined
+	post.Format = format
+	post.PublishAt = publishAt
+	post.ExpiresAt = expiresAt
+	post.Poll = poll
+	for _, meta := range attachments {
+		post.Attachments = append(post.Attachments, meta.Attachment)
 	}
-	postCreateResp := postCreateResp{
-		Data: jsonPost,
+	link := unfurl.FirstURL(post.Message)
+	if p.Previews != nil && link != "" {
+		if pv, ok := p.Previews.Cached(link); ok {
+			post.Preview = &model.Preview{
+				URL:         pv.URL,
+				Title:       pv.Title,
+				Description: pv.Description,
+				ImageURL:    pv.ImageURL,
+			}
+		}
 	}
-	enc := json.NewEncoder(w)
-	w.WriteHeader(http.StatusCreated)
-	err = enc.Encode(postCreateResp)
+	post.Tags, post.Mentions = tagging.Parse(post.Message)
+	err := p.Model.SaveNew(post)
 	if err != nil {
 		log.Warnf("Could not save post: %s", err)
-		jsonError(w, r, cErrServer, "")
+		p.releaseAttachments(post.ID, attachments)
+		return nil, cErrServer, []*jsonapi.Error{{}}
+	}
+	if p.Previews != nil && link != "" && post.Preview == nil {
+		if !p.Previews.Enqueue(post.ID, link) {
+			log.Warnf("Preview queue full, dropped preview of post %s", post.ID)
+		}
+	}
+	for _, meta := range attachments {
+		meta.PostID = post.ID
+		if err := saveAttachmentMeta(p.Attachments, meta); err != nil {
+			log.Warnf("Could not mark attachment %s as attached: %s", meta.ID, err)
+		}
+	}
+	if p.Index != nil {
+		if err := p.Index.Add(post.ID, post.Message); err != nil {
+			log.Warnf("Could not index post %s: %s", post.ID, err)
+		}
+	}
+	if post.PublishAt.IsZero() {
+		p.published(post)
+		p.announce(post)
+	}
+	return post, http.StatusCreated, nil
+}
+
+// parseSchedule converts the publish and expiry unix timestamps of a new post, zero timestamps are not set.
+// The publish time must be in the future, the expiry time after the publish time or now.
+func parseSchedule(publish, expires int64, now time.Time) (publishAt, expiresAt time.Time, errs []*jsonapi.Error) {
+	if publish != 0 {
+		publishAt = time.Unix(publish, 0)
+		if !publishAt.After(now) {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_publish_at",
+				Title:  "Invalid publish time",
+				Detail: "Publish time must be in the future",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/publish_at",
+				},
+			})
+		}
+	}
+	if expires != 0 {
+		expiresAt = time.Unix(expires, 0)
+		start := now
+		if publishAt.After(now) {
+			start = publishAt
+		}
+		if !expiresAt.After(start) {
+			errs = append(errs, &jsonapi.Error{
+				Code:   "invalid_expires_at",
+				Title:  "Invalid expiry time",
+				Detail: "Expiry time must be after the publish time",
+				Source: &jsonapi.ErrorSource{
+					Pointer: "/data/attributes/expires_at",
+				},
+			})
+		}
 	}
+	return publishAt, expiresAt, errs
+}
+
+// duplicateError describes why a message was rejected by the duplicate check.
+func duplicateError(verdict dedup.Verdict) *jsonapi.Error {
+	e := &jsonapi.Error{
+		Code:   "duplicate_message",
+		Title:  "Duplicate message",
+		Detail: "You posted this message recently",
+		Source: &jsonapi.ErrorSource{
+			Pointer: "/data/attributes/message",
+		},
+	}
+	if verdict == dedup.Flood {
+		e.Code = "message_flood"
+		e.Title = "Message flood"
+		e.Detail = "This message was posted too often recently"
+	}
+	return e
 }
 
 // Remove handles post remove requests and removes the post from the model if the user id matches the logged in user.
 // The post id is defined as an url parameter.
+// Removed posts are kept and can be restored within the restore window, afterwards they are purged together with their attachments.
 //
 // On success an empty response with status http.StatusNoContent is written.
 // If the post identified by the id could not be found http.StatusNotFound is returned.
@@ -145,8 +1009,7 @@ func (p *PostController) Remove(ctx context.Context, w http.ResponseWriter, r *h
 		jsonError(w, r, cErrServer, "")
 		return
 	}
-	urlParams := ctx.Value("urlparams").(map[string]string)
-	id, ok := urlParams["id"]
+	id, ok := urlParam(ctx, "id")
 	if !ok {
 		jsonError(w, r, cErrClient, "Missing id parameter")
 		return
@@ -165,5 +1028,230 @@ func (p *PostController) Remove(ctx context.Context, w http.ResponseWriter, r *h
 		jsonError(w, r, cErrServer, "")
 		return
 	}
+	if p.Index != nil {
+		if err := p.Index.Remove(post.ID); err != nil {
+			log.Warnf("Could not remove post %s from index: %s", post.ID, err)
+		}
+	}
+	if p.Trending != nil && trends(post, time.Now()) {
+		p.Trending.Forget(post.Tags, post.PublishedAt())
+	}
+	if announced(post, time.Now()) {
+		publish(p.Webhooks, model.EventPostDeleted, &jsonapi.Identifier{Type: "posts", ID: post.ID})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Restore handles requests to restore a removed post identified by the id url parameter.
+//
+// On success the restored post is returned with status code http.StatusOK.
+// If no removed post with the id exists http.StatusNotFound is returned, if it was removed by another user http.StatusUnauthorized.
+// Posts removed longer than the restore window ago can not be restored anymore, http.StatusGone is returned.
+func (p *PostController) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	post, err := p.Model.GetDeletedByID(id)
+	if err != nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	if post.UID != user {
+		jsonError(w, r, http.StatusUnauthorized, "Not allowed to restore resource")
+		return
+	}
+	if time.Since(post.DeletedAt) > p.restoreWindow() {
+		jsonErrors(w, r, http.StatusGone, &jsonapi.Error{
+			Code:   "restore_expired",
+			Title:  "Restore window expired",
+			Detail: "Posts can only be restored within " + p.restoreWindow().String() + " after they were removed",
+		})
+		return
+	}
+	if err := p.Model.Restore(post); err != nil {
+		log.Warnf("Could not restore post %s: %s", post.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if p.Index != nil {
+		if err := p.Index.Add(post.ID, post.Message); err != nil {
+			log.Warnf("Could not index post %s: %s", post.ID, err)
+		}
+	}
+	if p.Trending != nil && trends(post, time.Now()) {
+		p.Trending.Record(post.Tags, post.PublishedAt())
+	}
+	p.writePost(w, r, http.StatusOK, post, user, include, fs)
+}
+
+// Pin handles requests of moderators to pin the post identified by the id url parameter to the top of the wall.
+//
+// On success the pinned post is returned with status code http.StatusOK, pinning a pinned post again has no effect.
+// If MaxPinned posts are already pinned http.StatusConflict is returned, another post must be unpinned first.
+func (p *PostController) Pin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := requireModerator(ctx, w, r, p.Model)
+	if !ok {
+		return
+	}
+	include, fs, ok := parseQuery(w, r, postIncludes)
+	if !ok {
+		return
+	}
+	post, ok := p.pinTarget(ctx, w, r)
+	if !ok {
+		return
+	}
+	if post.PinnedAt.IsZero() {
+		max := p.MaxPinned
+		if max <= 0 {
+			max = DefaultMaxPinned
+		}
+		err := p.Model.Pin(post, max)
+		if err == model.ErrPinLimitReached {
+			jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+				Code:   "pin_limit_reached",
+				Title:  "Too many pinned posts",
+				Detail: "At most " + strconv.Itoa(max) + " posts can be pinned, unpin another post first",
+			})
+			return
+		}
+		if err != nil {
+			log.Warnf("Could not pin post %s: %s", post.ID, err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
+	p.writePost(w, r, http.StatusOK, post, user, include, fs)
+}
+
+// Unpin handles requests of moderators to unpin the post identified by the id url parameter.
+// On success http.StatusNoContent is returned, also if the post was not pinned.
+func (p *PostController) Unpin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireModerator(ctx, w, r, p.Model); !ok {
+		return
+	}
+	post, ok := p.pinTarget(ctx, w, r)
+	if !ok {
+		return
+	}
+	if !post.PinnedAt.IsZero() {
+		if err := p.Model.Unpin(post); err != nil {
+			log.Warnf("Could not unpin post %s: %s", post.ID, err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// pinTarget loads the post identified by the id url parameter, otherwise an error is written.
+func (p *PostController) pinTarget(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.Post, bool) {
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return nil, false
+	}
+	post, err := p.Model.GetByID(id)
+	if err != nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return nil, false
+	}
+	return post, true
+}
+
+// Purge permanently deletes all posts removed longer than the restore window before now, including their attachments and notifications.
+// It returns the number of purged posts.
+func (p *PostController) Purge(now time.Time) (int, error) {
+	posts, err := p.Model.GetDeletedPosts(now.Add(-p.restoreWindow()))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, post := range posts {
+		if err := p.Model.Purge(post); err != nil {
+			log.Warnf("Could not purge post %s: %s", post.ID, err)
+			continue
+		}
+		n++
+		if p.Attachments != nil {
+			for _, a := range post.Attachments {
+				removeAttachment(p.Attachments, a)
+			}
+		}
+		if p.Notifications != nil {
+			if err := p.Notifications.RemoveByPost(post.ID); err != nil {
+				log.Warnf("Could not remove notifications of post %s: %s", post.ID, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Publish handles the scheduled posts published after since until now, like posts created without publish time.
+// It is called periodically by every instance and returns the number of published posts, whose tags are counted by every instance.
+// Unless QuietPublish is set, all posts due until now which were not announced yet are announced, also those which became due
+// while no instance was running. Every post is marked as announced before, so it is announced by a single instance.
+func (p *PostController) Publish(since, now time.Time) (int, error) {
+	posts, err := p.Model.GetPublished(since, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, post := range posts {
+		p.published(post)
+	}
+	if p.QuietPublish {
+		return len(posts), nil
+	}
+	due, err := p.Model.GetDue(now)
+	if err != nil {
+		log.Warnf("Could not get due posts: %s", err)
+		return len(posts), nil
+	}
+	for _, post := range due {
+		err := p.Model.MarkAnnounced(post)
+		if err == model.ErrAlreadyAnnounced {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Could not mark post %s as announced: %s", post.ID, err)
+			continue
+		}
+		p.announce(post)
+	}
+	return len(posts), nil
+}
+
+// published is called once a post goes live, on creation or, if it was scheduled, by Publish.
+// It updates the state kept by every instance.
+func (p *PostController) published(post *model.Post) {
+	if p.Trending != nil && trends(post, time.Now()) {
+		p.Trending.Record(post.Tags, post.PublishedAt())
+	}
+}
+
+// announce tells others about a post which went live, unlike published it must only run on one instance.
+func (p *PostController) announce(post *model.Post) {
+	if !post.Quarantined && len(post.Mentions) > 0 {
+		p.notifyMentions(post.UID, post)
+	}
+	if visible(post, "") {
+		publish(p.Webhooks, model.EventPostCreated, p.postResource(post))
+	}
+}
+
+// announced returns true if the post was announced at now, i.e. it is published and was not quarantined.
+func announced(post *model.Post, now time.Time) bool {
+	return !post.Quarantined && !post.Scheduled(now)
+}
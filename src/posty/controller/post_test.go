@@ -1,10 +1,20 @@
 package controller
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"posty/dedup"
+	"posty/jsonapi"
+	"posty/markdown"
 	"posty/model"
+	"posty/search"
+	"posty/tagging"
+	"posty/unfurl"
+	"posty/validation"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -14,20 +24,135 @@ import (
 )
 
 type mockPostPeer struct {
-	userByIDFn func(id string) (*model.User, error)
-	postsFn    func() ([]*model.Post, error)
-	newFn      func(uid string) *model.Post
-	saveFn     func(p *model.Post) error
-	getidFn    func(id string) (*model.Post, error)
-	removeFn   func(p *model.Post) error
+	usersFn   func(ids []string) (map[string]*model.User, error)
+	handlesFn func(handles []string) (map[string]*model.User, error)
+	postsFn   func(q model.PostQuery) ([]*model.Post, error)
+	newFn     func(uid string) *model.Post
+	saveFn    func(p *model.Post) error
+	getidFn   func(id string) (*model.Post, error)
+	removeFn  func(p *model.Post) error
+	pinFn     func(p *model.Post, max int) error
+	unpinFn   func(p *model.Post) error
+	tagFn     func(tag string) ([]*model.Post, error)
+	mentionFn func(handle string) ([]*model.Post, error)
+	restoreFn func(p *model.Post) error
+	deletedFn func(id string) (*model.Post, error)
+	purgeFn   func(p *model.Post) error
+	// deletedPosts are returned by GetDeletedPosts if they were removed before the given time
+	deletedPosts []*model.Post
+	// announced are the ids of the scheduled posts marked as announced
+	announced map[string]bool
+	// claimed maps the ids of claimed attachments to the ids of their posts
+	claimed map[string]string
 }
 
-func (m *mockPostPeer) GetUserByID(id string) (*model.User, error) {
-	return m.userByIDFn(id)
+func (m *mockPostPeer) ClaimAttachment(id, postID string) error {
+	if m.claimed == nil {
+		m.claimed = make(map[string]string)
+	}
+	if _, ok := m.claimed[id]; ok {
+		return model.ErrAttachmentClaimed
+	}
+	m.claimed[id] = postID
+	return nil
+}
+
+func (m *mockPostPeer) ReleaseAttachment(id, postID string) error {
+	if m.claimed[id] == postID {
+		delete(m.claimed, id)
+	}
+	return nil
+}
+
+func (m *mockPostPeer) GetUsersByHandles(handles []string) (map[string]*model.User, error) {
+	return m.handlesFn(handles)
+}
+
+func (m *mockPostPeer) Pin(p *model.Post, max int) error {
+	return m.pinFn(p, max)
+}
+
+func (m *mockPostPeer) Unpin(p *model.Post) error {
+	return m.unpinFn(p)
+}
+
+func (m *mockPostPeer) Restore(p *model.Post) error {
+	return m.restoreFn(p)
+}
+
+func (m *mockPostPeer) GetDeletedByID(id string) (*model.Post, error) {
+	return m.deletedFn(id)
+}
+
+func (m *mockPostPeer) GetDeletedPosts(before time.Time) ([]*model.Post, error) {
+	var res []*model.Post
+	for _, p := range m.deletedPosts {
+		if p.DeletedAt.Before(before) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// GetPublished returns the live posts of postsFn published after since until until.
+func (m *mockPostPeer) GetPublished(since, until time.Time) ([]*model.Post, error) {
+	posts, err := m.postsFn(model.PostQuery{})
+	if err != nil {
+		return nil, err
+	}
+	var res []*model.Post
+	for _, p := range posts {
+		if p.PublishAt.After(since) && !p.PublishAt.After(until) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// GetDue returns the live posts of postsFn published until now which were not marked as announced.
+func (m *mockPostPeer) GetDue(now time.Time) ([]*model.Post, error) {
+	posts, err := m.postsFn(model.PostQuery{})
+	if err != nil {
+		return nil, err
+	}
+	var res []*model.Post
+	for _, p := range posts {
+		if !p.PublishAt.IsZero() && !p.PublishAt.After(now) && !m.announced[p.ID] {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockPostPeer) MarkAnnounced(p *model.Post) error {
+	if m.announced[p.ID] {
+		return model.ErrAlreadyAnnounced
+	}
+	if m.announced == nil {
+		m.announced = make(map[string]bool)
+	}
+	m.announced[p.ID] = true
+	return nil
+}
+
+func (m *mockPostPeer) Purge(p *model.Post) error {
+	return m.purgeFn(p)
 }
 
-func (m *mockPostPeer) GetPosts() ([]*model.Post, error) {
-	return m.postsFn()
+func (m *mockPostPeer) GetPostsByTag(tag string) ([]*model.Post, error) {
+	return m.tagFn(tag)
+}
+
+func (m *mockPostPeer) GetPostsByMention(handle string) ([]*model.Post, error) {
+	return m.mentionFn(handle)
+}
+
+func (m *mockPostPeer) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return m.usersFn(ids)
+}
+
+func (m *mockPostPeer) QueryPosts(q model.PostQuery) ([]*model.Post, error) {
+	return m.postsFn(q)
 }
 
 func (m *mockPostPeer) NewPost(uid string) *model.Post {
@@ -48,34 +173,157 @@ func (m *mockPostPeer) GetByID(id string) (*model.Post, error) {
 
 func TestPosts(t *testing.T) {
 	assert := assert.New(t)
-	const output = `{"data":[{"id":"id123","user_id":"uid123","username":"myname","message":"Message","created_at":1448272067}]}`
-	ts := time.Unix(1448272067, 0)
-	mockModel := &mockPostPeer{
-		postsFn: func() ([]*model.Post, error) {
-			return []*model.Post{
-				{
-					ID:        "id123",
-					UID:       "uid123",
-					Username:  "myname",
-					Message:   "Message",
-					CreatedAt: ts,
-				},
-			}, nil
-		},
-	}
+	const output = `{"data":[` +
+		`{"type":"posts","id":"id123","attributes":{"created_at":1448272067,"expires_at":null,"format":"plain","hidden":false,"mentions":[],"message":"Message","message_html":"\u003cp\u003eMessage\u003c/p\u003e\n","pinned":false,"poll":null,"preview":null,"publish_at":null,"quarantined":false,"tags":[]},"relationships":{"attachments":{"data":[]},"author":{"links":{"related":"/api/users/uid123"},"data":{"type":"users","id":"uid123"}}},"links":{"self":"/api/posts/id123"}},` +
+		`{"type":"posts","id":"id456","attributes":{"created_at":1448272067,"expires_at":null,"format":"plain","hidden":false,"mentions":[],"message":"Message2","message_html":"\u003cp\u003eMessage2\u003c/p\u003e\n","pinned":false,"poll":null,"preview":null,"publish_at":null,"quarantined":false,"tags":[]},"relationships":{"attachments":{"data":[]},"author":{"links":{"related":"/api/users/uid123"},"data":{"type":"users","id":"uid123"}}},"links":{"self":"/api/posts/id456"}},` +
+		`{"type":"posts","id":"id789","attributes":{"created_at":1448272067,"expires_at":null,"format":"plain","hidden":false,"mentions":[],"message":"Message3","message_html":"\u003cp\u003eMessage3\u003c/p\u003e\n","pinned":false,"poll":null,"preview":null,"publish_at":null,"quarantined":false,"tags":[]},"relationships":{"attachments":{"data":[]},"author":{"links":{"related":"/api/users/uid456"},"data":{"type":"users","id":"uid456"}}},"links":{"self":"/api/posts/id789"}}],` +
+		`"included":[{"type":"users","id":"uid123","attributes":{"created_at":1448272067,"username":"myname"},"links":{"self":"/api/users/uid123"}}],` +
+		`"links":{"self":"/api/posts"}}`
+	var lookups [][]string
+	mockModel := newMockPostsModel(&lookups)
 	c := &PostController{
 		Model: mockModel,
 	}
 	ctx := context.Background()
 	w := httptest.NewRecorder()
-	c.Posts(ctx, w, nil)
+	r, _ := http.NewRequest("GET", "http://posts", nil)
+	c.Posts(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(output, strings.TrimSpace(w.Body.String()), "Invalid output")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Len(lookups, 1, "Authors must be resolved using a single lookup")
+}
+
+func TestPostsSparseFieldsets(t *testing.T) {
+	assert := assert.New(t)
+	const output = `{"data":[` +
+		`{"type":"posts","id":"id123","attributes":{"message":"Message"},"links":{"self":"/api/posts/id123"}},` +
+		`{"type":"posts","id":"id456","attributes":{"message":"Message2"},"links":{"self":"/api/posts/id456"}},` +
+		`{"type":"posts","id":"id789","attributes":{"message":"Message3"},"links":{"self":"/api/posts/id789"}}],` +
+		`"links":{"self":"/api/posts"}}`
+	var lookups [][]string
+	c := &PostController{
+		Model: newMockPostsModel(&lookups),
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://posts?fields[posts]=message", nil)
+	c.Posts(context.Background(), w, r)
 	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
 	assert.Equal(output, strings.TrimSpace(w.Body.String()), "Invalid output")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Len(lookups, 0, "Authors must not be resolved without author relationship")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://posts?fields[users]=username&include=author", nil)
+	c.Posts(context.Background(), w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"included":[{"type":"users","id":"uid123","attributes":{"username":"myname"}`)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://posts?include=", nil)
+	c.Posts(context.Background(), w, r)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.NotContains(w.Body.String(), `"included"`)
+}
+
+func TestPostsInvalidInclude(t *testing.T) {
+	assert := assert.New(t)
+	const output = `{"errors":[{"status":"400","code":"invalid_include","title":"Invalid include parameter","detail":"Unsupported include path \"comments\"","source":{"parameter":"include"}}]}`
+	c := &PostController{
+		Model: &mockPostPeer{},
+	}
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://posts?include=comments", nil)
+	c.Posts(context.Background(), w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Equal(output, w.Body.String(), "Invalid output")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+}
+
+func TestPost(t *testing.T) {
+	assert := assert.New(t)
+	const output = `{"data":{"type":"posts","id":"id456","attributes":{"created_at":1448272067,"expires_at":null,"format":"plain","hidden":false,"mentions":[],"message":"Message2","message_html":"\u003cp\u003eMessage2\u003c/p\u003e\n","pinned":false,"poll":null,"preview":null,"publish_at":null,"quarantined":false,"tags":[]},"relationships":{"attachments":{"data":[]},"author":{"links":{"related":"/api/users/uid123"},"data":{"type":"users","id":"uid123"}}},"links":{"self":"/api/posts/id456"}},` +
+		`"included":[{"type":"users","id":"uid123","attributes":{"created_at":1448272067,"username":"myname"},"links":{"self":"/api/users/uid123"}}]}`
+	var lookups [][]string
+	c := &PostController{
+		Model: newMockPostsModel(&lookups),
+	}
+	ctx := context.WithValue(context.Background(), "urlparams", map[string]string{"id": "id456"})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://posts/id456", nil)
+	c.Post(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(output, strings.TrimSpace(w.Body.String()), "Invalid output")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+
+	ctx = context.WithValue(context.Background(), "urlparams", map[string]string{"id": "unknown"})
+	w = httptest.NewRecorder()
+	c.Post(ctx, w, r)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+}
+
+// newMockPostsModel creates a model containing three posts of two authors, only one of the authors exists.
+// All user lookups are recorded.
+func newMockPostsModel(lookups *[][]string) *mockPostPeer {
+	ts := time.Unix(1448272067, 0)
+	posts := []*model.Post{
+		{
+			ID:        "id123",
+			UID:       "uid123",
+			Message:   "Message",
+			CreatedAt: ts,
+		},
+		{
+			ID:        "id456",
+			UID:       "uid123",
+			Message:   "Message2",
+			CreatedAt: ts,
+		},
+		{
+			ID:        "id789",
+			UID:       "uid456",
+			Message:   "Message3",
+			CreatedAt: ts,
+		},
+	}
+	return &mockPostPeer{
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			var result []*model.Post
+			for _, p := range posts {
+				if q.Matches(p) {
+					result = append(result, p)
+				}
+			}
+			return result, nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			for _, p := range posts {
+				if p.ID == id {
+					return p, nil
+				}
+			}
+			return nil, fmt.Errorf("Unknown post")
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			*lookups = append(*lookups, ids)
+			return map[string]*model.User{
+				"uid123": {
+					ID:        "uid123",
+					Username:  "myname",
+					CreatedAt: ts,
+				},
+			}, nil
+		},
+	}
 }
+
 func TestCreate(t *testing.T) {
 	assert := assert.New(t)
-	const input = `{"data":{"message":"test message"}}`
-	const output = `{"data":{"id":"id","user_id":"uid123","username":"myname","message":"test message","created_at":1448272067}}`
+	const input = `{"data":{"type":"posts","attributes":{"message":"test message"}}}`
+	const output = `{"data":{"type":"posts","id":"id","attributes":{"created_at":1448272067,"expires_at":null,"format":"plain","hidden":false,"mentions":[],"message":"test message","message_html":"\u003cp\u003etest message\u003c/p\u003e\n","pinned":false,"poll":null,"preview":null,"publish_at":null,"quarantined":false,"tags":[]},"relationships":{"attachments":{"data":[]},"author":{"links":{"related":"/api/users/uid123"},"data":{"type":"users","id":"uid123"}}},"links":{"self":"/api/posts/id"}},` +
+		`"included":[{"type":"users","id":"uid123","attributes":{"created_at":1448272067,"username":"myname"},"links":{"self":"/api/users/uid123"}}]}`
 	ts := time.Unix(1448272067, 0)
 	var post *model.Post
 	mockModel := &mockPostPeer{
@@ -90,10 +338,13 @@ func TestCreate(t *testing.T) {
 			post = p
 			return nil
 		},
-		userByIDFn: func(id string) (*model.User, error) {
-			return &model.User{
-				ID:       id,
-				Username: "myname",
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{
+				ids[0]: {
+					ID:        ids[0],
+					Username:  "myname",
+					CreatedAt: ts,
+				},
 			}, nil
 		},
 	}
@@ -111,9 +362,367 @@ func TestCreate(t *testing.T) {
 	assert.NotNil(post)
 	assert.Equal("id", post.ID)
 	assert.Equal("uid123", post.UID)
-	assert.Equal("myname", post.Username)
 	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Equal("/api/posts/id", w.Header().Get("Location"))
 	assert.Equal(output, strings.TrimSpace(w.Body.String()), "Invalid output")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+}
+
+func TestCreateMarkdown(t *testing.T) {
+	assert := assert.New(t)
+	const input = `{"data":{"type":"posts","attributes":{"format":"markdown","message":"**hi** [x](javascript:alert(1)) ![a](https://img.example.com/a.png) ![b](https://evil.example.com/b.png)"}}}`
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+	}
+	c := &PostController{
+		Model:    mockModel,
+		Renderer: &markdown.Renderer{ImageOrigins: []string{"https://img.example.com"}},
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	if assert.NotNil(post) {
+		assert.Equal(model.FormatMarkdown, post.Format)
+	}
+	var doc struct {
+		Data struct {
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"data"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal("markdown", doc.Data.Attributes["format"])
+	assert.Equal(`<p><strong>hi</strong> x <img src="https://img.example.com/a.png" alt="a"> <a href="https://evil.example.com/b.png" rel="nofollow">b</a></p>`+"\n", doc.Data.Attributes["message_html"])
+}
+
+type mockPreviewQueue struct {
+	cached map[string]*unfurl.Preview
+	queued map[string]string
+}
+
+func (m *mockPreviewQueue) Cached(url string) (*unfurl.Preview, bool) {
+	p, ok := m.cached[url]
+	return p, ok
+}
+
+func (m *mockPreviewQueue) Enqueue(postID, url string) bool {
+	m.queued[postID] = url
+	return true
+}
+
+func TestCreatePreview(t *testing.T) {
+	assert := assert.New(t)
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	queue := &mockPreviewQueue{
+		cached: map[string]*unfurl.Preview{
+			"https://cached.example.com": {URL: "https://cached.example.com", Title: "Cached"},
+		},
+		queued: make(map[string]string),
+	}
+	c := &PostController{
+		Model:    mockModel,
+		Previews: queue,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"see https://new.example.com/page."}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"preview":null`)
+	assert.Equal(map[string]string{"id": "https://new.example.com/page"}, queue.queued)
+
+	queue.queued = make(map[string]string)
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://create", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"see https://cached.example.com"}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"preview":{"description":"","image_url":"","title":"Cached","url":"https://cached.example.com"}`)
+	assert.Equal("Cached", post.Preview.Title)
+	assert.Empty(queue.queued, "Cached previews must not be fetched again")
+}
+
+func TestCreateInvalidResource(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		input   string
+		code    int
+		pointer string
+	}{
+		{`{"data":{"type":"users","attributes":{"message":"test message"}}}`, http.StatusConflict, "/data/type"},
+		{`{"data":{"type":"posts","id":"myid","attributes":{"message":"test message"}}}`, http.StatusForbidden, "/data/id"},
+		{`{"data":{"type":"posts","attributes":{"message":"test"}}}`, http.StatusBadRequest, "/data/attributes/message"},
+		{`{"data":{"type":"posts","attributes":{"message":"test message","format":"html"}}}`, http.StatusBadRequest, "/data/attributes/format"},
+	}
+	c := &PostController{
+		Model: &mockPostPeer{},
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://create", strings.NewReader(test.input))
+		c.Create(ctx, w, r)
+		assert.Equal(test.code, w.Code, "Invalid statuscode")
+		assert.Contains(w.Body.String(), `"source":{"pointer":"`+test.pointer+`"}`)
+		assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	assert := assert.New(t)
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+	}
+	c := &PostController{
+		Model: mockModel,
+		MessageRules: &validation.Rules{
+			MinLength:   6,
+			MaxLength:   20,
+			BannedWords: []string{"spam"},
+		},
+		MaxBodySize: 256,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	tests := []struct {
+		message string
+		code    int
+		errs    []string
+	}{
+		{"äöüäö", http.StatusBadRequest, []string{"message_too_short"}},
+		{"this message is far too long", http.StatusBadRequest, []string{"message_too_long"}},
+		{"buy SPAM now", http.StatusBadRequest, []string{"message_banned_word"}},
+		{"this is SPAM spam spam", http.StatusBadRequest, []string{"message_too_long", "message_banned_word"}},
+		{strings.Repeat("a", 300), http.StatusRequestEntityTooLarge, []string{"request_too_large"}},
+	}
+	for _, test := range tests {
+		b, _ := json.Marshal(test.message)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://create", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":`+string(b)+`}}}`))
+		c.Create(ctx, w, r)
+		assert.Equal(test.code, w.Code, test.message)
+		for _, code := range test.errs {
+			assert.Contains(w.Body.String(), `"code":"`+code+`"`)
+		}
+		assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	}
+	assert.Nil(post)
+
+	// Messages are normalised before they are validated and saved
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"  cafe\u0301\u202e #tag\r\n\u0000 "}}}`))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	if assert.NotNil(post) {
+		assert.Equal("café #tag", post.Message)
+		assert.Equal([]string{"tag"}, post.Tags)
+	}
+}
+
+func TestCreateSchedule(t *testing.T) {
+	assert := assert.New(t)
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id", UID: uid, CreatedAt: time.Now()}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+	}
+	c := &PostController{
+		Model:    mockModel,
+		Trending: tagging.NewTrending(time.Hour),
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	now := time.Now().Unix()
+	tests := []struct {
+		publish, expires int64
+		errs             []string
+	}{
+		{now - 60, 0, []string{"invalid_publish_at"}},
+		{0, now - 60, []string{"invalid_expires_at"}},
+		{now + 3600, now + 60, []string{"invalid_expires_at"}},
+		{now - 60, now - 120, []string{"invalid_publish_at", "invalid_expires_at"}},
+	}
+	for _, test := range tests {
+		input := fmt.Sprintf(`{"data":{"type":"posts","attributes":{"message":"scheduled #news","publish_at":%d,"expires_at":%d}}}`, test.publish, test.expires)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://create", strings.NewReader(input))
+		c.Create(ctx, w, r)
+		assert.Equal(http.StatusBadRequest, w.Code, input)
+		for _, code := range test.errs {
+			assert.Contains(w.Body.String(), `"code":"`+code+`"`)
+		}
+	}
+	assert.Nil(post)
+
+	input := fmt.Sprintf(`{"data":{"type":"posts","attributes":{"message":"scheduled #news","publish_at":%d,"expires_at":%d}}}`, now+3600, now+7200)
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), fmt.Sprintf(`"expires_at":%d`, now+7200))
+	assert.Contains(w.Body.String(), fmt.Sprintf(`"publish_at":%d`, now+3600))
+	if assert.NotNil(post) {
+		assert.Equal(now+3600, post.PublishAt.Unix())
+		assert.Equal(now+7200, post.ExpiresAt.Unix())
+	}
+	assert.Empty(c.Trending.Top(10, time.Now()), "Scheduled posts must not be trending")
+}
+
+func TestScheduled(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	var query model.PostQuery
+	mockModel := &mockPostPeer{
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			query = q
+			return []*model.Post{
+				{ID: "later", UID: "uid123", PublishAt: now.Add(2 * time.Hour)},
+				{ID: "next", UID: "uid123", PublishAt: now.Add(time.Hour)},
+			}, nil
+		},
+	}
+	c := &PostController{Model: mockModel}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://scheduled?include=", nil)
+	c.Scheduled(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal(model.PostQuery{Author: "uid123", Scheduled: true}, query)
+	assert.True(strings.Index(w.Body.String(), `"id":"next"`) < strings.Index(w.Body.String(), `"id":"later"`), "Next scheduled post first")
+}
+
+func TestVisible(t *testing.T) {
+	assert := assert.New(t)
+	post := &model.Post{UID: "uid123"}
+	assert.True(visible(post, "uid456"))
+	post.PublishAt = time.Now().Add(time.Hour)
+	assert.False(visible(post, "uid456"), "Scheduled posts are only visible to their author")
+	assert.True(visible(post, "uid123"))
+	post.PublishAt = time.Time{}
+	post.ExpiresAt = time.Now().Add(-time.Second)
+	assert.False(visible(post, "uid456"), "Expired posts are only visible to their author")
+	assert.True(visible(post, "uid123"))
+}
+
+func TestCreateDuplicate(t *testing.T) {
+	assert := assert.New(t)
+	var saved []*model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "id" + strconv.Itoa(len(saved)), UID: uid}
+		},
+		saveFn: func(p *model.Post) error {
+			saved = append(saved, p)
+			return nil
+		},
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			return saved, nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			for _, p := range saved {
+				if p.ID == id {
+					return p, nil
+				}
+			}
+			return nil, errors.New("not found")
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	c := &PostController{
+		Model: mockModel,
+		Duplicates: &dedup.Checker{
+			Store:      dedup.NewMemoryStore(),
+			Window:     time.Hour,
+			MaxPerUser: 1,
+			MaxPerWall: 2,
+		},
+	}
+	create := func(user, message string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://create?include=", strings.NewReader(`{"data":{"type":"posts","attributes":{"message":"`+message+`"}}}`))
+		c.Create(ctx, w, r)
+		return w
+	}
+	assert.Equal(http.StatusCreated, create("uid1", "Buy cheap watches").Code)
+	w := create("uid1", "buy cheap watches!!")
+	assert.Equal(http.StatusConflict, w.Code, "Duplicates must be rejected")
+	assert.Contains(w.Body.String(), `"code":"duplicate_message"`)
+	assert.Equal(http.StatusCreated, create("uid2", "Buy cheap watches").Code)
+	w = create("uid3", "Buy cheap watches")
+	assert.Equal(http.StatusConflict, w.Code, "Floods must be rejected")
+	assert.Contains(w.Body.String(), `"code":"message_flood"`)
+	assert.Len(saved, 2)
+
+	c.QuarantineDuplicates = true
+	w = create("uid3", "Buy cheap watches")
+	assert.Equal(http.StatusCreated, w.Code)
+	assert.Contains(w.Body.String(), `"quarantined":true`)
+	if assert.Len(saved, 3) {
+		assert.True(saved[2].Quarantined)
+	}
+
+	// Quarantined posts are only visible to their author
+	for _, test := range []struct {
+		user  string
+		count int
+	}{
+		{"uid3", 3},
+		{"uid1", 2},
+	} {
+		ctx := context.WithValue(context.Background(), "user", test.user)
+		w = httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://posts?include=", nil)
+		c.Posts(ctx, w, r)
+		var doc struct {
+			Data []interface{} `json:"data"`
+		}
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Len(doc.Data, test.count, test.user)
+
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "id2"})
+		w = httptest.NewRecorder()
+		r, _ = http.NewRequest("GET", "http://post?include=", nil)
+		c.Post(ctx, w, r)
+		if test.user == "uid3" {
+			assert.Equal(http.StatusOK, w.Code)
+		} else {
+			assert.Equal(http.StatusNotFound, w.Code)
+		}
+	}
 }
 
 func TestCreateInvalidJson(t *testing.T) {
@@ -185,3 +794,481 @@ func TestRemove(t *testing.T) {
 	const unauthErr = `{"errors":[{"status":"401"`
 	assert.True(strings.HasPrefix(strings.TrimSpace(w.Body.String()), unauthErr), "Invalid output")
 }
+
+func TestRestore(t *testing.T) {
+	assert := assert.New(t)
+	deleted := &model.Post{ID: "123", UID: "uid123", Message: "restored message", Tags: []string{"tag"}, CreatedAt: time.Now(), DeletedAt: time.Now().Add(-time.Hour)}
+	var restored *model.Post
+	mockModel := &mockPostPeer{
+		deletedFn: func(id string) (*model.Post, error) {
+			if id != deleted.ID {
+				return nil, errors.New("Not found")
+			}
+			return deleted, nil
+		},
+		restoreFn: func(p *model.Post) error {
+			restored = p
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	c := &PostController{
+		Model:    mockModel,
+		Index:    search.NewInvertedIndex(),
+		Trending: tagging.NewTrending(time.Hour),
+	}
+	restore := func(user, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://restore", nil)
+		c.Restore(ctx, w, r)
+		return w
+	}
+
+	w := restore("uid123", "missing")
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+
+	w = restore("uid567", "123")
+	assert.Equal(http.StatusUnauthorized, w.Code, "Invalid statuscode")
+	assert.Nil(restored)
+
+	w = restore("uid123", "123")
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"id":"123"`)
+	assert.Equal(deleted, restored)
+	ids, _ := c.Index.Search("restored")
+	assert.Equal([]string{"123"}, ids, "Restored post must be indexed")
+
+	// Restore window expired
+	restored = nil
+	deleted.DeletedAt = time.Now().Add(-DefaultRestoreWindow - time.Minute)
+	w = restore("uid123", "123")
+	assert.Equal(http.StatusGone, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"restore_expired"`)
+	assert.Nil(restored)
+	c.RestoreWindow = 48 * time.Hour
+	w = restore("uid123", "123")
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+}
+
+func TestPurge(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	var purged []string
+	mockModel := &mockPostPeer{
+		purgeFn: func(p *model.Post) error {
+			if p.ID == "failing" {
+				return errors.New("Purge failed")
+			}
+			purged = append(purged, p.ID)
+			return nil
+		},
+		deletedPosts: []*model.Post{
+			{ID: "old", DeletedAt: now.Add(-2 * time.Hour)},
+			{ID: "failing", DeletedAt: now.Add(-2 * time.Hour)},
+			{ID: "recent", DeletedAt: now.Add(-time.Minute)},
+		},
+	}
+	c := &PostController{Model: mockModel, RestoreWindow: time.Hour}
+	n, err := c.Purge(now)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal([]string{"old"}, purged)
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	since := now.Add(-time.Minute)
+	posts := []*model.Post{
+		{ID: "created", UID: "uid123", Tags: []string{"news"}, CreatedAt: now.Add(-30 * time.Second)},
+		{ID: "published", UID: "uid123", Tags: []string{"news"}, CreatedAt: now.Add(-time.Hour), PublishAt: now.Add(-30 * time.Second)},
+		{ID: "earlier", UID: "uid123", Tags: []string{"old"}, CreatedAt: now.Add(-time.Hour), PublishAt: since},
+		{ID: "quarantined", UID: "uid123", Tags: []string{"spam"}, CreatedAt: now.Add(-time.Hour), PublishAt: now.Add(-10 * time.Second), Quarantined: true},
+	}
+	mockModel := &mockPostPeer{
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			return posts, nil
+		},
+	}
+	hooks := &mockPublisher{}
+	c := &PostController{Model: mockModel, Trending: tagging.NewTrending(time.Hour), Webhooks: hooks, QuietPublish: true}
+	n, err := c.Publish(since, now)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	assert.Equal([]tagging.TagCount{{Tag: "news", Count: 1}}, c.Trending.Top(10, now), "Tags of published posts are trending")
+	assert.Empty(hooks.events, "Quiet instances do not announce")
+
+	// Posts which became due before the window, e.g. while no instance ran, are announced as well
+	c.QuietPublish = false
+	n, err = c.Publish(now, now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal(0, n, "Posts are published once")
+	assert.Len(hooks.events, 2)
+	assert.Equal(map[string]bool{"published": true, "earlier": true, "quarantined": true}, mockModel.announced)
+
+	// Other instances do not announce posts again
+	other := &PostController{Model: mockModel, Webhooks: hooks}
+	_, err = other.Publish(now, now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Len(hooks.events, 2, "Posts are announced once")
+}
+
+func TestTrendsScheduled(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	post := &model.Post{ID: "scheduled", UID: "uid123", Tags: []string{"news"}, CreatedAt: now, PublishAt: now.Add(time.Hour)}
+	mockModel := &mockPostPeer{
+		getidFn: func(id string) (*model.Post, error) {
+			return post, nil
+		},
+		removeFn: func(p *model.Post) error {
+			p.DeletedAt = now
+			return nil
+		},
+		deletedFn: func(id string) (*model.Post, error) {
+			return post, nil
+		},
+		restoreFn: func(p *model.Post) error {
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	c := &PostController{Model: mockModel, Trending: tagging.NewTrending(time.Hour)}
+	c.Trending.Record([]string{"news"}, now)
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "scheduled"})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("DELETE", "http://remove", nil)
+	c.Remove(ctx, w, r)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	assert.Equal([]tagging.TagCount{{Tag: "news", Count: 1}}, c.Trending.Top(10, now), "Tags of scheduled posts were not counted")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "http://restore", nil)
+	c.Restore(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal([]tagging.TagCount{{Tag: "news", Count: 1}}, c.Trending.Top(10, now), "Restored scheduled posts are counted once published")
+
+	post.PublishAt = now.Add(-time.Minute)
+	w = httptest.NewRecorder()
+	c.Restore(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal([]tagging.TagCount{{Tag: "news", Count: 2}}, c.Trending.Top(10, now), "Restored published posts are counted")
+}
+
+func TestPin(t *testing.T) {
+	assert := assert.New(t)
+	posts := map[string]*model.Post{
+		"id1": {ID: "id1", UID: "uid123", CreatedAt: time.Unix(1448272067, 0)},
+		"id2": {ID: "id2", UID: "uid123", CreatedAt: time.Unix(1448272068, 0)},
+		"id3": {ID: "id3", UID: "uid123", CreatedAt: time.Unix(1448272069, 0)},
+	}
+	var updated []string
+	mockModel := &mockPostPeer{
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{
+				"uid123": {ID: "uid123"},
+				"mod":    {ID: "mod", Roles: []string{model.RoleModerator}},
+			}, nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			if p, ok := posts[id]; ok {
+				return p, nil
+			}
+			return nil, errors.New("Not found")
+		},
+		pinFn: func(p *model.Post, max int) error {
+			n := 0
+			for _, post := range posts {
+				if !post.PinnedAt.IsZero() {
+					n++
+				}
+			}
+			if n >= max {
+				return model.ErrPinLimitReached
+			}
+			updated = append(updated, p.ID)
+			p.PinnedAt = time.Now()
+			return nil
+		},
+		unpinFn: func(p *model.Post) error {
+			updated = append(updated, p.ID)
+			p.PinnedAt = time.Time{}
+			return nil
+		},
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			var res []*model.Post
+			for _, id := range []string{"id3", "id2", "id1"} {
+				if q.Matches(posts[id]) {
+					res = append(res, posts[id])
+				}
+			}
+			return res, nil
+		},
+	}
+	c := &PostController{Model: mockModel, MaxPinned: 1}
+	request := func(user, method, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://pin?include=", nil)
+		if method == "PUT" {
+			c.Pin(ctx, w, r)
+		} else {
+			c.Unpin(ctx, w, r)
+		}
+		return w
+	}
+
+	w := request("uid123", "PUT", "id1")
+	assert.Equal(http.StatusForbidden, w.Code, "Only moderators may pin posts")
+	w = request("mod", "PUT", "missing")
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+
+	w = request("mod", "PUT", "id1")
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"pinned":true`)
+	assert.False(posts["id1"].PinnedAt.IsZero())
+	assert.Equal([]string{"id1"}, updated)
+
+	// Pinned posts are returned first
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://posts?include=", nil)
+	c.Posts(context.Background(), w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	body := w.Body.String()
+	assert.True(strings.Index(body, `"id":"id1"`) < strings.Index(body, `"id":"id3"`), "Pinned post first")
+	assert.True(strings.Index(body, `"id":"id3"`) < strings.Index(body, `"id":"id2"`), "Newest first")
+
+	// Pinning again has no effect
+	w = request("mod", "PUT", "id1")
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal([]string{"id1"}, updated)
+
+	w = request("mod", "PUT", "id2")
+	assert.Equal(http.StatusConflict, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"code":"pin_limit_reached"`)
+	assert.True(posts["id2"].PinnedAt.IsZero())
+
+	w = request("uid123", "DELETE", "id1")
+	assert.Equal(http.StatusForbidden, w.Code, "Only moderators may unpin posts")
+	w = request("mod", "DELETE", "id1")
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	assert.True(posts["id1"].PinnedAt.IsZero())
+	w = request("mod", "DELETE", "id1")
+	assert.Equal(http.StatusNoContent, w.Code, "Unpinning an unpinned post has no effect")
+	assert.Equal([]string{"id1", "id1"}, updated)
+	w = request("mod", "PUT", "id2")
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+}
+
+func TestPostsFilter(t *testing.T) {
+	assert := assert.New(t)
+	var lookups [][]string
+	c := &PostController{
+		Model: newMockPostsModel(&lookups),
+		Index: search.NewInvertedIndex(),
+	}
+	c.Index.Add("id123", "Message")
+	c.Index.Add("id456", "Message2")
+	c.Index.Add("id789", "Other")
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"id123", "id456", "id789"}},
+		{"filter[author]=uid456", []string{"id789"}},
+		{"filter[since]=1448272067&filter[until]=2015-11-23T09:47:47Z", []string{"id123", "id456", "id789"}},
+		{"filter[since]=1448272068", []string{}},
+		{"filter[until]=1448272066", []string{}},
+		{"q=mess", []string{"id123", "id456"}},
+		{"q=message2&filter[author]=uid123", []string{"id456"}},
+		{"q=other&filter[author]=uid123", []string{}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://posts?"+test.query, nil)
+		c.Posts(context.Background(), w, r)
+		assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+		var doc struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Invalid json: %s", err)
+		}
+		ids := []string{}
+		for _, d := range doc.Data {
+			ids = append(ids, d.ID)
+		}
+		assert.Equal(test.ids, ids, test.query)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://posts?filter[since]=yesterday", nil)
+	c.Posts(context.Background(), w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+	assert.Contains(w.Body.String(), `"source":{"parameter":"filter[since]"}`)
+
+	c.Index = nil
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://posts?q=message", nil)
+	c.Posts(context.Background(), w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Search without index must fail")
+}
+
+func TestCreateRemoveIndex(t *testing.T) {
+	assert := assert.New(t)
+	const input = `{"data":{"type":"posts","attributes":{"message":"indexed message"}}}`
+	var saved *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{
+				ID:  "id",
+				UID: uid,
+			}
+		},
+		saveFn: func(p *model.Post) error {
+			saved = p
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			return saved, nil
+		},
+		removeFn: func(p *model.Post) error {
+			return nil
+		},
+	}
+	idx := search.NewInvertedIndex()
+	c := &PostController{
+		Model: mockModel,
+		Index: idx,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	ids, _ := idx.Search("indexed")
+	assert.Equal([]string{"id"}, ids)
+
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": "id"})
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("DELETE", "http://remove", nil)
+	c.Remove(ctx, w, r)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	ids, _ = idx.Search("indexed")
+	assert.Len(ids, 0)
+}
+
+func TestCreateTagsAndMentions(t *testing.T) {
+	assert := assert.New(t)
+	const input = `{"data":{"type":"posts","attributes":{"message":"Hello @Anna, #Go is #fun"}}}`
+	ts := time.Now()
+	var post *model.Post
+	mockModel := &mockPostPeer{
+		newFn: func(uid string) *model.Post {
+			return &model.Post{
+				ID:        "id",
+				UID:       uid,
+				CreatedAt: ts,
+			}
+		},
+		saveFn: func(p *model.Post) error {
+			post = p
+			return nil
+		},
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+	}
+	trending := tagging.NewTrending(time.Hour)
+	c := &PostController{
+		Model:    mockModel,
+		Trending: trending,
+	}
+	ctx := context.WithValue(context.Background(), "user", "uid123")
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://create", strings.NewReader(input))
+	c.Create(ctx, w, r)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Equal([]string{"go", "fun"}, post.Tags)
+	assert.Equal([]string{"anna"}, post.Mentions)
+	assert.Contains(w.Body.String(), `"mentions":["anna"]`)
+	assert.Contains(w.Body.String(), `"tags":["go","fun"]`)
+	assert.Equal([]tagging.TagCount{{Tag: "fun", Count: 1}, {Tag: "go", Count: 1}}, trending.Top(10, ts))
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://trending?limit=1", nil)
+	c.TrendingTags(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal(`{"data":[{"type":"tags","id":"fun","attributes":{"count":1},"links":{"related":"/api/tags/fun/posts"}}],"links":{"self":"/api/tags/trending"}}`, strings.TrimSpace(w.Body.String()))
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://trending?limit=0", nil)
+	c.TrendingTags(ctx, w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, "Invalid statuscode")
+}
+
+func TestTagPostsAndMentions(t *testing.T) {
+	assert := assert.New(t)
+	var lookups [][]string
+	mockModel := newMockPostsModel(&lookups)
+	var tag, handle string
+	mockModel.tagFn = func(t string) ([]*model.Post, error) {
+		tag = t
+		return []*model.Post{{ID: "id123", UID: "uid123", Tags: []string{t}}}, nil
+	}
+	mockModel.mentionFn = func(h string) ([]*model.Post, error) {
+		handle = h
+		return []*model.Post{{ID: "id456", UID: "uid456", Mentions: []string{h}}}, nil
+	}
+	c := &PostController{
+		Model: mockModel,
+	}
+	ctx := context.WithValue(context.Background(), "urlparams", map[string]string{"tag": "#Go"})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://tags/go/posts", nil)
+	c.TagPosts(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal("go", tag, "Tag must be normalized")
+	assert.Contains(w.Body.String(), `"id":"id123"`)
+	assert.Contains(w.Body.String(), `"links":{"self":"/api/tags/go/posts"}`)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+
+	ctx = context.WithValue(context.Background(), "user", "uid123")
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://mentions", nil)
+	c.Mentions(ctx, w, r)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.Equal("myname", handle, "Handle must be derived from the username")
+	assert.Contains(w.Body.String(), `"id":"id456"`)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+}
+
+func TestPreviewAttributeImageOrigins(t *testing.T) {
+	assert := assert.New(t)
+	pv := &model.Preview{URL: "https://example.com", ImageURL: "https://images.example.com/a.png"}
+	c := &PostController{}
+	assert.Equal("", c.previewAttribute(pv).(map[string]string)["image_url"], "Images are not returned without renderer")
+	c.Renderer = &markdown.Renderer{ImageOrigins: []string{"https://images.example.com"}}
+	assert.Equal(pv.ImageURL, c.previewAttribute(pv).(map[string]string)["image_url"])
+	pv.ImageURL = "http://10.0.0.1/a.png"
+	assert.Equal("", c.previewAttribute(pv).(map[string]string)["image_url"], "Images from other origins are not returned")
+	assert.Nil(c.previewAttribute(nil))
+}
@@ -0,0 +1,422 @@
+package controller
+
+import (
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/validation"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ReportDataProvider defines the needed model interactions.
+type ReportDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetPostByID(id string) (*model.Post, error)
+	SetPostHidden(p *model.Post, hidden bool) error
+	GetReportByID(id string) (*model.Report, error)
+	GetReportsByPost(postID string) ([]*model.Report, error)
+	GetOpenReports() ([]*model.Report, error)
+	NewReport(postID, uid string) *model.Report
+	SaveNewReport(r *model.Report) error
+	UpdateReport(r *model.Report) error
+	NewAuditEntry(moderatorID, action, postID string) *model.AuditEntry
+	SaveAuditEntry(e *model.AuditEntry) error
+	GetAuditEntries() ([]*model.AuditEntry, error)
+}
+
+// ReportController handles reports of posts and the moderation queue.
+// Posts reported by HideThreshold distinct users are hidden until a moderator dismisses the reports, 0 disables hiding.
+// Posts is used to render the reported posts included in the moderation queue.
+type ReportController struct {
+	Model         ReportDataProvider
+	Posts         *PostController
+	HideThreshold int
+}
+
+// reasonRules restricts the reason given for a report.
+var reasonRules = &validation.Rules{
+	MinLength: 3,
+	MaxLength: 500,
+}
+
+// reportResource converts a report to its JSON API representation.
+func reportResource(r *model.Report) *jsonapi.Resource {
+	attrs := map[string]interface{}{
+		"reason":     r.Reason,
+		"status":     r.Status,
+		"created_at": r.CreatedAt.Unix(),
+	}
+	if !r.ResolvedAt.IsZero() {
+		attrs["resolved_at"] = r.ResolvedAt.Unix()
+	}
+	return &jsonapi.Resource{
+		Type:       "reports",
+		ID:         r.ID,
+		Attributes: attrs,
+		Relationships: map[string]*jsonapi.Relationship{
+			"post": {
+				Links: &jsonapi.Links{
+					Related: "/api/posts/" + r.PostID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "posts",
+					ID:   r.PostID,
+				},
+			},
+			"reporter": {
+				Data: &jsonapi.Identifier{
+					Type: "users",
+					ID:   r.UID,
+				},
+			},
+		},
+	}
+}
+
+// auditResource converts an audit entry to its JSON API representation.
+func auditResource(e *model.AuditEntry) *jsonapi.Resource {
+	reports := make([]*jsonapi.Identifier, len(e.ReportIDs))
+	for i, id := range e.ReportIDs {
+		reports[i] = &jsonapi.Identifier{
+			Type: "reports",
+			ID:   id,
+		}
+	}
+	res := &jsonapi.Resource{
+		Type: "audit-entries",
+		ID:   e.ID,
+		Attributes: map[string]interface{}{
+			"action":     e.Action,
+			"created_at": e.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"post": {
+				Data: &jsonapi.Identifier{
+					Type: "posts",
+					ID:   e.PostID,
+				},
+			},
+			"reports": {
+				Data: reports,
+			},
+		},
+	}
+	if e.ModeratorID != "" {
+		res.Relationships["moderator"] = &jsonapi.Relationship{
+			Data: &jsonapi.Identifier{
+				Type: "users",
+				ID:   e.ModeratorID,
+			},
+		}
+	}
+	return res
+}
+
+type reportCreateReq struct {
+	Data struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			Reason string `json:"reason"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// Create handles a request of the logged in user to report the post identified by the id url parameter.
+//
+// Example request: `{"data":{"type":"reports","attributes":{"reason":"spam"}}}`
+//
+// Every user can report a post once, further reports are rejected with status code `http.StatusConflict`.
+// On success the report is returned with status code `http.StatusCreated`.
+// Once HideThreshold distinct users reported the post it is hidden and the decision is recorded in the audit log.
+func (c *ReportController) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	var req reportCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "reports" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'reports'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	reason, verrs := reasonRules.Check(req.Data.Attributes.Reason)
+	if len(verrs) > 0 {
+		jsonErrors(w, r, cErrClient, fieldErrors("reason", verrs)...)
+		return
+	}
+	post, err := c.Model.GetPostByID(id)
+	if err != nil || !visible(post, user) {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	report := c.Model.NewReport(post.ID, user)
+	report.Reason = reason
+	err = c.Model.SaveNewReport(report)
+	if err == model.ErrAlreadyReported {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "already_reported",
+			Title:  "Already reported",
+			Detail: "You already reported this post",
+		})
+		return
+	}
+	if err != nil {
+		log.Warnf("Could not save report: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	c.autoHide(post)
+	err = jsonapi.Write(w, http.StatusCreated, &jsonapi.Document{
+		Data: reportResource(report),
+	})
+	if err != nil {
+		log.Warnf("Could not write report: %s", err)
+	}
+}
+
+// autoHide hides the post if it was reported by enough distinct users.
+func (c *ReportController) autoHide(post *model.Post) {
+	if c.HideThreshold <= 0 || post.Hidden {
+		return
+	}
+	reports, err := c.Model.GetReportsByPost(post.ID)
+	if err != nil {
+		log.Warnf("Could not get reports of post %s: %s", post.ID, err)
+		return
+	}
+	var ids []string
+	for _, r := range reports {
+		if r.Status == model.ReportOpen {
+			ids = append(ids, r.ID)
+		}
+	}
+	if len(ids) < c.HideThreshold {
+		return
+	}
+	if err := c.setHidden(post, true); err != nil {
+		log.Warnf("Could not hide post %s: %s", post.ID, err)
+		return
+	}
+	entry := c.Model.NewAuditEntry("", model.ActionAutoHide, post.ID)
+	entry.ReportIDs = ids
+	if err := c.Model.SaveAuditEntry(entry); err != nil {
+		log.Warnf("Could not save audit entry: %s", err)
+	}
+}
+
+// setHidden hides or shows the post. The tags of hidden posts are not counted as trending, like when loading the posts at startup.
+func (c *ReportController) setHidden(post *model.Post, hidden bool) error {
+	now := time.Now()
+	counted := trends(post, now)
+	if err := c.Model.SetPostHidden(post, hidden); err != nil {
+		return err
+	}
+	if c.Posts == nil || c.Posts.Trending == nil {
+		return nil
+	}
+	if counts := trends(post, now); counted && !counts {
+		c.Posts.Trending.Forget(post.Tags, post.PublishedAt())
+	} else if !counted && counts {
+		c.Posts.Trending.Record(post.Tags, post.PublishedAt())
+	}
+	return nil
+}
+
+// userLookup looks up users by their ids.
+type userLookup interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+}
+
+// requireModerator returns the logged in user if it is a moderator, otherwise an error is written.
+func requireModerator(ctx context.Context, w http.ResponseWriter, r *http.Request, users userLookup) (string, bool) {
+	return requireRole(ctx, w, r, users, model.RoleModerator, "Moderator role required")
+}
+
+// requireRole returns the logged in user if it was granted the role, otherwise an error with the message is written.
+func requireRole(ctx context.Context, w http.ResponseWriter, r *http.Request, users userLookup, role, msg string) (string, bool) {
+	user, ok := ctx.Value("user").(string)
+	if !ok {
+		log.Warnf("Invalid user context")
+		jsonError(w, r, cErrServer, "")
+		return "", false
+	}
+	found, err := users.GetUsersByIDs([]string{user})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return "", false
+	}
+	if u, ok := found[user]; !ok || !u.HasRole(role) {
+		jsonError(w, r, http.StatusForbidden, msg)
+		return "", false
+	}
+	return user, true
+}
+
+// Reports returns the moderation queue of open reports, oldest first, to moderators.
+// The reported posts are included.
+func (c *ReportController) Reports(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireModerator(ctx, w, r, c.Model); !ok {
+		return
+	}
+	reports, err := c.Model.GetOpenReports()
+	if err != nil {
+		log.Warnf("Could not get open reports: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	data := make([]*jsonapi.Resource, len(reports))
+	included := []*jsonapi.Resource{}
+	seen := make(map[string]bool)
+	for i, report := range reports {
+		data[i] = reportResource(report)
+		if seen[report.PostID] {
+			continue
+		}
+		seen[report.PostID] = true
+		post, err := c.Model.GetPostByID(report.PostID)
+		if err != nil {
+			log.Warnf("Could not get reported post %s: %s", report.PostID, err)
+			continue
+		}
+		included = append(included, c.Posts.postResource(post))
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data:     data,
+		Included: included,
+		Links: &jsonapi.Links{
+			Self: "/api/moderation/reports",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write reports: %s", err)
+	}
+}
+
+// Resolve accepts the report identified by the id url parameter: the reported post is hidden and all open reports of the post are resolved.
+func (c *ReportController) Resolve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.decide(ctx, w, r, model.ActionResolve)
+}
+
+// Dismiss rejects the report identified by the id url parameter: the reported post is shown again and all open reports of the post are dismissed.
+func (c *ReportController) Dismiss(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	c.decide(ctx, w, r, model.ActionDismiss)
+}
+
+// decide closes all open reports of the reported post, updates the post and records the decision in the audit log.
+// Reports which are already closed are rejected with status code `http.StatusConflict`.
+func (c *ReportController) decide(ctx context.Context, w http.ResponseWriter, r *http.Request, action string) {
+	user, ok := requireModerator(ctx, w, r, c.Model)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	report, err := c.Model.GetReportByID(id)
+	if err != nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	if report.Status != model.ReportOpen {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "report_closed",
+			Title:  "Report closed",
+			Detail: "The report was already " + report.Status,
+		})
+		return
+	}
+	if post, err := c.Model.GetPostByID(report.PostID); err == nil {
+		if hide := action == model.ActionResolve; post.Hidden != hide {
+			if err := c.setHidden(post, hide); err != nil {
+				log.Warnf("Could not update post %s: %s", post.ID, err)
+				jsonError(w, r, cErrServer, "")
+				return
+			}
+		}
+	}
+	reports, err := c.Model.GetReportsByPost(report.PostID)
+	if err != nil {
+		log.Warnf("Could not get reports of post %s: %s", report.PostID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	status := model.ReportResolved
+	if action == model.ActionDismiss {
+		status = model.ReportDismissed
+	}
+	now := time.Now()
+	entry := c.Model.NewAuditEntry(user, action, report.PostID)
+	for _, rep := range reports {
+		if rep.Status != model.ReportOpen {
+			continue
+		}
+		rep.Status = status
+		rep.ResolvedBy = user
+		rep.ResolvedAt = now
+		if err := c.Model.UpdateReport(rep); err != nil {
+			log.Warnf("Could not update report %s: %s", rep.ID, err)
+			continue
+		}
+		entry.ReportIDs = append(entry.ReportIDs, rep.ID)
+		if rep.ID == report.ID {
+			report = rep
+		}
+	}
+	if err := c.Model.SaveAuditEntry(entry); err != nil {
+		log.Warnf("Could not save audit entry: %s", err)
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: reportResource(report),
+	})
+	if err != nil {
+		log.Warnf("Could not write report: %s", err)
+	}
+}
+
+// Audit returns the audit log of moderation decisions, newest first, to moderators.
+func (c *ReportController) Audit(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireModerator(ctx, w, r, c.Model); !ok {
+		return
+	}
+	entries, err := c.Model.GetAuditEntries()
+	if err != nil {
+		log.Warnf("Could not get audit entries: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	data := make([]*jsonapi.Resource, len(entries))
+	for i, e := range entries {
+		data[i] = auditResource(e)
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: data,
+		Links: &jsonapi.Links{
+			Self: "/api/moderation/audit",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write audit entries: %s", err)
+	}
+}
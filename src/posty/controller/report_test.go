@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"posty/tagging"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockReportModel keeps posts, reports and audit entries in memory.
+type mockReportModel struct {
+	users   map[string]*model.User
+	posts   map[string]*model.Post
+	reports []*model.Report
+	audit   []*model.AuditEntry
+}
+
+func (m *mockReportModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockReportModel) GetPostByID(id string) (*model.Post, error) {
+	if p, ok := m.posts[id]; ok {
+		return p, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockReportModel) SetPostHidden(p *model.Post, hidden bool) error {
+	p.Hidden = hidden
+	m.posts[p.ID] = p
+	return nil
+}
+
+func (m *mockReportModel) GetReportByID(id string) (*model.Report, error) {
+	for _, r := range m.reports {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+func (m *mockReportModel) GetReportsByPost(postID string) ([]*model.Report, error) {
+	var res []*model.Report
+	for _, r := range m.reports {
+		if r.PostID == postID {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockReportModel) GetOpenReports() ([]*model.Report, error) {
+	var res []*model.Report
+	for _, r := range m.reports {
+		if r.Status == model.ReportOpen {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+func (m *mockReportModel) NewReport(postID, uid string) *model.Report {
+	return &model.Report{
+		ID:        "rid" + strconv.Itoa(len(m.reports)+1),
+		PostID:    postID,
+		UID:       uid,
+		Status:    model.ReportOpen,
+		CreatedAt: time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockReportModel) SaveNewReport(r *model.Report) error {
+	for _, o := range m.reports {
+		if o.PostID == r.PostID && o.UID == r.UID {
+			return model.ErrAlreadyReported
+		}
+	}
+	m.reports = append(m.reports, r)
+	return nil
+}
+
+func (m *mockReportModel) UpdateReport(r *model.Report) error {
+	return nil
+}
+
+func (m *mockReportModel) NewAuditEntry(moderatorID, action, postID string) *model.AuditEntry {
+	return &model.AuditEntry{
+		ID:          "aid" + strconv.Itoa(len(m.audit)+1),
+		ModeratorID: moderatorID,
+		Action:      action,
+		PostID:      postID,
+		CreatedAt:   time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockReportModel) SaveAuditEntry(e *model.AuditEntry) error {
+	m.audit = append(m.audit, e)
+	return nil
+}
+
+func (m *mockReportModel) GetAuditEntries() ([]*model.AuditEntry, error) {
+	return m.audit, nil
+}
+
+func newMockReportModel() *mockReportModel {
+	return &mockReportModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1"},
+			"mod":  {ID: "mod", Roles: []string{model.RoleModerator}},
+		},
+		posts: map[string]*model.Post{
+			"pid1": {ID: "pid1", UID: "author", Message: "Bad message"},
+		},
+	}
+}
+
+func reportRequest(c *ReportController, user, postID, body string) *httptest.ResponseRecorder {
+	ctx := context.WithValue(context.Background(), "user", user)
+	ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": postID})
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://report", strings.NewReader(body))
+	c.Create(ctx, w, r)
+	return w
+}
+
+func TestCreateReport(t *testing.T) {
+	assert := assert.New(t)
+	m := newMockReportModel()
+	c := &ReportController{
+		Model:         m,
+		Posts:         &PostController{},
+		HideThreshold: 2,
+	}
+	const input = `{"data":{"type":"reports","attributes":{"reason":"  spam\u0000 "}}}`
+	w := reportRequest(c, "uid1", "pid1", input)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal(`{"data":{"type":"reports","id":"rid1","attributes":{"created_at":1448272067,"reason":"spam","status":"open"},`+
+		`"relationships":{"post":{"links":{"related":"/api/posts/pid1"},"data":{"type":"posts","id":"pid1"}},"reporter":{"data":{"type":"users","id":"uid1"}}}}}`,
+		strings.TrimSpace(w.Body.String()))
+	assert.False(m.posts["pid1"].Hidden)
+
+	w = reportRequest(c, "uid1", "pid1", input)
+	assert.Equal(http.StatusConflict, w.Code, "Users must report a post only once")
+	assert.Contains(w.Body.String(), `"code":"already_reported"`)
+	assert.False(m.posts["pid1"].Hidden)
+
+	tests := []struct {
+		postID string
+		input  string
+		code   int
+	}{
+		{"pid1", `{"data":{"type":"reports","attributes":{"reason":"x"}}}`, http.StatusBadRequest},
+		{"pid1", `{"data":{"type":"posts","attributes":{"reason":"spam"}}}`, http.StatusConflict},
+		{"pid1", `{"data":`, http.StatusBadRequest},
+		{"unknown", input, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w = reportRequest(c, "uid2", test.postID, test.input)
+		assert.Equal(test.code, w.Code, test.input)
+	}
+
+	// The second distinct report hides the post
+	w = reportRequest(c, "uid2", "pid1", input)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.True(m.posts["pid1"].Hidden, "Post must be hidden")
+	if assert.Len(m.audit, 1) {
+		assert.Equal(model.ActionAutoHide, m.audit[0].Action)
+		assert.Equal("", m.audit[0].ModeratorID)
+		assert.Equal([]string{"rid1", "rid2"}, m.audit[0].ReportIDs)
+	}
+	w = reportRequest(c, "uid3", "pid1", input)
+	assert.Equal(http.StatusNotFound, w.Code, "Hidden posts can not be reported")
+}
+
+func TestModeration(t *testing.T) {
+	assert := assert.New(t)
+	m := newMockReportModel()
+	now := time.Now()
+	m.posts["pid1"].Tags = []string{"bad"}
+	m.posts["pid1"].CreatedAt = now
+	m.posts["pid2"] = &model.Post{ID: "pid2", UID: "author", Message: "Fine #message", Tags: []string{"message"}, CreatedAt: now}
+	trending := tagging.NewTrending(time.Hour)
+	trending.Record([]string{"bad"}, now)
+	trending.Record([]string{"message"}, now)
+	c := &ReportController{
+		Model:         m,
+		Posts:         &PostController{Trending: trending},
+		HideThreshold: 2,
+	}
+	const input = `{"data":{"type":"reports","attributes":{"reason":"spam"}}}`
+	reportRequest(c, "uid1", "pid1", input)
+	reportRequest(c, "uid2", "pid1", input)
+	reportRequest(c, "uid1", "pid2", input)
+	assert.Len(m.audit, 1)
+	assert.Equal([]tagging.TagCount{{Tag: "message", Count: 1}}, trending.Top(10, now), "Tags of hidden posts must not trend")
+
+	serve := func(h func(context.Context, http.ResponseWriter, *http.Request), user, id string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://moderation", nil)
+		h(ctx, w, r)
+		return w
+	}
+	for _, h := range []func(context.Context, http.ResponseWriter, *http.Request){c.Reports, c.Audit, c.Resolve, c.Dismiss} {
+		assert.Equal(http.StatusForbidden, serve(h, "uid1", "rid1").Code, "Moderator role must be required")
+	}
+
+	w := serve(c.Reports, "mod", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	for _, id := range []string{"rid1", "rid2", "rid3"} {
+		assert.Contains(w.Body.String(), `"type":"reports","id":"`+id+`"`)
+	}
+	assert.Contains(w.Body.String(), `"included":[{"type":"posts","id":"pid1"`)
+	assert.Contains(w.Body.String(), `"hidden":true`)
+
+	// Dismissing shows the post again and closes all its reports
+	w = serve(c.Dismiss, "mod", "rid2")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `"status":"dismissed"`)
+	assert.False(m.posts["pid1"].Hidden)
+	assert.Equal([]tagging.TagCount{{Tag: "bad", Count: 1}, {Tag: "message", Count: 1}}, trending.Top(10, now), "Tags of shown posts must trend again")
+	for _, r := range m.reports[:2] {
+		assert.Equal(model.ReportDismissed, r.Status)
+		assert.Equal("mod", r.ResolvedBy)
+	}
+	assert.Equal(http.StatusConflict, serve(c.Resolve, "mod", "rid1").Code, "Closed reports must not be decided again")
+
+	w = serve(c.Resolve, "mod", "rid3")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.True(m.posts["pid2"].Hidden, "Resolving must hide the post")
+	assert.Equal([]tagging.TagCount{{Tag: "bad", Count: 1}}, trending.Top(10, now))
+	assert.Equal(model.ReportResolved, m.reports[2].Status)
+	assert.Equal(http.StatusNotFound, serve(c.Resolve, "mod", "unknown").Code)
+
+	w = serve(c.Audit, "mod", "")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	if assert.Len(m.audit, 3) {
+		assert.Equal(model.ActionDismiss, m.audit[1].Action)
+		assert.Equal([]string{"rid1", "rid2"}, m.audit[1].ReportIDs)
+		assert.Equal(model.ActionResolve, m.audit[2].Action)
+		assert.Equal("mod", m.audit[2].ModeratorID)
+	}
+	assert.Contains(w.Body.String(), `"moderator":{"data":{"type":"users","id":"mod"}}`)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"net/http"
+	"posty/jsonapi"
+	"posty/model"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// UserDataProvider defines the needed model interactions.
+type UserDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+}
+
+// UserController handles user related requests.
+type UserController struct {
+	Model UserDataProvider
+}
+
+// userResource converts an user to its JSON API representation.
+// Private data like the email address or the oauth id is not exposed.
+func userResource(u *model.User) *jsonapi.Resource {
+	return &jsonapi.Resource{
+		Type: "users",
+		ID:   u.ID,
+		Attributes: map[string]interface{}{
+			"username":   u.Username,
+			"created_at": u.CreatedAt.Unix(),
+		},
+		Links: &jsonapi.Links{
+			Self: "/api/users/" + u.ID,
+		},
+	}
+}
+
+// User returns the user identified by the id url parameter. The id `me` refers to the logged in user.
+// If the user could not be found http.StatusNotFound is returned.
+func (c *UserController) User(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return
+	}
+	if id == "me" {
+		id, ok = ctx.Value("user").(string)
+		if !ok {
+			log.Warnf("Invalid user context")
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
+	users, err := c.Model.GetUsersByIDs([]string{id})
+	if err != nil {
+		log.Warnf("Could not lookup user: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	u, ok := users[id]
+	if !ok {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	res := userResource(u)
+	fs.Apply(res)
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: res,
+	})
+	if err != nil {
+		log.Warnf("Could not write user: %s", err)
+	}
+}
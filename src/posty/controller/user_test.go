@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type mockUserDataProvider struct {
+	usersFn func(ids []string) (map[string]*model.User, error)
+}
+
+func (m *mockUserDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return m.usersFn(ids)
+}
+
+func TestUser(t *testing.T) {
+	assert := assert.New(t)
+	const output = `{"data":{"type":"users","id":"uid123","attributes":{"created_at":1448272067,"username":"myname"},"links":{"self":"/api/users/uid123"}}}`
+	ts := time.Unix(1448272067, 0)
+	c := &UserController{
+		Model: &mockUserDataProvider{
+			usersFn: func(ids []string) (map[string]*model.User, error) {
+				if ids[0] != "uid123" {
+					return map[string]*model.User{}, nil
+				}
+				return map[string]*model.User{
+					"uid123": {
+						ID:        "uid123",
+						Username:  "myname",
+						Email:     "secret@example.com",
+						CreatedAt: ts,
+					},
+				}, nil
+			},
+		},
+	}
+	r, _ := http.NewRequest("GET", "http://users/me", nil)
+	for _, id := range []string{"uid123", "me"} {
+		ctx := context.WithValue(context.Background(), "user", "uid123")
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		c.User(ctx, w, r)
+		assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+		assert.Equal(output, strings.TrimSpace(w.Body.String()), "Invalid output")
+		assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	}
+
+	ctx := context.WithValue(context.Background(), "urlparams", map[string]string{"id": "unknown"})
+	w := httptest.NewRecorder()
+	c.User(ctx, w, r)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+}
@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"posty/jsonapi"
+	"posty/model"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// WebhookPublisher publishes events to the webhooks subscribed to them.
+type WebhookPublisher interface {
+	Publish(event string, data interface{}) error
+}
+
+// publish publishes the event if webhooks are configured.
+// Failures are logged, webhooks never fail the action causing the event.
+func publish(hooks WebhookPublisher, event string, data interface{}) {
+	if hooks == nil {
+		return
+	}
+	if err := hooks.Publish(event, data); err != nil {
+		log.Warnf("Could not publish %s event: %s", event, err)
+	}
+}
+
+// WebhookDataProvider defines the needed model interactions.
+type WebhookDataProvider interface {
+	GetUsersByIDs(ids []string) (map[string]*model.User, error)
+	GetWebhooks() ([]*model.Webhook, error)
+	GetWebhook(id string) (*model.Webhook, error)
+	NewWebhook(url, secret string, events []string) *model.Webhook
+	SaveNewWebhook(h *model.Webhook) error
+	RemoveWebhook(h *model.Webhook) error
+	GetDeliveries(webhookID string, limit int) ([]*model.Delivery, error)
+	GetDelivery(id string) (*model.Delivery, error)
+}
+
+// WebhookRedeliverer sends the payload of a previous delivery again.
+type WebhookRedeliverer interface {
+	Redeliver(h *model.Webhook, previous *model.Delivery) (*model.Delivery, error)
+}
+
+// WebhookController lets admins configure webhooks, inspect their deliveries and redeliver them.
+// DeliveryLimit is the number of most recent deliveries returned, DefaultDeliveryLimit is used if it is not set.
+type WebhookController struct {
+	Model         WebhookDataProvider
+	Dispatcher    WebhookRedeliverer
+	DeliveryLimit int
+}
+
+// DefaultDeliveryLimit is the number of deliveries returned if no limit is configured.
+const DefaultDeliveryLimit = 50
+
+// webhookResource converts a webhook to its JSON API representation. The secret is not exposed.
+func webhookResource(h *model.Webhook) *jsonapi.Resource {
+	return &jsonapi.Resource{
+		Type: "webhooks",
+		ID:   h.ID,
+		Attributes: map[string]interface{}{
+			"url":        h.URL,
+			"events":     nonNil(h.Events),
+			"created_at": h.CreatedAt.Unix(),
+		},
+		Relationships: map[string]*jsonapi.Relationship{
+			"deliveries": {
+				Links: &jsonapi.Links{
+					Related: "/api/webhooks/" + h.ID + "/deliveries",
+				},
+			},
+		},
+		Links: &jsonapi.Links{
+			Self: "/api/webhooks/" + h.ID,
+		},
+	}
+}
+
+// deliveryResource converts a delivery to its JSON API representation.
+func deliveryResource(d *model.Delivery) *jsonapi.Resource {
+	attrs := map[string]interface{}{
+		"event":           d.Event,
+		"status":          d.Status,
+		"attempts":        d.Attempts,
+		"payload":         json.RawMessage(d.Payload),
+		"response_code":   nil,
+		"error":           nil,
+		"created_at":      d.CreatedAt.Unix(),
+		"last_attempt_at": timeAttribute(d.LastAttemptAt),
+		"next_attempt_at": nil,
+	}
+	if d.ResponseCode != 0 {
+		attrs["response_code"] = d.ResponseCode
+	}
+	if d.Error != "" {
+		attrs["error"] = d.Error
+	}
+	if d.Status == model.DeliveryPending {
+		attrs["next_attempt_at"] = timeAttribute(d.NextAttemptAt)
+	}
+	return &jsonapi.Resource{
+		Type:       "deliveries",
+		ID:         d.ID,
+		Attributes: attrs,
+		Relationships: map[string]*jsonapi.Relationship{
+			"webhook": {
+				Links: &jsonapi.Links{
+					Related: "/api/webhooks/" + d.WebhookID,
+				},
+				Data: &jsonapi.Identifier{
+					Type: "webhooks",
+					ID:   d.WebhookID,
+				},
+			},
+		},
+	}
+}
+
+// byWebhookCreatedAt sorts webhooks oldest first.
+type byWebhookCreatedAt []*model.Webhook
+
+func (o byWebhookCreatedAt) Len() int           { return len(o) }
+func (o byWebhookCreatedAt) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byWebhookCreatedAt) Less(i, j int) bool { return o[i].CreatedAt.Before(o[j].CreatedAt) }
+
+// Webhooks returns all webhooks, oldest first, to admins.
+func (c *WebhookController) Webhooks(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required"); !ok {
+		return
+	}
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	hooks, err := c.Model.GetWebhooks()
+	if err != nil {
+		log.Warnf("Could not get webhooks: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	sort.Sort(byWebhookCreatedAt(hooks))
+	data := make([]*jsonapi.Resource, len(hooks))
+	for i, h := range hooks {
+		data[i] = webhookResource(h)
+		fs.Apply(data[i])
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: data,
+		Links: &jsonapi.Links{
+			Self: "/api/webhooks",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write webhooks: %s", err)
+	}
+}
+
+type webhookCreateReq struct {
+	Data struct {
+		Type       string `json:"type"`
+		Attributes struct {
+			URL    string   `json:"url"`
+			Secret string   `json:"secret"`
+			Events []string `json:"events"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// newSecret generates a random secret signing deliveries.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Create handles requests of admins to create a webhook.
+//
+// Example request: `{"data":{"type":"webhooks","attributes":{"url":"https://ci.example.com/hook","events":["post.created"]}}}`
+//
+// The url must be an absolute http or https url and the events known webhook events.
+// If no secret is given a random secret is generated. On success the webhook is returned with status code http.StatusCreated,
+// this is the only response containing the secret.
+func (c *WebhookController) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required")
+	if !ok {
+		return
+	}
+	var req webhookCreateReq
+	if !decodeBody(w, r, DefaultMaxBodySize, &req) {
+		return
+	}
+	if req.Data.Type != "webhooks" {
+		jsonErrors(w, r, http.StatusConflict, &jsonapi.Error{
+			Code:   "invalid_type",
+			Title:  "Invalid resource type",
+			Detail: "Resource type must be 'webhooks'",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/type",
+			},
+		})
+		return
+	}
+	attrs := req.Data.Attributes
+	var errs []*jsonapi.Error
+	if u, err := url.Parse(attrs.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, &jsonapi.Error{
+			Code:   "invalid_url",
+			Title:  "Invalid url",
+			Detail: "Url must be an absolute http or https url",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/url",
+			},
+		})
+	}
+	valid := len(attrs.Events) > 0
+	for _, e := range attrs.Events {
+		valid = valid && model.ValidWebhookEvent(e)
+	}
+	if !valid {
+		errs = append(errs, &jsonapi.Error{
+			Code:   "invalid_events",
+			Title:  "Invalid events",
+			Detail: "Events must be a non-empty list of post.created, post.deleted and user.created",
+			Source: &jsonapi.ErrorSource{
+				Pointer: "/data/attributes/events",
+			},
+		})
+	}
+	if len(errs) > 0 {
+		jsonErrors(w, r, cErrClient, errs...)
+		return
+	}
+	secret := attrs.Secret
+	if secret == "" {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			log.Warnf("Could not generate webhook secret: %s", err)
+			jsonError(w, r, cErrServer, "")
+			return
+		}
+	}
+	h := c.Model.NewWebhook(attrs.URL, secret, attrs.Events)
+	h.CreatedBy = user
+	if err := c.Model.SaveNewWebhook(h); err != nil {
+		log.Warnf("Could not save webhook: %s", err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	res := webhookResource(h)
+	res.Attributes["secret"] = h.Secret
+	w.Header().Set("Location", "/api/webhooks/"+h.ID)
+	if err := jsonapi.Write(w, http.StatusCreated, &jsonapi.Document{Data: res}); err != nil {
+		log.Warnf("Could not write webhook: %s", err)
+	}
+}
+
+// webhook looks up the webhook identified by the id url parameter for an admin.
+// On error a json error is written and ok is false.
+func (c *WebhookController) webhook(ctx context.Context, w http.ResponseWriter, r *http.Request) (*model.Webhook, bool) {
+	if _, ok := requireRole(ctx, w, r, c.Model, model.RoleAdmin, "Admin role required"); !ok {
+		return nil, false
+	}
+	id, ok := urlParam(ctx, "id")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing id parameter")
+		return nil, false
+	}
+	h, err := c.Model.GetWebhook(id)
+	if err != nil {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return nil, false
+	}
+	return h, true
+}
+
+// Remove handles requests of admins to remove the webhook identified by the id url parameter.
+// Pending deliveries are given up. On success an empty response with status http.StatusNoContent is written.
+func (c *WebhookController) Remove(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h, ok := c.webhook(ctx, w, r)
+	if !ok {
+		return
+	}
+	if err := c.Model.RemoveWebhook(h); err != nil {
+		log.Warnf("Could not remove webhook %s: %s", h.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deliveries returns the delivery log of the webhook identified by the id url parameter to admins, newest first.
+func (c *WebhookController) Deliveries(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h, ok := c.webhook(ctx, w, r)
+	if !ok {
+		return
+	}
+	_, fs, ok := parseQuery(w, r, nil)
+	if !ok {
+		return
+	}
+	limit := c.DeliveryLimit
+	if limit <= 0 {
+		limit = DefaultDeliveryLimit
+	}
+	ds, err := c.Model.GetDeliveries(h.ID, limit)
+	if err != nil {
+		log.Warnf("Could not get deliveries of webhook %s: %s", h.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	data := make([]*jsonapi.Resource, len(ds))
+	for i, d := range ds {
+		data[i] = deliveryResource(d)
+		fs.Apply(data[i])
+	}
+	err = jsonapi.Write(w, http.StatusOK, &jsonapi.Document{
+		Data: data,
+		Links: &jsonapi.Links{
+			Self: "/api/webhooks/" + h.ID + "/deliveries",
+		},
+	})
+	if err != nil {
+		log.Warnf("Could not write deliveries: %s", err)
+	}
+}
+
+// Redeliver handles requests of admins to send the payload of the delivery identified by the delivery url parameter
+// to the webhook identified by the id url parameter again.
+// On success the new delivery is returned with status code http.StatusAccepted, it is sent in the background.
+func (c *WebhookController) Redeliver(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	h, ok := c.webhook(ctx, w, r)
+	if !ok {
+		return
+	}
+	id, ok := urlParam(ctx, "delivery")
+	if !ok {
+		jsonError(w, r, cErrClient, "Missing delivery parameter")
+		return
+	}
+	previous, err := c.Model.GetDelivery(id)
+	if err != nil || previous.WebhookID != h.ID {
+		jsonError(w, r, http.StatusNotFound, "Resource not found")
+		return
+	}
+	d, err := c.Dispatcher.Redeliver(h, previous)
+	if err != nil {
+		log.Warnf("Could not redeliver delivery %s: %s", previous.ID, err)
+		jsonError(w, r, cErrServer, "")
+		return
+	}
+	if err := jsonapi.Write(w, http.StatusAccepted, &jsonapi.Document{Data: deliveryResource(d)}); err != nil {
+		log.Warnf("Could not write delivery: %s", err)
+	}
+}
@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/model"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mockPublisher records published events.
+type mockPublisher struct {
+	events []string
+	data   []interface{}
+}
+
+func (m *mockPublisher) Publish(event string, data interface{}) error {
+	m.events = append(m.events, event)
+	m.data = append(m.data, data)
+	return nil
+}
+
+type mockWebhookModel struct {
+	users      map[string]*model.User
+	hooks      []*model.Webhook
+	deliveries []*model.Delivery
+}
+
+func (m *mockWebhookModel) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	res := make(map[string]*model.User)
+	for _, id := range ids {
+		if u, ok := m.users[id]; ok {
+			res[id] = u
+		}
+	}
+	return res, nil
+}
+
+func (m *mockWebhookModel) GetWebhooks() ([]*model.Webhook, error) {
+	return m.hooks, nil
+}
+
+func (m *mockWebhookModel) GetWebhook(id string) (*model.Webhook, error) {
+	for _, h := range m.hooks {
+		if h.ID == id {
+			return h, nil
+		}
+	}
+	return nil, errors.New("Not found")
+}
+
+func (m *mockWebhookModel) NewWebhook(url, secret string, events []string) *model.Webhook {
+	return &model.Webhook{
+		ID:        "wid" + strconv.Itoa(len(m.hooks)+1),
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: time.Unix(1448272067, 0),
+	}
+}
+
+func (m *mockWebhookModel) SaveNewWebhook(h *model.Webhook) error {
+	m.hooks = append(m.hooks, h)
+	return nil
+}
+
+func (m *mockWebhookModel) RemoveWebhook(h *model.Webhook) error {
+	var kept []*model.Webhook
+	for _, e := range m.hooks {
+		if e.ID != h.ID {
+			kept = append(kept, e)
+		}
+	}
+	m.hooks = kept
+	return nil
+}
+
+func (m *mockWebhookModel) GetDeliveries(webhookID string, limit int) ([]*model.Delivery, error) {
+	var res []*model.Delivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(res) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			res = append(res, m.deliveries[i])
+		}
+	}
+	return res, nil
+}
+
+func (m *mockWebhookModel) GetDelivery(id string) (*model.Delivery, error) {
+	for _, d := range m.deliveries {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return nil, errors.New("Not found")
+}
+
+func (m *mockWebhookModel) Redeliver(h *model.Webhook, previous *model.Delivery) (*model.Delivery, error) {
+	d := &model.Delivery{
+		ID:            "did" + strconv.Itoa(len(m.deliveries)+1),
+		WebhookID:     h.ID,
+		Event:         previous.Event,
+		Payload:       previous.Payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: time.Unix(1448272069, 0),
+		CreatedAt:     time.Unix(1448272069, 0),
+	}
+	m.deliveries = append(m.deliveries, d)
+	return d, nil
+}
+
+func TestWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockWebhookModel{
+		users: map[string]*model.User{
+			"uid1": {ID: "uid1", Username: "admin", Roles: []string{model.RoleAdmin}},
+			"uid2": {ID: "uid2", Username: "moderator", Roles: []string{model.RoleModerator}},
+		},
+	}
+	c := &WebhookController{Model: m, Dispatcher: m}
+	request := func(user, method, id, delivery, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", user)
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id, "delivery": delivery})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, "http://webhooks", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	w := request("uid2", "GET", "", "", "", c.Webhooks)
+	assert.Equal(http.StatusForbidden, w.Code, "Webhooks are restricted to admins")
+	w = request("uid2", "POST", "", "", `{"data":{"type":"webhooks","attributes":{"url":"https://ci.example.com/hook","events":["post.created"]}}}`, c.Create)
+	assert.Equal(http.StatusForbidden, w.Code, "Webhooks are restricted to admins")
+
+	for _, attrs := range []string{
+		`{"url":"ftp://ci.example.com/hook","events":["post.created"]}`,
+		`{"url":"/hook","events":["post.created"]}`,
+		`{"url":"https://ci.example.com/hook","events":[]}`,
+		`{"url":"https://ci.example.com/hook","events":["post.updated"]}`,
+	} {
+		w = request("uid1", "POST", "", "", `{"data":{"type":"webhooks","attributes":`+attrs+`}}`, c.Create)
+		assert.Equal(http.StatusBadRequest, w.Code, attrs)
+	}
+	w = request("uid1", "POST", "", "", `{"data":{"type":"webhooks","attributes":{"url":"https://ci.example.com/`+strings.Repeat("a", DefaultMaxBodySize)+`","events":["post.created"]}}}`, c.Create)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, "Bodies are limited")
+	assert.Empty(m.hooks)
+
+	w = request("uid1", "POST", "", "", `{"data":{"type":"webhooks","attributes":{"url":"https://ci.example.com/hook","events":["post.created","user.created"]}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Equal("/api/webhooks/wid1", w.Header().Get("Location"))
+	if assert.Len(m.hooks, 1) {
+		h := m.hooks[0]
+		assert.Equal("uid1", h.CreatedBy)
+		assert.Len(h.Secret, 64, "A secret is generated if none is given")
+		assert.Contains(w.Body.String(), `"secret":"`+h.Secret+`"`, "The secret is returned on creation")
+	}
+	w = request("uid1", "POST", "", "", `{"data":{"type":"webhooks","attributes":{"url":"http://chat.example.com","events":["post.deleted"],"secret":"chatsecret"}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Equal("chatsecret", m.hooks[1].Secret)
+
+	w = request("uid1", "GET", "", "", "", c.Webhooks)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `{"type":"webhooks","id":"wid1","attributes":{"created_at":1448272067,"events":["post.created","user.created"],"url":"https://ci.example.com/hook"},"relationships":{"deliveries":{"links":{"related":"/api/webhooks/wid1/deliveries"},"data":null}},"links":{"self":"/api/webhooks/wid1"}}`)
+	assert.NotContains(w.Body.String(), "secret", "Secrets are only returned on creation")
+
+	m.deliveries = []*model.Delivery{{
+		ID:            "did1",
+		WebhookID:     "wid1",
+		Event:         model.EventPostCreated,
+		Payload:       []byte(`{"event":"post.created"}`),
+		Status:        model.DeliveryFailed,
+		Attempts:      8,
+		LastAttemptAt: time.Unix(1448272068, 0),
+		ResponseCode:  503,
+		Error:         "Unexpected status code 503 Service Unavailable",
+		CreatedAt:     time.Unix(1448272067, 0),
+	}}
+	w = request("uid1", "GET", "wid1", "", "", c.Deliveries)
+	assert.Equal(http.StatusOK, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `{"type":"deliveries","id":"did1","attributes":{"attempts":8,"created_at":1448272067,"error":"Unexpected status code 503 Service Unavailable","event":"post.created","last_attempt_at":1448272068,"next_attempt_at":null,"payload":{"event":"post.created"},"response_code":503,"status":"failed"}`)
+	w = request("uid1", "GET", "wid9", "", "", c.Deliveries)
+	assert.Equal(http.StatusNotFound, w.Code, "Invalid statuscode")
+
+	w = request("uid1", "POST", "wid2", "did1", "", c.Redeliver)
+	assert.Equal(http.StatusNotFound, w.Code, "Deliveries can only be redelivered to their webhook")
+	w = request("uid1", "POST", "wid1", "did1", "", c.Redeliver)
+	assert.Equal(http.StatusAccepted, w.Code, "Invalid statuscode")
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+	assert.Contains(w.Body.String(), `"id":"did2"`)
+	assert.Contains(w.Body.String(), `"status":"pending"`)
+	assert.Contains(w.Body.String(), `"next_attempt_at":1448272069`)
+
+	w = request("uid2", "DELETE", "wid1", "", "", c.Remove)
+	assert.Equal(http.StatusForbidden, w.Code, "Invalid statuscode")
+	w = request("uid1", "DELETE", "wid1", "", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	if assert.Len(m.hooks, 1) {
+		assert.Equal("wid2", m.hooks[0].ID)
+	}
+}
+
+func TestPostWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	posts := map[string]*model.Post{}
+	mockModel := &mockPostPeer{
+		usersFn: func(ids []string) (map[string]*model.User, error) {
+			return map[string]*model.User{}, nil
+		},
+		newFn: func(uid string) *model.Post {
+			return &model.Post{ID: "pid" + strconv.Itoa(len(posts)+1), UID: uid, CreatedAt: time.Unix(1448272067, 0)}
+		},
+		saveFn: func(p *model.Post) error {
+			posts[p.ID] = p
+			return nil
+		},
+		getidFn: func(id string) (*model.Post, error) {
+			return posts[id], nil
+		},
+		removeFn: func(p *model.Post) error {
+			p.DeletedAt = time.Now()
+			return nil
+		},
+		postsFn: func(q model.PostQuery) ([]*model.Post, error) {
+			var res []*model.Post
+			for _, p := range posts {
+				if p.DeletedAt.IsZero() && q.Matches(p) {
+					res = append(res, p)
+				}
+			}
+			return res, nil
+		},
+	}
+	hooks := &mockPublisher{}
+	c := &PostController{Model: mockModel, Webhooks: hooks}
+	request := func(id, body string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "user", "uid1")
+		ctx = context.WithValue(ctx, "urlparams", map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://posts?include=", strings.NewReader(body))
+		handler(ctx, w, r)
+		return w
+	}
+
+	w := request("", `{"data":{"type":"posts","attributes":{"message":"Hello webhooks"}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	if assert.Equal([]string{model.EventPostCreated}, hooks.events) {
+		res := hooks.data[0].(*jsonapi.Resource)
+		assert.Equal("pid1", res.ID)
+		assert.Equal("Hello webhooks", res.Attributes["message"])
+	}
+	w = request("", `{"data":{"type":"posts","attributes":{"message":"Not yet","publish_at":`+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+`}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	assert.Len(hooks.events, 1, "Posts not visible to everyone are not published")
+	w = request("pid2", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	assert.Len(hooks.events, 1, "Removing posts which were not published is not published")
+
+	w = request("pid1", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	if assert.Len(hooks.events, 2) {
+		assert.Equal(model.EventPostDeleted, hooks.events[1])
+		assert.Equal(&jsonapi.Identifier{Type: "posts", ID: "pid1"}, hooks.data[1])
+	}
+
+	// Scheduled posts are published once they are published
+	w = request("", `{"data":{"type":"posts","attributes":{"message":"Now it is","publish_at":`+strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)+`}}}`, c.Create)
+	assert.Equal(http.StatusCreated, w.Code, "Invalid statuscode")
+	since := time.Now().Add(-time.Minute)
+	posts["pid3"].PublishAt = since.Add(time.Second)
+	n, err := c.Publish(since, time.Now())
+	assert.NoError(err)
+	assert.Equal(1, n)
+	if assert.Len(hooks.events, 3) {
+		assert.Equal(model.EventPostCreated, hooks.events[2])
+		assert.Equal("pid3", hooks.data[2].(*jsonapi.Resource).ID)
+	}
+	w = request("pid3", "", c.Remove)
+	assert.Equal(http.StatusNoContent, w.Code, "Invalid statuscode")
+	if assert.Len(hooks.events, 4) {
+		assert.Equal(model.EventPostDeleted, hooks.events[3])
+	}
+}
+
+func TestAuthWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	existing := &model.User{ID: "uid1", Username: "one"}
+	mock := &mockAuthDataProvider{
+		getByOAuthIDFn: func(oauthid string) (*model.User, error) {
+			if oauthid == "google:1" {
+				return existing, nil
+			}
+			return nil, errors.New("Unknown user")
+		},
+		updateLastLoginFn: func(id string) error {
+			return nil
+		},
+		newUserFn: func() *model.User {
+			return &model.User{ID: "uid2", CreatedAt: time.Unix(1448272067, 0)}
+		},
+		saveNewFn: func(u *model.User) error {
+			return nil
+		},
+	}
+	hooks := &mockPublisher{}
+	ac := &AuthController{Data: mock, Webhooks: hooks}
+
+	_, err := ac.loginUser("google:1", "one")
+	assert.NoError(err)
+	assert.Empty(hooks.events, "Logins of existing users are not published")
+	_, err = ac.loginUser("google:2", "two")
+	assert.NoError(err)
+	if assert.Equal([]string{model.EventUserCreated}, hooks.events) {
+		assert.Equal(userResource(&model.User{ID: "uid2", Username: "two", CreatedAt: time.Unix(1448272067, 0)}), hooks.data[0])
+	}
+}
@@ -0,0 +1,57 @@
+package dedup
+
+import "time"
+
+// Verdict is the result of checking a message.
+type Verdict int
+
+const (
+	// Unique messages were not posted recently.
+	Unique Verdict = iota
+	// Duplicate messages were posted by the same user too often within the window.
+	Duplicate
+	// Flood messages were posted by all users together too often within the window.
+	Flood
+)
+
+// Checker detects duplicate messages of a user and messages flooding the wall.
+type Checker struct {
+	Store Store
+	// Window is the time a message is remembered after it was last posted
+	Window time.Duration
+	// MaxPerUser is the number of identical messages a user may post within the window, 1 rejects every repetition
+	MaxPerUser int
+	// MaxPerWall is the number of identical messages all users together may post within the window, 0 is unlimited
+	MaxPerWall int
+}
+
+func userKey(uid, fp string) string {
+	return "user:" + uid + ":" + fp
+}
+
+func wallKey(fp string) string {
+	return "wall:" + fp
+}
+
+// Check records that the user posts the message unless it would exceed the limits.
+// The limits are checked and the message is recorded by the same atomic operation of the store,
+// so concurrent posts of the same message cannot exceed the limits.
+// Messages rejected as Flood still count as posted by the user, Duplicate messages are not recorded again.
+func (c *Checker) Check(uid, message string) (Verdict, error) {
+	fp := Fingerprint(message)
+	ok, err := c.Store.Add(userKey(uid, fp), c.MaxPerUser, c.Window)
+	if err != nil {
+		return Unique, err
+	}
+	if !ok {
+		return Duplicate, nil
+	}
+	ok, err = c.Store.Add(wallKey(fp), c.MaxPerWall, c.Window)
+	if err != nil {
+		return Unique, err
+	}
+	if !ok {
+		return Flood, nil
+	}
+	return Unique, nil
+}
@@ -0,0 +1,151 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	assert := assert.New(t)
+	fp := Fingerprint("Buy cheap watches now!")
+	for _, same := range []string{
+		"buy cheap watches now",
+		"  BUY   cheap\r\nwatches... now!!! ",
+		"Ｂｕｙ cheap watches, now",
+		"buy‮ cheap watches now",
+	} {
+		assert.Equal(fp, Fingerprint(same), same)
+	}
+	for _, other := range []string{
+		"buy cheap watches later",
+		"buycheap watches now",
+		"",
+	} {
+		assert.NotEqual(fp, Fingerprint(other), other)
+	}
+
+	symbols := map[string]string{}
+	for _, message := range []string{"👍", "!!!", "🎉🎉", "🎉", ""} {
+		fp := Fingerprint(message)
+		if other, ok := symbols[fp]; ok {
+			t.Errorf("Messages without letters %q and %q have the same fingerprint", message, other)
+		}
+		symbols[fp] = message
+	}
+	assert.Equal(Fingerprint("🎉🎉"), Fingerprint(" 🎉 🎉 "))
+}
+
+// testStore runs the same scenario against a store whose clock is controlled by the returned function.
+func testStore(t *testing.T, s Store, setNow func(time.Time)) {
+	assert := assert.New(t)
+	start := time.Unix(1448272067, 0)
+	setNow(start)
+	n, err := s.Count("a")
+	assert.NoError(err)
+	assert.Equal(0, n)
+	for i := 1; i <= 3; i++ {
+		ok, err := s.Add("a", 0, time.Minute)
+		assert.NoError(err)
+		assert.True(ok)
+		n, _ = s.Count("a")
+		assert.Equal(i, n)
+	}
+	n, _ = s.Count("b")
+	assert.Equal(0, n, "Keys must be independent")
+
+	setNow(start.Add(50 * time.Second))
+	ok, err := s.Add("a", 4, time.Minute)
+	assert.NoError(err)
+	assert.True(ok, "Occurrences below the limit must be recorded")
+	ok, err = s.Add("a", 4, time.Minute)
+	assert.NoError(err)
+	assert.False(ok, "Occurrences exceeding the limit must not be recorded")
+
+	setNow(start.Add(100 * time.Second))
+	n, _ = s.Count("a")
+	assert.Equal(4, n, "Occurrences must extend the window")
+
+	setNow(start.Add(111 * time.Second))
+	n, _ = s.Count("a")
+	assert.Equal(0, n, "Occurrences must expire")
+	ok, _ = s.Add("a", 1, time.Minute)
+	assert.True(ok, "Expired occurrences must not be counted")
+	ok, _ = s.Add("a", 1, time.Minute)
+	assert.False(ok)
+	n, _ = s.Count("a")
+	assert.Equal(1, n)
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	testStore(t, s, func(now time.Time) {
+		s.now = func() time.Time { return now }
+	})
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMemoryStore()
+	now := time.Unix(1448272067, 0)
+	s.now = func() time.Time { return now }
+	s.Add("a", 0, time.Second)
+	s.Add("b", 0, time.Hour)
+	now = now.Add(2 * cleanupInterval)
+	s.Add("c", 0, time.Second)
+	assert.Len(s.entries, 2, "Expired entries must be removed")
+	_, ok := s.entries["a"]
+	assert.False(ok)
+}
+
+func TestChecker(t *testing.T) {
+	assert := assert.New(t)
+	c := &Checker{
+		Store:      NewMemoryStore(),
+		Window:     time.Hour,
+		MaxPerUser: 1,
+		MaxPerWall: 3,
+	}
+	check := func(uid, message string) Verdict {
+		v, err := c.Check(uid, message)
+		assert.NoError(err)
+		return v
+	}
+	assert.Equal(Unique, check("u1", "Hello world"))
+	assert.Equal(Duplicate, check("u1", "hello, WORLD!"))
+	assert.Equal(Unique, check("u1", "Hello other world"))
+	assert.Equal(Unique, check("u2", "Hello world"))
+	assert.Equal(Unique, check("u3", "Hello world"))
+	assert.Equal(Flood, check("u4", "Hello world"))
+	assert.Equal(Duplicate, check("u4", "Hello world"), "Rejected floods count as posted by the user")
+	assert.Equal(Unique, check("u1", "👍"))
+	assert.Equal(Duplicate, check("u1", "👍"), "Messages without letters must be detected")
+	assert.Equal(Unique, check("u1", "🎉"))
+
+	c.MaxPerUser, c.MaxPerWall = 0, 0
+	assert.Equal(Unique, check("u1", "Hello world"), "Zero limits must allow all messages")
+}
+
+func TestCheckerConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	c := &Checker{
+		Store:      NewMemoryStore(),
+		Window:     time.Hour,
+		MaxPerUser: 1,
+	}
+	verdicts := make(chan Verdict)
+	for i := 0; i < 10; i++ {
+		go func() {
+			v, _ := c.Check("u1", "Hello world")
+			verdicts <- v
+		}()
+	}
+	unique := 0
+	for i := 0; i < 10; i++ {
+		if <-verdicts == Unique {
+			unique++
+		}
+	}
+	assert.Equal(1, unique, "Concurrent posts of the same message must not exceed the limit")
+}
@@ -0,0 +1,6 @@
+// Package dedup detects users posting the same message repeatedly.
+//
+// Messages are reduced to a fingerprint which ignores case, punctuation and whitespace, so trivially modified copies
+// are detected as well. The occurrences of fingerprints per user and across the wall are counted by a Store within a
+// sliding window, MemoryStore for single instances and DynamoStore for deployments with multiple instances.
+package dedup
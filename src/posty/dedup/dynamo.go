@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoStore counts occurrences in a dynamodb table shared by all instances.
+//
+// The table has the hash key `key` (S). `expires_at` holds the unix time the occurrences are forgotten and
+// should be enabled as TTL attribute of the table, expired items which were not removed yet are ignored.
+type DynamoStore struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+	now   func() time.Time
+}
+
+// NewDynamoStoreFromSession creates a store using the table.
+func NewDynamoStoreFromSession(sess *session.Session, table string) *DynamoStore {
+	return NewDynamoStore(dynamodb.New(sess), table)
+}
+
+// NewDynamoStore creates a store using the client and table.
+func NewDynamoStore(db dynamodbiface.DynamoDBAPI, table string) *DynamoStore {
+	return &DynamoStore{
+		db:    db,
+		table: table,
+		now:   time.Now,
+	}
+}
+
+// Add records an occurrence of the key unless max occurrences are remembered already, see Store.
+// The first occurrence is put conditionally on the key being absent or expired, so it is checked and recorded at once.
+// Further occurrences increment the counter conditionally on the key not being expired and the counter being below max.
+func (s *DynamoStore) Add(key string, max int, ttl time.Duration) (bool, error) {
+	now := s.now()
+	expires := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))}
+	nowValue := &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(now.Unix(), 10))}
+	_, err := s.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":        {S: aws.String(key)},
+			"count":      {N: aws.String("1")},
+			"expires_at": expires,
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#k) OR expires_at <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#k": aws.String("key"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": nowValue,
+		},
+	})
+	if !conditionFailed(err) {
+		return err == nil, err
+	}
+	if max == 1 {
+		return false, nil
+	}
+	params := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+		UpdateExpression:    aws.String("ADD #c :one SET expires_at = :expires"),
+		ConditionExpression: aws.String("expires_at > :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#c": aws.String("count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one":     {N: aws.String("1")},
+			":expires": expires,
+			":now":     nowValue,
+		},
+	}
+	if max > 0 {
+		params.ConditionExpression = aws.String("expires_at > :now AND #c < :max")
+		params.ExpressionAttributeValues[":max"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(max))}
+	}
+	_, err = s.db.UpdateItem(params)
+	if conditionFailed(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// conditionFailed returns true if the error is a failed condition of a conditional write.
+func conditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ConditionalCheckFailedException"
+}
+
+// Count returns the number of remembered occurrences of the key, see Store.
+func (s *DynamoStore) Count(key string) (int, error) {
+	resp, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || resp.Item == nil {
+		return 0, err
+	}
+	if v, ok := resp.Item["expires_at"]; !ok || v.N == nil {
+		return 0, nil
+	} else if exp, err := strconv.ParseInt(*v.N, 10, 64); err != nil || exp <= s.now().Unix() {
+		return 0, err
+	}
+	return countAttribute(resp.Item)
+}
+
+// countAttribute parses the count attribute of an item.
+func countAttribute(item map[string]*dynamodb.AttributeValue) (int, error) {
+	v, ok := item["count"]
+	if !ok || v.N == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(*v.N)
+}
@@ -0,0 +1,66 @@
+package dedup
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDynamo stores items by key in memory and evaluates the expressions used by DynamoStore, unused methods of the interface panic.
+type mockDynamo struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (m *mockDynamo) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[*in.Key["key"].S]}, nil
+}
+
+func (m *mockDynamo) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	key := *in.Item["key"].S
+	if item, ok := m.items[key]; ok && number(item["expires_at"]) > number(in.ExpressionAttributeValues[":now"]) {
+		return nil, awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	}
+	m.items[key] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamo) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	item, ok := m.items[*in.Key["key"].S]
+	values := in.ExpressionAttributeValues
+	if !ok || number(item["expires_at"]) <= number(values[":now"]) {
+		return nil, awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	}
+	if max, ok := values[":max"]; ok && number(item["count"]) >= number(max) {
+		return nil, awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	}
+	count := strconv.Itoa(number(item["count"]) + number(values[":one"]))
+	item["count"] = &dynamodb.AttributeValue{N: aws.String(count)}
+	item["expires_at"] = values[":expires"]
+	return &dynamodb.UpdateItemOutput{
+		Attributes: map[string]*dynamodb.AttributeValue{
+			"count":      item["count"],
+			"expires_at": item["expires_at"],
+		},
+	}, nil
+}
+
+func number(v *dynamodb.AttributeValue) int {
+	n, _ := strconv.Atoi(aws.StringValue(v.N))
+	return n
+}
+
+func TestDynamoStore(t *testing.T) {
+	m := &mockDynamo{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	s := NewDynamoStore(m, "fingerprint")
+	testStore(t, s, func(now time.Time) {
+		s.now = func() time.Time { return now }
+	})
+	assert.Equal(t, "1448272238", *m.items["a"]["expires_at"].N)
+}
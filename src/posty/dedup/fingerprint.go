@@ -0,0 +1,26 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"posty/validation"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fingerprint returns the hex encoded hash of the normalised message.
+// Messages differing only in case, compatibility characters, punctuation, symbols or whitespace have the same fingerprint.
+// Messages without letters and digits, e.g. emojis, only differing in whitespace have the same fingerprint.
+func Fingerprint(message string) string {
+	s := strings.ToLower(norm.NFKC.String(validation.Normalize(message)))
+	text := strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+	if text == "" {
+		text = strings.Join(strings.Fields(s), "")
+	}
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,83 @@
+package dedup
+
+import (
+	"sync"
+	"time"
+)
+
+// Store counts occurrences of keys within a sliding window.
+type Store interface {
+	// Add records an occurrence of the key unless max occurrences are remembered already, 0 is unlimited.
+	// Checking the limit and recording the occurrence is atomic, the result tells whether the occurrence was recorded.
+	// Occurrences are forgotten once no occurrence was recorded for ttl.
+	Add(key string, max int, ttl time.Duration) (bool, error)
+	// Count returns the number of remembered occurrences of the key.
+	Count(key string) (int, error)
+}
+
+// cleanupInterval is the minimum time between removals of expired entries.
+const cleanupInterval = time.Minute
+
+// MemoryStore counts occurrences in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	cleaned time.Time
+	now     func() time.Time
+}
+
+type memoryEntry struct {
+	count   int
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// Add records an occurrence of the key, see Store.
+func (s *MemoryStore) Add(key string, max int, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.cleanup(now)
+	e, ok := s.entries[key]
+	if !ok || !e.expires.After(now) {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	if max > 0 && e.count >= max {
+		return false, nil
+	}
+	e.count++
+	e.expires = now.Add(ttl)
+	return true, nil
+}
+
+// Count returns the number of remembered occurrences of the key, see Store.
+func (s *MemoryStore) Count(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !e.expires.After(s.now()) {
+		return 0, nil
+	}
+	return e.count, nil
+}
+
+// cleanup removes expired entries.
+func (s *MemoryStore) cleanup(now time.Time) {
+	if now.Sub(s.cleaned) < cleanupInterval {
+		return
+	}
+	s.cleaned = now
+	for key, e := range s.entries {
+		if !e.expires.After(now) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"posty/dump"
+	"posty/model/awsdynamo"
+)
+
+// runDump runs the export or import subcommand with its arguments and returns the exit code.
+//
+//	posty export [-o file]   writes all users and posts as newline-delimited JSON to the file or stdout
+//	posty import [-i file]   reads users and posts from the file or stdin, existing ones are skipped
+//
+// Progress is reported to stderr.
+func runDump(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	file := fs.String("o", "-", "File the dump is written to, - for stdout")
+	if cmd == "import" {
+		file = fs.String("i", "-", "File the dump is read from, - for stdin")
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !configLoaded() {
+		return 1
+	}
+	m := awsdynamo.NewModelFromSession(newAWSSession())
+	var (
+		stats *dump.Stats
+		err   error
+	)
+	if cmd == "export" {
+		var w io.Writer = os.Stdout
+		if *file != "-" {
+			f, ferr := os.Create(*file)
+			if ferr != nil {
+				fmt.Fprintf(os.Stderr, "Could not create %s: %s\n", *file, ferr)
+				return 1
+			}
+			defer f.Close()
+			w = f
+		}
+		stats, err = dump.Export(m, w, os.Stderr)
+	} else {
+		var r io.Reader = os.Stdin
+		if *file != "-" {
+			f, ferr := os.Open(*file)
+			if ferr != nil {
+				fmt.Fprintf(os.Stderr, "Could not open %s: %s\n", *file, ferr)
+				return 1
+			}
+			defer f.Close()
+			r = f
+		}
+		stats, err = dump.Import(m, r, os.Stderr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed after %s: %s\n", cmd, stats, err)
+		return 1
+	}
+	return 0
+}
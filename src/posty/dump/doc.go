@@ -0,0 +1,6 @@
+// Package dump exports and imports users and posts as newline-delimited JSON.
+//
+// Every line of a dump is a record with the type `user` or `post` and the entity as data, users come first.
+// Ids and timestamps, as unix timestamps in nanoseconds, are preserved, so a dump moves data between models of
+// different backends. Import skips entities which already exist, so importing a dump twice has no effect.
+package dump
@@ -0,0 +1,208 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"posty/model"
+	"sort"
+)
+
+// ProgressInterval is the number of records after which progress is reported.
+const ProgressInterval = 1000
+
+// PageSize is the number of users or posts fetched at once by Export.
+const PageSize = 100
+
+// Stats counts the records of an export or import.
+type Stats struct {
+	Users   int
+	Posts   int
+	Skipped int // records of entities which already existed
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf("%d users, %d posts, %d skipped", s.Users, s.Posts, s.Skipped)
+}
+
+// report writes the stats to the progress writer every ProgressInterval records or if final is set.
+func (s *Stats) report(progress io.Writer, final bool) {
+	if final || (s.Users+s.Posts+s.Skipped)%ProgressInterval == 0 {
+		fmt.Fprintln(progress, s)
+	}
+}
+
+type record struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Export writes all users and posts of the model to w, one record per line.
+// Records are written page by page, so the model is never loaded into memory as a whole.
+// Users are exported in the order of the user peer, oldest first within a page. Posts are exported whether they are live, scheduled,
+// expired or removed, oldest first. Progress is written to progress if it is not nil.
+func Export(m model.Model, w io.Writer, progress io.Writer) (*Stats, error) {
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	stats := &Stats{}
+	enc := json.NewEncoder(w)
+	for after := ""; ; {
+		users, next, err := m.UserPeer().GetPage(after, PageSize)
+		if err != nil {
+			return stats, err
+		}
+		sort.Sort(byUserCreatedAt(users))
+		for _, u := range users {
+			if err := enc.Encode(&record{Type: TypeUser, Data: newUserRecord(u)}); err != nil {
+				return stats, err
+			}
+			stats.Users++
+			stats.report(progress, false)
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	for after := ""; ; {
+		posts, next, err := m.PostPeer().GetPage(after, PageSize)
+		if err != nil {
+			return stats, err
+		}
+		for _, p := range posts {
+			if err := enc.Encode(&record{Type: TypePost, Data: newPostRecord(p)}); err != nil {
+				return stats, err
+			}
+			stats.Posts++
+			stats.report(progress, false)
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	stats.report(progress, true)
+	return stats, nil
+}
+
+// byUserCreatedAt sorts users oldest first.
+type byUserCreatedAt []*model.User
+
+func (o byUserCreatedAt) Len() int           { return len(o) }
+func (o byUserCreatedAt) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byUserCreatedAt) Less(i, j int) bool { return o[i].CreatedAt.Before(o[j].CreatedAt) }
+
+// Import saves the users and posts read from r to the model. Entities whose id already exists are skipped,
+// saving fails if it is not known whether they exist, e.g. if the model is throttled.
+// Progress is written to progress if it is not nil. On error the records before the failed one were imported.
+func Import(m model.Model, r io.Reader, progress io.Writer) (*Stats, error) {
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+	stats := &Stats{}
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		var rec struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("Record %d: %s", n, err)
+		}
+		switch rec.Type {
+		case TypeUser:
+			err = importUser(m.UserPeer(), rec.Data, stats)
+		case TypePost:
+			err = importPost(m.PostPeer(), rec.Data, stats)
+		default:
+			err = fmt.Errorf("Unknown type '%s'", rec.Type)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("Record %d: %s", n, err)
+		}
+		stats.report(progress, false)
+	}
+	stats.report(progress, true)
+	return stats, nil
+}
+
+func importUser(peer model.UserPeer, data []byte, stats *Stats) error {
+	var r userRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	u, err := r.user()
+	if err != nil {
+		return err
+	}
+	if _, err := peer.GetByID(u.ID); err == nil {
+		stats.Skipped++
+		return nil
+	} else if err != model.ErrNotFound {
+		return err
+	}
+	u.Peer = peer
+	err = u.SaveNew()
+	if err == model.ErrAlreadyExists {
+		// created since it was fetched
+		stats.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stats.Users++
+	return nil
+}
+
+func importPost(peer model.PostPeer, data []byte, stats *Stats) error {
+	var r postRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	p, err := r.post()
+	if err != nil {
+		return err
+	}
+	exists, err := postExists(peer, p.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		stats.Skipped++
+		return nil
+	}
+	p.Peer = peer
+	err = p.SaveNew()
+	if err == model.ErrAlreadyExists {
+		// the lookup by id is eventually consistent and may miss posts saved recently
+		stats.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stats.Posts++
+	return nil
+}
+
+// postExists returns true if the post exists, whether it was removed or not.
+func postExists(peer model.PostPeer, id string) (bool, error) {
+	_, err := peer.GetByID(id)
+	if err == nil {
+		return true, nil
+	}
+	if err == model.ErrNotFound {
+		return false, nil
+	}
+	if _, derr := peer.GetDeletedByID(id); derr == nil {
+		return true, nil
+	}
+	return false, err
+}
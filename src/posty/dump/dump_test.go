@@ -0,0 +1,250 @@
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"posty/model"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memModel keeps users and posts in memory, other peers are not supported.
+type memModel struct {
+	model.Model
+	users *memUserPeer
+	posts *memPostPeer
+}
+
+func newMemModel() *memModel {
+	return &memModel{
+		users: &memUserPeer{users: map[string]*model.User{}},
+		posts: &memPostPeer{posts: map[string]*model.Post{}},
+	}
+}
+
+func (m *memModel) UserPeer() model.UserPeer { return m.users }
+func (m *memModel) PostPeer() model.PostPeer { return m.posts }
+
+type memUserPeer struct {
+	model.UserPeer
+	users map[string]*model.User
+}
+
+func (p *memUserPeer) GetByID(id string) (*model.User, error) {
+	if u, ok := p.users[id]; ok {
+		return u, nil
+	}
+	return nil, model.ErrNotFound
+}
+
+// GetPage returns the users ordered by id, newest first to check that pages are sorted.
+func (p *memUserPeer) GetPage(after string, limit int) ([]*model.User, string, error) {
+	var ids []string
+	for id := range p.users {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	next := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+	var res []*model.User
+	for i := len(ids) - 1; i >= 0; i-- {
+		res = append(res, p.users[ids[i]])
+	}
+	return res, next, nil
+}
+
+func (p *memUserPeer) SaveNew(u *model.User) error {
+	if _, ok := p.users[u.ID]; ok {
+		return model.ErrAlreadyExists
+	}
+	p.users[u.ID] = u
+	return nil
+}
+
+type memPostPeer struct {
+	model.PostPeer
+	posts map[string]*model.Post
+	saved int
+	// err is returned by GetByID if set, stale hides posts from GetByID and GetDeletedByID
+	err   error
+	stale bool
+}
+
+func (p *memPostPeer) get(id string, deleted bool) (*model.Post, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if post, ok := p.posts[id]; ok && !p.stale {
+		if post.DeletedAt.IsZero() == deleted {
+			return post, nil
+		}
+		return nil, errors.New("Removed or not removed")
+	}
+	return nil, model.ErrNotFound
+}
+
+func (p *memPostPeer) GetByID(id string) (*model.Post, error) {
+	return p.get(id, false)
+}
+
+func (p *memPostPeer) GetDeletedByID(id string) (*model.Post, error) {
+	return p.get(id, true)
+}
+
+func (p *memPostPeer) GetPage(after string, limit int) ([]*model.Post, string, error) {
+	var res []*model.Post
+	for _, post := range p.posts {
+		if strconv.FormatInt(post.CreatedAt.UnixNano(), 10) > after {
+			res = append(res, post)
+		}
+	}
+	sort.Sort(sort.Reverse(model.ByCreatedAtDESC(res)))
+	next := ""
+	if len(res) > limit {
+		res = res[:limit]
+		next = strconv.FormatInt(res[limit-1].CreatedAt.UnixNano(), 10)
+	}
+	return res, next, nil
+}
+
+func (p *memPostPeer) SaveNew(post *model.Post) error {
+	if _, ok := p.posts[post.ID]; ok {
+		return model.ErrAlreadyExists
+	}
+	p.posts[post.ID] = post
+	p.saved++
+	return nil
+}
+
+func TestExportImport(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	created := time.Unix(1448272067, 123456789)
+	src := newMemModel()
+	src.users.users["uid1"] = &model.User{ID: "uid1", OAuthID: "google:1", Email: "one@example.com", Username: "one", Roles: []string{model.RoleAdmin}, Muted: []string{model.NotificationDigest}, CreatedAt: created, LastLogin: created.Add(time.Hour)}
+	src.users.users["uid2"] = &model.User{ID: "uid2", OAuthID: "paypal:2", Username: "two", CreatedAt: created.Add(time.Second)}
+	posts := []*model.Post{
+		{ID: "pid1", UID: "uid1", Message: "Hello #posty @two", Format: model.FormatMarkdown, Tags: []string{"posty"}, Mentions: []string{"two"}, CreatedAt: created.Add(time.Minute), PinnedAt: created.Add(time.Hour),
+			Attachments: []model.Attachment{{ID: "aid1", Name: "cat.png", ContentType: "image/png", Size: 1234, Width: 640, Height: 480, Thumbnail: true}},
+			Preview:     &model.Preview{URL: "https://example.com", Title: "Example"}},
+		{ID: "pid2", UID: "uid2", Message: "Which one?", CreatedAt: created.Add(2 * time.Minute), Hidden: true, Poll: &model.Poll{Options: []string{"a", "b"}, ClosesAt: now.Add(time.Hour), HideResults: true}},
+		{ID: "pid3", UID: "uid2", Message: "Later", CreatedAt: now.Add(-time.Minute), PublishAt: now.Add(time.Hour)},
+		{ID: "pid4", UID: "uid1", Message: "Gone", CreatedAt: created.Add(3 * time.Minute), ExpiresAt: created.Add(time.Hour)},
+		{ID: "pid5", UID: "uid1", Message: "Removed", CreatedAt: created.Add(4 * time.Minute), DeletedAt: now.Add(-time.Second)},
+	}
+	for _, p := range posts {
+		src.posts.posts[p.ID] = p
+	}
+
+	var b, progress bytes.Buffer
+	stats, err := Export(src, &b, &progress)
+	assert.NoError(err)
+	assert.Equal(&Stats{Users: 2, Posts: 5}, stats, "Live, scheduled, expired and removed posts are exported")
+	assert.Equal("2 users, 5 posts, 0 skipped\n", progress.String())
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if assert.Len(lines, 7) {
+		assert.Equal(`{"type":"user","data":{"id":"uid1","oauth_id":"google:1","email":"one@example.com","username":"one","roles":["admin"],"muted":["digest"],"created_at":1448272067123456789,"last_login":1448275667123456789}}`, lines[0])
+		assert.Contains(lines[1], `"id":"uid2"`, "Users of a page are exported oldest first")
+		assert.Contains(lines[2], `{"type":"post","data":{"id":"pid1"`, "Posts are exported after users, oldest first")
+		assert.Contains(lines[6], `"id":"pid3"`)
+	}
+
+	dst := newMemModel()
+	progress.Reset()
+	stats, err = Import(dst, bytes.NewReader(b.Bytes()), &progress)
+	assert.NoError(err)
+	assert.Equal(&Stats{Users: 2, Posts: 5}, stats)
+	assert.Equal("2 users, 5 posts, 0 skipped\n", progress.String())
+	for id, u := range src.users.users {
+		imported := dst.users.users[id]
+		if assert.NotNil(imported, id) {
+			assert.Equal(u.CreatedAt.UnixNano(), imported.CreatedAt.UnixNano(), "Timestamps are preserved in nanoseconds")
+			imported.Peer = nil
+			imported.CreatedAt, imported.LastLogin = u.CreatedAt, u.LastLogin
+			assert.Equal(u, imported)
+		}
+	}
+	for _, p := range posts {
+		imported := dst.posts.posts[p.ID]
+		if assert.NotNil(imported, p.ID) {
+			assert.Equal(dst.posts, imported.Peer)
+			assert.Equal(p.CreatedAt.UnixNano(), imported.CreatedAt.UnixNano(), "Timestamps are preserved in nanoseconds")
+			var again bytes.Buffer
+			Export(&memModel{users: &memUserPeer{users: map[string]*model.User{}}, posts: &memPostPeer{posts: map[string]*model.Post{p.ID: imported}}}, &again, nil)
+			assert.Contains(b.String(), again.String(), "Imported posts are exported unchanged")
+		}
+	}
+
+	stats, err = Import(dst, bytes.NewReader(b.Bytes()), nil)
+	assert.NoError(err)
+	assert.Equal(&Stats{Skipped: 7}, stats, "Imports are idempotent")
+	assert.Equal(5, dst.posts.saved)
+
+	dst.posts.stale = true
+	stats, err = Import(dst, bytes.NewReader(b.Bytes()), nil)
+	assert.NoError(err)
+	assert.Equal(&Stats{Skipped: 7}, stats, "Posts missed by stale reads are not overwritten")
+	assert.Equal(5, dst.posts.saved)
+
+	dst.posts.stale, dst.posts.err = false, errors.New("Throttled")
+	_, err = Import(dst, bytes.NewReader(b.Bytes()), nil)
+	if assert.Error(err, "Posts are only saved if they are known to be absent") {
+		assert.Equal("Record 3: Throttled", err.Error())
+	}
+	assert.Equal(5, dst.posts.saved)
+}
+
+func TestExportPages(t *testing.T) {
+	assert := assert.New(t)
+	created := time.Unix(1448272067, 0)
+	src := newMemModel()
+	for i := 0; i < PageSize+1; i++ {
+		id := fmt.Sprintf("uid%03d", i)
+		src.users.users[id] = &model.User{ID: id, CreatedAt: created}
+	}
+	for i := 0; i < 2*PageSize+1; i++ {
+		id := fmt.Sprintf("pid%03d", i)
+		src.posts.posts[id] = &model.Post{ID: id, UID: "uid000", CreatedAt: created.Add(time.Duration(i) * time.Second)}
+	}
+	var b bytes.Buffer
+	stats, err := Export(src, &b, nil)
+	assert.NoError(err)
+	assert.Equal(&Stats{Users: PageSize + 1, Posts: 2*PageSize + 1}, stats, "All pages are exported")
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if assert.Len(lines, 3*PageSize+2) {
+		assert.Contains(lines[PageSize+1], `"id":"pid000"`)
+		assert.Contains(lines[len(lines)-1], fmt.Sprintf(`"id":"pid%03d"`, 2*PageSize), "Posts are exported oldest first")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	assert := assert.New(t)
+	for input, msg := range map[string]string{
+		`{"type":"user","data":{"id":"uid1","created_at":1}}` + "\n" + `{"type":"vote","data":{}}`: "Record 2: Unknown type 'vote'",
+		`{"type":"post","data":{"id":"pid1","uid":"uid1"}}`:                                        "Record 1: Post without id, uid or created_at",
+		`{"type":"user","data":{"created_at":1}}`:                                                  "Record 1: User without id or created_at",
+		`{"type":"user"`: "Record 1: unexpected EOF",
+	} {
+		m := newMemModel()
+		_, err := Import(m, strings.NewReader(input), nil)
+		if assert.Error(err, input) {
+			assert.Equal(msg, err.Error())
+		}
+	}
+	m := newMemModel()
+	stats, err := Import(m, strings.NewReader(`{"type":"user","data":{"id":"uid1","created_at":1}}`+"\n"+`{"type":"vote","data":{}}`), nil)
+	assert.Error(err)
+	assert.Equal(1, stats.Users, "Records before the failed one are imported")
+	assert.Len(m.users.users, 1)
+}
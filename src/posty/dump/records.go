@@ -0,0 +1,206 @@
+package dump
+
+import (
+	"errors"
+	"posty/model"
+	"time"
+)
+
+// Types of records.
+const (
+	TypeUser = "user"
+	TypePost = "post"
+)
+
+// userRecord is the exported representation of a model.User.
+type userRecord struct {
+	ID        string   `json:"id"`
+	OAuthID   string   `json:"oauth_id"`
+	Email     string   `json:"email,omitempty"`
+	Username  string   `json:"username"`
+	Roles     []string `json:"roles,omitempty"`
+	Muted     []string `json:"muted,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+	LastLogin int64    `json:"last_login,omitempty"`
+}
+
+// postRecord is the exported representation of a model.Post.
+type postRecord struct {
+	ID          string             `json:"id"`
+	UID         string             `json:"uid"`
+	Message     string             `json:"message"`
+	Format      string             `json:"format,omitempty"`
+	Tags        []string           `json:"tags,omitempty"`
+	Mentions    []string           `json:"mentions,omitempty"`
+	Attachments []attachmentRecord `json:"attachments,omitempty"`
+	Preview     *previewRecord     `json:"preview,omitempty"`
+	Poll        *pollRecord        `json:"poll,omitempty"`
+	Quarantined bool               `json:"quarantined,omitempty"`
+	Hidden      bool               `json:"hidden,omitempty"`
+	CreatedAt   int64              `json:"created_at"`
+	PublishAt   int64              `json:"publish_at,omitempty"`
+	ExpiresAt   int64              `json:"expires_at,omitempty"`
+	PinnedAt    int64              `json:"pinned_at,omitempty"`
+	DeletedAt   int64              `json:"deleted_at,omitempty"`
+}
+
+type attachmentRecord struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Thumbnail   bool   `json:"thumbnail,omitempty"`
+}
+
+type previewRecord struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+type pollRecord struct {
+	Options     []string `json:"options"`
+	ClosesAt    int64    `json:"closes_at,omitempty"`
+	HideResults bool     `json:"hide_results,omitempty"`
+}
+
+// nanos returns the unix timestamp of t in nanoseconds, 0 if t is not set.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromNanos returns the time of the unix timestamp in nanoseconds, the zero time for 0.
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
+
+func newUserRecord(u *model.User) *userRecord {
+	return &userRecord{
+		ID:        u.ID,
+		OAuthID:   u.OAuthID,
+		Email:     u.Email,
+		Username:  u.Username,
+		Roles:     u.Roles,
+		Muted:     u.Muted,
+		CreatedAt: nanos(u.CreatedAt),
+		LastLogin: nanos(u.LastLogin),
+	}
+}
+
+func (r *userRecord) user() (*model.User, error) {
+	if r.ID == "" || r.CreatedAt == 0 {
+		return nil, errors.New("User without id or created_at")
+	}
+	return &model.User{
+		ID:        r.ID,
+		OAuthID:   r.OAuthID,
+		Email:     r.Email,
+		Username:  r.Username,
+		Roles:     r.Roles,
+		Muted:     r.Muted,
+		CreatedAt: fromNanos(r.CreatedAt),
+		LastLogin: fromNanos(r.LastLogin),
+	}, nil
+}
+
+func newPostRecord(p *model.Post) *postRecord {
+	r := &postRecord{
+		ID:          p.ID,
+		UID:         p.UID,
+		Message:     p.Message,
+		Format:      p.Format,
+		Tags:        p.Tags,
+		Mentions:    p.Mentions,
+		Quarantined: p.Quarantined,
+		Hidden:      p.Hidden,
+		CreatedAt:   nanos(p.CreatedAt),
+		PublishAt:   nanos(p.PublishAt),
+		ExpiresAt:   nanos(p.ExpiresAt),
+		PinnedAt:    nanos(p.PinnedAt),
+		DeletedAt:   nanos(p.DeletedAt),
+	}
+	for _, a := range p.Attachments {
+		r.Attachments = append(r.Attachments, attachmentRecord{
+			ID:          a.ID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Width:       a.Width,
+			Height:      a.Height,
+			Thumbnail:   a.Thumbnail,
+		})
+	}
+	if p.Preview != nil {
+		r.Preview = &previewRecord{
+			URL:         p.Preview.URL,
+			Title:       p.Preview.Title,
+			Description: p.Preview.Description,
+			ImageURL:    p.Preview.ImageURL,
+		}
+	}
+	if p.Poll != nil {
+		r.Poll = &pollRecord{
+			Options:     p.Poll.Options,
+			ClosesAt:    nanos(p.Poll.ClosesAt),
+			HideResults: p.Poll.HideResults,
+		}
+	}
+	return r
+}
+
+func (r *postRecord) post() (*model.Post, error) {
+	if r.ID == "" || r.UID == "" || r.CreatedAt == 0 {
+		return nil, errors.New("Post without id, uid or created_at")
+	}
+	p := &model.Post{
+		ID:          r.ID,
+		UID:         r.UID,
+		Message:     r.Message,
+		Format:      r.Format,
+		Tags:        r.Tags,
+		Mentions:    r.Mentions,
+		Quarantined: r.Quarantined,
+		Hidden:      r.Hidden,
+		CreatedAt:   fromNanos(r.CreatedAt),
+		PublishAt:   fromNanos(r.PublishAt),
+		ExpiresAt:   fromNanos(r.ExpiresAt),
+		PinnedAt:    fromNanos(r.PinnedAt),
+		DeletedAt:   fromNanos(r.DeletedAt),
+	}
+	for _, a := range r.Attachments {
+		p.Attachments = append(p.Attachments, model.Attachment{
+			ID:          a.ID,
+			Name:        a.Name,
+			ContentType: a.ContentType,
+			Size:        a.Size,
+			Width:       a.Width,
+			Height:      a.Height,
+			Thumbnail:   a.Thumbnail,
+		})
+	}
+	if r.Preview != nil {
+		p.Preview = &model.Preview{
+			URL:         r.Preview.URL,
+			Title:       r.Preview.Title,
+			Description: r.Preview.Description,
+			ImageURL:    r.Preview.ImageURL,
+		}
+	}
+	if r.Poll != nil {
+		p.Poll = &model.Poll{
+			Options:     r.Poll.Options,
+			ClosesAt:    fromNanos(r.Poll.ClosesAt),
+			HideResults: r.Poll.HideResults,
+		}
+	}
+	return p, nil
+}
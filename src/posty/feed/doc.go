@@ -0,0 +1,5 @@
+// Package feed renders the wall as Atom and RSS feed.
+//
+// A Feed is written by WriteAtom or WriteRSS. Feed readers can not log in, so private feeds are authenticated by
+// tokens of a `posty/token` Signer naming the user and the feed.
+package feed
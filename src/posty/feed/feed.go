@@ -0,0 +1,181 @@
+package feed
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"strings"
+	"time"
+)
+
+// Content types of the feed documents.
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+)
+
+// Feed is a list of entries, newest first.
+type Feed struct {
+	ID      string // permanent id of the feed, e.g. a TagURI
+	Title   string
+	Link    string // html page the feed belongs to
+	Self    string // url of the feed document
+	Updated time.Time
+	Entries []*Entry
+}
+
+// Entry is a single item of a feed.
+type Entry struct {
+	ID        string // permanent id of the entry, e.g. a TagURI
+	Title     string
+	Link      string
+	Author    string
+	Published time.Time
+	Updated   time.Time
+	Content   string
+	HTML      bool // Content is html, otherwise plain text
+}
+
+// TagURI returns the tag URI (RFC 4151) of the specific name minted by the authority, e.g. the host of the wall,
+// at the date.
+func TagURI(authority string, date time.Time, specific string) string {
+	return "tag:" + authority + "," + date.UTC().Format("2006-01-02") + ":" + specific
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Links   []atomLink   `xml:"link"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Author    atomPerson `xml:"author"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// WriteAtom writes the feed as Atom (RFC 4287) document.
+func (f *Feed) WriteAtom(w io.Writer) error {
+	doc := &atomFeed{
+		ID:      f.ID,
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self},
+		},
+	}
+	for _, e := range f.Entries {
+		content := atomText{Type: "text", Body: e.Content}
+		if e.HTML {
+			content.Type = "html"
+		}
+		doc.Entries = append(doc.Entries, &atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: e.Link}},
+			Author:    atomPerson{Name: e.Author},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Content:   content,
+		})
+	}
+	return write(w, doc)
+}
+
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	Self          atomLink   `xml:"atom:link"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Creator     string  `xml:"dc:creator"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// WriteRSS writes the feed as RSS 2.0 document. Authors are given as `dc:creator` as RSS expects email addresses.
+func (f *Feed) WriteRSS(w io.Writer) error {
+	doc := &rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Title,
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: f.Self},
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+		},
+	}
+	for _, e := range f.Entries {
+		description := e.Content
+		if !e.HTML {
+			description = strings.Replace(html.EscapeString(description), "\n", "<br>", -1)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, &rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: description,
+			Creator:     e.Author,
+			GUID:        rssGUID{ID: e.ID},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return write(w, doc)
+}
+
+func write(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,89 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFeed() *Feed {
+	created := time.Date(2015, 11, 23, 9, 47, 47, 0, time.UTC)
+	return &Feed{
+		ID:      TagURI("posty.example.com", time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC), "posts"),
+		Title:   "posty",
+		Link:    "https://posty.example.com/",
+		Self:    "https://posty.example.com/feeds/posts.atom?token=abc",
+		Updated: created.Add(time.Hour),
+		Entries: []*Entry{
+			{
+				ID:        TagURI("posty.example.com", created, "posts/pid2"),
+				Title:     "Build <42> failed",
+				Link:      "https://posty.example.com/",
+				Author:    "CI",
+				Published: created,
+				Updated:   created.Add(time.Hour),
+				Content:   "<p>Build <em>42</em> failed</p>",
+				HTML:      true,
+			},
+			{
+				ID:        TagURI("posty.example.com", created, "posts/pid1"),
+				Title:     "Hello",
+				Link:      "https://posty.example.com/",
+				Author:    "one",
+				Published: created,
+				Updated:   created,
+				Content:   "Hello\n<world>",
+			},
+		},
+	}
+}
+
+func TestTagURI(t *testing.T) {
+	assert := assert.New(t)
+	date := time.Date(2015, 11, 23, 23, 0, 0, 0, time.FixedZone("CET", -3600))
+	assert.Equal("tag:posty.example.com,2015-11-24:posts/pid1", TagURI("posty.example.com", date, "posts/pid1"))
+}
+
+func TestWriteAtom(t *testing.T) {
+	assert := assert.New(t)
+	var b bytes.Buffer
+	assert.NoError(testFeed().WriteAtom(&b))
+	out := b.String()
+	assert.Contains(out, `<?xml version="1.0" encoding="UTF-8"?>`)
+	assert.Contains(out, `<feed xmlns="http://www.w3.org/2005/Atom">`)
+	assert.Contains(out, `<id>tag:posty.example.com,2015-11-01:posts</id>`)
+	assert.Contains(out, `<updated>2015-11-23T10:47:47Z</updated>`)
+	assert.Contains(out, `<link rel="self" type="application/atom+xml" href="https://posty.example.com/feeds/posts.atom?token=abc"></link>`)
+	assert.Contains(out, `<id>tag:posty.example.com,2015-11-23:posts/pid2</id>`)
+	assert.Contains(out, `<title>Build &lt;42&gt; failed</title>`)
+	assert.Contains(out, `<author>`+"\n      "+`<name>CI</name>`)
+	assert.Contains(out, `<published>2015-11-23T09:47:47Z</published>`)
+	assert.Contains(out, `<content type="html">&lt;p&gt;Build &lt;em&gt;42&lt;/em&gt; failed&lt;/p&gt;</content>`)
+	assert.Contains(out, `<content type="text">Hello&#xA;&lt;world&gt;</content>`)
+
+	var doc atomFeed
+	assert.NoError(xml.Unmarshal(b.Bytes(), &doc), "Atom documents must be well-formed")
+	assert.Len(doc.Entries, 2)
+}
+
+func TestWriteRSS(t *testing.T) {
+	assert := assert.New(t)
+	var b bytes.Buffer
+	assert.NoError(testFeed().WriteRSS(&b))
+	out := b.String()
+	assert.Contains(out, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	assert.Contains(out, `<lastBuildDate>Mon, 23 Nov 2015 10:47:47 +0000</lastBuildDate>`)
+	assert.Contains(out, `<atom:link rel="self" type="application/rss+xml" href="https://posty.example.com/feeds/posts.atom?token=abc"></atom:link>`)
+	assert.Contains(out, `<guid isPermaLink="false">tag:posty.example.com,2015-11-23:posts/pid2</guid>`)
+	assert.Contains(out, `<dc:creator>CI</dc:creator>`)
+	assert.Contains(out, `<pubDate>Mon, 23 Nov 2015 09:47:47 +0000</pubDate>`)
+	assert.Contains(out, `<description>&lt;p&gt;Build &lt;em&gt;42&lt;/em&gt; failed&lt;/p&gt;</description>`)
+	assert.Contains(out, `<description>Hello&lt;br&gt;&amp;lt;world&amp;gt;</description>`, "Plain text is escaped as html")
+
+	var doc rssDocument
+	assert.NoError(xml.Unmarshal(b.Bytes(), &doc), "RSS documents must be well-formed")
+	assert.Len(doc.Channel.Items, 2)
+}
@@ -0,0 +1,2 @@
+// Package jsonapi provides types and helpers to build documents following the JSON API specification (http://jsonapi.org/format/).
+package jsonapi
@@ -0,0 +1,67 @@
+package jsonapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// MediaType is the media type of JSON API documents.
+const MediaType = "application/vnd.api+json"
+
+// Document represents a top level JSON API document containing primary data.
+// Data is either nil, a single *Resource or a slice of *Resource.
+type Document struct {
+	Data     interface{}            `json:"data"`
+	Included []*Resource            `json:"included,omitempty"`
+	Links    *Links                 `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+// Resource represents a JSON API resource object.
+type Resource struct {
+	Type          string                   `json:"type"`
+	ID            string                   `json:"id,omitempty"`
+	Attributes    map[string]interface{}   `json:"attributes,omitempty"`
+	Relationships map[string]*Relationship `json:"relationships,omitempty"`
+	Links         *Links                   `json:"links,omitempty"`
+}
+
+// Identifier identifies a single resource.
+func (r *Resource) Identifier() *Identifier {
+	return &Identifier{
+		Type: r.Type,
+		ID:   r.ID,
+	}
+}
+
+// Identifier represents a resource identifier object used as relationship linkage.
+type Identifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Relationship represents a relationship object.
+// Data is either a single *Identifier for to-one relationships or a slice of *Identifier for to-many relationships.
+// A nil Data is encoded as an empty to-one relationship (`null`).
+type Relationship struct {
+	Links *Links      `json:"links,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+// Links represents a links object.
+type Links struct {
+	Self    string `json:"self,omitempty"`
+	Related string `json:"related,omitempty"`
+}
+
+// Write encodes the document to the responsewriter using the given status code.
+func Write(w http.ResponseWriter, code int, doc *Document) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", MediaType)
+	w.WriteHeader(code)
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,48 @@
+package jsonapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error represents a JSON API error object.
+type Error struct {
+	Status int          `json:"status,string"`
+	Code   string       `json:"code,omitempty"`
+	Title  string       `json:"title"`
+	Detail string       `json:"detail,omitempty"`
+	Source *ErrorSource `json:"source,omitempty"`
+}
+
+// ErrorSource references the part of the request which caused the error.
+// Pointer is a JSON Pointer [RFC6901] into the request document, Parameter names a query parameter.
+type ErrorSource struct {
+	Pointer   string `json:"pointer,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// ErrorDocument represents a top level JSON API document containing errors.
+type ErrorDocument struct {
+	Errors []*Error `json:"errors"`
+}
+
+// WriteErrors writes an error document containing errs with the given status code to the responsewriter.
+// Errors without a status inherit the status code, errors without a title get the default status text.
+func WriteErrors(w http.ResponseWriter, code int, errs ...*Error) {
+	for _, e := range errs {
+		if e.Status == 0 {
+			e.Status = code
+		}
+		if e.Title == "" {
+			e.Title = http.StatusText(e.Status)
+		}
+	}
+	b, err := json.Marshal(ErrorDocument{Errors: errs})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", MediaType)
+	w.WriteHeader(code)
+	w.Write(b)
+}
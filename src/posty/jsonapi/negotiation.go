@@ -0,0 +1,50 @@
+package jsonapi
+
+import (
+	"mime"
+	"strings"
+)
+
+// ValidContentType checks the Content-Type header of a request sending a JSON API document.
+// The media type must be present without any media type parameters.
+func ValidContentType(contentType string) bool {
+	mediatype, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediatype == MediaType && len(params) == 0
+}
+
+// Acceptable checks the Accept header of a request.
+// If the JSON API media type is listed, at least one instance must be present without media type parameters.
+// Clients not listing the JSON API media type are served if they accept any other json compatible type.
+func Acceptable(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	var jsonapi, plain, other bool
+	for _, part := range strings.Split(accept, ",") {
+		mediatype, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		delete(params, "q")
+		switch mediatype {
+		case MediaType:
+			if len(params) == 0 {
+				plain = true
+			} else {
+				jsonapi = true
+			}
+		case "*/*", "application/*", "application/json":
+			other = true
+		}
+	}
+	if plain {
+		return true
+	}
+	if jsonapi {
+		return false
+	}
+	return other
+}
@@ -0,0 +1,27 @@
+package jsonapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidContentType(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(ValidContentType("application/vnd.api+json"))
+	assert.False(ValidContentType("application/vnd.api+json; charset=utf-8"))
+	assert.False(ValidContentType("application/json"))
+	assert.False(ValidContentType(""))
+}
+
+func TestAcceptable(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(Acceptable(""))
+	assert.True(Acceptable("application/vnd.api+json"))
+	assert.True(Acceptable("application/json, text/plain, */*"))
+	assert.True(Acceptable("application/vnd.api+json; version=1, application/vnd.api+json"))
+	assert.True(Acceptable("application/vnd.api+json; q=0.9"))
+	assert.False(Acceptable("application/vnd.api+json; version=1"))
+	assert.False(Acceptable("application/vnd.api+json; version=1, */*"))
+	assert.False(Acceptable("text/html"))
+}
@@ -0,0 +1,94 @@
+package jsonapi
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Fieldsets maps resource types to the fields requested using sparse fieldsets, e.g. `fields[posts]=message,author`.
+// Types without an entry are returned with all fields.
+type Fieldsets map[string][]string
+
+// ParseFieldsets parses all `fields[TYPE]` query parameters.
+func ParseFieldsets(query url.Values) Fieldsets {
+	fs := make(Fieldsets)
+	for k, vs := range query {
+		if !strings.HasPrefix(k, "fields[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		typ := k[len("fields[") : len(k)-1]
+		fields := []string{}
+		for _, v := range vs {
+			fields = append(fields, splitList(v)...)
+		}
+		fs[typ] = fields
+	}
+	return fs
+}
+
+// Apply removes all attributes and relationships of the resource which were not requested.
+func (fs Fieldsets) Apply(r *Resource) {
+	fields, ok := fs[r.Type]
+	if !ok {
+		return
+	}
+	wanted := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		wanted[f] = true
+	}
+	for k := range r.Attributes {
+		if !wanted[k] {
+			delete(r.Attributes, k)
+		}
+	}
+	for k := range r.Relationships {
+		if !wanted[k] {
+			delete(r.Relationships, k)
+		}
+	}
+}
+
+// ParseInclude parses the `include` query parameter and validates the requested relationship paths against the supported ones.
+// If the parameter is absent, def is returned.
+// An error is returned if an unsupported path was requested.
+func ParseInclude(query url.Values, supported []string, def []string) ([]string, error) {
+	vs, ok := query["include"]
+	if !ok {
+		return def, nil
+	}
+	include := []string{}
+	for _, v := range vs {
+		for _, path := range splitList(v) {
+			if !contains(supported, path) {
+				return nil, fmt.Errorf("Unsupported include path %q", path)
+			}
+			include = append(include, path)
+		}
+	}
+	return include, nil
+}
+
+// Includes returns true if path is part of the include paths.
+func Includes(include []string, path string) bool {
+	return contains(include, path)
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package jsonapi
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldsets(t *testing.T) {
+	assert := assert.New(t)
+	q, _ := url.ParseQuery("fields[posts]=message,author&fields[users]=&sort=x")
+	fs := ParseFieldsets(q)
+	assert.Equal([]string{"message", "author"}, fs["posts"])
+	assert.Equal([]string{}, fs["users"])
+	_, ok := fs["sort"]
+	assert.False(ok)
+
+	r := &Resource{
+		Type: "posts",
+		ID:   "1",
+		Attributes: map[string]interface{}{
+			"message":    "msg",
+			"created_at": 1,
+		},
+		Relationships: map[string]*Relationship{
+			"author": {},
+		},
+	}
+	fs.Apply(r)
+	assert.Equal(map[string]interface{}{"message": "msg"}, r.Attributes)
+	assert.Len(r.Relationships, 1)
+
+	u := &Resource{
+		Type:       "users",
+		Attributes: map[string]interface{}{"username": "name"},
+	}
+	fs.Apply(u)
+	assert.Len(u.Attributes, 0)
+
+	other := &Resource{
+		Type:       "comments",
+		Attributes: map[string]interface{}{"text": "t"},
+	}
+	fs.Apply(other)
+	assert.Len(other.Attributes, 1, "Types without fieldset keep all fields")
+}
+
+func TestParseInclude(t *testing.T) {
+	assert := assert.New(t)
+	supported := []string{"author"}
+
+	inc, err := ParseInclude(url.Values{}, supported, supported)
+	assert.NoError(err)
+	assert.Equal(supported, inc, "Default expected")
+
+	q, _ := url.ParseQuery("include=")
+	inc, err = ParseInclude(q, supported, supported)
+	assert.NoError(err)
+	assert.False(Includes(inc, "author"))
+
+	q, _ = url.ParseQuery("include=author")
+	inc, err = ParseInclude(q, supported, nil)
+	assert.NoError(err)
+	assert.True(Includes(inc, "author"))
+
+	q, _ = url.ParseQuery("include=author,comments")
+	_, err = ParseInclude(q, supported, nil)
+	assert.Error(err)
+}
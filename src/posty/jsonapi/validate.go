@@ -0,0 +1,221 @@
+package jsonapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Validate checks if b is a valid JSON API response document.
+// It verifies the structure of the top level document, resource objects, relationships, error objects and full linkage of included resources.
+// This is mainly useful to verify responses in tests.
+func Validate(b []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("Document is not a json object: %s", err)
+	}
+	if err := onlyMembers(doc, "top level", "data", "errors", "meta", "jsonapi", "links", "included"); err != nil {
+		return err
+	}
+	_, hasData := doc["data"]
+	_, hasErrors := doc["errors"]
+	_, hasMeta := doc["meta"]
+	_, hasIncluded := doc["included"]
+	if !hasData && !hasErrors && !hasMeta {
+		return errors.New("Document must contain at least one of 'data', 'errors' or 'meta'")
+	}
+	if hasData && hasErrors {
+		return errors.New("Document must not contain both 'data' and 'errors'")
+	}
+	if hasIncluded && !hasData {
+		return errors.New("Document must not contain 'included' without 'data'")
+	}
+	if hasErrors {
+		return validateErrors(doc["errors"])
+	}
+	if !hasData {
+		return nil
+	}
+
+	seen := make(map[Identifier]bool)
+	linked := make(map[Identifier]bool)
+	primary, err := resources(doc["data"], true)
+	if err != nil {
+		return err
+	}
+	for _, r := range primary {
+		if err := validateResource(r, seen, linked); err != nil {
+			return err
+		}
+	}
+	if !hasIncluded {
+		return nil
+	}
+	included, err := resources(doc["included"], false)
+	if err != nil {
+		return err
+	}
+	var includedIDs []Identifier
+	for _, r := range included {
+		if err := validateResource(r, seen, linked); err != nil {
+			return err
+		}
+		var id Identifier
+		json.Unmarshal(r["type"], &id.Type)
+		json.Unmarshal(r["id"], &id.ID)
+		includedIDs = append(includedIDs, id)
+	}
+	for _, id := range includedIDs {
+		if !linked[id] {
+			return fmt.Errorf("Included resource %s/%s is not referenced by any relationship", id.Type, id.ID)
+		}
+	}
+	return nil
+}
+
+// resources decodes primary data or included resources. Primary data may be null or a single resource.
+func resources(raw json.RawMessage, primary bool) ([]map[string]json.RawMessage, error) {
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		if list == nil {
+			if primary {
+				return nil, nil
+			}
+			return nil, errors.New("'included' must be an array")
+		}
+		return list, nil
+	}
+	if !primary {
+		return nil, errors.New("'included' must be an array of resource objects")
+	}
+	var single map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &single); err != nil {
+		return nil, errors.New("'data' must be null, a resource object or an array of resource objects")
+	}
+	if single == nil {
+		return nil, nil
+	}
+	return []map[string]json.RawMessage{single}, nil
+}
+
+func validateResource(r map[string]json.RawMessage, seen, linked map[Identifier]bool) error {
+	if err := onlyMembers(r, "resource", "id", "type", "attributes", "relationships", "links", "meta"); err != nil {
+		return err
+	}
+	var id Identifier
+	if err := json.Unmarshal(r["type"], &id.Type); err != nil || id.Type == "" {
+		return errors.New("Resource must contain a non empty string 'type'")
+	}
+	if err := json.Unmarshal(r["id"], &id.ID); err != nil || id.ID == "" {
+		return fmt.Errorf("Resource of type %s must contain a non empty string 'id'", id.Type)
+	}
+	if seen[id] {
+		return fmt.Errorf("Resource %s/%s is contained more than once", id.Type, id.ID)
+	}
+	seen[id] = true
+
+	fields := make(map[string]bool)
+	if raw, ok := r["attributes"]; ok {
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &attrs); err != nil || attrs == nil {
+			return fmt.Errorf("Attributes of %s/%s must be an object", id.Type, id.ID)
+		}
+		for k := range attrs {
+			if k == "id" || k == "type" || k == "relationships" || k == "links" {
+				return fmt.Errorf("Attributes of %s/%s must not contain %q", id.Type, id.ID, k)
+			}
+			fields[k] = true
+		}
+	}
+	if raw, ok := r["relationships"]; ok {
+		var rels map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &rels); err != nil || rels == nil {
+			return fmt.Errorf("Relationships of %s/%s must be an object of relationship objects", id.Type, id.ID)
+		}
+		for k, rel := range rels {
+			if k == "id" || k == "type" {
+				return fmt.Errorf("Relationships of %s/%s must not contain %q", id.Type, id.ID, k)
+			}
+			if fields[k] {
+				return fmt.Errorf("Field %q of %s/%s is both attribute and relationship", k, id.Type, id.ID)
+			}
+			if err := validateRelationship(rel, linked); err != nil {
+				return fmt.Errorf("Relationship %q of %s/%s: %s", k, id.Type, id.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateRelationship(rel map[string]json.RawMessage, linked map[Identifier]bool) error {
+	if err := onlyMembers(rel, "relationship", "links", "data", "meta"); err != nil {
+		return err
+	}
+	raw, ok := rel["data"]
+	if !ok {
+		if _, ok := rel["links"]; ok {
+			return nil
+		}
+		if _, ok := rel["meta"]; ok {
+			return nil
+		}
+		return errors.New("Relationship must contain at least one of 'links', 'data' or 'meta'")
+	}
+	var ids []*Identifier
+	if err := json.Unmarshal(raw, &ids); err != nil {
+		var id *Identifier
+		if err := json.Unmarshal(raw, &id); err != nil {
+			return errors.New("Resource linkage must be null, a resource identifier or an array of resource identifiers")
+		}
+		ids = []*Identifier{id}
+	}
+	for _, id := range ids {
+		if id == nil {
+			continue
+		}
+		if id.Type == "" || id.ID == "" {
+			return errors.New("Resource identifier must contain 'type' and 'id'")
+		}
+		linked[*id] = true
+	}
+	return nil
+}
+
+func validateErrors(raw json.RawMessage) error {
+	var errs []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &errs); err != nil || errs == nil {
+		return errors.New("'errors' must be an array of error objects")
+	}
+	for _, e := range errs {
+		if err := onlyMembers(e, "error", "id", "links", "status", "code", "title", "detail", "source", "meta"); err != nil {
+			return err
+		}
+		for _, k := range []string{"status", "code", "title", "detail"} {
+			if v, ok := e[k]; ok {
+				var s string
+				if err := json.Unmarshal(v, &s); err != nil {
+					return fmt.Errorf("Error member %q must be a string", k)
+				}
+			}
+		}
+		if v, ok := e["source"]; ok {
+			var src map[string]json.RawMessage
+			if err := json.Unmarshal(v, &src); err != nil || src == nil {
+				return errors.New("Error member 'source' must be an object")
+			}
+			if err := onlyMembers(src, "error source", "pointer", "parameter"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func onlyMembers(obj map[string]json.RawMessage, name string, allowed ...string) error {
+	for k := range obj {
+		if !contains(allowed, k) {
+			return fmt.Errorf("Invalid member %q in %s object", k, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package jsonapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	valid := []string{
+		`{"data":null}`,
+		`{"data":[]}`,
+		`{"meta":{"count":1}}`,
+		`{"data":{"type":"posts","id":"1","attributes":{"message":"msg"}}}`,
+		`{"data":[{"type":"posts","id":"1","relationships":{"author":{"data":{"type":"users","id":"u1"}}}}],"included":[{"type":"users","id":"u1"}]}`,
+		`{"data":{"type":"posts","id":"1","relationships":{"author":{"links":{"related":"/api/users/u1"}}}}}`,
+		`{"errors":[{"status":"400","code":"too_short","title":"Too short","source":{"pointer":"/data/attributes/message"}}]}`,
+	}
+	for _, doc := range valid {
+		assert.NoError(Validate([]byte(doc)), doc)
+	}
+	invalid := []string{
+		`[]`,
+		`{}`,
+		`{"foo":1,"data":null}`,
+		`{"data":null,"errors":[]}`,
+		`{"included":[],"meta":{}}`,
+		`{"data":{"id":"1"}}`,
+		`{"data":{"type":"posts"}}`,
+		`{"data":{"type":"posts","id":1}}`,
+		`{"data":{"type":"posts","id":"1","foo":"bar"}}`,
+		`{"data":{"type":"posts","id":"1","attributes":{"id":"2"}}}`,
+		`{"data":{"type":"posts","id":"1","attributes":{"author":"x"},"relationships":{"author":{"data":null}}}}`,
+		`{"data":{"type":"posts","id":"1","relationships":{"author":{}}}}`,
+		`{"data":{"type":"posts","id":"1","relationships":{"author":{"data":{"type":"users"}}}}}`,
+		`{"data":[{"type":"posts","id":"1"},{"type":"posts","id":"1"}]}`,
+		`{"data":[{"type":"posts","id":"1"}],"included":[{"type":"users","id":"u1"}]}`,
+		`{"errors":[{"status":400}]}`,
+		`{"errors":[{"title":"x","source":{"foo":"bar"}}]}`,
+	}
+	for _, doc := range invalid {
+		assert.Error(Validate([]byte(doc)), doc)
+	}
+}
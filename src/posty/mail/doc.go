@@ -0,0 +1,5 @@
+// Package mail sends emails to users.
+//
+// Emails are sent by a pluggable Mailer: SMTPMailer delivers them to a SMTP server,
+// FileMailer and LogMailer keep them local during development. Queue sends emails in the background.
+package mail
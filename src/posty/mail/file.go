@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// FileMailer writes emails as `.eml` files to a directory instead of sending them, it is meant for development.
+type FileMailer struct {
+	dir  string
+	from string
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewFileMailer creates a mailer writing to the directory, the directory is created if it does not exist.
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new file named after the current time.
+func (f *FileMailer) Send(m *Message) error {
+	now := time.Now()
+	f.mu.Lock()
+	f.n++
+	name := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.Itoa(f.n) + ".eml"
+	f.mu.Unlock()
+	return ioutil.WriteFile(filepath.Join(f.dir, name), format(f.from, m, now), 0644)
+}
+
+// LogMailer logs emails instead of sending them, it is meant for development.
+type LogMailer struct{}
+
+// Send logs the recipient, subject and body of the message.
+func (LogMailer) Send(m *Message) error {
+	log.WithFields(log.Fields{
+		"to":      m.To,
+		"subject": m.Subject,
+	}).Info(m.Body)
+	return nil
+}
@@ -0,0 +1,83 @@
+package mail
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2015, 11, 23, 10, 0, 0, 0, time.UTC)
+	b := format("posty@example.com", &Message{
+		To:      "user@example.com",
+		Subject: "Hello",
+		Body:    "Line one\nLine two with = sign",
+		Headers: map[string]string{"X-B": "b", "X-A": "a"},
+	}, now)
+	assert.Equal("From: posty@example.com\r\n"+
+		"To: user@example.com\r\n"+
+		"Subject: Hello\r\n"+
+		"Date: Mon, 23 Nov 2015 10:00:00 +0000\r\n"+
+		"X-A: a\r\n"+
+		"X-B: b\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n"+
+		"\r\n"+
+		"Line one\r\nLine two with =3D sign", string(b))
+}
+
+func TestFileMailer(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "posty-mail")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	m, err := NewFileMailer(filepath.Join(dir, "outbox"), "posty@example.com")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.NoError(m.Send(&Message{To: "a@example.com", Subject: "First"}))
+	assert.NoError(m.Send(&Message{To: "b@example.com", Subject: "Second"}))
+	files, _ := filepath.Glob(filepath.Join(dir, "outbox", "*.eml"))
+	if assert.Len(files, 2) {
+		b, _ := ioutil.ReadFile(files[0])
+		assert.Contains(string(b), "From: posty@example.com\r\n")
+	}
+}
+
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []*Message
+	err  error
+}
+
+func (r *recordingMailer) Send(m *Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, m)
+	return r.err
+}
+
+func TestQueue(t *testing.T) {
+	assert := assert.New(t)
+	r := &recordingMailer{err: errors.New("failed")}
+	q := NewQueue(r, 2, 10)
+	for i := 0; i < 5; i++ {
+		assert.NoError(q.Send(&Message{To: "user@example.com"}))
+	}
+	q.Close()
+	assert.Len(r.sent, 5, "Failed emails must not block the queue")
+
+	full := &Queue{mailer: r, msgs: make(chan *Message, 1)}
+	assert.NoError(full.Send(&Message{}))
+	assert.Equal(ErrQueueFull, full.Send(&Message{}))
+}
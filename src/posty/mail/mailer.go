@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"bytes"
+	"mime"
+	"mime/quotedprintable"
+	"sort"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	// Headers are additional headers, e.g. List-Unsubscribe
+	Headers map[string]string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	// Send delivers the message from the address of the mailer.
+	Send(m *Message) error
+}
+
+// format encodes the message as RFC 5322 email sent from the address at the given time.
+// The subject is encoded as MIME encoded-word, the body as quoted-printable UTF-8 text.
+func format(from string, m *Message, now time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(k, v string) {
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+	header("From", from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		header(k, m.Headers[k])
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(m.Body))
+	w.Close()
+	return buf.Bytes()
+}
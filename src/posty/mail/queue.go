@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"errors"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrQueueFull is returned if a message was dropped because the queue is full.
+var ErrQueueFull = errors.New("Mail queue full")
+
+// Queue sends emails in the background using another mailer. Failed emails are logged and dropped.
+type Queue struct {
+	mailer Mailer
+	msgs   chan *Message
+	wg     sync.WaitGroup
+}
+
+// NewQueue starts n goroutines sending emails using the mailer. At most size emails are buffered.
+func NewQueue(mailer Mailer, n, size int) *Queue {
+	q := &Queue{
+		mailer: mailer,
+		msgs:   make(chan *Message, size),
+	}
+	for i := 0; i < n; i++ {
+		q.wg.Add(1)
+		go q.run()
+	}
+	return q
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+	for m := range q.msgs {
+		if err := q.mailer.Send(m); err != nil {
+			log.Warnf("Could not send email %q: %s", m.Subject, err)
+		}
+	}
+}
+
+// Send schedules sending the message. It does not block, ErrQueueFull is returned if the message was dropped.
+func (q *Queue) Send(m *Message) error {
+	select {
+	case q.msgs <- m:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting emails and waits until all queued emails are sent.
+func (q *Queue) Close() {
+	close(q.msgs)
+	q.wg.Wait()
+}
@@ -0,0 +1,24 @@
+package mail
+
+import (
+	"errors"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer delivers emails to a SMTP server, e.g. `smtp.example.com:587`.
+// If Auth is set, the server must support STARTTLS unless it runs on localhost.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Send delivers the message to the server.
+func (s *SMTPMailer) Send(m *Message) error {
+	if strings.ContainsAny(m.To, "\r\n") {
+		return errors.New("Invalid recipient")
+	}
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, format(s.From, m, time.Now()))
+}
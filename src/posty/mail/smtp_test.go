@@ -0,0 +1,94 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpStub accepts a single SMTP session and passes the envelope and data to the channel.
+func smtpStub(t *testing.T) (string, <-chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err)
+	}
+	received := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			conn.Write([]byte(s + "\r\n"))
+		}
+		var session []string
+		reply("220 localhost ESMTP stub")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				session = append(session, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var data []string
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data = append(data, l)
+				}
+				session = append(session, strings.Join(data, ""))
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				received <- session
+				return
+			default:
+				reply("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	assert := assert.New(t)
+	addr, received := smtpStub(t)
+	m := &SMTPMailer{Addr: addr, From: "posty@example.com"}
+	err := m.Send(&Message{
+		To:      "user@example.com",
+		Subject: "Grüße",
+		Body:    "Hello\nWorld",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	session := <-received
+	if assert.Len(session, 3) {
+		assert.Equal("MAIL FROM:<posty@example.com>", session[0])
+		assert.Equal("RCPT TO:<user@example.com>", session[1])
+		assert.Contains(session[2], "Subject: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n")
+		assert.Contains(session[2], "List-Unsubscribe: <https://example.com/unsubscribe>\r\n")
+		assert.Contains(session[2], "\r\n\r\nHello\r\nWorld")
+	}
+	assert.Error(m.Send(&Message{To: "user@example.com\r\nBcc: other@example.com"}), "Header injection must be rejected")
+}
@@ -0,0 +1,96 @@
+package mail
+
+import (
+	"bytes"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// MentionData is rendered into the email telling an user about a post mentioning them.
+type MentionData struct {
+	Username       string // recipient
+	Author         string
+	Message        string
+	WallURL        string
+	UnsubscribeURL string
+}
+
+// DigestPost is a post summarised by a digest.
+type DigestPost struct {
+	Author    string
+	Message   string
+	CreatedAt time.Time
+}
+
+// DigestData is rendered into the digest of new posts.
+type DigestData struct {
+	Username       string // recipient
+	Since          time.Time
+	Posts          []DigestPost
+	More           int // number of new posts not part of Posts
+	WallURL        string
+	UnsubscribeURL string
+}
+
+var templates = template.Must(template.New("mention").Parse(`Hi {{.Username}},
+
+{{.Author}} mentioned you:
+
+{{.Message}}
+
+Read it on posty: {{.WallURL}}
+
+--
+Stop receiving emails about mentions: {{.UnsubscribeURL}}
+`))
+
+func init() {
+	template.Must(templates.New("digest").Parse(`Hi {{.Username}},
+
+this is what happened on posty since {{.Since.Format "Mon, 02 Jan 2006 15:04 MST"}}:
+{{range .Posts}}
+{{.Author}} ({{.CreatedAt.Format "15:04"}}):
+{{.Message}}
+{{end}}{{if .More}}
+... and {{.More}} more posts.
+{{end}}
+Read them on posty: {{.WallURL}}
+
+--
+Stop receiving the daily digest: {{.UnsubscribeURL}}
+`))
+}
+
+// render executes the named template and returns the message to the recipient.
+// One-click unsubscribe headers (RFC 8058) are added for the unsubscribe url.
+func render(name, to, subject, unsubscribeURL string, data interface{}) (*Message, error) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return &Message{
+		To:      to,
+		Subject: subject,
+		Body:    buf.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// MentionMessage renders the email to the address telling the user about a post mentioning them.
+func MentionMessage(to string, d *MentionData) (*Message, error) {
+	return render("mention", to, d.Author+" mentioned you on posty", d.UnsubscribeURL, d)
+}
+
+// DigestMessage renders the digest email to the address.
+func DigestMessage(to string, d *DigestData) (*Message, error) {
+	n := len(d.Posts) + d.More
+	subject := strconv.Itoa(n) + " new posts on posty"
+	if n == 1 {
+		subject = "1 new post on posty"
+	}
+	return render("digest", to, subject, d.UnsubscribeURL, d)
+}
@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentionMessage(t *testing.T) {
+	assert := assert.New(t)
+	m, err := MentionMessage("user@example.com", &MentionData{
+		Username:       "Benedikt",
+		Author:         "Anna",
+		Message:        "Lunch @benedikt?",
+		WallURL:        "https://posty.example.com/",
+		UnsubscribeURL: "https://posty.example.com/unsubscribe?token=abc",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("user@example.com", m.To)
+	assert.Equal("Anna mentioned you on posty", m.Subject)
+	assert.Contains(m.Body, "Hi Benedikt,\n\nAnna mentioned you:\n\nLunch @benedikt?\n")
+	assert.Contains(m.Body, "Read it on posty: https://posty.example.com/\n")
+	assert.Equal("<https://posty.example.com/unsubscribe?token=abc>", m.Headers["List-Unsubscribe"])
+	assert.Equal("List-Unsubscribe=One-Click", m.Headers["List-Unsubscribe-Post"])
+}
+
+func TestDigestMessage(t *testing.T) {
+	assert := assert.New(t)
+	since := time.Date(2015, 11, 22, 10, 0, 0, 0, time.UTC)
+	m, err := DigestMessage("user@example.com", &DigestData{
+		Username: "Benedikt",
+		Since:    since,
+		Posts: []DigestPost{
+			{Author: "Anna", Message: "Hello <world>", CreatedAt: since.Add(time.Hour)},
+		},
+		More:           2,
+		WallURL:        "https://posty.example.com/",
+		UnsubscribeURL: "https://posty.example.com/unsubscribe?token=abc",
+	})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal("3 new posts on posty", m.Subject)
+	assert.Contains(m.Body, "since Sun, 22 Nov 2015 10:00 UTC")
+	assert.Contains(m.Body, "Anna (11:00):\nHello <world>\n")
+	assert.Contains(m.Body, "... and 2 more posts.\n\nRead them on posty: https://posty.example.com/\n")
+
+	m, _ = DigestMessage("user@example.com", &DigestData{Posts: []DigestPost{{}}})
+	assert.Equal("1 new post on posty", m.Subject)
+}
@@ -2,15 +2,32 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
 	filepath "path"
+	"posty/blob"
+	"posty/config"
 	"posty/controller"
+	"posty/dedup"
+	"posty/mail"
+	"posty/markdown"
 	"posty/middleware"
 	"posty/model"
 	"posty/model/awsdynamo"
 	"posty/oidc"
+	"posty/ratelimit"
+	"posty/search"
+	"posty/tagging"
+	"posty/token"
+	"posty/unfurl"
+	"posty/validation"
+	"posty/webhook"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -26,81 +43,401 @@ import (
 
 const envprefix = "POSTY_"
 
-func envOrDefault(env string, def string) string {
-	if ev := os.Getenv(envprefix + env); ev != "" {
-		return ev
-	}
-	return def
-}
-
-var (
-	listen                 = flag.String("http", envOrDefault("LISTEN", ":8080"), "Listen on")
-	frontendPath           = flag.String("frontend-path", envOrDefault("FRONTEND_PATH", "./frontend"), "Path to frontend")
-	dynamodbEndpoint       = flag.String("dynamodb-endpoint", envOrDefault("DYNAMODB_ENDPOINT", ""), "Dynamodb endpoint, leave blank in production, e.g. http://127.0.0.1:8000")
-	debug                  = flag.Bool("debug", false, "Enable debugging")
-	oidcGoogleClientID     = flag.String("oidc-google-client-id", envOrDefault("OIDC_GOOGLE_CLIENT_ID", ""), "Google OpenID Connect Client ID")
-	oidcGoogleClientSecret = flag.String("oidc-google-client-secret", envOrDefault("OIDC_GOOGLE_CLIENT_SECRET", ""), "Google OpenID Connect Client Secret")
-	oidcPaypalClientID     = flag.String("oidc-paypal-client-id", envOrDefault("OIDC_PAYPAL_CLIENT_ID", ""), "Paypal OpenID Connect Client ID")
-	oidcPaypalClientSecret = flag.String("oidc-paypal-client-secret", envOrDefault("OIDC_PAYPAL_CLIENT_SECRET", ""), "Paypal OpenID Connect Client Secret")
-	publicURL              = flag.String("public-url", envOrDefault("PUBLIC_URL", "http://127.0.0.1:8080"), "http://[host]")
-	sessionHashKey         = flag.String("session-hash-key", envOrDefault("SESSION_HASH_KEY", ""), "Session hash key, 32/64 Byte")
-	sessionBlockKey        = flag.String("session-block-key", envOrDefault("SESSION_BLOCK_KEY", ""), "Session block encryption key, valid lengths are 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256")
-)
+// splitList splits a comma separated list, empty entries are omitted.
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
 
-func checkFlags() bool {
-	flag.Parse()
-	if *listen == "" {
-		log.Fatal("Flag 'listen' must be set")
-		return false
+// parseLimit parses the rate limit of the flag.
+func parseLimit(name, value string) ratelimit.Limit {
+	l, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Invalid rate limit in flag '%s': %s", name, err)
 	}
-	if *frontendPath == "" {
-		log.Fatal("Flag 'frontend-path' must be set")
-		return false
+	return l
+}
+
+// checkConfig validates the configuration of the server and returns all problems found, including those of loading it.
+func checkConfig() []error {
+	errs := append([]error(nil), configErrs...)
+	if conf.Listen == "" {
+		errs = append(errs, errors.New("Flag 'listen' must be set"))
 	}
-	if *oidcGoogleClientID == "" {
-		log.Fatal("Flag 'oidc-google-client-id' must be set")
-		return false
+	if conf.FrontendPath == "" {
+		errs = append(errs, errors.New("Flag 'frontend-path' must be set"))
 	}
-	if *oidcGoogleClientSecret == "" {
-		log.Fatal("Flag 'oidc-google-client-secret' must be set")
-		return false
+	if conf.OIDCGoogleClientID == "" {
+		errs = append(errs, errors.New("Flag 'oidc-google-client-id' must be set"))
 	}
-	if *oidcPaypalClientID == "" {
-		log.Fatal("Flag 'oidc-paypal-client-id' must be set")
-		return false
+	if conf.OIDCGoogleClientSecret == "" {
+		errs = append(errs, errors.New("Flag 'oidc-google-client-secret' must be set"))
 	}
-	if *oidcPaypalClientSecret == "" {
-		log.Fatal("Flag 'oidc-paypal-client-secret' must be set")
-		return false
+	if conf.OIDCPaypalClientID == "" {
+		errs = append(errs, errors.New("Flag 'oidc-paypal-client-id' must be set"))
+	}
+	if conf.OIDCPaypalClientSecret == "" {
+		errs = append(errs, errors.New("Flag 'oidc-paypal-client-secret' must be set"))
+	}
+	if conf.PublicURL == "" {
+		errs = append(errs, errors.New("Flag 'oauth-redirect-url' must be set"))
+	}
+	if conf.PurgeInterval <= 0 {
+		errs = append(errs, errors.New("Flag 'purge-interval' must be positive"))
+	}
+	if conf.PublishInterval <= 0 {
+		errs = append(errs, errors.New("Flag 'publish-interval' must be positive"))
+	}
+	if conf.AttachmentsUploadTTL <= 0 {
+		errs = append(errs, errors.New("Flag 'attachments-upload-ttl' must be positive"))
+	}
+	if conf.AttachmentsMaxPixels <= 0 {
+		errs = append(errs, errors.New("Flag 'attachments-max-pixels' must be positive"))
+	}
+	if conf.UserCacheSize <= 0 {
+		errs = append(errs, errors.New("Flag 'user-cache-size' must be positive"))
+	}
+	if conf.DuplicateAction != "reject" && conf.DuplicateAction != "quarantine" {
+		errs = append(errs, errors.New("Flag 'duplicate-action' must be reject or quarantine"))
+	}
+	if conf.Mailer != "none" && conf.Mailer != "smtp" && conf.Mailer != "file" && conf.Mailer != "log" {
+		errs = append(errs, errors.New("Flag 'mailer' must be none, smtp, file or log"))
+	}
+	if conf.DigestInterval < 0 {
+		errs = append(errs, errors.New("Flag 'digest-interval' must not be negative"))
+	}
+	if conf.FeedLimit <= 0 {
+		errs = append(errs, errors.New("Flag 'feed-limit' must be positive"))
+	}
+	if conf.WebhookInterval <= 0 || conf.WebhookTimeout <= 0 || conf.WebhookMaxAttempts <= 0 {
+		errs = append(errs, errors.New("Flags 'webhook-interval', 'webhook-timeout' and 'webhook-max-attempts' must be positive"))
+	}
+	for _, f := range []struct{ name, value string }{
+		{"rate-limit-create", conf.RateLimitCreate},
+		{"rate-limit-create-ip", conf.RateLimitCreateIP},
+		{"rate-limit-delete", conf.RateLimitDelete},
+		{"rate-limit-delete-ip", conf.RateLimitDeleteIP},
+		{"rate-limit-incoming-webhook", conf.RateLimitIncoming},
+		{"rate-limit-incoming-webhook-ip", conf.RateLimitIncomingIP},
+		{"rate-limit-upload", conf.RateLimitUpload},
+		{"rate-limit-upload-ip", conf.RateLimitUploadIP},
+		{"rate-limit-login-ip", conf.RateLimitLoginIP},
+	} {
+		if _, err := ratelimit.ParseLimit(f.value); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid rate limit in flag '%s': %s", f.name, err))
+		}
 	}
-	if *publicURL == "" {
-		log.Fatal("Flag 'oauth-redirect-url' must be set")
+	return errs
+}
+
+// configWarnings returns the settings of the configuration which work but are unsuitable in production.
+func configWarnings() []string {
+	var warnings []string
+	if conf.SessionHashKey == "" && conf.Mailer != "none" {
+		warnings = append(warnings, "Flag 'session-hash-key' is not set, unsubscribe links of sent emails become invalid on restart")
+	}
+	if conf.SessionHashKey == "" {
+		warnings = append(warnings, "Flag 'session-hash-key' is not set, feed urls become invalid on restart")
+	}
+	return warnings
+}
+
+// checkFlags logs the problems of the configuration and generates the session keys if they are not set.
+func checkFlags() bool {
+	if errs := checkConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
 		return false
 	}
-	if *sessionHashKey == "" {
-		*sessionHashKey = string(securecookie.GenerateRandomKey(64))
+	for _, w := range configWarnings() {
+		log.Warn(w)
 	}
-	if *sessionBlockKey == "" {
-		*sessionBlockKey = string(securecookie.GenerateRandomKey(32))
+	if conf.SessionHashKey == "" {
+		conf.SessionHashKey = string(securecookie.GenerateRandomKey(64))
+	}
+	if conf.SessionBlockKey == "" {
+		conf.SessionBlockKey = string(securecookie.GenerateRandomKey(32))
 	}
 	return true
 }
 
 type postDataProvider struct {
 	model.PostPeer
-	UserPeer model.UserPeer
+	UserCache *model.UserCache
+}
+
+func (p *postDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *postDataProvider) GetFeedVersion(uid string) (int, error) {
+	u, err := p.UserCache.Peer.GetByID(uid)
+	if err != nil {
+		return 0, err
+	}
+	return u.FeedVersion, nil
+}
+
+func (p *postDataProvider) RotateFeedVersion(uid string) (int, error) {
+	version, err := p.UserCache.Peer.RotateFeedVersion(uid)
+	p.UserCache.Invalidate(uid)
+	return version, err
+}
+
+func (p *postDataProvider) GetUsersPage(after string, limit int) ([]*model.User, string, error) {
+	return p.UserCache.Peer.GetPage(after, limit)
+}
+
+func (p *postDataProvider) GetUsersByHandles(handles []string) (map[string]*model.User, error) {
+	users, err := p.UserCache.Peer.GetByHandles(handles)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]*model.User, len(users))
+	for _, u := range users {
+		res[u.ID] = u
+	}
+	return res, nil
+}
+
+type reportDataProvider struct {
+	Posts     model.PostPeer
+	Reports   model.ReportPeer
+	UserCache *model.UserCache
+}
+
+func (p *reportDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *reportDataProvider) GetPostByID(id string) (*model.Post, error) {
+	return p.Posts.GetByID(id)
+}
+
+func (p *reportDataProvider) SetPostHidden(post *model.Post, hidden bool) error {
+	return p.Posts.SetHidden(post, hidden)
+}
+
+func (p *reportDataProvider) GetReportByID(id string) (*model.Report, error) {
+	return p.Reports.GetByID(id)
+}
+
+func (p *reportDataProvider) GetReportsByPost(postID string) ([]*model.Report, error) {
+	return p.Reports.GetByPost(postID)
+}
+
+func (p *reportDataProvider) GetOpenReports() ([]*model.Report, error) {
+	return p.Reports.GetOpen()
+}
+
+func (p *reportDataProvider) NewReport(postID, uid string) *model.Report {
+	return p.Reports.NewReport(postID, uid)
+}
+
+func (p *reportDataProvider) SaveNewReport(r *model.Report) error {
+	return p.Reports.SaveNew(r)
+}
+
+func (p *reportDataProvider) UpdateReport(r *model.Report) error {
+	return p.Reports.Update(r)
+}
+
+func (p *reportDataProvider) NewAuditEntry(moderatorID, action, postID string) *model.AuditEntry {
+	return p.Reports.NewAuditEntry(moderatorID, action, postID)
+}
+
+func (p *reportDataProvider) SaveAuditEntry(e *model.AuditEntry) error {
+	return p.Reports.SaveAuditEntry(e)
+}
+
+func (p *reportDataProvider) GetAuditEntries() ([]*model.AuditEntry, error) {
+	return p.Reports.GetAuditEntries()
+}
+
+type conversationDataProvider struct {
+	Conversations model.ConversationPeer
+	UserCache     *model.UserCache
+}
+
+func (p *conversationDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *conversationDataProvider) OpenConversation(uid, other string) (*model.Conversation, bool, error) {
+	return p.Conversations.Open(uid, other)
+}
+
+func (p *conversationDataProvider) GetConversation(id, uid string) (*model.Conversation, error) {
+	return p.Conversations.Get(id, uid)
+}
+
+func (p *conversationDataProvider) GetConversations(uid string) ([]*model.Conversation, error) {
+	return p.Conversations.GetByUser(uid)
+}
+
+func (p *conversationDataProvider) NewMessage(c *model.Conversation, text string) *model.Message {
+	return p.Conversations.NewMessage(c, text)
+}
+
+func (p *conversationDataProvider) SaveMessage(c *model.Conversation, m *model.Message) error {
+	return p.Conversations.SaveMessage(c, m)
+}
+
+func (p *conversationDataProvider) GetMessages(conversationID string) ([]*model.Message, error) {
+	return p.Conversations.GetMessages(conversationID)
+}
+
+func (p *conversationDataProvider) MarkRead(c *model.Conversation) error {
+	return p.Conversations.MarkRead(c)
+}
+
+type notificationDataProvider struct {
+	Notifications model.NotificationPeer
+	UserCache     *model.UserCache
+}
+
+type webhookDataProvider struct {
+	Webhooks  model.WebhookPeer
+	UserCache *model.UserCache
+}
+
+func (p *webhookDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *webhookDataProvider) GetWebhooks() ([]*model.Webhook, error) {
+	return p.Webhooks.GetAll()
+}
+
+func (p *webhookDataProvider) GetWebhook(id string) (*model.Webhook, error) {
+	return p.Webhooks.GetByID(id)
+}
+
+func (p *webhookDataProvider) NewWebhook(url, secret string, events []string) *model.Webhook {
+	return p.Webhooks.NewWebhook(url, secret, events)
 }
 
-func (p *postDataProvider) GetUserByID(id string) (*model.User, error) {
-	return p.UserPeer.GetByID(id)
+func (p *webhookDataProvider) SaveNewWebhook(h *model.Webhook) error {
+	return p.Webhooks.SaveNew(h)
+}
+
+func (p *webhookDataProvider) RemoveWebhook(h *model.Webhook) error {
+	return p.Webhooks.Remove(h)
+}
+
+func (p *webhookDataProvider) GetDeliveries(webhookID string, limit int) ([]*model.Delivery, error) {
+	return p.Webhooks.GetDeliveries(webhookID, limit)
+}
+
+func (p *webhookDataProvider) GetDelivery(id string) (*model.Delivery, error) {
+	return p.Webhooks.GetDelivery(id)
+}
+
+type incomingWebhookDataProvider struct {
+	IncomingWebhooks model.IncomingWebhookPeer
+	UserCache        *model.UserCache
+}
+
+func (p *incomingWebhookDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *incomingWebhookDataProvider) GetIncomingWebhooks() ([]*model.IncomingWebhook, error) {
+	return p.IncomingWebhooks.GetAll()
+}
+
+func (p *incomingWebhookDataProvider) GetIncomingWebhook(id string) (*model.IncomingWebhook, error) {
+	return p.IncomingWebhooks.GetByID(id)
+}
+
+func (p *incomingWebhookDataProvider) NewIncomingWebhook(name, botID, tokenHash string) *model.IncomingWebhook {
+	return p.IncomingWebhooks.NewIncomingWebhook(name, botID, tokenHash)
+}
+
+func (p *incomingWebhookDataProvider) SaveNewIncomingWebhook(h *model.IncomingWebhook) error {
+	return p.IncomingWebhooks.SaveNew(h)
+}
+
+func (p *incomingWebhookDataProvider) RemoveIncomingWebhook(h *model.IncomingWebhook) error {
+	return p.IncomingWebhooks.Remove(h)
+}
+
+func (p *incomingWebhookDataProvider) NewUser() *model.User {
+	return p.UserCache.Peer.NewUser()
+}
+
+func (p *incomingWebhookDataProvider) SaveNewUser(u *model.User) error {
+	return p.UserCache.Peer.SaveNew(u)
+}
+
+func (p *notificationDataProvider) GetUsersByIDs(ids []string) (map[string]*model.User, error) {
+	return p.UserCache.GetByIDs(ids)
+}
+
+func (p *notificationDataProvider) GetNotifications(uid string, limit int) ([]*model.Notification, error) {
+	return p.Notifications.GetByUser(uid, limit)
+}
+
+func (p *notificationDataProvider) CountUnreadNotifications(uid string) (int, error) {
+	return p.Notifications.CountUnread(uid)
+}
+
+func (p *notificationDataProvider) MarkNotificationRead(n *model.Notification) error {
+	return p.Notifications.MarkRead(n)
+}
+
+func (p *notificationDataProvider) UpdateMutedNotifications(uid string, kinds []string) error {
+	if err := p.UserCache.Peer.UpdateMutedNotifications(uid, kinds); err != nil {
+		return err
+	}
+	p.UserCache.Invalidate(uid)
+	return nil
+}
+
+// newAWSSession returns the aws session of dynamodb.
+func newAWSSession() *session.Session {
+	cfg := &aws.Config{}
+	if conf.DynamodbEndpoint != "" {
+		cfg.Endpoint = aws.String(conf.DynamodbEndpoint)
+	}
+	sess := session.New(cfg)
+	if conf.Debug {
+		sess.Config.LogLevel = aws.LogLevel(aws.LogDebug)
+	}
+	return sess
 }
 
 func main() {
+	loader, err := config.New(conf, envprefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loader.Register(flag.CommandLine)
+	configFile := flag.String("config", os.Getenv(envprefix+"CONFIG"), "YAML or TOML file options are read from, environment variables and flags take precedence (env "+envprefix+"CONFIG)")
+	flag.Usage = usage
+	flag.Parse()
+	configLoader = loader
+	configErrs = loader.Load(flag.CommandLine, *configFile)
+	os.Exit(run(flag.Args()))
+}
+
+// runServe runs the serve subcommand, the server of the API and the frontend.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
 	if !checkFlags() {
-		os.Exit(1)
+		return 1
 	}
 
-	sessionStore := sessions.NewCookieStore([]byte(*sessionHashKey), []byte(*sessionBlockKey))
+	sessionStore := sessions.NewCookieStore([]byte(conf.SessionHashKey), []byte(conf.SessionBlockKey))
 
 	// OpenID Connect Providers
 
@@ -108,9 +445,9 @@ func main() {
 	oidcGoogleLoginRoute := "/logingoogle"
 	oidcGoogleCBRoute := "/gcallback"
 	oidcGoogle := &oidc.Google{
-		ClientID:     *oidcGoogleClientID,
-		ClientSecret: *oidcGoogleClientSecret,
-		RedirectURI:  *publicURL + oidcGoogleCBRoute,
+		ClientID:     conf.OIDCGoogleClientID,
+		ClientSecret: conf.OIDCGoogleClientSecret,
+		RedirectURI:  conf.PublicURL + oidcGoogleCBRoute,
 		SessionStore: sessionStore,
 	}
 
@@ -118,26 +455,36 @@ func main() {
 	oidcPaypalLoginRoute := "/loginpaypal"
 	oidcPaypalCBRoute := "/pcallback"
 	oidcPaypal := &oidc.Paypal{
-		ClientID:     *oidcPaypalClientID,
-		ClientSecret: *oidcPaypalClientSecret,
-		RedirectURI:  *publicURL + oidcPaypalCBRoute,
+		ClientID:     conf.OIDCPaypalClientID,
+		ClientSecret: conf.OIDCPaypalClientSecret,
+		RedirectURI:  conf.PublicURL + oidcPaypalCBRoute,
 		SessionStore: sessionStore,
 	}
 
 	// Dynamodb
-	cfg := &aws.Config{}
-	if *dynamodbEndpoint != "" {
-		cfg.Endpoint = aws.String(*dynamodbEndpoint)
-	}
-	sess := session.New(cfg)
-	if *debug {
-		sess.Config.LogLevel = aws.LogLevel(aws.LogDebug)
-	}
+	sess := newAWSSession()
 
 	// Model
 	var m model.Model
 	m = awsdynamo.NewModelFromSession(sess)
 
+	// Blob storage for attachments
+	var blobs blob.Store
+	if conf.AttachmentsS3Bucket != "" {
+		s3cfg := &aws.Config{}
+		if conf.S3Endpoint != "" {
+			s3cfg.Endpoint = aws.String(conf.S3Endpoint)
+			s3cfg.S3ForcePathStyle = aws.Bool(true)
+		}
+		blobs = blob.NewS3StoreFromSession(session.New(s3cfg), conf.AttachmentsS3Bucket)
+	} else {
+		fileStore, err := blob.NewFileStore(conf.AttachmentsDir)
+		if err != nil {
+			log.Fatalf("Could not create attachment directory: %s", err)
+		}
+		blobs = fileStore
+	}
+
 	// Controller
 	// OAuth / OpenID Connect
 	authCGoogle := controller.NewAuthController(m.UserPeer(), oidcGoogle, "google")
@@ -145,20 +492,163 @@ func main() {
 
 	// Post Controller
 	postContrData := &postDataProvider{
-		PostPeer: m.PostPeer(),
-		UserPeer: m.UserPeer(),
+		PostPeer:  m.PostPeer(),
+		UserCache: model.NewUserCache(m.UserPeer(), conf.UserCacheTTL, int(conf.UserCacheSize)),
 	}
 	postController := &controller.PostController{
+		Model:    postContrData,
+		Index:    search.NewInvertedIndex(),
+		Trending: tagging.NewTrending(conf.TrendingWindow),
+		Renderer: &markdown.Renderer{
+			ImageOrigins: splitList(conf.ImageOrigins),
+		},
+		Attachments: blobs,
+		MessageRules: &validation.Rules{
+			MinLength:   int(conf.MessageMinLength),
+			MaxLength:   int(conf.MessageMaxLength),
+			BannedWords: splitList(conf.BannedWords),
+		},
+		MaxBodySize:   conf.MaxBodySize,
+		RestoreWindow: conf.RestoreWindow,
+		MaxPinned:     int(conf.MaxPinned),
+		Votes:         m.VotePeer(),
+		Notifications: m.NotificationPeer(),
+		QuietPublish:  !conf.PublishAnnounce,
+	}
+	if conf.DuplicateMaxPerUser > 0 || conf.DuplicateMaxPerWall > 0 {
+		var fingerprints dedup.Store = dedup.NewMemoryStore()
+		if conf.DuplicateTable != "" {
+			fingerprints = dedup.NewDynamoStoreFromSession(sess, conf.DuplicateTable)
+		}
+		postController.Duplicates = &dedup.Checker{
+			Store:      fingerprints,
+			Window:     conf.DuplicateWindow,
+			MaxPerUser: int(conf.DuplicateMaxPerUser),
+			MaxPerWall: int(conf.DuplicateMaxPerWall),
+		}
+		postController.QuarantineDuplicates = conf.DuplicateAction == "quarantine"
+	}
+	if conf.LinkPreviews {
+		fetcher := unfurl.NewFetcher(unfurl.Options{
+			Timeout: conf.LinkPreviewTimeout,
+		})
+		postController.Previews = unfurl.NewWorker(fetcher, 4, 1000, savePreview(m.PostPeer()))
+	}
+	// posts published from now on are counted by publishScheduled
+	loaded := time.Now()
+	loadPosts(m.PostPeer(), postController.Index, postController.Trending)
+
+	// Emails
+	signer := token.NewSigner([]byte(conf.SessionHashKey), controller.UnsubscribeTokenPurpose)
+	if conf.Mailer != "none" {
+		mailer, digestMailer, err := newMailer()
+		if err != nil {
+			log.Fatalf("Could not create mailer: %s", err)
+		}
+		postController.Emails = &controller.Emailer{
+			Model:        postContrData,
+			Mailer:       mailer,
+			DigestMailer: digestMailer,
+			Signer:       signer,
+			Jobs:         m.JobPeer(),
+			BaseURL:      conf.PublicURL,
+		}
+		if conf.DigestInterval > 0 {
+			go sendDigests(postController.Emails, conf.DigestInterval)
+		}
+	}
+
+	// Attachment Controller
+	attachmentController := &controller.AttachmentController{
+		Store:         blobs,
+		MaxSize:       conf.AttachmentsMaxSize,
+		ContentTypes:  splitList(conf.AttachmentsTypes),
+		MaxPixels:     int(conf.AttachmentsMaxPixels),
+		UnattachedTTL: conf.AttachmentsUploadTTL,
+	}
+	go purgeDeleted(postController, attachmentController, m.PostPeer(), conf.PurgeInterval)
+
+	// User Controller
+	userController := &controller.UserController{
 		Model: postContrData,
 	}
 
+	// Report Controller
+	reportController := &controller.ReportController{
+		Model: &reportDataProvider{
+			Posts:     m.PostPeer(),
+			Reports:   m.ReportPeer(),
+			UserCache: postContrData.UserCache,
+		},
+		Posts:         postController,
+		HideThreshold: int(conf.ReportHideThreshold),
+	}
+
+	// Conversation Controller
+	conversationController := &controller.ConversationController{
+		Model: &conversationDataProvider{
+			Conversations: m.ConversationPeer(),
+			UserCache:     postContrData.UserCache,
+		},
+	}
+
+	// Feeds
+	feedController := &controller.FeedController{
+		Model:    postContrData,
+		Renderer: postController.Renderer,
+		Signer:   token.NewSigner([]byte(conf.SessionHashKey), controller.FeedTokenPurpose),
+		BaseURL:  conf.PublicURL,
+		Limit:    int(conf.FeedLimit),
+	}
+
+	// Webhooks
+	dispatcher := webhook.NewDispatcher(m.WebhookPeer(), webhook.Options{
+		Timeout:     conf.WebhookTimeout,
+		MaxAttempts: int(conf.WebhookMaxAttempts),
+	})
+	if conf.Webhooks {
+		postController.Webhooks = dispatcher
+		authCGoogle.Webhooks = dispatcher
+		authCPaypal.Webhooks = dispatcher
+		go dispatcher.Run(conf.WebhookInterval)
+	}
+	go publishScheduled(postController, loaded, conf.PublishInterval)
+	webhookController := &controller.WebhookController{
+		Model: &webhookDataProvider{
+			Webhooks:  m.WebhookPeer(),
+			UserCache: postContrData.UserCache,
+		},
+		Dispatcher: dispatcher,
+	}
+	incomingWebhookController := &controller.IncomingWebhookController{
+		Model: &incomingWebhookDataProvider{
+			IncomingWebhooks: m.IncomingWebhookPeer(),
+			UserCache:        postContrData.UserCache,
+		},
+		Posts:   postController,
+		BaseURL: conf.PublicURL,
+	}
+
+	// Notification Controller
+	notificationData := &notificationDataProvider{
+		Notifications: m.NotificationPeer(),
+		UserCache:     postContrData.UserCache,
+	}
+	notificationController := &controller.NotificationController{
+		Model: notificationData,
+	}
+	unsubscribeController := &controller.UnsubscribeController{
+		Model:  notificationData,
+		Signer: signer,
+	}
+
 	// Middleware
 	baseChain := xhandler.Chain{}
 	baseChain.UseC(xhandler.TimeoutHandler(2 * time.Second))
 
 	// Session management
 	sessionMiddleware := middleware.Session{}
-	sessionMiddleware.Init([]byte(*sessionHashKey), []byte(*sessionBlockKey))
+	sessionMiddleware.Init([]byte(conf.SessionHashKey), []byte(conf.SessionBlockKey))
 	baseChain.UseC(sessionMiddleware.Enable("posty-session"))
 
 	// Chain for authenticated routes
@@ -171,12 +661,39 @@ func main() {
 	jsonChain := xhandler.Chain{}
 	jsonChain = append(jsonChain, authedChain...)
 	jsonChain.UseC(middleware.JSONWrapper())
+	jsonChain.UseC(middleware.ContentNegotiation())
+
+	// Chain for authenticated uploads with json response
+	uploadChain := xhandler.Chain{}
+	uploadChain = append(uploadChain, authedChain...)
+	uploadChain.UseC(middleware.JSONWrapper())
 
 	// Chain for unauthenticated routes
 	unauthedChain := xhandler.Chain{}
 	unauthedChain = append(unauthedChain, baseChain...)
 	unauthedChain.UseC(middleware.UnauthenticatedFilter("/"))
 
+	// Rate limited chains
+	var limits ratelimit.Store = ratelimit.NewMemoryStore()
+	if conf.RateLimitTable != "" {
+		limits = ratelimit.NewDynamoStoreFromSession(sess, conf.RateLimitTable)
+	}
+	limited := func(chain xhandler.Chain, name string, perUser, perIP ratelimit.Limit) xhandler.Chain {
+		c := xhandler.Chain{}
+		c = append(c, chain...)
+		c.UseC(middleware.RateLimit(limits, name, perUser, perIP))
+		return c
+	}
+	createChain := limited(jsonChain, "create", parseLimit("rate-limit-create", conf.RateLimitCreate), parseLimit("rate-limit-create-ip", conf.RateLimitCreateIP))
+	deleteChain := limited(jsonChain, "delete", parseLimit("rate-limit-delete", conf.RateLimitDelete), parseLimit("rate-limit-delete-ip", conf.RateLimitDeleteIP))
+	reportChain := limited(jsonChain, "report", parseLimit("rate-limit-create", conf.RateLimitCreate), parseLimit("rate-limit-create-ip", conf.RateLimitCreateIP))
+	messageChain := limited(jsonChain, "message", parseLimit("rate-limit-create", conf.RateLimitCreate), parseLimit("rate-limit-create-ip", conf.RateLimitCreateIP))
+	hookChain := xhandler.Chain{}
+	hookChain = append(hookChain, baseChain...)
+	hookChain.UseC(middleware.RateLimitParam(limits, "hook", "id", parseLimit("rate-limit-incoming-webhook", conf.RateLimitIncoming), parseLimit("rate-limit-incoming-webhook-ip", conf.RateLimitIncomingIP)))
+	uploadLimitedChain := limited(uploadChain, "upload", parseLimit("rate-limit-upload", conf.RateLimitUpload), parseLimit("rate-limit-upload-ip", conf.RateLimitUploadIP))
+	loginChain := limited(unauthedChain, "login", ratelimit.Limit{}, parseLimit("rate-limit-login-ip", conf.RateLimitLoginIP))
+
 	// Main Context
 	ctx := context.Background()
 	route := func(chain xhandler.Chain, handler xhandler.HandlerC) web.Handler {
@@ -186,22 +703,200 @@ func main() {
 	// Routes
 	mux := web.New()
 	mux.Get("/api/posts", route(jsonChain, xhandler.HandlerFuncC(postController.Posts)))
-	mux.Post("/api/posts", route(jsonChain, xhandler.HandlerFuncC(postController.Create)))
-	mux.Delete("/api/posts/:id", route(jsonChain, xhandler.HandlerFuncC(postController.Remove)))
+	mux.Post("/api/posts", route(createChain, xhandler.HandlerFuncC(postController.Create)))
+	mux.Get("/api/posts/:id", route(jsonChain, xhandler.HandlerFuncC(postController.Post)))
+	mux.Delete("/api/posts/:id", route(deleteChain, xhandler.HandlerFuncC(postController.Remove)))
+	mux.Post("/api/posts/:id/restore", route(deleteChain, xhandler.HandlerFuncC(postController.Restore)))
+	mux.Post("/api/posts/:id/votes", route(jsonChain, xhandler.HandlerFuncC(postController.Vote)))
+	mux.Put("/api/posts/:id/pin", route(jsonChain, xhandler.HandlerFuncC(postController.Pin)))
+	mux.Delete("/api/posts/:id/pin", route(jsonChain, xhandler.HandlerFuncC(postController.Unpin)))
+	mux.Get("/api/tags/trending", route(jsonChain, xhandler.HandlerFuncC(postController.TrendingTags)))
+	mux.Get("/api/tags/:tag/posts", route(jsonChain, xhandler.HandlerFuncC(postController.TagPosts)))
+	mux.Get("/api/scheduled", route(jsonChain, xhandler.HandlerFuncC(postController.Scheduled)))
+	mux.Get("/api/mentions", route(jsonChain, xhandler.HandlerFuncC(postController.Mentions)))
+	mux.Post("/api/posts/:id/reports", route(reportChain, xhandler.HandlerFuncC(reportController.Create)))
+	mux.Get("/api/moderation/reports", route(jsonChain, xhandler.HandlerFuncC(reportController.Reports)))
+	mux.Post("/api/moderation/reports/:id/resolve", route(jsonChain, xhandler.HandlerFuncC(reportController.Resolve)))
+	mux.Post("/api/moderation/reports/:id/dismiss", route(jsonChain, xhandler.HandlerFuncC(reportController.Dismiss)))
+	mux.Get("/api/moderation/audit", route(jsonChain, xhandler.HandlerFuncC(reportController.Audit)))
+	mux.Get("/api/webhooks", route(jsonChain, xhandler.HandlerFuncC(webhookController.Webhooks)))
+	mux.Post("/api/webhooks", route(jsonChain, xhandler.HandlerFuncC(webhookController.Create)))
+	mux.Delete("/api/webhooks/:id", route(jsonChain, xhandler.HandlerFuncC(webhookController.Remove)))
+	mux.Get("/api/webhooks/:id/deliveries", route(jsonChain, xhandler.HandlerFuncC(webhookController.Deliveries)))
+	mux.Post("/api/webhooks/:id/deliveries/:delivery/redeliver", route(jsonChain, xhandler.HandlerFuncC(webhookController.Redeliver)))
+	mux.Get("/api/incoming-webhooks", route(jsonChain, xhandler.HandlerFuncC(incomingWebhookController.Webhooks)))
+	mux.Post("/api/incoming-webhooks", route(jsonChain, xhandler.HandlerFuncC(incomingWebhookController.Create)))
+	mux.Delete("/api/incoming-webhooks/:id", route(jsonChain, xhandler.HandlerFuncC(incomingWebhookController.Remove)))
+	mux.Post("/hooks/:id/:token", route(hookChain, xhandler.HandlerFuncC(incomingWebhookController.Post)))
+	mux.Get("/api/conversations", route(jsonChain, xhandler.HandlerFuncC(conversationController.Conversations)))
+	mux.Post("/api/conversations", route(jsonChain, xhandler.HandlerFuncC(conversationController.Open)))
+	mux.Get("/api/conversations/:id", route(jsonChain, xhandler.HandlerFuncC(conversationController.Conversation)))
+	mux.Get("/api/conversations/:id/messages", route(jsonChain, xhandler.HandlerFuncC(conversationController.Messages)))
+	mux.Post("/api/conversations/:id/messages", route(messageChain, xhandler.HandlerFuncC(conversationController.Send)))
+	mux.Get("/api/notifications", route(jsonChain, xhandler.HandlerFuncC(notificationController.Notifications)))
+	mux.Post("/api/notifications/read", route(jsonChain, xhandler.HandlerFuncC(notificationController.ReadAll)))
+	mux.Get("/api/notifications/preferences", route(jsonChain, xhandler.HandlerFuncC(notificationController.Preferences)))
+	mux.Patch("/api/notifications/preferences", route(jsonChain, xhandler.HandlerFuncC(notificationController.UpdatePreferences)))
+	mux.Post("/api/notifications/:id/read", route(jsonChain, xhandler.HandlerFuncC(notificationController.Read)))
+	mux.Get("/api/feeds", route(jsonChain, xhandler.HandlerFuncC(feedController.Feeds)))
+	mux.Post("/api/feeds/rotate", route(jsonChain, xhandler.HandlerFuncC(feedController.Rotate)))
+	mux.Get("/feeds/posts.atom", route(baseChain, xhandler.HandlerFuncC(feedController.Atom)))
+	mux.Get("/feeds/posts.rss", route(baseChain, xhandler.HandlerFuncC(feedController.RSS)))
+	mux.Get("/unsubscribe", route(baseChain, xhandler.HandlerFuncC(unsubscribeController.Unsubscribe)))
+	mux.Post("/unsubscribe", route(baseChain, xhandler.HandlerFuncC(unsubscribeController.Unsubscribe)))
+	mux.Get("/api/users/:id", route(jsonChain, xhandler.HandlerFuncC(userController.User)))
+	mux.Post("/api/attachments", route(uploadLimitedChain, xhandler.HandlerFuncC(attachmentController.Upload)))
+	mux.Get("/api/attachments/:id", route(authedChain, xhandler.HandlerFuncC(attachmentController.Attachment)))
+	mux.Get("/api/attachments/:id/thumbnail", route(authedChain, xhandler.HandlerFuncC(attachmentController.Thumbnail)))
 	// OIDC Routes
-	mux.Get(oidcGoogleLoginRoute, route(unauthedChain, authCGoogle.Login()))
-	mux.Get(oidcGoogleCBRoute, route(unauthedChain, authCGoogle.Callback("/")))
-	mux.Get(oidcPaypalLoginRoute, route(unauthedChain, authCPaypal.Login()))
-	mux.Get(oidcPaypalCBRoute, route(unauthedChain, authCPaypal.Callback("/")))
+	mux.Get(oidcGoogleLoginRoute, route(loginChain, authCGoogle.Login()))
+	mux.Get(oidcGoogleCBRoute, route(loginChain, authCGoogle.Callback("/")))
+	mux.Get(oidcPaypalLoginRoute, route(loginChain, authCPaypal.Login()))
+	mux.Get(oidcPaypalCBRoute, route(loginChain, authCPaypal.Callback("/")))
 	mux.Get("/logout", route(authedChain, authCGoogle.Logout("/login")))
 
 	// Static file
-	mux.Get("/login", route(unauthedChain, serveSingleFile(filepath.Join(*frontendPath, "login.html"))))
-	mux.Get("/", route(authedChain, serveSingleFile(filepath.Join(*frontendPath, "index.html"))))
-	mux.Get("/static/*", route(baseChain, serveFiles(filepath.Join(*frontendPath, "/static"), "/static/")))
+	mux.Get("/login", route(unauthedChain, serveSingleFile(filepath.Join(conf.FrontendPath, "login.html"))))
+	mux.Get("/", route(authedChain, serveSingleFile(filepath.Join(conf.FrontendPath, "index.html"))))
+	mux.Get("/static/*", route(baseChain, serveFiles(filepath.Join(conf.FrontendPath, "/static"), "/static/")))
 
-	log.Infof("Listening on %s", *listen)
-	log.Fatal(http.ListenAndServe(":8080", gctx.ClearHandler(mux)))
+	log.Infof("Listening on %s", conf.Listen)
+	log.Error(http.ListenAndServe(conf.Listen, gctx.ClearHandler(mux)))
+	return 1
+}
+
+// savePreview returns a function storing fetched link previews on the post.
+func savePreview(peer model.PostPeer) func(id string, pv *unfurl.Preview) {
+	return func(id string, pv *unfurl.Preview) {
+		post, err := peer.GetByID(id)
+		if err != nil {
+			log.Warnf("Could not load post %s to save preview: %s", id, err)
+			return
+		}
+		err = peer.SetPreview(post, &model.Preview{
+			URL:         pv.URL,
+			Title:       pv.Title,
+			Description: pv.Description,
+			ImageURL:    pv.ImageURL,
+		})
+		if err != nil {
+			log.Warnf("Could not save preview of post %s: %s", id, err)
+		}
+	}
+}
+
+// purgeDeleted permanently deletes removed posts whose restore window expired and uploads which were never attached every interval.
+// It also indexes the tags and mentions of posts which could not be indexed when they were saved.
+func purgeDeleted(c *controller.PostController, a *controller.AttachmentController, posts model.PostPeer, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		n, err := c.Purge(now)
+		if err != nil {
+			log.Warnf("Could not purge removed posts: %s", err)
+		} else if n > 0 {
+			log.Infof("Purged %d removed posts", n)
+		}
+		n, err = a.PurgeUnattached(now)
+		if err != nil {
+			log.Warnf("Could not purge unattached uploads: %s", err)
+		} else if n > 0 {
+			log.Infof("Purged %d unattached uploads", n)
+		}
+		n, err = posts.RepairTerms()
+		if err != nil {
+			log.Warnf("Could not index tags and mentions of posts: %s", err)
+		} else if n > 0 {
+			log.Infof("Indexed tags and mentions of %d posts", n)
+		}
+	}
+}
+
+// newMailer creates the mailer configured by the mailer flags and the mailer for digests.
+// SMTP deliveries are queued, digests are delivered directly as they are sent in the background anyway.
+func newMailer() (mail.Mailer, mail.Mailer, error) {
+	switch conf.Mailer {
+	case "smtp":
+		var auth smtp.Auth
+		if conf.MailSMTPUser != "" {
+			host, _, err := net.SplitHostPort(conf.MailSMTPAddr)
+			if err != nil {
+				return nil, nil, err
+			}
+			auth = smtp.PlainAuth("", conf.MailSMTPUser, conf.MailSMTPPassword, host)
+		}
+		smtpMailer := &mail.SMTPMailer{
+			Addr: conf.MailSMTPAddr,
+			From: conf.MailFrom,
+			Auth: auth,
+		}
+		return mail.NewQueue(smtpMailer, 2, 1000), smtpMailer, nil
+	case "file":
+		m, err := mail.NewFileMailer(conf.MailDir, conf.MailFrom)
+		if err != nil {
+			return nil, nil, err
+		}
+		return m, m, nil
+	default:
+		return mail.LogMailer{}, mail.LogMailer{}, nil
+	}
+}
+
+// sendDigests checks every minute, or every interval if it is shorter, whether the digests of the interval are due.
+// The run is recorded in the model, so the digests are sent by one of the instances once per interval, also across restarts.
+func sendDigests(e *controller.Emailer, interval time.Duration) {
+	check := time.Minute
+	if interval < check {
+		check = interval
+	}
+	for now := range time.Tick(check) {
+		n, err := e.SendDueDigests(interval, now)
+		if err != nil {
+			log.Warnf("Could not send digests: %s", err)
+			continue
+		}
+		if n > 0 {
+			log.Infof("Sent %d digests", n)
+		}
+	}
+}
+
+// publishScheduled handles the scheduled posts which were published since every interval.
+// Posts published before since were loaded already, due posts which were not announced yet are announced by Publish regardless.
+func publishScheduled(c *controller.PostController, since time.Time, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		n, err := c.Publish(since, now)
+		if err != nil {
+			log.Warnf("Could not publish scheduled posts: %s", err)
+			continue
+		}
+		since = now
+		if n > 0 {
+			log.Infof("Published %d scheduled posts", n)
+		}
+	}
+}
+
+// loadPosts adds all existing posts to the search index and records their tags for trending.
+func loadPosts(peer model.PostPeer, idx search.Index, trending *tagging.Trending) {
+	posts, err := peer.GetPosts()
+	if err != nil {
+		log.Fatalf("Could not load posts: %s", err)
+	}
+	for _, p := range posts {
+		idx.Add(p.ID, p.Message)
+		// Quarantined and hidden posts are not counted, like by the PostController
+		if !p.Quarantined && !p.Hidden {
+			trending.Record(p.Tags, p.PublishedAt())
+		}
+	}
+	// Scheduled posts are indexed in advance, so they can be found once they are published
+	scheduled, err := peer.QueryPosts(model.PostQuery{Scheduled: true})
+	if err != nil {
+		log.Fatalf("Could not load scheduled posts: %s", err)
+	}
+	for _, p := range scheduled {
+		idx.Add(p.ID, p.Message)
+	}
+	log.Infof("Loaded %d posts, %d scheduled", len(posts), len(scheduled))
 }
 
 // handler transformation xhandler.HandlerC -> web.Handler
@@ -0,0 +1,6 @@
+// Package markdown renders a subset of markdown to sanitised html.
+//
+// The renderer never passes through html from the source. It only emits an allow-listed set of tags:
+// paragraphs, line breaks, headings, emphasis, strikethrough, code, blockquotes, lists, horizontal rules, links and images.
+// Links are restricted to http, https and mailto and carry `rel="nofollow"`, images are only embedded from allowed origins.
+package markdown
@@ -0,0 +1,20 @@
+package markdown
+
+import "testing"
+
+// FuzzRender renders random input and fails if the rendered html is not sanitised.
+// Run it with `go test -fuzz FuzzRender posty/markdown`.
+func FuzzRender(f *testing.F) {
+	for _, seed := range []string{"**bold** *em* ~~del~~", "[a](https://example.com)", "![a](https://images.example.com/a.png)", "<http://example.com>", "> `code`\n- item"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		out := testRenderer.Render(src)
+		if err := testRenderer.validate(out); err != nil {
+			t.Fatalf("%q rendered to %q: %s", src, out, err)
+		}
+		if err := testRenderer.validate(RenderPlain(src)); err != nil {
+			t.Fatalf("%q: %s", src, err)
+		}
+	})
+}
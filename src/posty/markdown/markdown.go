@@ -0,0 +1,451 @@
+package markdown
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Renderer renders markdown to sanitised html.
+type Renderer struct {
+	// ImageOrigins lists the origins (`scheme://host[:port]`) images may be embedded from.
+	// Images from other origins are rendered as links.
+	ImageOrigins []string
+}
+
+var (
+	headingRe = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+	hrRe      = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	ulRe      = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	olRe      = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)][ \t]+(.*)$`)
+	quoteRe   = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	fenceRe   = regexp.MustCompile("^ {0,3}(```+|~~~+)")
+)
+
+// Render renders the markdown source to sanitised html.
+func (r *Renderer) Render(src string) string {
+	var b bytes.Buffer
+	lines := strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")
+	r.blocks(&b, lines, 0)
+	return b.String()
+}
+
+// RenderPlain renders plain text to html, preserving line breaks.
+func RenderPlain(src string) string {
+	var b bytes.Buffer
+	for _, para := range strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n\n") {
+		if strings.TrimSpace(para) == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.Replace(escape(strings.Trim(para, "\n")), "\n", "<br>", -1))
+		b.WriteString("</p>\n")
+	}
+	return b.String()
+}
+
+// maxDepth limits the nesting of blockquotes.
+const maxDepth = 8
+
+// blocks renders block level elements.
+func (r *Renderer) blocks(b *bytes.Buffer, lines []string, depth int) {
+	var para []string
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		b.WriteString("<p>")
+		for i, l := range para {
+			if i > 0 {
+				b.WriteString("<br>")
+			}
+			b.WriteString(r.inline(strings.TrimSpace(l), false, 0))
+		}
+		b.WriteString("</p>\n")
+		para = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case fenceRe.MatchString(line):
+			flush()
+			fence := fenceRe.FindStringSubmatch(line)[1]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(escape(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+		case headingRe.MatchString(line):
+			flush()
+			m := headingRe.FindStringSubmatch(line)
+			tag := "h" + strconv.Itoa(len(m[1]))
+			b.WriteString("<" + tag + ">")
+			b.WriteString(r.inline(m[2], false, 0))
+			b.WriteString("</" + tag + ">\n")
+		case hrRe.MatchString(line):
+			flush()
+			b.WriteString("<hr>\n")
+		case quoteRe.MatchString(line):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			i--
+			b.WriteString("<blockquote>\n")
+			if depth < maxDepth {
+				r.blocks(b, quoted, depth+1)
+			} else {
+				b.WriteString("<p>" + escape(strings.Join(quoted, " ")) + "</p>\n")
+			}
+			b.WriteString("</blockquote>\n")
+		case ulRe.MatchString(line):
+			flush()
+			b.WriteString("<ul>\n")
+			for ; i < len(lines) && ulRe.MatchString(lines[i]); i++ {
+				b.WriteString("<li>" + r.inline(ulRe.FindStringSubmatch(lines[i])[1], false, 0) + "</li>\n")
+			}
+			i--
+			b.WriteString("</ul>\n")
+		case olRe.MatchString(line):
+			flush()
+			b.WriteString("<ol>\n")
+			for ; i < len(lines) && olRe.MatchString(lines[i]); i++ {
+				b.WriteString("<li>" + r.inline(olRe.FindStringSubmatch(lines[i])[2], false, 0) + "</li>\n")
+			}
+			i--
+			b.WriteString("</ol>\n")
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+}
+
+// maxInlineDepth limits the nesting of emphasis and links, deeper text is rendered as is.
+const maxInlineDepth = 8
+
+// maxURLLength limits the length of bare urls, longer urls are rendered as text.
+const maxURLLength = 2048
+
+// inline renders inline elements. Links are not rendered inside of link texts.
+func (r *Renderer) inline(s string, inLink bool, depth int) string {
+	if depth > maxInlineDepth {
+		return escape(s)
+	}
+	p := &inlineParser{r: r, s: s, inLink: inLink, depth: depth, unclosed: map[string]bool{}}
+	p.match()
+	var b bytes.Buffer
+	text := 0
+	emit := func(i int, out string) {
+		b.WriteString(escape(s[text:i]))
+		b.WriteString(out)
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		var out string
+		n := 0
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			out, n = escape(s[i+1:i+2]), 2
+		case c == '`':
+			out, n = p.codeSpan(i)
+		case c == '!' && strings.HasPrefix(s[i:], "!["):
+			out, n = p.image(i)
+		case c == '[' && !inLink:
+			out, n = p.link(i)
+		case c == '*' || c == '_' || c == '~':
+			if c == '_' && i > 0 && isWordByte(s[i-1]) {
+				break
+			}
+			out, n = p.emphasis(i)
+		case c == '<' && !inLink:
+			out, n = p.angleLink(i)
+		case (c == 'h' || c == 'H') && !inLink && (i == 0 || !isWordByte(s[i-1])):
+			out, n = p.bareLink(i)
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		emit(i, out)
+		i += n
+		text = i
+	}
+	b.WriteString(escape(s[text:]))
+	return b.String()
+}
+
+// inlineParser holds the state of rendering the inline elements of a text.
+// The text is scanned from left to right, closing delimiters which were not found are remembered and brackets
+// are matched upfront, so unclosed markers do not rescan the rest of the text and rendering stays linear.
+type inlineParser struct {
+	r      *Renderer
+	s      string
+	inLink bool
+	depth  int
+	// brackets maps the positions of `[` to their matching `]`, parens the positions of `(` to their matching `)`.
+	brackets map[int]int
+	parens   map[int]int
+	// unclosed contains the emphasis and code delimiters which do not occur after the current position.
+	unclosed map[string]bool
+	// urlEnd is the end of the bare url found last.
+	urlEnd int
+}
+
+// match matches brackets and parentheses of the text. Escaped brackets are skipped, parentheses are not escapable.
+func (p *inlineParser) match() {
+	p.brackets, p.parens = map[int]int{}, map[int]int{}
+	var brackets, parens []int
+	for i := 0; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			i++
+		case '[':
+			brackets = append(brackets, i)
+		case ']':
+			if len(brackets) > 0 {
+				p.brackets[brackets[len(brackets)-1]] = i
+				brackets = brackets[:len(brackets)-1]
+			}
+		}
+	}
+	for i := 0; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '(':
+			parens = append(parens, i)
+		case ')':
+			if len(parens) > 0 {
+				p.parens[parens[len(parens)-1]] = i
+				parens = parens[:len(parens)-1]
+			}
+		}
+	}
+}
+
+// codeSpan renders a code span starting with a backtick run, the span is closed by a run of the same length.
+func (p *inlineParser) codeSpan(i int) (string, int) {
+	s := p.s[i:]
+	run := 0
+	for run < len(s) && s[run] == '`' {
+		run++
+	}
+	delim := s[:run]
+	end := -1
+	if !p.unclosed[delim] {
+		end = strings.Index(s[run:], delim)
+	}
+	if end < 0 {
+		p.unclosed[delim] = true
+		return escape(delim), run
+	}
+	code := strings.TrimSpace(s[run : run+end])
+	return "<code>" + escape(code) + "</code>", run + end + run
+}
+
+// emphasis renders `**strong**`, `__strong__`, `*em*`, `_em_` and `~~del~~`.
+func (p *inlineParser) emphasis(i int) (string, int) {
+	s := p.s[i:]
+	c := s[0]
+	double := len(s) > 1 && s[1] == c
+	if c == '~' && !double {
+		return "", 0
+	}
+	delim := s[:1]
+	tag := "em"
+	if double {
+		delim = s[:2]
+		tag = "strong"
+		if c == '~' {
+			tag = "del"
+		}
+	}
+	rest := s[len(delim):]
+	if rest == "" || rest[0] == ' ' || rest[0] == c || p.unclosed[delim] {
+		return "", 0
+	}
+	for off := 0; ; {
+		end := strings.Index(rest[off:], delim)
+		if end < 0 {
+			// no later opening delimiter finds a closing one either
+			p.unclosed[delim] = true
+			return "", 0
+		}
+		end += off
+		// a longer closing run closes nested emphasis first, this delimiter takes its end
+		runEnd := end
+		for runEnd < len(rest) && rest[runEnd] == c {
+			runEnd++
+		}
+		if end == 0 || rest[end-1] == ' ' || (c == '_' && runEnd < len(rest) && isWordByte(rest[runEnd])) {
+			off = runEnd
+			continue
+		}
+		end = runEnd - len(delim)
+		inner := p.r.inline(rest[:end], p.inLink, p.depth+1)
+		return "<" + tag + ">" + inner + "</" + tag + ">", len(delim) + end + len(delim)
+	}
+}
+
+// linkParts parses `[text](destination)` starting at the bracket at i and returns text, destination and the consumed length.
+func (p *inlineParser) linkParts(i int) (string, string, int) {
+	closeText, ok := p.brackets[i]
+	if !ok || closeText+1 >= len(p.s) || p.s[closeText+1] != '(' {
+		return "", "", 0
+	}
+	// destinations may contain balanced parentheses
+	end, ok := p.parens[closeText+1]
+	if !ok {
+		return "", "", 0
+	}
+	dest := strings.TrimSpace(p.s[closeText+2 : end])
+	if strings.ContainsAny(dest, " \t\n") {
+		return "", "", 0
+	}
+	return p.s[i+1 : closeText], dest, end + 1 - i
+}
+
+// link renders `[text](url)`. Links to disallowed destinations are rendered as their text.
+func (p *inlineParser) link(i int) (string, int) {
+	text, dest, n := p.linkParts(i)
+	if n == 0 {
+		return "", 0
+	}
+	inner := p.r.inline(text, true, p.depth+1)
+	href, ok := safeURL(dest)
+	if !ok {
+		return inner, n
+	}
+	return anchor(href, inner), n
+}
+
+// image renders `![alt](url)`. Images from origins which are not allowed are rendered as link to the image.
+func (p *inlineParser) image(i int) (string, int) {
+	alt, dest, n := p.linkParts(i + 1)
+	if n == 0 {
+		return "", 0
+	}
+	n++
+	src, ok := safeURL(dest)
+	if !ok {
+		return escape(alt), n
+	}
+	if p.r.AllowedImage(src) {
+		return `<img src="` + escape(src) + `" alt="` + escape(alt) + `">`, n
+	}
+	if p.inLink {
+		return escape(alt), n
+	}
+	return anchor(src, escape(alt)), n
+}
+
+// AllowedImage returns true if the image url is served from one of the ImageOrigins.
+func (r *Renderer) AllowedImage(src string) bool {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	origin := u.Scheme + "://" + u.Host
+	for _, o := range r.ImageOrigins {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// angleLink renders autolinks like `<http://example.com>`.
+func (p *inlineParser) angleLink(i int) (string, int) {
+	s := p.s[i:]
+	// the destination ends at the first character which may not be part of it
+	end := strings.IndexAny(s[1:], "> \t\n<") + 1
+	if end == 0 || s[end] != '>' {
+		return "", 0
+	}
+	dest := s[1:end]
+	href, ok := safeURL(dest)
+	if !ok || !strings.Contains(dest, ":") {
+		return "", 0
+	}
+	return anchor(href, escape(dest)), end + 1
+}
+
+// bareLink renders urls starting with http:// or https:// in the text. Trailing punctuation is not part of the url.
+func (p *inlineParser) bareLink(i int) (string, int) {
+	s := p.s[i:]
+	if !hasPrefixFold(s, "http://") && !hasPrefixFold(s, "https://") {
+		return "", 0
+	}
+	// urls following each other without separator end at the same position
+	if p.urlEnd <= i {
+		end := strings.IndexAny(s, " \t\n<>\"")
+		if end < 0 {
+			end = len(s)
+		}
+		for end > 0 && strings.IndexByte(".,:;!?)'*_~", s[end-1]) >= 0 {
+			end--
+		}
+		p.urlEnd = i + end
+	}
+	end := p.urlEnd - i
+	if end > maxURLLength {
+		return "", 0
+	}
+	dest := s[:end]
+	href, ok := safeURL(dest)
+	if !ok || strings.Index(dest, "://")+3 >= len(dest) {
+		return "", 0
+	}
+	return anchor(href, escape(dest)), end
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// safeURL checks that the url is absolute and uses an allowed scheme. It returns the normalised url.
+func safeURL(dest string) (string, bool) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		if u.Host == "" {
+			return "", false
+		}
+	case "mailto":
+		if u.Opaque == "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return u.String(), true
+}
+
+func anchor(href, inner string) string {
+	return `<a href="` + escape(href) + `" rel="nofollow">` + inner + `</a>`
+}
+
+func escape(s string) string {
+	return html.EscapeString(s)
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
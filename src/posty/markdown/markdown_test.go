@@ -0,0 +1,149 @@
+package markdown
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRenderer = &Renderer{ImageOrigins: []string{"https://images.example.com"}}
+
+func TestRender(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		src  string
+		html string
+	}{
+		{"hello world", "<p>hello world</p>\n"},
+		{"line one\nline two\n\nnext", "<p>line one<br>line two</p>\n<p>next</p>\n"},
+		{"# Title #\n###### small", "<h1>Title</h1>\n<h6>small</h6>\n"},
+		{"#go is no heading", "<p>#go is no heading</p>\n"},
+		{"**bold** *em* __bold__ _em_ ~~del~~", "<p><strong>bold</strong> <em>em</em> <strong>bold</strong> <em>em</em> <del>del</del></p>\n"},
+		{"**bold *nested***", "<p><strong>bold <em>nested</em></strong></p>\n"},
+		{"snake_case_name and 2 * 3 * 4", "<p>snake_case_name and 2 * 3 * 4</p>\n"},
+		{"`a < b` and ``x ` y``", "<p><code>a &lt; b</code> and <code>x ` y</code></p>\n"},
+		{"```\n<b>code</b>\n```", "<pre><code>&lt;b&gt;code&lt;/b&gt;</code></pre>\n"},
+		{"> quoted\n> **text**", "<blockquote>\n<p>quoted<br><strong>text</strong></p>\n</blockquote>\n"},
+		{"- one\n- two", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n"},
+		{"1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"---", "<hr>\n"},
+		{`\*not em\*`, "<p>*not em*</p>\n"},
+		{"[posty](https://example.com/a?b=1&c=2)", `<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow">posty</a></p>` + "\n"},
+		{"[mail](mailto:me@example.com)", `<p><a href="mailto:me@example.com" rel="nofollow">mail</a></p>` + "\n"},
+		{"see https://example.com/x.", `<p>see <a href="https://example.com/x" rel="nofollow">https://example.com/x</a>.</p>` + "\n"},
+		{"<http://example.com>", `<p><a href="http://example.com" rel="nofollow">http://example.com</a></p>` + "\n"},
+		{"![logo](https://images.example.com/logo.png)", `<p><img src="https://images.example.com/logo.png" alt="logo"></p>` + "\n"},
+		{"![logo](https://evil.example.com/logo.png)", `<p><a href="https://evil.example.com/logo.png" rel="nofollow">logo</a></p>` + "\n"},
+		{"[![logo](https://evil.example.com/l.png)](https://example.com)", `<p><a href="https://example.com" rel="nofollow">logo</a></p>` + "\n"},
+		{"[relative](/api/posts)", "<p>relative</p>\n"},
+		{"[wiki](https://example.com/Go_(language)) [js](javascript:alert(1))", `<p><a href="https://example.com/Go_(language)" rel="nofollow">wiki</a> js</p>` + "\n"},
+	}
+	for _, test := range tests {
+		out := testRenderer.Render(test.src)
+		assert.Equal(test.html, out, test.src)
+		assert.NoError(testRenderer.validate(out), test.src)
+	}
+}
+
+func TestRenderPlain(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("<p>a &lt;b&gt;<br>**c**</p>\n<p>d</p>\n", RenderPlain("a <b>\n**c**\n\n\nd"))
+	assert.Equal("", RenderPlain(" \n"))
+}
+
+// TestRenderXSS contains regression tests for known cross site scripting vectors.
+func TestRenderXSS(t *testing.T) {
+	assert := assert.New(t)
+	vectors := []string{
+		`<script>alert(1)</script>`,
+		`<img src=x onerror=alert(1)>`,
+		`[click](javascript:alert(1))`,
+		`[click](JaVaScRiPt:alert(1))`,
+		`[click](javascript&#58;alert(1))`,
+		`[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+		`[click](vbscript:msgbox(1))`,
+		`[click](//evil.example.com)`,
+		`[x](https://example.com/"onmouseover="alert(1))`,
+		`[x](https://example.com/'><script>alert(1)</script>)`,
+		`![x"onerror="alert(1)](https://images.example.com/a.png)`,
+		`![x](https://images.example.com/a.png"onerror="alert(1))`,
+		`![x](javascript:alert(1))`,
+		`![x](https://images.example.com.evil.com/a.png)`,
+		`![x](https://user@images.example.com@evil.com/a.png)`,
+		`<javascript:alert(1)>`,
+		`<https://example.com/"onclick="alert(1)>`,
+		`https://example.com/"><script>alert(1)</script>`,
+		"**<iframe src=https://evil.example.com>**",
+		"`<svg onload=alert(1)>`",
+		"```\n</code></pre><script>alert(1)</script>\n```",
+		"> <style>body{display:none}</style>",
+		"- <a href=\"javascript:alert(1)\">x</a>",
+		"# <h1 onclick=alert(1)>",
+		`[a](https://example.com)<!-- comment -->`,
+		"\x00<script>\x00",
+	}
+	for _, v := range vectors {
+		out := testRenderer.Render(v)
+		assert.NoError(testRenderer.validate(out), "%q rendered to %q", v, out)
+		assert.NoError(testRenderer.validate(RenderPlain(v)), v)
+		assert.NotContains(out, "<script", v)
+	}
+}
+
+// TestRenderRandom renders random markdown-like input and checks the output is sanitised.
+func TestRenderRandom(t *testing.T) {
+	const alphabet = "ab #*_~`[]()!<>\"'&\\\n:/.-1>@"
+	fragments := []string{"https://", "http://images.example.com/", "https://images.example.com/", "javascript:", "mailto:", "```", "> ", "- ", "1. ", "](", "![", "<script>", "onerror="}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		var src []byte
+		for n := rnd.Intn(40); n > 0; n-- {
+			if rnd.Intn(5) == 0 {
+				src = append(src, fragments[rnd.Intn(len(fragments))]...)
+			} else {
+				src = append(src, alphabet[rnd.Intn(len(alphabet))])
+			}
+		}
+		out := testRenderer.Render(string(src))
+		if err := testRenderer.validate(out); err != nil {
+			t.Fatalf("%q rendered to %q: %s", src, out, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(testRenderer.validate(`<p><a href="https://example.com" rel="nofollow">x</a></p>`))
+	assert.Error(testRenderer.validate(`<script>alert(1)</script>`))
+	assert.Error(testRenderer.validate(`<p onclick="alert(1)">x</p>`))
+	assert.Error(testRenderer.validate(`<a href="https://example.com">x</a>`))
+	assert.Error(testRenderer.validate(`<a href="javascript:alert(1)" rel="nofollow">x</a>`))
+	assert.Error(testRenderer.validate(`<img src="https://evil.example.com/a.png" alt="">`))
+	assert.Error(testRenderer.validate(`<!-- x -->`))
+}
+
+// TestRenderLinear renders unclosed markers which used to rescan the rest of the text for every marker.
+func TestRenderLinear(t *testing.T) {
+	assert := assert.New(t)
+	inputs := []string{
+		strings.Repeat("*a ", 20000),
+		strings.Repeat("**a ", 20000),
+		strings.Repeat("[", 20000),
+		strings.Repeat("[a](", 20000),
+		strings.Repeat("![a](", 20000),
+		strings.Repeat("`a``", 20000),
+		strings.Repeat("<a", 20000) + ">",
+		strings.Repeat("h ", 20000),
+		strings.Repeat("http://[a.", 20000),
+		strings.Repeat("*_", 20000) + "x" + strings.Repeat("_*", 20000),
+	}
+	for _, src := range inputs {
+		start := time.Now()
+		out := testRenderer.Render(src)
+		assert.True(time.Since(start) < time.Second, "rendering %q... took %s", src[:10], time.Since(start))
+		assert.NoError(testRenderer.validate(out))
+	}
+}
@@ -0,0 +1,85 @@
+package markdown
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags maps the tags emitted by the renderer to their allowed attributes.
+var allowedTags = map[string][]string{
+	"p": nil, "br": nil, "hr": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"strong": nil, "em": nil, "del": nil, "code": nil, "pre": nil,
+	"blockquote": nil, "ul": nil, "ol": nil, "li": nil,
+	"a":   {"href", "rel"},
+	"img": {"src", "alt"},
+}
+
+// validate checks that the rendered html only contains allow-listed tags and attributes,
+// links are nofollow with allowed schemes and images are only embedded from allowed origins.
+// It is used by the tests and the fuzz test.
+func (r *Renderer) validate(out string) error {
+	z := html.NewTokenizer(strings.NewReader(out))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		case html.CommentToken, html.DoctypeToken:
+			return fmt.Errorf("unexpected token %q", z.Token().String())
+		case html.TextToken:
+			if strings.ContainsAny(string(z.Raw()), "<>") {
+				return fmt.Errorf("unescaped text %q", z.Raw())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			t := z.Token()
+			attrs, ok := allowedTags[t.Data]
+			if !ok {
+				return fmt.Errorf("tag %q not allowed", t.Data)
+			}
+			if tt == html.EndTagToken {
+				continue
+			}
+			if err := r.validateAttrs(t, attrs); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Renderer) validateAttrs(t html.Token, allowed []string) error {
+	vals := make(map[string]string, len(t.Attr))
+	for _, a := range t.Attr {
+		found := false
+		for _, name := range allowed {
+			found = found || a.Key == name
+		}
+		if !found || a.Namespace != "" {
+			return fmt.Errorf("attribute %q not allowed on %q", a.Key, t.Data)
+		}
+		if _, dup := vals[a.Key]; dup {
+			return fmt.Errorf("duplicate attribute %q on %q", a.Key, t.Data)
+		}
+		vals[a.Key] = a.Val
+	}
+	switch t.Data {
+	case "a":
+		if _, ok := safeURL(vals["href"]); !ok {
+			return fmt.Errorf("unsafe link %q", vals["href"])
+		}
+		if vals["rel"] != "nofollow" {
+			return fmt.Errorf("link %q without nofollow", vals["href"])
+		}
+	case "img":
+		if !r.AllowedImage(vals["src"]) {
+			return fmt.Errorf("image from disallowed origin %q", vals["src"])
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"posty/jsonapi"
 
 	"github.com/rs/xhandler"
 	"golang.org/x/net/context"
@@ -11,8 +12,37 @@ import (
 func JSONWrapper() func(next xhandler.HandlerC) xhandler.HandlerC {
 	return func(next xhandler.HandlerC) xhandler.HandlerC {
 		return xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/vnd.api+json")
+			w.Header().Set("Content-Type", jsonapi.MediaType)
 			next.ServeHTTPC(ctx, w, r)
 		})
 	}
 }
+
+// ContentNegotiation enforces the JSON API content negotiation rules.
+// Requests sending a document with an invalid Content-Type are rejected with http.StatusUnsupportedMediaType,
+// requests only accepting the JSON API media type with media type parameters are rejected with http.StatusNotAcceptable.
+func ContentNegotiation() func(next xhandler.HandlerC) xhandler.HandlerC {
+	return func(next xhandler.HandlerC) xhandler.HandlerC {
+		return xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if hasBody(r) && !jsonapi.ValidContentType(r.Header.Get("Content-Type")) {
+				jsonapi.WriteErrors(w, http.StatusUnsupportedMediaType, &jsonapi.Error{
+					Detail: "Content-Type must be " + jsonapi.MediaType + " without media type parameters",
+				})
+				return
+			}
+			if !jsonapi.Acceptable(r.Header.Get("Accept")) {
+				jsonapi.WriteErrors(w, http.StatusNotAcceptable, &jsonapi.Error{
+					Detail: "Accept must allow " + jsonapi.MediaType + " without media type parameters",
+				})
+				return
+			}
+			next.ServeHTTPC(ctx, w, r)
+		})
+	}
+}
+
+// hasBody returns true for requests which transport a document. Requests without body, e.g. `POST .../restore`,
+// are sent by clients without Content-Type.
+func hasBody(r *http.Request) bool {
+	return r.ContentLength > 0 || len(r.TransferEncoding) > 0
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"strings"
+	"testing"
+
+	"github.com/rs/xhandler"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	assert := assert.New(t)
+	h := ContentNegotiation()(xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(method string, body io.Reader, header map[string]string) int {
+		r, _ := http.NewRequest(method, "http://posts", body)
+		if _, ok := body.(io.ReadCloser); ok {
+			// the server receives bodies of unknown length chunked
+			r.ContentLength, r.TransferEncoding = -1, []string{"chunked"}
+		}
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTPC(context.Background(), w, r)
+		return w.Code
+	}
+	doc := `{"data":{"type":"posts","attributes":{"message":"hello"}}}`
+
+	assert.Equal(http.StatusNoContent, serve("POST", strings.NewReader(doc), map[string]string{"Content-Type": jsonapi.MediaType}))
+	assert.Equal(http.StatusUnsupportedMediaType, serve("POST", strings.NewReader(doc), nil), "Documents must have the JSON API media type")
+	assert.Equal(http.StatusUnsupportedMediaType, serve("PATCH", strings.NewReader(doc), map[string]string{"Content-Type": "application/json"}))
+	assert.Equal(http.StatusUnsupportedMediaType, serve("POST", strings.NewReader(doc), map[string]string{"Content-Type": jsonapi.MediaType + "; charset=utf-8"}))
+	assert.Equal(http.StatusUnsupportedMediaType, serve("POST", ioutil.NopCloser(strings.NewReader(doc)), nil), "Documents of unknown length are checked")
+	for _, method := range []string{"POST", "PUT", "DELETE", "GET"} {
+		assert.Equal(http.StatusNoContent, serve(method, nil, nil), "Requests without body need no Content-Type: %s", method)
+	}
+
+	assert.Equal(http.StatusNoContent, serve("GET", nil, map[string]string{"Accept": "application/json"}))
+	assert.Equal(http.StatusNotAcceptable, serve("GET", nil, map[string]string{"Accept": jsonapi.MediaType + "; ext=bulk"}))
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"posty/jsonapi"
+	"posty/ratelimit"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xhandler"
+	"golang.org/x/net/context"
+)
+
+// RateLimit limits the requests of every logged in user to perUser and of every client ip to perIP.
+// The buckets are kept by the store, name separates the buckets of different routes.
+// Rejected requests get a JSON API error with status http.StatusTooManyRequests and a Retry-After header.
+// If the store fails the request is let through.
+func RateLimit(store ratelimit.Store, name string, perUser, perIP ratelimit.Limit) func(next xhandler.HandlerC) xhandler.HandlerC {
+	return func(next xhandler.HandlerC) xhandler.HandlerC {
+		return xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if user, ok := ctx.Value("user").(string); ok && user != "" {
+				if !allow(store, w, name+":user:"+user, perUser) {
+					return
+				}
+			}
+			if !allow(store, w, name+":ip:"+clientIP(r), perIP) {
+				return
+			}
+			next.ServeHTTPC(ctx, w, r)
+		})
+	}
+}
+
+// RateLimitParam limits the requests for every value of the url parameter to perParam and of every client ip to perIP,
+// e.g. the requests to every incoming webhook. Buckets and rejected requests are handled as by RateLimit.
+func RateLimitParam(store ratelimit.Store, name, param string, perParam, perIP ratelimit.Limit) func(next xhandler.HandlerC) xhandler.HandlerC {
+	return func(next xhandler.HandlerC) xhandler.HandlerC {
+		return xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+			if params, ok := ctx.Value("urlparams").(map[string]string); ok && params[param] != "" {
+				if !allow(store, w, name+":"+param+":"+params[param], perParam) {
+					return
+				}
+			}
+			if !allow(store, w, name+":ip:"+clientIP(r), perIP) {
+				return
+			}
+			next.ServeHTTPC(ctx, w, r)
+		})
+	}
+}
+
+// allow takes a token of the bucket and writes the error response if it is empty.
+func allow(store ratelimit.Store, w http.ResponseWriter, key string, l ratelimit.Limit) bool {
+	ok, retry, err := store.Take(key, l)
+	if err != nil {
+		log.Warnf("Could not check rate limit %s: %s", key, err)
+		return true
+	}
+	if ok {
+		return true
+	}
+	secs := int64((retry + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	jsonapi.WriteErrors(w, http.StatusTooManyRequests, &jsonapi.Error{
+		Code:   "rate_limited",
+		Title:  "Too many requests",
+		Detail: "Rate limit of " + l.String() + " exceeded, retry in " + strconv.FormatInt(secs, 10) + " seconds",
+	})
+	return false
+}
+
+// clientIP returns the ip address of the client connection.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"posty/jsonapi"
+	"posty/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/rs/xhandler"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	store := ratelimit.NewMemoryStore()
+	perUser := ratelimit.Limit{Burst: 1, Period: time.Minute}
+	perIP := ratelimit.Limit{Burst: 2, Period: time.Minute}
+	h := RateLimit(store, "create", perUser, perIP)(xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(user, addr string) *httptest.ResponseRecorder {
+		ctx := context.Background()
+		if user != "" {
+			ctx = context.WithValue(ctx, "user", user)
+		}
+		r, _ := http.NewRequest("POST", "http://create", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTPC(ctx, w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusNoContent, serve("uid1", "10.0.0.1:1234").Code)
+	w := serve("uid1", "10.0.0.1:1234")
+	assert.Equal(http.StatusTooManyRequests, w.Code, "User limit must be enforced")
+	assert.Equal("60", w.Header().Get("Retry-After"))
+	assert.Contains(w.Body.String(), `"code":"rate_limited"`)
+	assert.NoError(jsonapi.Validate(w.Body.Bytes()), "Invalid JSON API document")
+
+	assert.Equal(http.StatusNoContent, serve("uid2", "10.0.0.1:4321").Code)
+	assert.Equal(http.StatusTooManyRequests, serve("uid3", "10.0.0.1:1234").Code, "IP limit must be enforced")
+	assert.Equal(http.StatusNoContent, serve("", "10.0.0.2:1234").Code)
+}
+
+func TestRateLimitParam(t *testing.T) {
+	assert := assert.New(t)
+	store := ratelimit.NewMemoryStore()
+	perHook := ratelimit.Limit{Burst: 1, Period: time.Minute}
+	perIP := ratelimit.Limit{Burst: 2, Period: time.Minute}
+	h := RateLimitParam(store, "hook", "id", perHook, perIP)(xhandler.HandlerFuncC(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(id, addr string) *httptest.ResponseRecorder {
+		ctx := context.WithValue(context.Background(), "urlparams", map[string]string{"id": id})
+		r, _ := http.NewRequest("POST", "http://hooks", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		h.ServeHTTPC(ctx, w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusNoContent, serve("hook1", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusTooManyRequests, serve("hook1", "10.0.0.2:1234").Code, "Parameter limit must be enforced")
+	assert.Equal(http.StatusNoContent, serve("hook2", "10.0.0.1:1234").Code)
+	assert.Equal(http.StatusTooManyRequests, serve("hook3", "10.0.0.1:1234").Code, "IP limit must be enforced")
+}
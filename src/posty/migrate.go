@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"posty/model/awsdynamo"
+	"time"
+)
+
+// runMigrate runs the migrate subcommand with its arguments and returns the exit code.
+//
+//	posty migrate [-read-capacity n] [-write-capacity n] [-timeout d]
+//
+// Missing tables and indexes are created, including the tables of -rate-limit-table and -duplicate-table,
+// and the pending migrations are applied. Running it again has no effect.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	readCapacity := fs.Int64("read-capacity", 1, "Read capacity units of new tables and indexes")
+	writeCapacity := fs.Int64("write-capacity", 1, "Write capacity units of new tables and indexes")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Maximum time to wait for a table to become active")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !configLoaded() {
+		return 1
+	}
+	if *readCapacity < 1 || *writeCapacity < 1 {
+		fmt.Fprintln(os.Stderr, "Capacity units must be positive")
+		return 2
+	}
+	m := awsdynamo.NewMigratorFromSession(newAWSSession())
+	m.ReadCapacity, m.WriteCapacity, m.Timeout = *readCapacity, *writeCapacity, *timeout
+	for _, table := range []string{conf.RateLimitTable, conf.DuplicateTable} {
+		if table != "" {
+			m.Tables = append(m.Tables, &awsdynamo.Table{Name: table, Hash: "key", HashType: "S"})
+		}
+	}
+	from, to, err := m.Migrate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate failed at schema version %d: %s\n", to, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Schema version %d, was %d\n", to, from)
+	return 0
+}
@@ -0,0 +1,51 @@
+package awsdynamo
+
+import (
+	"posty/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// attachmentKey returns the primary key of the claim of an attachment.
+func attachmentKey(id string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id": {S: aws.String(id)},
+	}
+}
+
+// ClaimAttachment attaches the uploaded attachment to the post.
+// The claim is a conditional update, so of concurrent posts referencing the same attachment only one succeeds,
+// the others get model.ErrAttachmentClaimed.
+func (pp *DynamoPostPeer) ClaimAttachment(id, postID string) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("attachment"),
+		Key:                 attachmentKey(id),
+		UpdateExpression:    aws.String("SET post_id = :pid"),
+		ConditionExpression: aws.String("attribute_not_exists(post_id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {S: aws.String(postID)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAttachmentClaimed
+	}
+	return err
+}
+
+// ReleaseAttachment removes the claim of the attachment if it was claimed by the post.
+func (pp *DynamoPostPeer) ReleaseAttachment(id, postID string) error {
+	_, err := pp.model.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:           aws.String("attachment"),
+		Key:                 attachmentKey(id),
+		ConditionExpression: aws.String("post_id = :pid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {S: aws.String(postID)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,303 @@
+package awsdynamo
+
+import (
+	"errors"
+	"posty/model"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	uuid "github.com/satori/go.uuid"
+)
+
+var clog *logrus.Entry
+
+func init() {
+	clog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoConversationPeer",
+	})
+}
+
+// DynamoConversationPeer defines interaction with conversations and messages backed by dynamodb.
+//
+// Every participant has an entry in the table `conversation` with the hash key `uid` and the range key `conversation_id`,
+// which holds the unread counter of the participant.
+// Messages are stored in the table `message` with the hash key `conversation_id` and the range key `created_at`.
+type DynamoConversationPeer struct {
+	model *DynamoModel
+}
+
+// Open returns the conversation between the users as seen by uid, it is created for both users if it does not exist yet.
+func (cp *DynamoConversationPeer) Open(uid, other string) (*model.Conversation, bool, error) {
+	now := time.Now()
+	id := model.ConversationID(uid, other)
+	c := &model.Conversation{
+		ID:        id,
+		UID:       uid,
+		Other:     other,
+		CreatedAt: now,
+	}
+	created := false
+	for i, view := range []*model.Conversation{c, {ID: id, UID: other, Other: uid, CreatedAt: now}} {
+		items := make(map[string]*dynamodb.AttributeValue)
+		if err := marshalConversation(view, items); err != nil {
+			return nil, false, err
+		}
+		_, err := cp.model.db.PutItem(&dynamodb.PutItemInput{
+			Item:                items,
+			TableName:           aws.String("conversation"),
+			ConditionExpression: aws.String("attribute_not_exists(uid)"),
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if i == 0 {
+			created = true
+		}
+	}
+	if !created {
+		existing, err := cp.Get(id, uid)
+		return existing, false, err
+	}
+	return c, true, nil
+}
+
+// Get fetches the conversation identified by the id as seen by uid.
+// If uid does not participate in the conversation model.ErrNotParticipant is returned.
+func (cp *DynamoConversationPeer) Get(id, uid string) (*model.Conversation, error) {
+	resp, err := cp.model.db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String("conversation"),
+		Key:            conversationKey(id, uid),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, model.ErrNotParticipant
+	}
+	c := &model.Conversation{}
+	if err := unmarshalConversation(c, resp.Item); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetByUser returns all conversations of the user, the most recently active first.
+func (cp *DynamoConversationPeer) GetByUser(uid string) ([]*model.Conversation, error) {
+	items, err := cp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("conversation"),
+		KeyConditionExpression: aws.String("uid = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {
+				S: aws.String(uid),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cs := make([]*model.Conversation, 0, len(items))
+	for _, item := range items {
+		c := &model.Conversation{}
+		if err := unmarshalConversation(c, item); err != nil {
+			clog.Warnf("Error unmarshal conversation: %#v", item)
+			continue
+		}
+		cs = append(cs, c)
+	}
+	sort.Sort(model.ByLastMessageAtDESC(cs))
+	return cs, nil
+}
+
+// NewMessage creates a new message of the viewing participant to the conversation. The message is not inserted into the database until it is saved.
+func (cp *DynamoConversationPeer) NewMessage(c *model.Conversation, text string) *model.Message {
+	return &model.Message{
+		ID:             uuid.NewV4().String(),
+		ConversationID: c.ID,
+		UID:            c.UID,
+		Text:           text,
+		CreatedAt:      time.Now(),
+	}
+}
+
+// SaveMessage appends the message to the conversation and increments the unread counter of the other participant.
+func (cp *DynamoConversationPeer) SaveMessage(c *model.Conversation, m *model.Message) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalMessage(m, items); err != nil {
+		return err
+	}
+	_, err := cp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("message"),
+		ConditionExpression: aws.String("attribute_not_exists(created_at)"),
+	})
+	if err != nil {
+		return err
+	}
+	ts := nanoAttribute(m.CreatedAt)
+	_, err = cp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String("conversation"),
+		Key:              conversationKey(c.ID, c.UID),
+		UpdateExpression: aws.String("SET last_message_at = :ts"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":ts": ts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	c.LastMessageAt = m.CreatedAt
+	_, err = cp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String("conversation"),
+		Key:              conversationKey(c.ID, c.Other),
+		UpdateExpression: aws.String("SET last_message_at = :ts ADD unread :one"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":ts":  ts,
+			":one": {N: aws.String("1")},
+		},
+	})
+	return err
+}
+
+// GetMessages returns all messages of the conversation, oldest first.
+func (cp *DynamoConversationPeer) GetMessages(conversationID string) ([]*model.Message, error) {
+	items, err := cp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("message"),
+		KeyConditionExpression: aws.String("conversation_id = :cid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":cid": {
+				S: aws.String(conversationID),
+			},
+		},
+		ScanIndexForward: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ms := make([]*model.Message, 0, len(items))
+	for _, item := range items {
+		m := &model.Message{}
+		if err := unmarshalMessage(m, item); err != nil {
+			clog.Warnf("Error unmarshal message: %#v", item)
+			continue
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// MarkRead resets the unread counter of the viewing participant. The counter is only reset if the last message is still
+// the one of the fetched conversation, so messages arriving in the meantime are not marked as read without being seen.
+// ErrConversationChanged is returned otherwise.
+func (cp *DynamoConversationPeer) MarkRead(c *model.Conversation) error {
+	_, err := cp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("conversation"),
+		Key:                 conversationKey(c.ID, c.UID),
+		UpdateExpression:    aws.String("SET unread = :zero"),
+		ConditionExpression: aws.String("attribute_exists(uid) AND last_message_at = :last"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":zero": {N: aws.String("0")},
+			":last": nanoAttribute(c.LastMessageAt),
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrConversationChanged
+	}
+	if err != nil {
+		return err
+	}
+	c.Unread = 0
+	return nil
+}
+
+// conversationKey returns the primary key of the conversation entry of the participant.
+func conversationKey(id, uid string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"uid":             {S: aws.String(uid)},
+		"conversation_id": {S: aws.String(id)},
+	}
+}
+
+// marshalConversation builds an aws AttributeValue data structure for the given conversation entry.
+func marshalConversation(c *model.Conversation, items map[string]*dynamodb.AttributeValue) error {
+	if c == nil {
+		return errors.New("Undefined conversation")
+	}
+	items["uid"] = &dynamodb.AttributeValue{S: aws.String(c.UID)}
+	items["conversation_id"] = &dynamodb.AttributeValue{S: aws.String(c.ID)}
+	items["other"] = &dynamodb.AttributeValue{S: aws.String(c.Other)}
+	items["unread"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(c.Unread))}
+	items["created_at"] = nanoAttribute(c.CreatedAt)
+	if !c.LastMessageAt.IsZero() {
+		items["last_message_at"] = nanoAttribute(c.LastMessageAt)
+	}
+	return nil
+}
+
+// unmarshalConversation unmarshals a conversation entry from the aws datastructure to `model.Conversation`.
+func unmarshalConversation(c *model.Conversation, items map[string]*dynamodb.AttributeValue) error {
+	if c == nil {
+		return errors.New("Undefined conversation")
+	}
+	if items["uid"] == nil || items["conversation_id"] == nil {
+		return errors.New("Missing key attributes")
+	}
+	c.UID = aws.StringValue(items["uid"].S)
+	c.ID = aws.StringValue(items["conversation_id"].S)
+	if v, ok := items["other"]; ok {
+		c.Other = aws.StringValue(v.S)
+	}
+	if v, ok := items["unread"]; ok && v.N != nil {
+		unread, err := strconv.Atoi(*v.N)
+		if err != nil {
+			return err
+		}
+		c.Unread = unread
+	}
+	c.CreatedAt = nanoValue(items["created_at"])
+	c.LastMessageAt = nanoValue(items["last_message_at"])
+	return nil
+}
+
+// marshalMessage builds an aws AttributeValue data structure for the given message.
+func marshalMessage(m *model.Message, items map[string]*dynamodb.AttributeValue) error {
+	if m == nil {
+		return errors.New("Undefined message")
+	}
+	items["conversation_id"] = &dynamodb.AttributeValue{S: aws.String(m.ConversationID)}
+	items["created_at"] = nanoAttribute(m.CreatedAt)
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(m.ID)}
+	items["uid"] = &dynamodb.AttributeValue{S: aws.String(m.UID)}
+	items["text"] = &dynamodb.AttributeValue{S: aws.String(m.Text)}
+	return nil
+}
+
+// unmarshalMessage unmarshals a message from the aws datastructure to `model.Message`.
+func unmarshalMessage(m *model.Message, items map[string]*dynamodb.AttributeValue) error {
+	if m == nil {
+		return errors.New("Undefined message")
+	}
+	if items["conversation_id"] == nil || items["created_at"] == nil {
+		return errors.New("Missing key attributes")
+	}
+	m.ConversationID = aws.StringValue(items["conversation_id"].S)
+	m.CreatedAt = nanoValue(items["created_at"])
+	if v, ok := items["id"]; ok {
+		m.ID = aws.StringValue(v.S)
+	}
+	if v, ok := items["uid"]; ok {
+		m.UID = aws.StringValue(v.S)
+	}
+	if v, ok := items["text"]; ok {
+		m.Text = aws.StringValue(v.S)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalConversation(t *testing.T) {
+	assert := assert.New(t)
+	c := &model.Conversation{
+		ID:            "uid123:uid456",
+		UID:           "uid123",
+		Other:         "uid456",
+		Unread:        3,
+		LastMessageAt: time.Unix(1448272067, 123),
+		CreatedAt:     time.Unix(1448272000, 456),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalConversation(c, m); err != nil {
+		t.Fatalf("Error marshalling conversation: %s", err)
+	}
+	assert.Equal("3", *m["unread"].N)
+	var u model.Conversation
+	if err := unmarshalConversation(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling conversation: %s", err)
+	}
+	assert.Equal(c, &u)
+
+	m = make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalConversation(&model.Conversation{ID: "uid123:uid456", UID: "uid123"}, m))
+	_, ok := m["last_message_at"]
+	assert.False(ok, "Conversations without messages must omit the attribute")
+	assert.Error(unmarshalConversation(&u, map[string]*dynamodb.AttributeValue{}))
+}
+
+func TestMarshalMessage(t *testing.T) {
+	assert := assert.New(t)
+	msg := &model.Message{
+		ID:             "mid123",
+		ConversationID: "uid123:uid456",
+		UID:            "uid123",
+		Text:           "hello",
+		CreatedAt:      time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalMessage(msg, m); err != nil {
+		t.Fatalf("Error marshalling message: %s", err)
+	}
+	assert.Equal("1448272067000000123", *m["created_at"].N)
+	var u model.Message
+	if err := unmarshalMessage(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling message: %s", err)
+	}
+	assert.Equal(msg, &u)
+	assert.Error(unmarshalMessage(&u, map[string]*dynamodb.AttributeValue{}))
+}
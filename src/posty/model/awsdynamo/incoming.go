@@ -0,0 +1,155 @@
+package awsdynamo
+
+import (
+	"errors"
+	"fmt"
+	"posty/model"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	uuid "github.com/satori/go.uuid"
+)
+
+var ilog *logrus.Entry
+
+func init() {
+	ilog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoIncomingWebhookPeer",
+	})
+}
+
+// DynamoIncomingWebhookPeer defines interaction with incoming webhooks backed by dynamodb.
+//
+// Incoming webhooks are stored in the table `incoming_webhook` with the hash key `wall_id` and the range key `id`.
+type DynamoIncomingWebhookPeer struct {
+	model *DynamoModel
+}
+
+// NewIncomingWebhook creates a new incoming webhook posting as the bot user.
+// The webhook is not inserted into the database until it is saved.
+func (ip *DynamoIncomingWebhookPeer) NewIncomingWebhook(name, botID, tokenHash string) *model.IncomingWebhook {
+	return &model.IncomingWebhook{
+		ID:        uuid.NewV4().String(),
+		Name:      name,
+		BotID:     botID,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+	}
+}
+
+// SaveNew saves a new incoming webhook.
+func (ip *DynamoIncomingWebhookPeer) SaveNew(h *model.IncomingWebhook) error {
+	items := map[string]*dynamodb.AttributeValue{
+		"wall_id": {S: aws.String("1")},
+	}
+	if err := marshalIncomingWebhook(h, items); err != nil {
+		return err
+	}
+	_, err := ip.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("incoming_webhook"),
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	return err
+}
+
+// GetByID fetches the incoming webhook identified by the id. Otherwise an error is returned.
+func (ip *DynamoIncomingWebhookPeer) GetByID(id string) (*model.IncomingWebhook, error) {
+	resp, err := ip.model.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("incoming_webhook"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"wall_id": {S: aws.String("1")},
+			"id":      {S: aws.String(id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, fmt.Errorf("Incoming webhook %s not found", id)
+	}
+	h := &model.IncomingWebhook{}
+	if err := unmarshalIncomingWebhook(h, resp.Item); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// GetAll returns all incoming webhooks of the wall, the order of the result is not defined.
+func (ip *DynamoIncomingWebhookPeer) GetAll() ([]*model.IncomingWebhook, error) {
+	items, err := ip.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("incoming_webhook"),
+		KeyConditionExpression: aws.String("wall_id = :wid"),
+		ConsistentRead:         aws.Bool(true),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]*model.IncomingWebhook, 0, len(items))
+	for _, item := range items {
+		h := &model.IncomingWebhook{}
+		if err := unmarshalIncomingWebhook(h, item); err != nil {
+			ilog.Warnf("Error unmarshal incoming webhook: %#v", item)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+// Remove removes the incoming webhook, its token is no longer accepted. The bot user and its posts are kept.
+func (ip *DynamoIncomingWebhookPeer) Remove(h *model.IncomingWebhook) error {
+	_, err := ip.model.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("incoming_webhook"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"wall_id": {S: aws.String("1")},
+			"id":      {S: aws.String(h.ID)},
+		},
+	})
+	return err
+}
+
+// marshalIncomingWebhook builds an aws AttributeValue data structure for the given incoming webhook.
+func marshalIncomingWebhook(h *model.IncomingWebhook, items map[string]*dynamodb.AttributeValue) error {
+	if h == nil {
+		return errors.New("Undefined incoming webhook")
+	}
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(h.ID)}
+	items["name"] = &dynamodb.AttributeValue{S: aws.String(h.Name)}
+	items["bot_id"] = &dynamodb.AttributeValue{S: aws.String(h.BotID)}
+	items["token_hash"] = &dynamodb.AttributeValue{S: aws.String(h.TokenHash)}
+	if h.CreatedBy != "" {
+		items["created_by"] = &dynamodb.AttributeValue{S: aws.String(h.CreatedBy)}
+	}
+	items["created_at"] = nanoAttribute(h.CreatedAt)
+	return nil
+}
+
+// unmarshalIncomingWebhook unmarshals an incoming webhook from the aws datastructure to `model.IncomingWebhook`.
+func unmarshalIncomingWebhook(h *model.IncomingWebhook, items map[string]*dynamodb.AttributeValue) error {
+	if h == nil {
+		return errors.New("Undefined incoming webhook")
+	}
+	if items["id"] == nil || items["bot_id"] == nil || items["token_hash"] == nil {
+		return errors.New("Missing attributes")
+	}
+	h.ID = aws.StringValue(items["id"].S)
+	h.BotID = aws.StringValue(items["bot_id"].S)
+	h.TokenHash = aws.StringValue(items["token_hash"].S)
+	if v, ok := items["name"]; ok {
+		h.Name = aws.StringValue(v.S)
+	}
+	if v, ok := items["created_by"]; ok {
+		h.CreatedBy = aws.StringValue(v.S)
+	}
+	h.CreatedAt = nanoValue(items["created_at"])
+	return nil
+}
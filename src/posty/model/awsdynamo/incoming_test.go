@@ -0,0 +1,33 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalIncomingWebhook(t *testing.T) {
+	assert := assert.New(t)
+	h := &model.IncomingWebhook{
+		ID:        "iid123",
+		Name:      "CI",
+		BotID:     "uid456",
+		TokenHash: "0123abcd",
+		CreatedBy: "uid123",
+		CreatedAt: time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalIncomingWebhook(h, m); err != nil {
+		t.Fatalf("Error marshalling incoming webhook: %s", err)
+	}
+	var u model.IncomingWebhook
+	if err := unmarshalIncomingWebhook(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling incoming webhook: %s", err)
+	}
+	assert.Equal(h, &u)
+	delete(m, "token_hash")
+	assert.Error(unmarshalIncomingWebhook(&u, m), "Webhooks without token can not be used")
+}
@@ -0,0 +1,114 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadConversationFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	if err := deleteTable(db, "conversation"); err != nil {
+		fmt.Printf("Warn: Delete table 'conversation' failed: %s\n", err)
+	}
+	if err := createKeyTable(db, "conversation", "uid", "S", "conversation_id", "S"); err != nil {
+		fmt.Printf("Warn: Create conversation table failed: %s\n", err)
+	}
+	if err := deleteTable(db, "message"); err != nil {
+		fmt.Printf("Warn: Delete table 'message' failed: %s\n", err)
+	}
+	if err := createKeyTable(db, "message", "conversation_id", "S", "created_at", "N"); err != nil {
+		fmt.Printf("Warn: Create message table failed: %s\n", err)
+	}
+	return nil
+}
+
+// createKeyTable creates a table with a hash and range key and no secondary indexes.
+func createKeyTable(db *dynamodb.DynamoDB, table, hash, hashType, rng, rngType string) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(hash),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String(rng),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String(hash),
+				AttributeType: aws.String(hashType),
+			},
+			{
+				AttributeName: aws.String(rng),
+				AttributeType: aws.String(rngType),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func TestConversations(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.ConversationPeer()
+
+	c, created, err := peer.Open("uidconv1", "uidconv2")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.True(created)
+	assert.Equal(model.ConversationID("uidconv1", "uidconv2"), c.ID)
+	other, created, err := peer.Open("uidconv2", "uidconv1")
+	if !assert.NoError(err) {
+		return
+	}
+	assert.False(created, "Conversations must be opened only once")
+	assert.Equal(c.ID, other.ID)
+	assert.Equal("uidconv1", other.Other)
+
+	_, err = peer.Get(c.ID, "uidconv3")
+	assert.Equal(model.ErrNotParticipant, err)
+
+	for _, text := range []string{"hello", "there"} {
+		assert.NoError(peer.SaveMessage(c, peer.NewMessage(c, text)))
+	}
+	assert.NoError(peer.SaveMessage(other, peer.NewMessage(other, "hi")))
+	messages, err := peer.GetMessages(c.ID)
+	if assert.NoError(err) && assert.Len(messages, 3) {
+		assert.Equal("hello", messages[0].Text, "Oldest first")
+		assert.Equal("uidconv2", messages[2].UID)
+	}
+
+	other, err = peer.Get(c.ID, "uidconv2")
+	if assert.NoError(err) {
+		assert.Equal(2, other.Unread)
+		stale := *other
+		stale.LastMessageAt = other.LastMessageAt.Add(-time.Second)
+		assert.Equal(model.ErrConversationChanged, peer.MarkRead(&stale), "Messages arrived since fetching")
+		assert.NoError(peer.MarkRead(other))
+	}
+	other, err = peer.Get(c.ID, "uidconv2")
+	if assert.NoError(err) {
+		assert.Equal(0, other.Unread)
+	}
+	cs, err := peer.GetByUser("uidconv1")
+	if assert.NoError(err) && assert.Len(cs, 1) {
+		assert.Equal(1, cs[0].Unread)
+		assert.False(cs[0].LastMessageAt.IsZero())
+	}
+}
@@ -0,0 +1,72 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadJobFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	if err := deleteTable(db, "job"); err != nil {
+		fmt.Printf("Warn: Delete table 'job' failed: %s\n", err)
+	}
+	if err := createJobTable(db); err != nil {
+		fmt.Printf("Warn: Create job table failed: %s\n", err)
+	}
+	return nil
+}
+
+func createJobTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("job"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("name"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("name"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func TestJobRuns(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.JobPeer()
+
+	last, err := peer.LastRun("integration")
+	assert.NoError(err)
+	assert.True(last.IsZero(), "Jobs which never ran have no last run")
+
+	first := time.Unix(1448272067, 0)
+	assert.NoError(peer.ClaimRun("integration", time.Time{}, first))
+	assert.Equal(model.ErrJobClaimed, peer.ClaimRun("integration", time.Time{}, first.Add(time.Second)), "The first run is claimed once")
+	last, err = peer.LastRun("integration")
+	assert.NoError(err)
+	assert.Equal(first, last)
+
+	second := first.Add(time.Hour)
+	assert.NoError(peer.ClaimRun("integration", first, second))
+	assert.Equal(model.ErrJobClaimed, peer.ClaimRun("integration", first, second.Add(time.Second)), "Stale runs cannot be claimed")
+	last, err = peer.LastRun("integration")
+	assert.NoError(err)
+	assert.Equal(second, last)
+}
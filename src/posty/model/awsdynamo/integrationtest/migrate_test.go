@@ -0,0 +1,49 @@
+package integrationtest
+
+import (
+	"posty/model/awsdynamo"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	defer teardown()
+	db := dynamodb.New(sess)
+	deleteTable(db, awsdynamo.SchemaVersionTable)
+	_, err := db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("user"),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":         {S: aws.String("uidmigrate")},
+			"oauthid":    {S: aws.String("google:migrate")},
+			"username":   {S: aws.String("Migrated User")},
+			"created_at": {N: aws.String("1448272067")},
+		},
+	})
+	assert.NoError(err)
+
+	m := awsdynamo.NewMigratorFromSession(sess)
+	m.PollInterval = 100 * time.Millisecond
+	from, to, err := m.Migrate()
+	assert.NoError(err, "Tables created by the fixtures match the schema")
+	assert.Equal(0, from)
+	assert.Equal(len(awsdynamo.Migrations), to)
+	users, err := mmodel.UserPeer().GetByHandles([]string{"migrateduser"})
+	assert.NoError(err)
+	if assert.Len(users, 1, "Handles of existing users are added") {
+		assert.Equal("uidmigrate", users[0].ID)
+	}
+
+	from, to, err = m.Migrate()
+	assert.NoError(err)
+	assert.Equal(len(awsdynamo.Migrations), from, "Migrations are applied once")
+	assert.Equal(len(awsdynamo.Migrations), to)
+	version, err := m.Version()
+	assert.NoError(err)
+	assert.Equal(to, version)
+}
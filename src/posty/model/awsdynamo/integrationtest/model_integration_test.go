@@ -44,6 +44,30 @@ func TestMain(m *testing.M) {
 		fmt.Fprintf(os.Stderr, "Error loading 'post' integration fixtures: %s", err)
 		os.Exit(1)
 	}
+	if err := loadReportFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'report' integration fixtures: %s", err)
+		os.Exit(1)
+	}
+	if err := loadVoteFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'vote' integration fixtures: %s", err)
+		os.Exit(1)
+	}
+	if err := loadConversationFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'conversation' integration fixtures: %s", err)
+		os.Exit(1)
+	}
+	if err := loadNotificationFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'notification' integration fixtures: %s", err)
+		os.Exit(1)
+	}
+	if err := loadWebhookFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'webhook' integration fixtures: %s", err)
+		os.Exit(1)
+	}
+	if err := loadJobFixtures(sess); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading 'job' integration fixtures: %s", err)
+		os.Exit(1)
+	}
 	os.Exit(m.Run())
 }
 
@@ -0,0 +1,118 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadNotificationFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	if err := deleteTable(db, "notification"); err != nil {
+		fmt.Printf("Warn: Delete table 'notification' failed: %s\n", err)
+	}
+	if err := createNotificationTable(db); err != nil {
+		fmt.Printf("Warn: Create notification table failed: %s\n", err)
+	}
+	return nil
+}
+
+func createNotificationTable(db *dynamodb.DynamoDB) error {
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(1),
+		WriteCapacityUnits: aws.Int64(1),
+	}
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("notification"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("uid"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("uid"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("post_id"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: throughput,
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("PostIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("post_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("KEYS_ONLY"),
+				},
+				ProvisionedThroughput: throughput,
+			},
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func TestNotifications(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.NotificationPeer()
+
+	first := peer.NewNotification("uidnotif", model.NotificationMention, "uid123", "pidnotif1")
+	second := peer.NewNotification("uidnotif", model.NotificationVote, "uid456", "pidnotif2")
+	for _, n := range []*model.Notification{first, second} {
+		if err := peer.SaveNew(n); err != nil {
+			t.Fatalf("Could not save notification: %s\n", err)
+		}
+	}
+	ns, err := peer.GetByUser("uidnotif", 10)
+	if !assert.NoError(err) || !assert.Len(ns, 2) {
+		return
+	}
+	assert.Equal(second.ID, ns[0].ID, "Newest notifications first")
+	assert.True(ns[0].Unread())
+	ns, _ = peer.GetByUser("uidnotif", 1)
+	assert.Len(ns, 1)
+	count, err := peer.CountUnread("uidnotif")
+	assert.NoError(err)
+	assert.Equal(2, count, "Unread notifications beyond the limit are counted")
+
+	if err := peer.MarkRead(second); err != nil {
+		t.Fatalf("Could not mark notification read: %s\n", err)
+	}
+	ns, _ = peer.GetByUser("uidnotif", 10)
+	assert.False(ns[0].Unread())
+	assert.True(ns[1].Unread())
+	count, err = peer.CountUnread("uidnotif")
+	assert.NoError(err)
+	assert.Equal(1, count)
+
+	if err := peer.RemoveByPost("pidnotif1"); err != nil {
+		t.Fatalf("Could not remove notifications of post: %s\n", err)
+	}
+	ns, _ = peer.GetByUser("uidnotif", 10)
+	if assert.Len(ns, 1) {
+		assert.Equal(second.ID, ns[0].ID)
+	}
+}
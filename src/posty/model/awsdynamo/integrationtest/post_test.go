@@ -23,6 +23,24 @@ func loadPostFixtures(s *session.Session) error {
 	if err := createPostTable(db); err != nil {
 		fmt.Printf("Warn: Create Post table failed: %s\n", err)
 	}
+	if err := deleteTable(db, "post_term"); err != nil {
+		fmt.Printf("Warn: Delete table 'post_term' failed: %s\n", err)
+	}
+	if err := createPostTermTable(db); err != nil {
+		fmt.Printf("Warn: Create post_term table failed: %s\n", err)
+	}
+	if err := deleteTable(db, "pin"); err != nil {
+		fmt.Printf("Warn: Delete table 'pin' failed: %s\n", err)
+	}
+	if err := createPinTable(db); err != nil {
+		fmt.Printf("Warn: Create pin table failed: %s\n", err)
+	}
+	if err := deleteTable(db, "attachment"); err != nil {
+		fmt.Printf("Warn: Delete table 'attachment' failed: %s\n", err)
+	}
+	if err := createAttachmentTable(db); err != nil {
+		fmt.Printf("Warn: Create attachment table failed: %s\n", err)
+	}
 	if err := fixturePost(db); err != nil {
 		return err
 	}
@@ -47,10 +65,6 @@ func createPostTable(db *dynamodb.DynamoDB) error {
 				AttributeName: aws.String("id"),
 				AttributeType: aws.String("S"),
 			},
-			{
-				AttributeName: aws.String("uid"),
-				AttributeType: aws.String("S"),
-			},
 			{
 				AttributeName: aws.String("created_at"),
 				AttributeType: aws.String("N"),
@@ -59,31 +73,27 @@ func createPostTable(db *dynamodb.DynamoDB) error {
 				AttributeName: aws.String("wall_id"),
 				AttributeType: aws.String("S"),
 			},
+			{
+				AttributeName: aws.String("publish_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("due_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("unindexed_at"),
+				AttributeType: aws.String("N"),
+			},
 		},
 		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(1),
 			WriteCapacityUnits: aws.Int64(1),
 		},
 		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String("UIDIndex"),
-				KeySchema: []*dynamodb.KeySchemaElement{
-					{
-						AttributeName: aws.String("uid"),
-						KeyType:       aws.String("HASH"),
-					},
-				},
-				Projection: &dynamodb.Projection{
-					ProjectionType: aws.String("INCLUDE"),
-					NonKeyAttributes: []*string{
-						aws.String("id"),
-					},
-				},
-				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-					ReadCapacityUnits:  aws.Int64(1),
-					WriteCapacityUnits: aws.Int64(1),
-				},
-			},
+			scheduleIndex("PublishIndex", "publish_at"),
+			scheduleIndex("DueIndex", "due_at"),
+			scheduleIndex("UnindexedIndex", "unindexed_at"),
 			{ // Required
 				IndexName: aws.String("IDIndex"),
 				KeySchema: []*dynamodb.KeySchemaElement{
@@ -114,6 +124,113 @@ func createPostTable(db *dynamodb.DynamoDB) error {
 	return nil
 }
 
+// scheduleIndex returns an index of the posts on the wall by the range key, projecting all attributes.
+func scheduleIndex(name, rangeKey string) *dynamodb.GlobalSecondaryIndex {
+	return &dynamodb.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("wall_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String(rangeKey),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		Projection: &dynamodb.Projection{
+			ProjectionType: aws.String("ALL"),
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+}
+
+func createPostTermTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("post_term"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("term"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("term"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createPinTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("pin"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("slot"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("slot"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func createAttachmentTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("attachment"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
 func fixturePost(db *dynamodb.DynamoDB) error {
 	params := &dynamodb.PutItemInput{
 		Item: map[string]*dynamodb.AttributeValue{
@@ -159,6 +276,9 @@ func TestPostGetByID(t *testing.T) {
 	assert.Equal("uid123", p.UID)
 	assert.Equal("message", p.Message)
 	assert.True(p.CreatedAt.Unix() > 0, "Timestamp should exist")
+
+	_, err = peer.GetByID("pidmissing")
+	assert.Equal(model.ErrNotFound, err)
 }
 
 func TestPostCreateNew(t *testing.T) {
@@ -180,6 +300,137 @@ func TestPostCreateNew(t *testing.T) {
 	assert.Equal(p.UID, gp.UID)
 	assert.Equal(p.Message, gp.Message)
 	assert.Equal(p.CreatedAt.Unix(), gp.CreatedAt.Unix())
+	assert.Equal(model.ErrAlreadyExists, p.SaveNew(), "Existing posts are not overwritten")
+}
+
+func TestPostGetPage(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	removed := peer.NewPost("uidpage")
+	removed.Message = "removed"
+	scheduled := peer.NewPost("uidpage")
+	scheduled.Message = "scheduled"
+	scheduled.PublishAt = time.Now().Add(time.Hour)
+	for _, p := range []*model.Post{removed, scheduled} {
+		if err := p.SaveNew(); err != nil {
+			t.Fatalf("Error inserting post: %s", err)
+		}
+	}
+	assert.NoError(peer.Remove(removed))
+
+	seen := make(map[string]bool)
+	var last time.Time
+	after := ""
+	for {
+		page, next, err := peer.GetPage(after, 1)
+		if err != nil {
+			t.Fatalf("Error getting page: %s", err)
+		}
+		for _, p := range page {
+			assert.False(p.CreatedAt.Before(last), "Posts are returned oldest first")
+			last = p.CreatedAt
+			seen[p.ID] = true
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	assert.True(seen["pid123"])
+	assert.True(seen[removed.ID], "Removed posts are returned")
+	assert.True(seen[scheduled.ID], "Scheduled posts are returned")
+}
+
+func TestPostSetPreview(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	p := peer.NewPost("uid123")
+	p.Message = "check https://example.com"
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Error saving new post: %s\n", err)
+	}
+	stale := *p
+	if err := peer.SetHidden(p, true); err != nil {
+		t.Fatalf("Error hiding post: %s\n", err)
+	}
+	pv := &model.Preview{URL: "https://example.com", Title: "Example"}
+	if err := peer.SetPreview(&stale, pv); err != nil {
+		t.Fatalf("Error setting preview: %s\n", err)
+	}
+	gp, err := peer.GetByID(p.ID)
+	if err != nil {
+		t.Fatalf("Could not get updated post: %s\n", err)
+	}
+	assert.Equal(pv, gp.Preview)
+	assert.True(gp.Hidden, "Other attributes are kept")
+
+	if err := peer.Remove(p); err != nil {
+		t.Fatalf("Could not remove post: %s", err)
+	}
+	assert.Error(peer.SetPreview(p, pv), "Removed posts must not be recreated")
+}
+
+func TestPostSetHidden(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	p := peer.NewPost("uid123")
+	p.Message = "hide me"
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Error saving new post: %s\n", err)
+	}
+	stale := *p
+	if err := peer.SetPreview(p, &model.Preview{URL: "https://example.com"}); err != nil {
+		t.Fatalf("Error setting preview: %s\n", err)
+	}
+	if err := peer.SetHidden(&stale, true); err != nil {
+		t.Fatalf("Error hiding post: %s\n", err)
+	}
+	assert.True(stale.Hidden)
+	gp, err := peer.GetByID(p.ID)
+	if err != nil {
+		t.Fatalf("Could not get hidden post: %s\n", err)
+	}
+	assert.True(gp.Hidden)
+	assert.NotNil(gp.Preview, "Other attributes are kept")
+
+	if err := peer.SetHidden(gp, false); err != nil {
+		t.Fatalf("Error showing post: %s\n", err)
+	}
+	gp, err = peer.GetByID(p.ID)
+	if assert.NoError(err) {
+		assert.False(gp.Hidden)
+	}
+
+	if err := peer.Remove(p); err != nil {
+		t.Fatalf("Could not remove post: %s", err)
+	}
+	assert.Error(peer.SetHidden(p, true), "Removed posts must not be recreated")
+}
+
+func TestPostClaimAttachment(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	assert.NoError(peer.ClaimAttachment("aidclaim", "pid1"))
+	assert.Equal(model.ErrAttachmentClaimed, peer.ClaimAttachment("aidclaim", "pid2"), "Attachments are claimed once")
+	assert.NoError(peer.ReleaseAttachment("aidclaim", "pid2"), "Claims of other posts are kept")
+	assert.Equal(model.ErrAttachmentClaimed, peer.ClaimAttachment("aidclaim", "pid2"))
+	assert.NoError(peer.ReleaseAttachment("aidclaim", "pid1"))
+	assert.NoError(peer.ClaimAttachment("aidclaim", "pid2"), "Released attachments can be claimed again")
+
+	// Claims are removed with the purged post
+	p := peer.NewPost("uidclaim")
+	p.Attachments = []model.Attachment{{ID: "aidpurge"}}
+	assert.NoError(peer.ClaimAttachment("aidpurge", p.ID))
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Could not create post: %s", err)
+	}
+	assert.NoError(peer.Remove(p))
+	assert.NoError(peer.Purge(p))
+	assert.NoError(peer.ClaimAttachment("aidpurge", "pid3"))
 }
 
 func TestPostRemove(t *testing.T) {
@@ -202,6 +453,61 @@ func TestPostRemove(t *testing.T) {
 	if err == nil && gp != nil {
 		t.Fatalf("Post still exists after remove")
 	}
+	ps, err := peer.QueryPosts(model.PostQuery{Author: "uiddelete"})
+	if err != nil {
+		t.Fatalf("Could not query posts: %s", err)
+	}
+	if len(ps) != 0 {
+		t.Fatalf("Removed post must not be listed")
+	}
+
+	// Removed posts can be restored
+	gp, err = peer.GetDeletedByID(p.ID)
+	if err != nil {
+		t.Fatalf("Could not get removed post: %s", err)
+	}
+	if gp.DeletedAt.IsZero() {
+		t.Fatalf("Removed post without deletion time")
+	}
+	if err := peer.Restore(gp); err != nil {
+		t.Fatalf("Could not restore post: %s", err)
+	}
+	if _, err := peer.GetByID(p.ID); err != nil {
+		t.Fatalf("Could not get restored post: %s", err)
+	}
+	if err := peer.Restore(gp); err == nil {
+		t.Fatalf("Posts which are not removed must not be restored")
+	}
+
+	// Removed posts are purged together with their reports
+	if err := mmodel.ReportPeer().SaveNew(mmodel.ReportPeer().NewReport(p.ID, "uid1")); err != nil {
+		t.Fatalf("Could not report post: %s", err)
+	}
+	if err := peer.Remove(p); err != nil {
+		t.Fatalf("Could not remove post: %s", err)
+	}
+	deleted, err := peer.GetDeletedPosts(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Could not get removed posts: %s", err)
+	}
+	found := false
+	for _, dp := range deleted {
+		if dp.ID == p.ID {
+			found = true
+			if err := peer.Purge(dp); err != nil {
+				t.Fatalf("Could not purge post: %s", err)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("Removed post must be listed as removed")
+	}
+	if _, err := peer.GetDeletedByID(p.ID); err == nil {
+		t.Fatalf("Purged post still exists")
+	}
+	if reports, err := mmodel.ReportPeer().GetByPost(p.ID); err != nil || len(reports) != 0 {
+		t.Fatalf("Reports of purged post still exist: %v %v", reports, err)
+	}
 }
 
 func TestPostGetPosts(t *testing.T) {
@@ -274,3 +580,246 @@ func checkPosts(ps []*model.Post, fn func(*model.Post, *model.Post) error) error
 	}
 	return nil
 }
+
+func TestPostQueryPosts(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	start := time.Now()
+	var created []*model.Post
+	for i := 0; i < 3; i++ {
+		for _, uid := range []string{"uidquery1", "uidquery2"} {
+			p := peer.NewPost(uid)
+			p.Message = "query message"
+			if err := p.SaveNew(); err != nil {
+				t.Fatalf("Error inserting post: %s", err)
+			}
+			created = append(created, p)
+		}
+	}
+
+	posts, err := peer.QueryPosts(model.PostQuery{Author: "uidquery1"})
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 3)
+	for _, p := range posts {
+		assert.Equal("uidquery1", p.UID)
+	}
+
+	posts, err = peer.QueryPosts(model.PostQuery{Since: created[2].CreatedAt, Until: created[3].CreatedAt})
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 2)
+	if len(posts) == 2 {
+		assert.Equal(created[3].ID, posts[0].ID, "Newest first")
+		assert.Equal(created[2].ID, posts[1].ID)
+	}
+
+	posts, err = peer.QueryPosts(model.PostQuery{Author: "uidquery2", Since: start})
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 3)
+}
+
+func TestPostScheduledAndExpired(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	scheduled := peer.NewPost("uidschedule")
+	scheduled.Message = "scheduled #schedule"
+	scheduled.Tags = []string{"schedule"}
+	scheduled.PublishAt = time.Now().Add(time.Hour)
+	expired := peer.NewPost("uidschedule")
+	expired.Message = "expired #schedule"
+	expired.Tags = []string{"schedule"}
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	live := peer.NewPost("uidschedule")
+	live.Message = "live #schedule"
+	live.Tags = []string{"schedule"}
+	live.ExpiresAt = time.Now().Add(time.Hour)
+	for _, p := range []*model.Post{scheduled, expired, live} {
+		if err := p.SaveNew(); err != nil {
+			t.Fatalf("Error inserting post: %s", err)
+		}
+	}
+
+	posts, err := peer.QueryPosts(model.PostQuery{Author: "uidschedule"})
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(live.ID, posts[0].ID)
+	}
+	posts, err = peer.QueryPosts(model.PostQuery{Author: "uidschedule", Scheduled: true})
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(scheduled.ID, posts[0].ID)
+		assert.Equal(scheduled.PublishAt.UnixNano(), posts[0].PublishAt.UnixNano())
+	}
+	posts, err = peer.QueryPosts(model.PostQuery{Author: "uidschedule", Expired: true})
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(expired.ID, posts[0].ID)
+	}
+	posts, err = peer.GetPostsByTag("schedule")
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(live.ID, posts[0].ID)
+	}
+	p, err := peer.GetByID(scheduled.ID)
+	if assert.NoError(err, "Scheduled posts must be fetched by id") {
+		assert.True(p.Scheduled(time.Now()))
+	}
+}
+
+func TestPostPublishedAndDue(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	now := time.Now()
+	p := peer.NewPost("uiddue")
+	p.Message = "due soon"
+	p.PublishAt = now.Add(time.Second)
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Error inserting post: %s", err)
+	}
+	due, err := peer.GetDue(now)
+	assert.NoError(err)
+	assert.Len(due, 0, "Posts are not due before they are published")
+
+	later := now.Add(time.Minute)
+	posts, err := peer.GetPublished(now, later)
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(p.ID, posts[0].ID)
+	}
+	posts, err = peer.GetPublished(p.PublishAt, later)
+	assert.NoError(err)
+	assert.Len(posts, 0, "Posts published at since are excluded")
+
+	due, err = peer.GetDue(later)
+	if assert.NoError(err) && assert.Len(due, 1) {
+		assert.Equal(p.ID, due[0].ID)
+		assert.NoError(peer.MarkAnnounced(due[0]))
+		assert.Equal(model.ErrAlreadyAnnounced, peer.MarkAnnounced(due[0]), "Posts are announced once")
+	}
+	due, err = peer.GetDue(later)
+	assert.NoError(err)
+	assert.Len(due, 0, "Announced posts are not due anymore")
+}
+
+func TestPostPinned(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	p := peer.NewPost("uidpin")
+	p.Message = "pinned message"
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Error inserting post: %s", err)
+	}
+	posts, err := peer.QueryPosts(model.PostQuery{Author: "uidpin", Pinned: true})
+	assert.NoError(err)
+	assert.Len(posts, 0)
+
+	assert.NoError(peer.Pin(p, 1))
+	assert.False(p.PinnedAt.IsZero())
+	posts, err = peer.QueryPosts(model.PostQuery{Author: "uidpin", Pinned: true})
+	if assert.NoError(err) && assert.Len(posts, 1) {
+		assert.Equal(p.ID, posts[0].ID)
+		assert.Equal(p.PinnedAt.UnixNano(), posts[0].PinnedAt.UnixNano())
+	}
+	assert.NoError(peer.Pin(p, 1), "Pinning again keeps the slot")
+
+	// Removed posts keep their slot until they are purged
+	other := peer.NewPost("uidpin")
+	other.Message = "other message"
+	if err := other.SaveNew(); err != nil {
+		t.Fatalf("Error inserting post: %s", err)
+	}
+	assert.NoError(peer.Remove(p))
+	assert.Equal(model.ErrPinLimitReached, peer.Pin(other, 1))
+	assert.NoError(peer.Restore(p))
+
+	assert.NoError(peer.Unpin(p))
+	assert.True(p.PinnedAt.IsZero())
+	posts, err = peer.QueryPosts(model.PostQuery{Author: "uidpin", Pinned: true})
+	assert.NoError(err)
+	assert.Len(posts, 0)
+	assert.NoError(peer.Pin(other, 1), "Unpinning releases the slot")
+	assert.NoError(peer.Unpin(other))
+}
+
+func TestPostGetPostsByTagAndMention(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	p1 := peer.NewPost("uidtags")
+	p1.Message = "#integration with @tester"
+	p1.Tags = []string{"integration"}
+	p1.Mentions = []string{"tester"}
+	p2 := peer.NewPost("uidtags")
+	p2.Message = "#integration #other"
+	p2.Tags = []string{"integration", "other"}
+	for _, p := range []*model.Post{p1, p2} {
+		if err := p.SaveNew(); err != nil {
+			t.Fatalf("Error inserting post: %s", err)
+		}
+	}
+
+	posts, err := peer.GetPostsByTag("integration")
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 2)
+	if len(posts) == 2 {
+		assert.Equal(p2.ID, posts[0].ID, "Newest first")
+		assert.Equal([]string{"integration", "other"}, posts[0].Tags)
+	}
+
+	posts, err = peer.GetPostsByMention("tester")
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 1)
+
+	if err := peer.Remove(p1); err != nil {
+		t.Fatalf("Could not remove post: %s", err)
+	}
+	posts, err = peer.GetPostsByMention("tester")
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	assert.Len(posts, 0, "Removed posts must be removed from the index")
+}
+
+func TestPostRepairTerms(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.PostPeer()
+	createdAt := strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err := dynamodb.New(sess).PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String("post"),
+		Item: map[string]*dynamodb.AttributeValue{
+			"wall_id":      {S: aws.String("1")},
+			"created_at":   {N: aws.String(createdAt)},
+			"id":           {S: aws.String("pidunindexed")},
+			"uid":          {S: aws.String("uidunindexed")},
+			"message":      {S: aws.String("#unindexed")},
+			"tags":         {SS: aws.StringSlice([]string{"unindexed"})},
+			"unindexed_at": {N: aws.String(createdAt)},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error inserting post: %s", err)
+	}
+	posts, err := peer.GetPostsByTag("unindexed")
+	assert.NoError(err)
+	assert.Len(posts, 0)
+
+	n, err := peer.RepairTerms()
+	assert.NoError(err)
+	assert.Equal(1, n)
+	posts, err = peer.GetPostsByTag("unindexed")
+	if assert.NoError(err) && assert.Len(posts, 1, "Repaired posts are indexed") {
+		assert.Equal("pidunindexed", posts[0].ID)
+	}
+	n, err = peer.RepairTerms()
+	assert.NoError(err)
+	assert.Equal(0, n, "Indexed posts are not repaired again")
+}
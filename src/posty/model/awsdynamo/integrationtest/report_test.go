@@ -0,0 +1,194 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadReportFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	if err := deleteTable(db, "report"); err != nil {
+		fmt.Printf("Warn: Delete table 'report' failed: %s\n", err)
+	}
+	if err := createReportTable(db); err != nil {
+		fmt.Printf("Warn: Create report table failed: %s\n", err)
+	}
+	if err := deleteTable(db, "audit"); err != nil {
+		fmt.Printf("Warn: Delete table 'audit' failed: %s\n", err)
+	}
+	if err := createAuditTable(db); err != nil {
+		fmt.Printf("Warn: Create audit table failed: %s\n", err)
+	}
+	return nil
+}
+
+func createReportTable(db *dynamodb.DynamoDB) error {
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(1),
+		WriteCapacityUnits: aws.Int64(1),
+	}
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("report"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("post_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("uid"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("post_id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("uid"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("status"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		ProvisionedThroughput: throughput,
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("IDIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: throughput,
+			},
+			{
+				IndexName: aws.String("StatusIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("status"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("created_at"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: throughput,
+			},
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func createAuditTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("audit"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("wall_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("wall_id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func TestReports(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.ReportPeer()
+	r1 := peer.NewReport("pidreport", "uid1")
+	r1.Reason = "spam"
+	if err := peer.SaveNew(r1); err != nil {
+		t.Fatalf("Error saving report: %s\n", err)
+	}
+	assert.Equal(model.ErrAlreadyReported, peer.SaveNew(peer.NewReport("pidreport", "uid1")), "Users must report a post only once")
+	r2 := peer.NewReport("pidreport", "uid2")
+	if err := peer.SaveNew(r2); err != nil {
+		t.Fatalf("Error saving report: %s\n", err)
+	}
+
+	rs, err := peer.GetByPost("pidreport")
+	if err != nil {
+		t.Fatalf("Error getting reports by post: %s\n", err)
+	}
+	assert.Len(rs, 2)
+	open, err := peer.GetOpen()
+	if err != nil {
+		t.Fatalf("Error getting open reports: %s\n", err)
+	}
+	assert.Len(open, 2)
+
+	r1.Status = model.ReportResolved
+	r1.ResolvedBy = "uidmod"
+	if err := peer.Update(r1); err != nil {
+		t.Fatalf("Error updating report: %s\n", err)
+	}
+	gr, err := peer.GetByID(r1.ID)
+	if err != nil {
+		t.Fatalf("Error getting report: %s\n", err)
+	}
+	assert.Equal(model.ReportResolved, gr.Status)
+	assert.Equal("spam", gr.Reason)
+	open, _ = peer.GetOpen()
+	if assert.Len(open, 1) {
+		assert.Equal(r2.ID, open[0].ID)
+	}
+
+	e := peer.NewAuditEntry("uidmod", model.ActionResolve, "pidreport")
+	e.ReportIDs = []string{r1.ID}
+	if err := peer.SaveAuditEntry(e); err != nil {
+		t.Fatalf("Error saving audit entry: %s\n", err)
+	}
+	entries, err := peer.GetAuditEntries()
+	if err != nil {
+		t.Fatalf("Error getting audit entries: %s\n", err)
+	}
+	if assert.Len(entries, 1) {
+		assert.Equal(e.ReportIDs, entries[0].ReportIDs)
+	}
+}
@@ -2,6 +2,7 @@ package integrationtest
 
 import (
 	"fmt"
+	"posty/model"
 	"testing"
 	"time"
 
@@ -42,6 +43,10 @@ func createUserTable(db *dynamodb.DynamoDB) error {
 				AttributeName: aws.String("oauthid"),
 				AttributeType: aws.String("S"),
 			},
+			{
+				AttributeName: aws.String("handle"),
+				AttributeType: aws.String("S"),
+			},
 		},
 		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(1),
@@ -67,6 +72,22 @@ func createUserTable(db *dynamodb.DynamoDB) error {
 					WriteCapacityUnits: aws.Int64(1),
 				},
 			},
+			{
+				IndexName: aws.String("HandleIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("handle"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(1),
+					WriteCapacityUnits: aws.Int64(1),
+				},
+			},
 		},
 	}
 	_, err := db.CreateTable(params)
@@ -125,6 +146,9 @@ func TestUserGetByID(t *testing.T) {
 	assert.Equal("google:1234", u.OAuthID)
 	assert.Equal("test@example.com", u.Email)
 	assert.Equal("username", u.Username)
+
+	_, err = peer.GetByID("uidmissing")
+	assert.Equal(model.ErrNotFound, err)
 }
 
 func TestUserCreateNew(t *testing.T) {
@@ -149,6 +173,7 @@ func TestUserCreateNew(t *testing.T) {
 	assert.Equal(u.Username, gu.Username)
 	assert.Equal(u.Email, gu.Email)
 	assert.Equal(u.CreatedAt.Unix(), gu.CreatedAt.Unix())
+	assert.Equal(model.ErrAlreadyExists, u.SaveNew(), "Existing users are not overwritten")
 }
 func TestUserGetByOAuthID(t *testing.T) {
 	assert := assert.New(t)
@@ -182,3 +207,116 @@ func TestUserUpdateLastLogin(t *testing.T) {
 	assert.True(u.LastLogin.Unix() <= time.Now().Unix())
 	assert.True(u.LastLogin.Unix() >= time.Now().Add(-time.Hour).Unix())
 }
+
+func TestUserUpdateRoles(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.UserPeer()
+	if err := peer.UpdateRoles("uid123", []string{model.RoleModerator}); err != nil {
+		t.Fatalf("Could not update roles: %s\n", err)
+	}
+	u, err := peer.GetByID("uid123")
+	if err != nil {
+		t.Fatalf("Could not get user: %s\n", err)
+	}
+	assert.True(u.HasRole(model.RoleModerator))
+	if err := peer.UpdateRoles("uid123", nil); err != nil {
+		t.Fatalf("Could not remove roles: %s\n", err)
+	}
+	u, _ = peer.GetByID("uid123")
+	assert.Empty(u.Roles)
+	assert.Error(peer.UpdateRoles("unknown", []string{model.RoleModerator}), "Users must not be created")
+}
+
+func TestUserGetByIDs(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.UserPeer()
+	u := peer.NewUser()
+	u.OAuthID = "google:batch"
+	u.Username = "batchuser"
+	err := u.SaveNew()
+	if err != nil {
+		t.Fatalf("Error saving new user: %s\n", err)
+	}
+
+	users, err := peer.GetByIDs([]string{"uid123", u.ID, "uid123", "unknown"})
+	if err != nil {
+		t.Fatalf("Error getting ByIDs: %s\n", err)
+	}
+	assert.Len(users, 2)
+	names := make(map[string]string)
+	for _, gu := range users {
+		names[gu.ID] = gu.Username
+	}
+	assert.Equal("username", names["uid123"])
+	assert.Equal("batchuser", names[u.ID])
+
+	paged := make(map[string]bool)
+	after := ""
+	for {
+		page, next, err := peer.GetPage(after, 1)
+		if err != nil {
+			t.Fatalf("Error getting page of users: %s\n", err)
+		}
+		assert.True(len(page) <= 1)
+		for _, gu := range page {
+			paged[gu.ID] = true
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	assert.True(paged["uid123"], "Pages contain all users")
+	assert.True(paged[u.ID])
+}
+
+func TestUserNotificationPreferences(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.UserPeer()
+	u := peer.NewUser()
+	u.OAuthID = "google:handle"
+	u.Username = "Handle User"
+	if err := u.SaveNew(); err != nil {
+		t.Fatalf("Error saving new user: %s\n", err)
+	}
+	if err := peer.UpdateMutedNotifications(u.ID, []string{model.NotificationVote}); err != nil {
+		t.Fatalf("Could not update muted notifications: %s\n", err)
+	}
+	users, err := peer.GetByHandles([]string{"handleuser", "handleuser", "unknown"})
+	if err != nil {
+		t.Fatalf("Error getting ByHandles: %s\n", err)
+	}
+	if assert.Len(users, 1) {
+		assert.Equal(u.ID, users[0].ID)
+		assert.False(users[0].Notifies(model.NotificationVote))
+		assert.True(users[0].Notifies(model.NotificationMention))
+	}
+	assert.NoError(peer.UpdateMutedNotifications(u.ID, nil))
+	u, _ = peer.GetByID(u.ID)
+	assert.Empty(u.Muted)
+	assert.Error(peer.UpdateMutedNotifications("unknown", nil), "Users must not be created")
+}
+
+func TestUserRotateFeedVersion(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.UserPeer()
+	u := peer.NewUser()
+	u.OAuthID = "google:feed"
+	u.Username = "feeduser"
+	if err := u.SaveNew(); err != nil {
+		t.Fatalf("Error saving new user: %s\n", err)
+	}
+	for i := 1; i <= 2; i++ {
+		version, err := peer.RotateFeedVersion(u.ID)
+		assert.NoError(err)
+		assert.Equal(i, version)
+	}
+	u, _ = peer.GetByID(u.ID)
+	assert.Equal(2, u.FeedVersion)
+	_, err := peer.RotateFeedVersion("unknown")
+	assert.Error(err, "Users must not be created")
+}
@@ -0,0 +1,100 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadVoteFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	if err := deleteTable(db, "vote"); err != nil {
+		fmt.Printf("Warn: Delete table 'vote' failed: %s\n", err)
+	}
+	if err := createVoteTable(db); err != nil {
+		fmt.Printf("Warn: Create vote table failed: %s\n", err)
+	}
+	return nil
+}
+
+func createVoteTable(db *dynamodb.DynamoDB) error {
+	params := &dynamodb.CreateTableInput{
+		TableName: aws.String("vote"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("post_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("uid"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("post_id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("uid"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	}
+	_, err := db.CreateTable(params)
+	return err
+}
+
+func TestVotes(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.VotePeer()
+	votes, err := peer.GetVotes("pollpost")
+	assert.NoError(err)
+	assert.Len(votes, 0)
+
+	assert.NoError(peer.SaveVote(&model.Vote{PostID: "pollpost", UID: "uid1", Option: 1, CreatedAt: time.Now()}))
+	assert.NoError(peer.SaveVote(&model.Vote{PostID: "pollpost", UID: "uid2", Option: 0, CreatedAt: time.Now()}))
+	assert.Equal(model.ErrAlreadyVoted, peer.SaveVote(&model.Vote{PostID: "pollpost", UID: "uid1", Option: 0, CreatedAt: time.Now()}), "Users must vote only once")
+
+	votes, err = peer.GetVotes("pollpost")
+	if assert.NoError(err) {
+		assert.Equal([]int{1, 1}, model.Tally(votes, 2))
+	}
+	byPost, err := peer.GetVotesByPosts([]string{"pollpost", "otherpoll"})
+	if assert.NoError(err) {
+		assert.Len(byPost["pollpost"], 2)
+		assert.Len(byPost["otherpoll"], 0)
+	}
+}
+
+func TestVotesPurged(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	posts := mmodel.PostPeer()
+	p := posts.NewPost("uidpoll")
+	p.Message = "Lunch?"
+	p.Poll = &model.Poll{Options: []string{"yes", "no"}}
+	if err := p.SaveNew(); err != nil {
+		t.Fatalf("Error inserting post: %s", err)
+	}
+	peer := mmodel.VotePeer()
+	assert.NoError(peer.SaveVote(&model.Vote{PostID: p.ID, UID: "uid1", Option: 0, CreatedAt: time.Now()}))
+	if err := posts.Remove(p); err != nil {
+		t.Fatalf("Could not remove post: %s", err)
+	}
+	assert.NoError(posts.Purge(p))
+	votes, err := peer.GetVotes(p.ID)
+	assert.NoError(err)
+	assert.Len(votes, 0, "Votes are purged with the post")
+}
@@ -0,0 +1,245 @@
+package integrationtest
+
+import (
+	"fmt"
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadWebhookFixtures(s *session.Session) error {
+	db := dynamodb.New(s)
+	for _, table := range []string{"webhook", "webhook_delivery", "incoming_webhook"} {
+		if err := deleteTable(db, table); err != nil {
+			fmt.Printf("Warn: Delete table '%s' failed: %s\n", table, err)
+		}
+	}
+	if err := createWebhookTables(db); err != nil {
+		fmt.Printf("Warn: Create webhook tables failed: %s\n", err)
+	}
+	if err := createIncomingWebhookTable(db); err != nil {
+		fmt.Printf("Warn: Create incoming webhook table failed: %s\n", err)
+	}
+	return nil
+}
+
+func createWebhookTables(db *dynamodb.DynamoDB) error {
+	throughput := &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(1),
+		WriteCapacityUnits: aws.Int64(1),
+	}
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String("webhook"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: throughput,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String("webhook_delivery"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("webhook_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("webhook_id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("created_at"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("status"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("next_attempt_at"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		ProvisionedThroughput: throughput,
+		GlobalSecondaryIndexes: []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("IDIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: throughput,
+			},
+			{
+				IndexName: aws.String("DueIndex"),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("status"),
+						KeyType:       aws.String("HASH"),
+					},
+					{
+						AttributeName: aws.String("next_attempt_at"),
+						KeyType:       aws.String("RANGE"),
+					},
+				},
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String("ALL"),
+				},
+				ProvisionedThroughput: throughput,
+			},
+		},
+	})
+	return err
+}
+
+func TestWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.WebhookPeer()
+
+	h := peer.NewWebhook("https://ci.example.com/hook", "secret", []string{model.EventPostCreated})
+	h.CreatedBy = "uidadmin"
+	if err := peer.SaveNew(h); err != nil {
+		t.Fatalf("Could not save webhook: %s\n", err)
+	}
+	found, err := peer.GetByID(h.ID)
+	if assert.NoError(err) {
+		assert.Equal(h.URL, found.URL)
+		assert.Equal(h.Secret, found.Secret)
+		assert.True(found.Subscribes(model.EventPostCreated))
+	}
+	all, err := peer.GetAll()
+	assert.NoError(err)
+	assert.Len(all, 1)
+
+	first := peer.NewDelivery(h, model.EventPostCreated, []byte(`{"event":"post.created"}`))
+	if err := peer.SaveDelivery(first); err != nil {
+		t.Fatalf("Could not save delivery: %s\n", err)
+	}
+	second := peer.NewDelivery(h, model.EventPostCreated, []byte(`{"event":"post.created"}`))
+	second.NextAttemptAt = time.Now().Add(time.Hour)
+	if err := peer.SaveDelivery(second); err != nil {
+		t.Fatalf("Could not save delivery: %s\n", err)
+	}
+	due, err := peer.GetDueDeliveries(time.Now())
+	if assert.NoError(err) && assert.Len(due, 1) {
+		assert.Equal(first.ID, due[0].ID)
+		assert.Equal(first.Payload, due[0].Payload)
+	}
+	assert.Error(peer.SaveDelivery(first), "Deliveries are not replaced")
+	stale := *due[0]
+	claimedUntil := time.Now().Add(time.Minute)
+	assert.NoError(peer.ClaimDelivery(due[0], claimedUntil))
+	assert.Equal(model.ErrDeliveryClaimed, peer.ClaimDelivery(&stale, time.Now().Add(time.Minute)), "A delivery can only be claimed once")
+
+	first.Status = model.DeliverySucceeded
+	first.Attempts = 1
+	first.ResponseCode = 200
+	first.LastAttemptAt = time.Now()
+	assert.Equal(model.ErrDeliveryClaimed, peer.UpdateDelivery(first, claimedUntil.Add(time.Second)), "Only the holder of the claim saves the outcome")
+	assert.NoError(peer.UpdateDelivery(first, claimedUntil))
+	assert.Equal(model.ErrDeliveryClaimed, peer.UpdateDelivery(first, claimedUntil), "Finished deliveries are not replaced")
+	due, _ = peer.GetDueDeliveries(time.Now().Add(2 * time.Hour))
+	if assert.Len(due, 1, "Finished deliveries are no longer due") {
+		assert.Equal(second.ID, due[0].ID)
+	}
+	d, err := peer.GetDelivery(first.ID)
+	if assert.NoError(err) {
+		assert.Equal(model.DeliverySucceeded, d.Status)
+		assert.Equal(200, d.ResponseCode)
+	}
+	ds, err := peer.GetDeliveries(h.ID, 10)
+	if assert.NoError(err) && assert.Len(ds, 2) {
+		assert.Equal(second.ID, ds[0].ID, "Newest deliveries first")
+	}
+
+	assert.NoError(peer.Remove(h))
+	_, err = peer.GetByID(h.ID)
+	assert.Error(err)
+}
+
+func createIncomingWebhookTable(db *dynamodb.DynamoDB) error {
+	_, err := db.CreateTable(&dynamodb.CreateTableInput{
+		TableName: aws.String("incoming_webhook"),
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("wall_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("wall_id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(1),
+			WriteCapacityUnits: aws.Int64(1),
+		},
+	})
+	return err
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	assert := assert.New(t)
+	setup()
+	peer := mmodel.IncomingWebhookPeer()
+
+	h := peer.NewIncomingWebhook("CI", "uidbot", "0123abcd")
+	h.CreatedBy = "uidadmin"
+	if err := peer.SaveNew(h); err != nil {
+		t.Fatalf("Could not save incoming webhook: %s\n", err)
+	}
+	found, err := peer.GetByID(h.ID)
+	if assert.NoError(err) {
+		assert.Equal("uidbot", found.BotID)
+		assert.Equal("0123abcd", found.TokenHash)
+	}
+	all, err := peer.GetAll()
+	assert.NoError(err)
+	assert.Len(all, 1)
+
+	assert.NoError(peer.Remove(h))
+	_, err = peer.GetByID(h.ID)
+	assert.Error(err, "Removed webhooks are revoked")
+}
@@ -0,0 +1,60 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// DynamoJobPeer defines interaction with the runs of periodic jobs backed by dynamodb.
+//
+// Runs are stored in the table `job` with the hash key `name`, the attribute `last_run_at` holds the time of the last run.
+type DynamoJobPeer struct {
+	model *DynamoModel
+}
+
+// jobKey returns the primary key of the job.
+func jobKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"name": {S: aws.String(name)},
+	}
+}
+
+// LastRun returns the time of the last run of the job, the zero time if it never ran.
+func (jp *DynamoJobPeer) LastRun(name string) (time.Time, error) {
+	resp, err := jp.model.db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String("job"),
+		Key:            jobKey(name),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return nanoValue(resp.Item["last_run_at"]), nil
+}
+
+// ClaimRun records a run of the job at now if its last run is still last.
+// The update is conditional, so only one of the instances claiming the same run succeeds, the others get model.ErrJobClaimed.
+func (jp *DynamoJobPeer) ClaimRun(name string, last, now time.Time) error {
+	params := &dynamodb.UpdateItemInput{
+		TableName:           aws.String("job"),
+		Key:                 jobKey(name),
+		UpdateExpression:    aws.String("SET last_run_at = :now"),
+		ConditionExpression: aws.String("attribute_not_exists(last_run_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": nanoAttribute(now),
+		},
+	}
+	if !last.IsZero() {
+		params.ConditionExpression = aws.String("last_run_at = :last")
+		params.ExpressionAttributeValues[":last"] = nanoAttribute(last)
+	}
+	_, err := jp.model.db.UpdateItem(params)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrJobClaimed
+	}
+	return err
+}
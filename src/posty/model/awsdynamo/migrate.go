@@ -0,0 +1,535 @@
+package awsdynamo
+
+import (
+	"errors"
+	"fmt"
+	"posty/tagging"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var mlog *logrus.Entry
+
+func init() {
+	mlog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoMigrator",
+	})
+}
+
+// Table describes a table and its global secondary indexes. Range is empty for tables with a hash key only.
+// Key types are the dynamodb attribute types, e.g. `S` or `N`.
+type Table struct {
+	Name      string
+	Hash      string
+	HashType  string
+	Range     string
+	RangeType string
+	Indexes   []*Index
+}
+
+// Index describes a global secondary index. Projection is `ALL`, `KEYS_ONLY` or `INCLUDE` with the NonKeyAttributes.
+type Index struct {
+	Name             string
+	Hash             string
+	HashType         string
+	Range            string
+	RangeType        string
+	Projection       string
+	NonKeyAttributes []string
+}
+
+// Schema lists the tables of the model.
+var Schema = []*Table{
+	{Name: "user", Hash: "id", HashType: "S", Indexes: []*Index{
+		{Name: "AuthIDIndex", Hash: "oauthid", HashType: "S", Projection: "INCLUDE", NonKeyAttributes: []string{"id"}},
+		{Name: "HandleIndex", Hash: "handle", HashType: "S", Projection: "ALL"},
+	}},
+	{Name: "post", Hash: "wall_id", HashType: "S", Range: "created_at", RangeType: "N", Indexes: []*Index{
+		{Name: "IDIndex", Hash: "id", HashType: "S", Projection: "INCLUDE", NonKeyAttributes: []string{"wall_id", "created_at", "uid"}},
+		{Name: "PublishIndex", Hash: "wall_id", HashType: "S", Range: "publish_at", RangeType: "N", Projection: "ALL"},
+		{Name: "DueIndex", Hash: "wall_id", HashType: "S", Range: "due_at", RangeType: "N", Projection: "ALL"},
+		{Name: "UnindexedIndex", Hash: "wall_id", HashType: "S", Range: "unindexed_at", RangeType: "N", Projection: "ALL"},
+	}},
+	{Name: "post_term", Hash: "term", HashType: "S", Range: "created_at", RangeType: "N"},
+	{Name: "pin", Hash: "slot", HashType: "N"},
+	{Name: "attachment", Hash: "id", HashType: "S"},
+	{Name: "report", Hash: "post_id", HashType: "S", Range: "uid", RangeType: "S", Indexes: []*Index{
+		{Name: "IDIndex", Hash: "id", HashType: "S", Projection: "ALL"},
+		{Name: "StatusIndex", Hash: "status", HashType: "S", Range: "created_at", RangeType: "N", Projection: "ALL"},
+	}},
+	{Name: "audit", Hash: "wall_id", HashType: "S", Range: "created_at", RangeType: "N"},
+	{Name: "vote", Hash: "post_id", HashType: "S", Range: "uid", RangeType: "S"},
+	{Name: "conversation", Hash: "uid", HashType: "S", Range: "conversation_id", RangeType: "S"},
+	{Name: "message", Hash: "conversation_id", HashType: "S", Range: "created_at", RangeType: "N"},
+	{Name: "notification", Hash: "uid", HashType: "S", Range: "created_at", RangeType: "N", Indexes: []*Index{
+		{Name: "PostIndex", Hash: "post_id", HashType: "S", Projection: "KEYS_ONLY"},
+	}},
+	{Name: "webhook", Hash: "id", HashType: "S"},
+	{Name: "webhook_delivery", Hash: "webhook_id", HashType: "S", Range: "created_at", RangeType: "N", Indexes: []*Index{
+		{Name: "IDIndex", Hash: "id", HashType: "S", Projection: "ALL"},
+		{Name: "DueIndex", Hash: "status", HashType: "S", Range: "next_attempt_at", RangeType: "N", Projection: "ALL"},
+	}},
+	{Name: "incoming_webhook", Hash: "wall_id", HashType: "S", Range: "id", RangeType: "S"},
+	{Name: "job", Hash: "name", HashType: "S"},
+}
+
+// SchemaVersionTable is the table the version of the applied migrations is recorded in.
+const SchemaVersionTable = "schema_version"
+
+// Migration is a versioned step changing the data, e.g. adding attributes to existing items.
+// Migrations should be idempotent, as a migration is applied again if recording its version failed.
+type Migration struct {
+	Version     int
+	Description string
+	Run         func(db dynamodbiface.DynamoDBAPI) error
+}
+
+// Migrator creates and updates the tables of the model and applies the pending migrations.
+// New tables and indexes get the capacity units ReadCapacity and WriteCapacity.
+// The status of tables is polled every PollInterval until they are active or Timeout passed.
+type Migrator struct {
+	Tables        []*Table
+	Migrations    []*Migration
+	ReadCapacity  int64
+	WriteCapacity int64
+	PollInterval  time.Duration
+	Timeout       time.Duration
+	db            dynamodbiface.DynamoDBAPI
+}
+
+// ErrConcurrentMigration is returned if another migrator recorded a schema version in the meantime.
+var ErrConcurrentMigration = errors.New("Schema version was changed by a concurrent migration")
+
+// NewMigratorFromSession creates a migrator of the Schema and Migrations using the aws session.
+func NewMigratorFromSession(s *session.Session) *Migrator {
+	return NewMigrator(dynamodb.New(s))
+}
+
+// NewMigrator creates a migrator of the Schema and Migrations using the client.
+func NewMigrator(db dynamodbiface.DynamoDBAPI) *Migrator {
+	return &Migrator{
+		Tables:        Schema,
+		Migrations:    Migrations,
+		ReadCapacity:  1,
+		WriteCapacity: 1,
+		PollInterval:  time.Second,
+		Timeout:       5 * time.Minute,
+		db:            db,
+	}
+}
+
+// Migrate creates missing tables and indexes, waits until they are active and applies the migrations newer
+// than the recorded schema version in order. The schema versions before and after the migration are returned.
+// Running Migrate again has no effect.
+func (m *Migrator) Migrate() (from, to int, err error) {
+	versionTable := &Table{Name: SchemaVersionTable, Hash: "id", HashType: "S"}
+	for _, t := range append([]*Table{versionTable}, m.Tables...) {
+		if err := m.EnsureTable(t); err != nil {
+			return 0, 0, err
+		}
+	}
+	from, err = m.Version()
+	if err != nil {
+		return 0, 0, err
+	}
+	to = from
+	for _, mig := range m.Migrations {
+		if mig.Version <= to {
+			continue
+		}
+		mlog.Infof("Applying migration %d: %s", mig.Version, mig.Description)
+		if err := mig.Run(m.db); err != nil {
+			return from, to, fmt.Errorf("Migration %d failed: %s", mig.Version, err)
+		}
+		if err := m.setVersion(to, mig.Version); err != nil {
+			return from, to, err
+		}
+		to = mig.Version
+	}
+	return from, to, nil
+}
+
+// Version returns the recorded schema version, 0 if no migration was applied yet.
+func (m *Migrator) Version() (int, error) {
+	resp, err := m.db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(SchemaVersionTable),
+		Key:            map[string]*dynamodb.AttributeValue{"id": {S: aws.String("posty")}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if v := resp.Item["version"]; v != nil && v.N != nil {
+		return strconv.Atoi(*v.N)
+	}
+	return 0, nil
+}
+
+// setVersion records the schema version if the recorded version still is prev.
+func (m *Migrator) setVersion(prev, version int) error {
+	params := &dynamodb.PutItemInput{
+		TableName: aws.String(SchemaVersionTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":         {S: aws.String("posty")},
+			"version":    {N: aws.String(strconv.Itoa(version))},
+			"updated_at": nanoAttribute(time.Now()),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(id) OR version = :prev"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prev": {N: aws.String(strconv.Itoa(prev))},
+		},
+	}
+	if _, err := m.db.PutItem(params); err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+			return ErrConcurrentMigration
+		}
+		return err
+	}
+	return nil
+}
+
+// EnsureTable creates the table if it does not exist, otherwise missing indexes are added one after the other.
+// An error is returned if the key schema of the existing table differs.
+func (m *Migrator) EnsureTable(t *Table) error {
+	resp, err := m.db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(t.Name)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ResourceNotFoundException" {
+		mlog.Infof("Creating table %s", t.Name)
+		if _, err := m.db.CreateTable(m.createTableInput(t)); err != nil {
+			return err
+		}
+		return m.waitActive(t.Name)
+	}
+	if err != nil {
+		return err
+	}
+	if !sameKeys(resp.Table.KeySchema, t.Hash, t.Range) {
+		return fmt.Errorf("Table %s exists with a different key schema", t.Name)
+	}
+	existing := make(map[string]bool)
+	for _, idx := range resp.Table.GlobalSecondaryIndexes {
+		existing[aws.StringValue(idx.IndexName)] = true
+	}
+	if err := m.waitActive(t.Name); err != nil {
+		return err
+	}
+	for _, idx := range t.Indexes {
+		if existing[idx.Name] {
+			continue
+		}
+		mlog.Infof("Creating index %s of table %s", idx.Name, t.Name)
+		params := &dynamodb.UpdateTableInput{
+			TableName:            aws.String(t.Name),
+			AttributeDefinitions: attributeDefinitions(t.Hash, t.HashType, t.Range, t.RangeType, idx.Hash, idx.HashType, idx.Range, idx.RangeType),
+			GlobalSecondaryIndexUpdates: []*dynamodb.GlobalSecondaryIndexUpdate{
+				{Create: &dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName:             aws.String(idx.Name),
+					KeySchema:             keySchema(idx.Hash, idx.Range),
+					Projection:            projection(idx),
+					ProvisionedThroughput: m.throughput(),
+				}},
+			},
+		}
+		if _, err := m.db.UpdateTable(params); err != nil {
+			return err
+		}
+		// dynamodb creates a single index of a table at a time
+		if err := m.waitActive(t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitActive polls the status of the table until the table and all its indexes are active.
+func (m *Migrator) waitActive(name string) error {
+	deadline := time.Now().Add(m.Timeout)
+	for {
+		resp, err := m.db.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+		if err != nil {
+			return err
+		}
+		active := aws.StringValue(resp.Table.TableStatus) == dynamodb.TableStatusActive
+		for _, idx := range resp.Table.GlobalSecondaryIndexes {
+			active = active && aws.StringValue(idx.IndexStatus) == dynamodb.IndexStatusActive
+		}
+		if active {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Table %s is not active after %s", name, m.Timeout)
+		}
+		time.Sleep(m.PollInterval)
+	}
+}
+
+func (m *Migrator) throughput() *dynamodb.ProvisionedThroughput {
+	return &dynamodb.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(m.ReadCapacity),
+		WriteCapacityUnits: aws.Int64(m.WriteCapacity),
+	}
+}
+
+func (m *Migrator) createTableInput(t *Table) *dynamodb.CreateTableInput {
+	keys := []string{t.Hash, t.HashType, t.Range, t.RangeType}
+	params := &dynamodb.CreateTableInput{
+		TableName:             aws.String(t.Name),
+		KeySchema:             keySchema(t.Hash, t.Range),
+		ProvisionedThroughput: m.throughput(),
+	}
+	for _, idx := range t.Indexes {
+		keys = append(keys, idx.Hash, idx.HashType, idx.Range, idx.RangeType)
+		params.GlobalSecondaryIndexes = append(params.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:             aws.String(idx.Name),
+			KeySchema:             keySchema(idx.Hash, idx.Range),
+			Projection:            projection(idx),
+			ProvisionedThroughput: m.throughput(),
+		})
+	}
+	params.AttributeDefinitions = attributeDefinitions(keys...)
+	return params
+}
+
+// keySchema returns the key schema of the hash and, if it is not empty, range attribute.
+func keySchema(hash, rng string) []*dynamodb.KeySchemaElement {
+	ks := []*dynamodb.KeySchemaElement{{AttributeName: aws.String(hash), KeyType: aws.String(dynamodb.KeyTypeHash)}}
+	if rng != "" {
+		ks = append(ks, &dynamodb.KeySchemaElement{AttributeName: aws.String(rng), KeyType: aws.String(dynamodb.KeyTypeRange)})
+	}
+	return ks
+}
+
+// sameKeys returns true if the key schema consists of the hash and range attribute.
+func sameKeys(ks []*dynamodb.KeySchemaElement, hash, rng string) bool {
+	want := map[string]string{hash: dynamodb.KeyTypeHash}
+	if rng != "" {
+		want[rng] = dynamodb.KeyTypeRange
+	}
+	if len(ks) != len(want) {
+		return false
+	}
+	for _, k := range ks {
+		if want[aws.StringValue(k.AttributeName)] != aws.StringValue(k.KeyType) {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeDefinitions defines the pairs of attribute names and types once, empty names are skipped.
+func attributeDefinitions(pairs ...string) []*dynamodb.AttributeDefinition {
+	var defs []*dynamodb.AttributeDefinition
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == "" || seen[pairs[i]] {
+			continue
+		}
+		seen[pairs[i]] = true
+		defs = append(defs, &dynamodb.AttributeDefinition{AttributeName: aws.String(pairs[i]), AttributeType: aws.String(pairs[i+1])})
+	}
+	return defs
+}
+
+func projection(idx *Index) *dynamodb.Projection {
+	p := &dynamodb.Projection{ProjectionType: aws.String(idx.Projection)}
+	if len(idx.NonKeyAttributes) > 0 {
+		p.NonKeyAttributes = aws.StringSlice(idx.NonKeyAttributes)
+	}
+	return p
+}
+
+// Migrations lists the migrations of the model ordered by version.
+var Migrations = []*Migration{
+	{Version: 1, Description: "Add the handle of users", Run: backfillHandles},
+	{Version: 2, Description: "Claim pin slots of pinned posts", Run: backfillPinSlots},
+	{Version: 3, Description: "Mark scheduled posts as due", Run: backfillDuePosts},
+	{Version: 4, Description: "Add the tags and mentions of posts", Run: backfillTerms},
+}
+
+// backfillHandles sets the handle of users created before handles were stored, used by the index `HandleIndex`.
+func backfillHandles(db dynamodbiface.DynamoDBAPI) error {
+	params := &dynamodb.ScanInput{
+		TableName:            aws.String("user"),
+		FilterExpression:     aws.String("attribute_exists(username) AND attribute_not_exists(handle)"),
+		ProjectionExpression: aws.String("id, username"),
+	}
+	var updateErr error
+	err := db.ScanPages(params, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if item["id"] == nil || item["username"] == nil {
+				continue
+			}
+			handle := tagging.Handle(aws.StringValue(item["username"].S))
+			if handle == "" {
+				continue
+			}
+			_, updateErr = db.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:        aws.String("user"),
+				Key:              map[string]*dynamodb.AttributeValue{"id": item["id"]},
+				UpdateExpression: aws.String("SET handle = :handle"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":handle": {S: aws.String(handle)},
+				},
+			})
+			if updateErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// backfillPinSlots claims a slot in the table `pin` for every post pinned before slots limited the number of pinned posts.
+// Posts holding a slot already are skipped, the others claim the lowest free slots.
+func backfillPinSlots(db dynamodbiface.DynamoDBAPI) error {
+	claimed := make(map[string]bool)
+	used := make(map[int]bool)
+	err := db.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String("pin"),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if item["slot"] == nil || item["post_id"] == nil {
+				continue
+			}
+			slot, err := strconv.Atoi(aws.StringValue(item["slot"].N))
+			if err != nil {
+				continue
+			}
+			used[slot] = true
+			claimed[aws.StringValue(item["post_id"].S)] = true
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	var pinned []string
+	err = db.ScanPages(&dynamodb.ScanInput{
+		TableName:            aws.String("post"),
+		FilterExpression:     aws.String("attribute_exists(pinned_at)"),
+		ProjectionExpression: aws.String("id"),
+	}, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if item["id"] != nil && !claimed[aws.StringValue(item["id"].S)] {
+				pinned = append(pinned, aws.StringValue(item["id"].S))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	slot := 0
+	for _, id := range pinned {
+		for used[slot] {
+			slot++
+		}
+		_, err := db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String("pin"),
+			Item: map[string]*dynamodb.AttributeValue{
+				"slot":    {N: aws.String(strconv.Itoa(slot))},
+				"post_id": {S: aws.String(id)},
+			},
+			ConditionExpression: aws.String("attribute_not_exists(slot)"),
+		})
+		if err != nil {
+			return err
+		}
+		used[slot] = true
+	}
+	return nil
+}
+
+// backfillDuePosts adds posts scheduled for later publication to the index `DueIndex`, so they are announced once.
+// Posts published before the migration were announced already.
+func backfillDuePosts(db dynamodbiface.DynamoDBAPI) error {
+	params := &dynamodb.ScanInput{
+		TableName:            aws.String("post"),
+		FilterExpression:     aws.String("publish_at > :now AND attribute_not_exists(due_at) AND attribute_not_exists(announced_at)"),
+		ProjectionExpression: aws.String("wall_id, created_at, publish_at"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": nanoAttribute(time.Now()),
+		},
+	}
+	var updateErr error
+	err := db.ScanPages(params, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if item["wall_id"] == nil || item["created_at"] == nil || item["publish_at"] == nil {
+				continue
+			}
+			_, updateErr = db.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:        aws.String("post"),
+				Key:              map[string]*dynamodb.AttributeValue{"wall_id": item["wall_id"], "created_at": item["created_at"]},
+				UpdateExpression: aws.String("SET due_at = :due"),
+				ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+					":due": item["publish_at"],
+				},
+			})
+			if updateErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// backfillTerms sets the tags and mentions of posts created before messages were parsed.
+// The posts are added to the index `UnindexedIndex`, so RepairTerms writes their entries of the table `post_term`.
+func backfillTerms(db dynamodbiface.DynamoDBAPI) error {
+	params := &dynamodb.ScanInput{
+		TableName:            aws.String("post"),
+		FilterExpression:     aws.String("attribute_exists(message) AND attribute_not_exists(tags) AND attribute_not_exists(mentions)"),
+		ProjectionExpression: aws.String("wall_id, created_at, message"),
+	}
+	var updateErr error
+	err := db.ScanPages(params, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if item["wall_id"] == nil || item["created_at"] == nil || item["message"] == nil {
+				continue
+			}
+			tags, mentions := tagging.Parse(aws.StringValue(item["message"].S))
+			if len(tags) == 0 && len(mentions) == 0 {
+				continue
+			}
+			expr := "SET unindexed_at = created_at"
+			values := make(map[string]*dynamodb.AttributeValue)
+			if len(tags) > 0 {
+				expr += ", tags = :tags"
+				values[":tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice(tags)}
+			}
+			if len(mentions) > 0 {
+				expr += ", mentions = :mentions"
+				values[":mentions"] = &dynamodb.AttributeValue{SS: aws.StringSlice(mentions)}
+			}
+			_, updateErr = db.UpdateItem(&dynamodb.UpdateItemInput{
+				TableName:                 aws.String("post"),
+				Key:                       map[string]*dynamodb.AttributeValue{"wall_id": item["wall_id"], "created_at": item["created_at"]},
+				UpdateExpression:          aws.String(expr),
+				ExpressionAttributeValues: values,
+			})
+			if updateErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
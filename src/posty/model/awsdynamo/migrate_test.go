@@ -0,0 +1,271 @@
+package awsdynamo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMigrateDynamo keeps table descriptions and the schema version in memory, unused methods of the interface panic.
+// New tables and indexes are active after they were described once.
+type mockMigrateDynamo struct {
+	dynamodbiface.DynamoDBAPI
+	tables  map[string]*dynamodb.TableDescription
+	version map[string]*dynamodb.AttributeValue
+	creates []string
+	updates []string
+	users   []map[string]*dynamodb.AttributeValue
+	handles map[string]string
+	// pinned are the posts returned by scanning the post table, pins maps pin slots to post ids
+	pinned []map[string]*dynamodb.AttributeValue
+	pins   map[string]string
+	// scheduled are the posts returned by scanning for scheduled posts, due maps their creation time to the due time
+	scheduled []map[string]*dynamodb.AttributeValue
+	due       map[string]string
+	// untagged are the posts returned by scanning for posts without tags, tagged maps their creation time to the update expression
+	untagged []map[string]*dynamodb.AttributeValue
+	tagged   map[string]string
+}
+
+func newMockMigrateDynamo() *mockMigrateDynamo {
+	return &mockMigrateDynamo{tables: make(map[string]*dynamodb.TableDescription), handles: make(map[string]string), pins: make(map[string]string), due: make(map[string]string), tagged: make(map[string]string)}
+}
+
+func (m *mockMigrateDynamo) DescribeTable(in *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	t, ok := m.tables[*in.TableName]
+	if !ok {
+		return nil, awserr.New("ResourceNotFoundException", "Requested resource not found", nil)
+	}
+	out := &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{
+		TableName:              t.TableName,
+		TableStatus:            aws.String(*t.TableStatus),
+		KeySchema:              t.KeySchema,
+		GlobalSecondaryIndexes: make([]*dynamodb.GlobalSecondaryIndexDescription, len(t.GlobalSecondaryIndexes)),
+	}}
+	for i, idx := range t.GlobalSecondaryIndexes {
+		out.Table.GlobalSecondaryIndexes[i] = &dynamodb.GlobalSecondaryIndexDescription{IndexName: idx.IndexName, IndexStatus: aws.String(*idx.IndexStatus)}
+		idx.IndexStatus = aws.String(dynamodb.IndexStatusActive)
+	}
+	t.TableStatus = aws.String(dynamodb.TableStatusActive)
+	return out, nil
+}
+
+func (m *mockMigrateDynamo) CreateTable(in *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.creates = append(m.creates, *in.TableName)
+	t := &dynamodb.TableDescription{TableName: in.TableName, TableStatus: aws.String(dynamodb.TableStatusCreating), KeySchema: in.KeySchema}
+	for _, idx := range in.GlobalSecondaryIndexes {
+		t.GlobalSecondaryIndexes = append(t.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{IndexName: idx.IndexName, IndexStatus: aws.String(dynamodb.IndexStatusCreating)})
+	}
+	m.tables[*in.TableName] = t
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (m *mockMigrateDynamo) UpdateTable(in *dynamodb.UpdateTableInput) (*dynamodb.UpdateTableOutput, error) {
+	t := m.tables[*in.TableName]
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		m.updates = append(m.updates, *in.TableName+"."+*u.Create.IndexName)
+		t.GlobalSecondaryIndexes = append(t.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndexDescription{IndexName: u.Create.IndexName, IndexStatus: aws.String(dynamodb.IndexStatusCreating)})
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (m *mockMigrateDynamo) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.version}, nil
+}
+
+func (m *mockMigrateDynamo) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if *in.TableName == "pin" {
+		if _, ok := m.pins[*in.Item["slot"].N]; ok {
+			return nil, awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+		}
+		m.pins[*in.Item["slot"].N] = *in.Item["post_id"].S
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	if m.version != nil && *m.version["version"].N != *in.ExpressionAttributeValues[":prev"].N {
+		return nil, awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	}
+	m.version = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockMigrateDynamo) ScanPages(in *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	items := m.users
+	switch *in.TableName {
+	case "post":
+		items = m.pinned
+		if strings.Contains(*in.FilterExpression, "publish_at") {
+			items = m.scheduled
+		}
+		if strings.Contains(*in.FilterExpression, "message") {
+			items = m.untagged
+		}
+	case "pin":
+		items = nil
+		for slot, id := range m.pins {
+			items = append(items, map[string]*dynamodb.AttributeValue{"slot": {N: aws.String(slot)}, "post_id": {S: aws.String(id)}})
+		}
+	}
+	for i, item := range items {
+		if !fn(&dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{item}}, i == len(items)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockMigrateDynamo) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	if *in.TableName == "post" && in.ExpressionAttributeValues[":due"] == nil {
+		m.tagged[*in.Key["created_at"].N] = *in.UpdateExpression
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	if *in.TableName == "post" {
+		m.due[*in.Key["created_at"].N] = *in.ExpressionAttributeValues[":due"].N
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	m.handles[*in.Key["id"].S] = *in.ExpressionAttributeValues[":handle"].S
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func newTestMigrator(db dynamodbiface.DynamoDBAPI) *Migrator {
+	m := NewMigrator(db)
+	m.PollInterval = time.Millisecond
+	return m
+}
+
+func TestMigrate(t *testing.T) {
+	assert := assert.New(t)
+	db := newMockMigrateDynamo()
+	db.users = []map[string]*dynamodb.AttributeValue{
+		{"id": {S: aws.String("uid1")}, "username": {S: aws.String("Jane Doe")}},
+		{"id": {S: aws.String("uid2")}, "username": {S: aws.String("!!!")}},
+	}
+	db.pinned = []map[string]*dynamodb.AttributeValue{
+		{"id": {S: aws.String("pid1")}},
+		{"id": {S: aws.String("pid2")}},
+		{"id": {S: aws.String("pid3")}},
+	}
+	db.pins["1"] = "pid2"
+	db.scheduled = []map[string]*dynamodb.AttributeValue{
+		{"wall_id": {S: aws.String("1")}, "created_at": {N: aws.String("1448272067")}, "publish_at": {N: aws.String("1448300000")}},
+	}
+	db.untagged = []map[string]*dynamodb.AttributeValue{
+		{"wall_id": {S: aws.String("1")}, "created_at": {N: aws.String("1448272001")}, "message": {S: aws.String("Hello #World @jane")}},
+		{"wall_id": {S: aws.String("1")}, "created_at": {N: aws.String("1448272002")}, "message": {S: aws.String("Hello @jane")}},
+		{"wall_id": {S: aws.String("1")}, "created_at": {N: aws.String("1448272003")}, "message": {S: aws.String("Hello")}},
+	}
+	m := newTestMigrator(db)
+	from, to, err := m.Migrate()
+	assert.NoError(err)
+	assert.Equal(0, from)
+	assert.Equal(len(Migrations), to)
+	assert.Len(db.creates, len(Schema)+1, "All tables are created")
+	assert.Equal(SchemaVersionTable, db.creates[0])
+	assert.Empty(db.updates)
+	assert.Equal(map[string]string{"uid1": "janedoe"}, db.handles, "Handles of existing users are added")
+	assert.Equal(map[string]string{"0": "pid1", "1": "pid2", "2": "pid3"}, db.pins, "Pinned posts claim the free slots")
+	assert.Equal(map[string]string{"1448272067": "1448300000"}, db.due, "Scheduled posts are due")
+	assert.Equal(map[string]string{
+		"1448272001": "SET unindexed_at = created_at, tags = :tags, mentions = :mentions",
+		"1448272002": "SET unindexed_at = created_at, mentions = :mentions",
+	}, db.tagged, "Tags and mentions of existing posts are added and indexed later")
+	version, err := m.Version()
+	assert.NoError(err)
+	assert.Equal(to, version)
+
+	db.creates, db.handles = nil, make(map[string]string)
+	delete(db.tables, "post_term")
+	user := db.tables["user"]
+	user.GlobalSecondaryIndexes = user.GlobalSecondaryIndexes[:1]
+	from, to, err = m.Migrate()
+	assert.NoError(err)
+	assert.Equal(len(Migrations), from)
+	assert.Equal(len(Migrations), to)
+	assert.Equal([]string{"post_term"}, db.creates, "Missing tables are created")
+	assert.Equal([]string{"user.HandleIndex"}, db.updates, "Missing indexes are created")
+	assert.Empty(db.handles, "Applied migrations are skipped")
+}
+
+func TestMigrateSteps(t *testing.T) {
+	assert := assert.New(t)
+	db := newMockMigrateDynamo()
+	var applied []int
+	step := func(version int, err error) *Migration {
+		return &Migration{Version: version, Run: func(dynamodbiface.DynamoDBAPI) error {
+			applied = append(applied, version)
+			return err
+		}}
+	}
+	m := newTestMigrator(db)
+	m.Tables = nil
+	m.Migrations = []*Migration{step(1, nil), step(2, nil), step(3, errors.New("Failure")), step(4, nil)}
+	from, to, err := m.Migrate()
+	if assert.Error(err) {
+		assert.Equal("Migration 3 failed: Failure", err.Error())
+	}
+	assert.Equal(0, from)
+	assert.Equal(2, to, "The version of the last successful migration is recorded")
+	assert.Equal([]int{1, 2, 3}, applied)
+
+	applied = nil
+	m.Migrations[2] = step(3, nil)
+	from, to, err = m.Migrate()
+	assert.NoError(err)
+	assert.Equal(2, from)
+	assert.Equal(4, to)
+	assert.Equal([]int{3, 4}, applied, "Migrations continue after the recorded version")
+
+	assert.Equal(ErrConcurrentMigration, m.setVersion(3, 5), "Versions changed concurrently are not overwritten")
+}
+
+func TestEnsureTableKeyMismatch(t *testing.T) {
+	assert := assert.New(t)
+	db := newMockMigrateDynamo()
+	m := newTestMigrator(db)
+	assert.NoError(m.EnsureTable(&Table{Name: "vote", Hash: "post_id", HashType: "S"}))
+	err := m.EnsureTable(&Table{Name: "vote", Hash: "post_id", HashType: "S", Range: "uid", RangeType: "S"})
+	if assert.Error(err) {
+		assert.Equal("Table vote exists with a different key schema", err.Error())
+	}
+}
+
+func TestWaitActiveTimeout(t *testing.T) {
+	assert := assert.New(t)
+	db := newMockMigrateDynamo()
+	db.tables["post"] = &dynamodb.TableDescription{TableName: aws.String("post"), TableStatus: aws.String(dynamodb.TableStatusUpdating)}
+	m := newTestMigrator(db)
+	assert.NoError(m.waitActive("post"))
+
+	m.Timeout = 0
+	db.tables["post"].TableStatus = aws.String(dynamodb.TableStatusUpdating)
+	err := m.waitActive("post")
+	if assert.Error(err) {
+		assert.Equal("Table post is not active after 0s", err.Error())
+	}
+}
+
+func TestCreateTableInput(t *testing.T) {
+	assert := assert.New(t)
+	m := newTestMigrator(nil)
+	m.ReadCapacity, m.WriteCapacity = 5, 2
+	in := m.createTableInput(&Table{Name: "report", Hash: "post_id", HashType: "S", Range: "uid", RangeType: "S", Indexes: []*Index{
+		{Name: "IDIndex", Hash: "id", HashType: "S", Projection: "ALL"},
+		{Name: "StatusIndex", Hash: "status", HashType: "S", Range: "created_at", RangeType: "N", Projection: "INCLUDE", NonKeyAttributes: []string{"uid"}},
+	}})
+	var defs []string
+	for _, d := range in.AttributeDefinitions {
+		defs = append(defs, *d.AttributeName+":"+*d.AttributeType)
+	}
+	assert.Equal([]string{"post_id:S", "uid:S", "id:S", "status:S", "created_at:N"}, defs, "Attributes are defined once")
+	assert.Equal(int64(5), *in.ProvisionedThroughput.ReadCapacityUnits)
+	assert.Equal(int64(2), *in.GlobalSecondaryIndexes[1].ProvisionedThroughput.WriteCapacityUnits)
+	assert.Len(in.GlobalSecondaryIndexes[1].KeySchema, 2)
+	assert.Equal([]*string{aws.String("uid")}, in.GlobalSecondaryIndexes[1].Projection.NonKeyAttributes)
+	assert.Nil(in.GlobalSecondaryIndexes[0].Projection.NonKeyAttributes)
+}
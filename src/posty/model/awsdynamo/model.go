@@ -3,15 +3,30 @@ package awsdynamo
 import (
 	"posty/model"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
+const (
+	// batchGetMaxKeys is the maximum amount of keys dynamodb accepts in a single BatchGetItem request.
+	batchGetMaxKeys = 100
+	// batchWriteMaxItems is the maximum amount of items dynamodb accepts in a single BatchWriteItem request.
+	batchWriteMaxItems = 25
+)
+
 // DynamoModel implements `posty/model` for the dynamodb
 type DynamoModel struct {
-	db       *dynamodb.DynamoDB
-	userPeer *DynamoUserPeer
-	postPeer *DynamoPostPeer
+	db         *dynamodb.DynamoDB
+	userPeer   *DynamoUserPeer
+	postPeer   *DynamoPostPeer
+	reportPeer *DynamoReportPeer
+	votePeer   *DynamoVotePeer
+	convPeer   *DynamoConversationPeer
+	notifPeer  *DynamoNotificationPeer
+	hookPeer   *DynamoWebhookPeer
+	inHookPeer *DynamoIncomingWebhookPeer
+	jobPeer    *DynamoJobPeer
 }
 
 // NewModelFromSession creates an new Model from an aws session.
@@ -26,6 +41,27 @@ func NewModelFromSession(s *session.Session) *DynamoModel {
 	model.postPeer = &DynamoPostPeer{
 		model: model,
 	}
+	model.reportPeer = &DynamoReportPeer{
+		model: model,
+	}
+	model.votePeer = &DynamoVotePeer{
+		model: model,
+	}
+	model.convPeer = &DynamoConversationPeer{
+		model: model,
+	}
+	model.notifPeer = &DynamoNotificationPeer{
+		model: model,
+	}
+	model.hookPeer = &DynamoWebhookPeer{
+		model: model,
+	}
+	model.inHookPeer = &DynamoIncomingWebhookPeer{
+		model: model,
+	}
+	model.jobPeer = &DynamoJobPeer{
+		model: model,
+	}
 	return model
 }
 
@@ -38,3 +74,109 @@ func (m *DynamoModel) UserPeer() model.UserPeer {
 func (m *DynamoModel) PostPeer() model.PostPeer {
 	return model.PostPeer(m.postPeer)
 }
+
+// ReportPeer returns the dynamodb ReportPeer associated with the model
+func (m *DynamoModel) ReportPeer() model.ReportPeer {
+	return m.reportPeer
+}
+
+// VotePeer returns the dynamodb VotePeer associated with the model
+func (m *DynamoModel) VotePeer() model.VotePeer {
+	return m.votePeer
+}
+
+// ConversationPeer returns the dynamodb ConversationPeer associated with the model
+func (m *DynamoModel) ConversationPeer() model.ConversationPeer {
+	return m.convPeer
+}
+
+// NotificationPeer returns the dynamodb NotificationPeer associated with the model
+func (m *DynamoModel) NotificationPeer() model.NotificationPeer {
+	return m.notifPeer
+}
+
+// WebhookPeer returns the dynamodb WebhookPeer associated with the model
+func (m *DynamoModel) WebhookPeer() model.WebhookPeer {
+	return m.hookPeer
+}
+
+// IncomingWebhookPeer returns the dynamodb IncomingWebhookPeer associated with the model
+func (m *DynamoModel) IncomingWebhookPeer() model.IncomingWebhookPeer {
+	return m.inHookPeer
+}
+
+// JobPeer returns the dynamodb JobPeer associated with the model
+func (m *DynamoModel) JobPeer() model.JobPeer {
+	return m.jobPeer
+}
+
+// query returns the items of all result pages of the query.
+func (m *DynamoModel) query(params *dynamodb.QueryInput) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	for {
+		resp, err := m.db.Query(params)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Items...)
+		if resp.LastEvaluatedKey == nil {
+			return items, nil
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// batchGet fetches all given keys from the table using as many BatchGetItem requests as needed.
+// Unprocessed keys are requested again until all keys are processed.
+func (m *DynamoModel) batchGet(table string, keys []map[string]*dynamodb.AttributeValue, consistent bool) ([]map[string]*dynamodb.AttributeValue, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > batchGetMaxKeys {
+			n = batchGetMaxKeys
+		}
+		requestItems := map[string]*dynamodb.KeysAndAttributes{
+			table: {
+				Keys:           keys[:n],
+				ConsistentRead: aws.Bool(consistent),
+			},
+		}
+		for len(requestItems) > 0 {
+			resp, err := m.db.BatchGetItem(&dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, resp.Responses[table]...)
+			requestItems = resp.UnprocessedKeys
+		}
+		keys = keys[n:]
+	}
+	return items, nil
+}
+
+// batchWrite executes all write requests on the table using as many BatchWriteItem requests as needed.
+// Unprocessed items are written again until all requests are processed.
+func (m *DynamoModel) batchWrite(table string, reqs []*dynamodb.WriteRequest) error {
+	for len(reqs) > 0 {
+		n := len(reqs)
+		if n > batchWriteMaxItems {
+			n = batchWriteMaxItems
+		}
+		requestItems := map[string][]*dynamodb.WriteRequest{
+			table: reqs[:n],
+		}
+		for len(requestItems) > 0 {
+			resp, err := m.db.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+				RequestItems: requestItems,
+			})
+			if err != nil {
+				return err
+			}
+			requestItems = resp.UnprocessedItems
+		}
+		reqs = reqs[n:]
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package awsdynamo
+
+import (
+	"errors"
+	"posty/model"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	uuid "github.com/satori/go.uuid"
+)
+
+var nlog *logrus.Entry
+
+func init() {
+	nlog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoNotificationPeer",
+	})
+}
+
+// DynamoNotificationPeer defines interaction with notifications backed by dynamodb.
+//
+// Notifications are stored in the table `notification` with the hash key `uid` of the recipient and the range key `created_at`.
+// The global secondary index `PostIndex` on `post_id` is used to remove the notifications of a post.
+type DynamoNotificationPeer struct {
+	model *DynamoModel
+}
+
+// NewNotification creates a new unread notification of the user. The notification is not inserted into the database until it is saved.
+func (np *DynamoNotificationPeer) NewNotification(uid, kind, actorID, postID string) *model.Notification {
+	return &model.Notification{
+		ID:        uuid.NewV4().String(),
+		UID:       uid,
+		Kind:      kind,
+		ActorID:   actorID,
+		PostID:    postID,
+		CreatedAt: time.Now(),
+	}
+}
+
+// SaveNew saves a new notification.
+func (np *DynamoNotificationPeer) SaveNew(n *model.Notification) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalNotification(n, items); err != nil {
+		return err
+	}
+	_, err := np.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:      items,
+		TableName: aws.String("notification"),
+	})
+	return err
+}
+
+// GetByUser returns up to limit notifications of the user, newest first.
+func (np *DynamoNotificationPeer) GetByUser(uid string, limit int) ([]*model.Notification, error) {
+	resp, err := np.model.db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("notification"),
+		KeyConditionExpression: aws.String("uid = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {
+				S: aws.String(uid),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ns := make([]*model.Notification, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		n := &model.Notification{}
+		if err := unmarshalNotification(n, item); err != nil {
+			nlog.Warnf("Error unmarshal notification: %#v", item)
+			continue
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+// CountUnread returns the number of all unread notifications of the user, not only of the most recent ones.
+func (np *DynamoNotificationPeer) CountUnread(uid string) (int, error) {
+	params := &dynamodb.QueryInput{
+		TableName:              aws.String("notification"),
+		KeyConditionExpression: aws.String("uid = :uid"),
+		FilterExpression:       aws.String("attribute_not_exists(read_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {
+				S: aws.String(uid),
+			},
+		},
+		Select: aws.String(dynamodb.SelectCount),
+	}
+	count := 0
+	for {
+		resp, err := np.model.db.Query(params)
+		if err != nil {
+			return 0, err
+		}
+		count += int(aws.Int64Value(resp.Count))
+		if resp.LastEvaluatedKey == nil {
+			return count, nil
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// MarkRead marks the notification as read now.
+func (np *DynamoNotificationPeer) MarkRead(n *model.Notification) error {
+	now := time.Now()
+	_, err := np.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("notification"),
+		Key:                 notificationKey(n),
+		UpdateExpression:    aws.String("SET read_at = :ts"),
+		ConditionExpression: aws.String("attribute_exists(uid)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":ts": nanoAttribute(now),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	n.ReadAt = now
+	return nil
+}
+
+// RemoveByPost removes all notifications concerning the post.
+func (np *DynamoNotificationPeer) RemoveByPost(postID string) error {
+	items, err := np.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("notification"),
+		IndexName:              aws.String("PostIndex"),
+		KeyConditionExpression: aws.String("post_id = :pid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	reqs := make([]*dynamodb.WriteRequest, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"uid":        item["uid"],
+					"created_at": item["created_at"],
+				},
+			},
+		})
+	}
+	return np.model.batchWrite("notification", reqs)
+}
+
+// notificationKey returns the primary key of the notification.
+func notificationKey(n *model.Notification) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"uid":        {S: aws.String(n.UID)},
+		"created_at": nanoAttribute(n.CreatedAt),
+	}
+}
+
+// marshalNotification builds an aws AttributeValue data structure for the given notification.
+func marshalNotification(n *model.Notification, items map[string]*dynamodb.AttributeValue) error {
+	if n == nil {
+		return errors.New("Undefined notification")
+	}
+	items["uid"] = &dynamodb.AttributeValue{S: aws.String(n.UID)}
+	items["created_at"] = nanoAttribute(n.CreatedAt)
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(n.ID)}
+	items["kind"] = &dynamodb.AttributeValue{S: aws.String(n.Kind)}
+	items["actor_id"] = &dynamodb.AttributeValue{S: aws.String(n.ActorID)}
+	if n.PostID != "" {
+		items["post_id"] = &dynamodb.AttributeValue{S: aws.String(n.PostID)}
+	}
+	if !n.ReadAt.IsZero() {
+		items["read_at"] = nanoAttribute(n.ReadAt)
+	}
+	return nil
+}
+
+// unmarshalNotification unmarshals a notification from the aws datastructure to `model.Notification`.
+func unmarshalNotification(n *model.Notification, items map[string]*dynamodb.AttributeValue) error {
+	if n == nil {
+		return errors.New("Undefined notification")
+	}
+	if items["uid"] == nil || items["created_at"] == nil {
+		return errors.New("Missing key attributes")
+	}
+	n.UID = aws.StringValue(items["uid"].S)
+	n.CreatedAt = nanoValue(items["created_at"])
+	if v, ok := items["id"]; ok {
+		n.ID = aws.StringValue(v.S)
+	}
+	if v, ok := items["kind"]; ok {
+		n.Kind = aws.StringValue(v.S)
+	}
+	if v, ok := items["actor_id"]; ok {
+		n.ActorID = aws.StringValue(v.S)
+	}
+	if v, ok := items["post_id"]; ok {
+		n.PostID = aws.StringValue(v.S)
+	}
+	n.ReadAt = nanoValue(items["read_at"])
+	return nil
+}
@@ -0,0 +1,40 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalNotification(t *testing.T) {
+	assert := assert.New(t)
+	n := &model.Notification{
+		ID:        "nid123",
+		UID:       "uid123",
+		Kind:      model.NotificationMention,
+		ActorID:   "uid456",
+		PostID:    "pid123",
+		CreatedAt: time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalNotification(n, m); err != nil {
+		t.Fatalf("Error marshalling notification: %s", err)
+	}
+	_, ok := m["read_at"]
+	assert.False(ok, "Unread notifications must omit read_at")
+	var u model.Notification
+	if err := unmarshalNotification(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling notification: %s", err)
+	}
+	assert.Equal(n, &u)
+
+	n.ReadAt = time.Unix(1448272068, 0)
+	assert.NoError(marshalNotification(n, m))
+	assert.NoError(unmarshalNotification(&u, m))
+	assert.False(u.Unread())
+	assert.Equal(notificationKey(n), map[string]*dynamodb.AttributeValue{"uid": m["uid"], "created_at": m["created_at"]})
+	assert.Error(unmarshalNotification(&u, map[string]*dynamodb.AttributeValue{}))
+}
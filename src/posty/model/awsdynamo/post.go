@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"posty/model"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/satori/go.uuid"
 )
@@ -22,12 +24,49 @@ func init() {
 }
 
 // DynamoPostPeer defines interaction with the post data backed by dynamodb.
+//
+// Posts scheduled for later publication are found by the global secondary index `PublishIndex` (hash key `wall_id`, range key `publish_at`).
+// Until they are announced, they also have the attribute `due_at` which is the range key of the sparse index `DueIndex`.
+// Posts whose tags and mentions are not written to the table `post_term` yet have the attribute `unindexed_at`,
+// the range key of the sparse index `UnindexedIndex`.
+// The posts uploaded attachments are attached to are recorded in the table `attachment` with the hash key `id`.
 type DynamoPostPeer struct {
 	model *DynamoModel
 }
 
-// GetByID fetches the post identified by the id primary hash key. Otherwise an error is returned.
+// errDeleted is returned when fetching a removed post by id.
+var errDeleted = errors.New("Post is deleted")
+
+// errNotDeleted is returned when fetching a post which was not removed as removed post.
+var errNotDeleted = errors.New("Post is not deleted")
+
+// GetByID fetches the post identified by the id primary hash key. Removed posts are not returned.
+// If the post does not exist model.ErrNotFound is returned.
 func (pp *DynamoPostPeer) GetByID(id string) (*model.Post, error) {
+	p, err := pp.getByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !p.DeletedAt.IsZero() {
+		return nil, errDeleted
+	}
+	return p, nil
+}
+
+// GetDeletedByID fetches the removed post identified by the id. If the post was not removed an error is returned.
+func (pp *DynamoPostPeer) GetDeletedByID(id string) (*model.Post, error) {
+	p, err := pp.getByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.DeletedAt.IsZero() {
+		return nil, errNotDeleted
+	}
+	return p, nil
+}
+
+// getByID fetches the post identified by the id including removed posts.
+func (pp *DynamoPostPeer) getByID(id string) (*model.Post, error) {
 	params := &dynamodb.QueryInput{
 		TableName:              aws.String("post"),
 		IndexName:              aws.String("IDIndex"),
@@ -43,6 +82,9 @@ func (pp *DynamoPostPeer) GetByID(id string) (*model.Post, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(respQuery.Items) == 0 {
+		return nil, model.ErrNotFound
+	}
 	// Check if exactly one result
 	if respQuery.Count == nil || *respQuery.Count != 1 || len(respQuery.Items) != 1 {
 		return nil, fmt.Errorf("Results: %d len(%d)", respQuery.Count, respQuery.Items)
@@ -101,21 +143,350 @@ func (pp *DynamoPostPeer) SaveNew(p *model.Post) error {
 	if err != nil {
 		return err
 	}
+	if p.PublishAt.After(time.Now()) {
+		items["due_at"] = nanoAttribute(p.PublishAt)
+	}
+	if len(terms(p)) > 0 {
+		items["unindexed_at"] = nanoAttribute(p.CreatedAt)
+	}
 	params := &dynamodb.PutItemInput{
-		Item:      items,
-		TableName: aws.String("post"),
+		Item:                items,
+		TableName:           aws.String("post"),
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
 	}
 	_, err = pp.model.db.PutItem(params)
 
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAlreadyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	// The post is saved, so failing to index it must not make the client retry and create a duplicate.
+	// It stays in the index `UnindexedIndex` until RepairTerms indexes it.
+	if _, ok := items["unindexed_at"]; ok {
+		if err := pp.indexTerms(p); err != nil {
+			plog.Warnf("Could not index tags and mentions of post %s: %s", p.ID, err)
+		}
+	}
+	return nil
+}
+
+// RepairTerms indexes the tags and mentions of posts found by the sparse index `UnindexedIndex`.
+// These are posts whose index entries could not be written when they were saved and posts tagged by a migration.
+func (pp *DynamoPostPeer) RepairTerms() (int, error) {
+	items, err := pp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("post"),
+		IndexName:              aws.String("UnindexedIndex"),
+		KeyConditionExpression: aws.String("wall_id = :wid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, item := range items {
+		p := &model.Post{}
+		if err := unmarshalPost(p, item); err != nil {
+			plog.Warnf("Error unmarshal post: %#v", item)
+			continue
+		}
+		if err := pp.indexTerms(p); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// indexTerms writes the index entries of the post and removes it from the index `UnindexedIndex`.
+// Writing the entries again is harmless, so it is retried until it succeeds.
+func (pp *DynamoPostPeer) indexTerms(p *model.Post) error {
+	if err := pp.saveTerms(p); err != nil {
+		return err
+	}
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("REMOVE unindexed_at"),
+		ConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		// purged in the meantime
+		return nil
+	}
+	return err
+}
+
+// SetPreview stores the link preview of the post. Only the preview is written, other attributes changed in the meantime are kept.
+// If the post does not exist anymore or was removed an error is returned.
+func (pp *DynamoPostPeer) SetPreview(p *model.Post, pv *model.Preview) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("SET preview = :preview"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+			":preview": {
+				M: marshalPreview(pv),
+			},
+		},
+	})
 	if err != nil {
 		return err
 	}
+	p.Preview = pv
+	return nil
+}
 
+// SetHidden hides or shows the post. Only the flag is written, other attributes changed in the meantime are kept.
+// If the post does not exist anymore or was removed an error is returned.
+func (pp *DynamoPostPeer) SetHidden(p *model.Post, hidden bool) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("SET hidden = :hidden"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+			":hidden": {
+				BOOL: aws.Bool(hidden),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	p.Hidden = hidden
 	return nil
 }
 
-// Remove deletes a post from the database. The implementation choses a valid identification of the post given by the data.
+// Pin pins the post to the top of the wall, at most max posts can be pinned at the same time.
+// Every pinned post claims one of the slots 0 to max-1 of the table `pin` (hash key `slot` of type number) by a conditional put,
+// so moderators pinning posts concurrently can not exceed the limit. ErrPinLimitReached is returned if all slots are claimed.
+// Slots are released when the post is unpinned or purged, removed and expired posts keep their slot until then.
+func (pp *DynamoPostPeer) Pin(p *model.Post, max int) error {
+	slots, err := pp.pinSlots(p.ID)
+	if err != nil {
+		return err
+	}
+	claimed := -1
+	if len(slots) == 0 {
+		for slot := 0; slot < max && claimed < 0; slot++ {
+			_, err := pp.model.db.PutItem(&dynamodb.PutItemInput{
+				TableName: aws.String("pin"),
+				Item: map[string]*dynamodb.AttributeValue{
+					"slot":    {N: aws.String(strconv.Itoa(slot))},
+					"post_id": {S: aws.String(p.ID)},
+				},
+				ConditionExpression: aws.String("attribute_not_exists(slot)"),
+			})
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			claimed = slot
+		}
+		if claimed < 0 {
+			return model.ErrPinLimitReached
+		}
+	}
+	pinnedAt := time.Now()
+	_, err = pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("SET pinned_at = :pinned_at"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+			":pinned_at": nanoAttribute(pinnedAt),
+		},
+	})
+	if err != nil {
+		if claimed >= 0 {
+			if rerr := pp.releasePinSlot(p.ID, claimed); rerr != nil {
+				plog.Warnf("Could not release pin slot %d of post %s: %s", claimed, p.ID, rerr)
+			}
+		}
+		return err
+	}
+	p.PinnedAt = pinnedAt
+	return nil
+}
+
+// Unpin unpins the post and releases its pin slot.
+func (pp *DynamoPostPeer) Unpin(p *model.Post) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("REMOVE pinned_at"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	p.PinnedAt = time.Time{}
+	return pp.releasePinSlots(p.ID)
+}
+
+// pinSlots returns the pin slots claimed by the post. The table `pin` holds only a few items, it is scanned.
+func (pp *DynamoPostPeer) pinSlots(postID string) ([]int, error) {
+	resp, err := pp.model.db.Scan(&dynamodb.ScanInput{
+		TableName:        aws.String("pin"),
+		FilterExpression: aws.String("post_id = :pid"),
+		ConsistentRead:   aws.Bool(true),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]int, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		slot, err := strconv.Atoi(aws.StringValue(item["slot"].N))
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// releasePinSlots releases all pin slots claimed by the post.
+func (pp *DynamoPostPeer) releasePinSlots(postID string) error {
+	slots, err := pp.pinSlots(postID)
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		if err := pp.releasePinSlot(postID, slot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePinSlot releases the pin slot if it is still claimed by the post.
+func (pp *DynamoPostPeer) releasePinSlot(postID string, slot int) error {
+	_, err := pp.model.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("pin"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"slot": {N: aws.String(strconv.Itoa(slot))},
+		},
+		ConditionExpression: aws.String("post_id = :pid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return nil
+	}
+	return err
+}
+
+// postKey returns the primary key of the post.
+func postKey(p *model.Post) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"wall_id": {
+			S: aws.String("1"),
+		},
+		"created_at": {
+			N: aws.String(strconv.FormatInt(p.CreatedAt.UnixNano(), 10)),
+		},
+	}
+}
+
+// Remove marks a post as deleted, it is kept until it is purged and can be restored until then.
+// The deletion time is stored as `deleted_at`. Removing a post which does not exist or was already removed fails.
 func (pp *DynamoPostPeer) Remove(p *model.Post) error {
+	deletedAt := time.Now()
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("SET deleted_at = :deleted_at"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+			":deleted_at": {
+				N: aws.String(strconv.FormatInt(deletedAt.UnixNano(), 10)),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	p.DeletedAt = deletedAt
+	return nil
+}
+
+// Restore reverts the removal of a post. Restoring a post which was not removed fails.
+func (pp *DynamoPostPeer) Restore(p *model.Post) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("REMOVE deleted_at"),
+		ConditionExpression: aws.String("id = :id AND attribute_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	p.DeletedAt = time.Time{}
+	return nil
+}
+
+// GetDeletedPosts returns all posts removed before the given time, newest first.
+func (pp *DynamoPostPeer) GetDeletedPosts(before time.Time) ([]*model.Post, error) {
+	posts, err := pp.getPosts(model.PostQuery{}, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.Post, 0, len(posts))
+	for _, p := range posts {
+		if p.DeletedAt.Before(before) {
+			res = append(res, p)
+		}
+	}
+	return res, nil
+}
+
+// Purge permanently deletes a post, its index entries, its pin slot, its votes and its reports from the database.
+func (pp *DynamoPostPeer) Purge(p *model.Post) error {
 	params := &dynamodb.DeleteItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
 			"wall_id": {
@@ -131,17 +502,84 @@ func (pp *DynamoPostPeer) Remove(p *model.Post) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := pp.removeTerms(p); err != nil {
+		return err
+	}
+	if !p.PinnedAt.IsZero() {
+		if err := pp.releasePinSlots(p.ID); err != nil {
+			return err
+		}
+	}
+	if p.Poll != nil {
+		if err := pp.removeByPost("vote", p.ID); err != nil {
+			return err
+		}
+	}
+	if len(p.Attachments) > 0 {
+		reqs := make([]*dynamodb.WriteRequest, 0, len(p.Attachments))
+		for _, a := range p.Attachments {
+			reqs = append(reqs, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{
+					Key: attachmentKey(a.ID),
+				},
+			})
+		}
+		if err := pp.model.batchWrite("attachment", reqs); err != nil {
+			return err
+		}
+	}
+	// reports of purged posts could never be decided, the audit log still references them by id
+	return pp.removeByPost("report", p.ID)
 }
 
-// getposts queries all posts from the database using Exclusive start key for pagination. If an error occurred in those iterations no result set is returned.
-func (pp *DynamoPostPeer) getPosts(lastKey map[string]*dynamodb.AttributeValue) ([]*model.Post, error) {
+// removeByPost deletes the items of the post from a table with the hash key `post_id` and the range key `uid`, e.g. votes and reports.
+func (pp *DynamoPostPeer) removeByPost(table, postID string) error {
+	items, err := pp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		KeyConditionExpression: aws.String("post_id = :pid"),
+		ProjectionExpression:   aws.String("post_id, uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	reqs := make([]*dynamodb.WriteRequest, 0, len(items))
+	for _, item := range items {
+		reqs = append(reqs, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: item,
+			},
+		})
+	}
+	return pp.model.batchWrite(table, reqs)
+}
+
+// getPosts queries all posts matching the query from the database using Exclusive start key for pagination. If an error occurred in those iterations no result set is returned.
+// The creation timestamp boundaries are mapped to the key condition, the author, deletion and publication to a filter expression.
+// Only removed posts are returned if deleted is set, otherwise removed posts are excluded.
+// Posts which are not published yet or expired are excluded unless deleted is set, only scheduled or expired posts are returned if the query asks for them.
+func (pp *DynamoPostPeer) getPosts(q model.PostQuery, deleted bool, lastKey map[string]*dynamodb.AttributeValue) ([]*model.Post, error) {
+	since := int64(0)
+	if !q.Since.IsZero() {
+		since = q.Since.UnixNano()
+	}
+	until := time.Now().Add(24 * time.Hour).UnixNano()
+	if !q.Until.IsZero() && q.Until.UnixNano() < until {
+		until = q.Until.UnixNano()
+	}
 	params := &dynamodb.QueryInput{
 		TableName:              aws.String("post"),
-		KeyConditionExpression: aws.String("wall_id = :wid AND created_at <= :now"),
+		KeyConditionExpression: aws.String("wall_id = :wid AND created_at BETWEEN :since AND :until"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":now": {
-				N: aws.String(strconv.FormatInt(time.Now().Add(24*time.Hour).UnixNano(), 10)),
+			":since": {
+				N: aws.String(strconv.FormatInt(since, 10)),
+			},
+			":until": {
+				N: aws.String(strconv.FormatInt(until, 10)),
 			},
 			":wid": {
 				S: aws.String("1"),
@@ -149,6 +587,30 @@ func (pp *DynamoPostPeer) getPosts(lastKey map[string]*dynamodb.AttributeValue)
 		},
 		ScanIndexForward: aws.Bool(false),
 	}
+	switch {
+	case deleted:
+		params.FilterExpression = aws.String("attribute_exists(deleted_at)")
+	case q.Scheduled:
+		params.FilterExpression = aws.String("attribute_not_exists(deleted_at) AND publish_at > :now")
+		params.ExpressionAttributeValues[":now"] = nanoAttribute(time.Now())
+	case q.Expired:
+		params.FilterExpression = aws.String("attribute_not_exists(deleted_at) AND expires_at <= :now")
+		params.ExpressionAttributeValues[":now"] = nanoAttribute(time.Now())
+	default:
+		params.FilterExpression = aws.String("attribute_not_exists(deleted_at)" +
+			" AND (attribute_not_exists(publish_at) OR publish_at <= :now)" +
+			" AND (attribute_not_exists(expires_at) OR expires_at > :now)")
+		params.ExpressionAttributeValues[":now"] = nanoAttribute(time.Now())
+	}
+	if q.Pinned {
+		params.FilterExpression = aws.String(*params.FilterExpression + " AND attribute_exists(pinned_at)")
+	}
+	if q.Author != "" {
+		params.FilterExpression = aws.String(*params.FilterExpression + " AND uid = :uid")
+		params.ExpressionAttributeValues[":uid"] = &dynamodb.AttributeValue{
+			S: aws.String(q.Author),
+		}
+	}
 	if lastKey != nil {
 		params.ExclusiveStartKey = lastKey
 	}
@@ -168,7 +630,7 @@ func (pp *DynamoPostPeer) getPosts(lastKey map[string]*dynamodb.AttributeValue)
 		posts = append(posts, p)
 	}
 	if resp.LastEvaluatedKey != nil {
-		newposts, err := pp.getPosts(resp.LastEvaluatedKey)
+		newposts, err := pp.getPosts(q, deleted, resp.LastEvaluatedKey)
 		if err != nil {
 			return nil, err
 		}
@@ -177,9 +639,246 @@ func (pp *DynamoPostPeer) getPosts(lastKey map[string]*dynamodb.AttributeValue)
 	return posts, nil
 }
 
+// GetPage returns up to limit posts created after the post whose creation timestamp in nanoseconds is after, oldest first.
+// Removed, scheduled and expired posts are included. The timestamp to pass as after to get the next page is returned along with the posts,
+// it is empty after the last page.
+func (pp *DynamoPostPeer) GetPage(after string, limit int) ([]*model.Post, string, error) {
+	params := &dynamodb.QueryInput{
+		TableName:              aws.String("post"),
+		KeyConditionExpression: aws.String("wall_id = :wid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+		},
+		ScanIndexForward: aws.Bool(true),
+		Limit:            aws.Int64(int64(limit)),
+	}
+	if after != "" {
+		params.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"wall_id":    {S: aws.String("1")},
+			"created_at": {N: aws.String(after)},
+		}
+	}
+	resp, err := pp.model.db.Query(params)
+	if err != nil {
+		return nil, "", err
+	}
+	posts := make([]*model.Post, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		p := &model.Post{}
+		if err := unmarshalPost(p, item); err != nil {
+			plog.Warnf("Error unmarshal post: %#v", item)
+			continue
+		}
+		posts = append(posts, p)
+	}
+	next := ""
+	if v, ok := resp.LastEvaluatedKey["created_at"]; ok {
+		next = aws.StringValue(v.N)
+	}
+	return posts, next, nil
+}
+
 // GetPosts returns all posts from the database.
 func (pp *DynamoPostPeer) GetPosts() ([]*model.Post, error) {
-	return pp.getPosts(nil)
+	return pp.getPosts(model.PostQuery{}, false, nil)
+}
+
+// QueryPosts returns all posts from the database matching the query, newest first.
+func (pp *DynamoPostPeer) QueryPosts(q model.PostQuery) ([]*model.Post, error) {
+	return pp.getPosts(q, false, nil)
+}
+
+// GetPublished returns the scheduled posts published after since until until using the index `PublishIndex`.
+// Removed posts and posts expired at until are excluded.
+func (pp *DynamoPostPeer) GetPublished(since, until time.Time) ([]*model.Post, error) {
+	return pp.queryScheduled(&dynamodb.QueryInput{
+		TableName:              aws.String("post"),
+		IndexName:              aws.String("PublishIndex"),
+		KeyConditionExpression: aws.String("wall_id = :wid AND publish_at BETWEEN :since AND :until"),
+		FilterExpression:       aws.String("attribute_not_exists(deleted_at) AND (attribute_not_exists(expires_at) OR expires_at > :until)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+			":since": nanoAttribute(since.Add(time.Nanosecond)),
+			":until": nanoAttribute(until),
+		},
+	})
+}
+
+// GetDue returns the scheduled posts published until now which were not announced yet using the sparse index `DueIndex`.
+// Removed posts are excluded.
+func (pp *DynamoPostPeer) GetDue(now time.Time) ([]*model.Post, error) {
+	return pp.queryScheduled(&dynamodb.QueryInput{
+		TableName:              aws.String("post"),
+		IndexName:              aws.String("DueIndex"),
+		KeyConditionExpression: aws.String("wall_id = :wid AND due_at <= :now"),
+		FilterExpression:       aws.String("attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+			":now": nanoAttribute(now),
+		},
+	})
+}
+
+// queryScheduled returns the posts of all result pages of a query of the scheduled posts.
+func (pp *DynamoPostPeer) queryScheduled(params *dynamodb.QueryInput) ([]*model.Post, error) {
+	items, err := pp.model.query(params)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]*model.Post, 0, len(items))
+	for _, item := range items {
+		p := &model.Post{}
+		if err := unmarshalPost(p, item); err != nil {
+			plog.Warnf("Error unmarshal post: %#v", item)
+			continue
+		}
+		posts = append(posts, p)
+	}
+	return posts, nil
+}
+
+// MarkAnnounced records the time the scheduled post was announced as `announced_at` and removes it from the index `DueIndex`.
+// The update is conditional, so instances announcing due posts concurrently announce every post once.
+// ErrAlreadyAnnounced is returned if the post was announced or removed in the meantime.
+func (pp *DynamoPostPeer) MarkAnnounced(p *model.Post) error {
+	_, err := pp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("post"),
+		Key:                 postKey(p),
+		UpdateExpression:    aws.String("SET announced_at = :now REMOVE due_at"),
+		ConditionExpression: aws.String("id = :id AND attribute_not_exists(announced_at) AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(p.ID),
+			},
+			":now": nanoAttribute(time.Now()),
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAlreadyAnnounced
+	}
+	return err
+}
+
+// GetPostsByTag returns all posts tagged with the lowercase tag, newest first.
+func (pp *DynamoPostPeer) GetPostsByTag(tag string) ([]*model.Post, error) {
+	return pp.getPostsByTerm("#" + tag)
+}
+
+// GetPostsByMention returns all posts mentioning the lowercase handle, newest first.
+func (pp *DynamoPostPeer) GetPostsByMention(handle string) ([]*model.Post, error) {
+	return pp.getPostsByTerm("@" + handle)
+}
+
+// terms returns the index terms of a post. Tags are prefixed with `#`, mentions with `@`.
+func terms(p *model.Post) []string {
+	terms := make([]string, 0, len(p.Tags)+len(p.Mentions))
+	for _, t := range p.Tags {
+		terms = append(terms, "#"+t)
+	}
+	for _, m := range p.Mentions {
+		terms = append(terms, "@"+m)
+	}
+	return terms
+}
+
+// saveTerms adds the post to the `post_term` index table for each of its tags and mentions.
+// The table uses the term as hash key and the creation timestamp as range key and references the post by its primary key.
+func (pp *DynamoPostPeer) saveTerms(p *model.Post) error {
+	var reqs []*dynamodb.WriteRequest
+	createdAt := strconv.FormatInt(p.CreatedAt.UnixNano(), 10)
+	for _, term := range terms(p) {
+		reqs = append(reqs, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: map[string]*dynamodb.AttributeValue{
+					"term":       {S: aws.String(term)},
+					"created_at": {N: aws.String(createdAt)},
+					"wall_id":    {S: aws.String("1")},
+					"post_id":    {S: aws.String(p.ID)},
+				},
+			},
+		})
+	}
+	return pp.model.batchWrite("post_term", reqs)
+}
+
+// removeTerms removes the post from the `post_term` index table.
+func (pp *DynamoPostPeer) removeTerms(p *model.Post) error {
+	var reqs []*dynamodb.WriteRequest
+	createdAt := strconv.FormatInt(p.CreatedAt.UnixNano(), 10)
+	for _, term := range terms(p) {
+		reqs = append(reqs, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					"term":       {S: aws.String(term)},
+					"created_at": {N: aws.String(createdAt)},
+				},
+			},
+		})
+	}
+	return pp.model.batchWrite("post_term", reqs)
+}
+
+// getPostsByTerm queries the `post_term` index table for the term and fetches the referenced posts using batch requests.
+// Removed, scheduled and expired posts are skipped.
+func (pp *DynamoPostPeer) getPostsByTerm(term string) ([]*model.Post, error) {
+	var keys []map[string]*dynamodb.AttributeValue
+	params := &dynamodb.QueryInput{
+		TableName:              aws.String("post_term"),
+		KeyConditionExpression: aws.String("term = :term"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":term": {
+				S: aws.String(term),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	for {
+		resp, err := pp.model.db.Query(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Items {
+			if item["wall_id"] == nil || item["created_at"] == nil {
+				plog.Warnf("Invalid term index entry: %#v", item)
+				continue
+			}
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"wall_id":    item["wall_id"],
+				"created_at": item["created_at"],
+			})
+		}
+		if resp.LastEvaluatedKey == nil {
+			break
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+	items, err := pp.model.batchGet("post", keys, false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	posts := make([]*model.Post, 0, len(items))
+	for _, item := range items {
+		p := &model.Post{
+			Peer: pp,
+		}
+		if err := unmarshalPost(p, item); err != nil {
+			plog.Warnf("Error unmarshal post: %#v", item)
+			continue
+		}
+		if !p.DeletedAt.IsZero() || !p.Live(now) {
+			continue
+		}
+		posts = append(posts, p)
+	}
+	sort.Sort(model.ByCreatedAtDESC(posts))
+	return posts, nil
 }
 
 // unmarshalPost unmarshals a post from the aws datastructure to `model.Post`.
@@ -202,9 +901,44 @@ func unmarshalPost(p *model.Post, items map[string]*dynamodb.AttributeValue) err
 			p.Message = *v.S
 		}
 	}
-	if v, ok := items["username"]; ok {
+	if v, ok := items["format"]; ok {
 		if v.S != nil {
-			p.Username = *v.S
+			p.Format = *v.S
+		}
+	}
+	if v, ok := items["tags"]; ok {
+		p.Tags = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := items["mentions"]; ok {
+		p.Mentions = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := items["attachments"]; ok {
+		p.Attachments = make([]model.Attachment, 0, len(v.L))
+		for _, av := range v.L {
+			p.Attachments = append(p.Attachments, unmarshalAttachment(av.M))
+		}
+	}
+	if v, ok := items["preview"]; ok && v.M != nil {
+		p.Preview = unmarshalPreview(v.M)
+	}
+	if v, ok := items["poll"]; ok && v.M != nil {
+		p.Poll = unmarshalPoll(v.M)
+	}
+	if v, ok := items["quarantined"]; ok {
+		p.Quarantined = aws.BoolValue(v.BOOL)
+	}
+	if v, ok := items["hidden"]; ok {
+		p.Hidden = aws.BoolValue(v.BOOL)
+	}
+	p.PublishAt = nanoValue(items["publish_at"])
+	p.ExpiresAt = nanoValue(items["expires_at"])
+	p.PinnedAt = nanoValue(items["pinned_at"])
+	if v, ok := items["deleted_at"]; ok && v.N != nil {
+		ts64, err := strconv.ParseInt(*v.N, 10, 64)
+		if err == nil {
+			p.DeletedAt = time.Unix(0, ts64)
+		} else {
+			plog.Warnf("Unable to parse 'deleted_at' on %s: %s", items["id"], err)
 		}
 	}
 	if v, ok := items["created_at"]; ok {
@@ -230,10 +964,164 @@ func marshalPost(p *model.Post, items map[string]*dynamodb.AttributeValue) error
 	if p.Message != "" {
 		items["message"] = &dynamodb.AttributeValue{S: aws.String(p.Message)}
 	}
-	if p.Username != "" {
-		items["username"] = &dynamodb.AttributeValue{S: aws.String(p.Username)}
+	if p.Format != "" && p.Format != model.FormatPlain {
+		items["format"] = &dynamodb.AttributeValue{S: aws.String(p.Format)}
+	}
+	if len(p.Tags) > 0 {
+		items["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice(p.Tags)}
+	}
+	if len(p.Mentions) > 0 {
+		items["mentions"] = &dynamodb.AttributeValue{SS: aws.StringSlice(p.Mentions)}
+	}
+	if p.Preview != nil {
+		items["preview"] = &dynamodb.AttributeValue{M: marshalPreview(p.Preview)}
+	}
+	if p.Poll != nil {
+		items["poll"] = &dynamodb.AttributeValue{M: marshalPoll(p.Poll)}
+	}
+	if p.Quarantined {
+		items["quarantined"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	if p.Hidden {
+		items["hidden"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	if len(p.Attachments) > 0 {
+		l := make([]*dynamodb.AttributeValue, len(p.Attachments))
+		for i, a := range p.Attachments {
+			l[i] = &dynamodb.AttributeValue{M: marshalAttachment(a)}
+		}
+		items["attachments"] = &dynamodb.AttributeValue{L: l}
 	}
 	items["created_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(p.CreatedAt.UnixNano(), 10))}
+	if !p.PublishAt.IsZero() {
+		items["publish_at"] = nanoAttribute(p.PublishAt)
+	}
+	if !p.ExpiresAt.IsZero() {
+		items["expires_at"] = nanoAttribute(p.ExpiresAt)
+	}
+	if !p.PinnedAt.IsZero() {
+		items["pinned_at"] = nanoAttribute(p.PinnedAt)
+	}
+	if !p.DeletedAt.IsZero() {
+		items["deleted_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(p.DeletedAt.UnixNano(), 10))}
+	}
 
 	return nil
 }
+
+// marshalAttachment builds the map attribute of an attachment stored as part of a post.
+func marshalAttachment(a model.Attachment) map[string]*dynamodb.AttributeValue {
+	m := map[string]*dynamodb.AttributeValue{
+		"id":           {S: aws.String(a.ID)},
+		"content_type": {S: aws.String(a.ContentType)},
+		"size":         {N: aws.String(strconv.FormatInt(a.Size, 10))},
+		"thumbnail":    {BOOL: aws.Bool(a.Thumbnail)},
+	}
+	if a.Name != "" {
+		m["name"] = &dynamodb.AttributeValue{S: aws.String(a.Name)}
+	}
+	if a.Width > 0 && a.Height > 0 {
+		m["width"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(a.Width))}
+		m["height"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(a.Height))}
+	}
+	return m
+}
+
+// unmarshalAttachment builds an attachment from the map attribute.
+func unmarshalAttachment(m map[string]*dynamodb.AttributeValue) model.Attachment {
+	str := func(k string) string {
+		if v, ok := m[k]; ok {
+			return aws.StringValue(v.S)
+		}
+		return ""
+	}
+	num := func(k string) int64 {
+		if v, ok := m[k]; ok && v.N != nil {
+			n, err := strconv.ParseInt(*v.N, 10, 64)
+			if err != nil {
+				plog.Warnf("Unable to parse attachment '%s': %s", k, err)
+			}
+			return n
+		}
+		return 0
+	}
+	a := model.Attachment{
+		ID:          str("id"),
+		Name:        str("name"),
+		ContentType: str("content_type"),
+		Size:        num("size"),
+		Width:       int(num("width")),
+		Height:      int(num("height")),
+	}
+	if v, ok := m["thumbnail"]; ok {
+		a.Thumbnail = aws.BoolValue(v.BOOL)
+	}
+	return a
+}
+
+// marshalPreview builds the map attribute of a link preview, empty strings are omitted.
+func marshalPreview(p *model.Preview) map[string]*dynamodb.AttributeValue {
+	m := make(map[string]*dynamodb.AttributeValue)
+	for k, v := range map[string]string{
+		"url":         p.URL,
+		"title":       p.Title,
+		"description": p.Description,
+		"image_url":   p.ImageURL,
+	} {
+		if v != "" {
+			m[k] = &dynamodb.AttributeValue{S: aws.String(v)}
+		}
+	}
+	return m
+}
+
+// unmarshalPreview builds a link preview from the map attribute.
+func unmarshalPreview(m map[string]*dynamodb.AttributeValue) *model.Preview {
+	str := func(k string) string {
+		if v, ok := m[k]; ok {
+			return aws.StringValue(v.S)
+		}
+		return ""
+	}
+	return &model.Preview{
+		URL:         str("url"),
+		Title:       str("title"),
+		Description: str("description"),
+		ImageURL:    str("image_url"),
+	}
+}
+
+// marshalPoll builds the map attribute of a poll. Options are stored as list to keep their order.
+func marshalPoll(p *model.Poll) map[string]*dynamodb.AttributeValue {
+	options := make([]*dynamodb.AttributeValue, len(p.Options))
+	for i, o := range p.Options {
+		options[i] = &dynamodb.AttributeValue{S: aws.String(o)}
+	}
+	m := map[string]*dynamodb.AttributeValue{
+		"options": {L: options},
+	}
+	if !p.ClosesAt.IsZero() {
+		m["closes_at"] = nanoAttribute(p.ClosesAt)
+	}
+	if p.HideResults {
+		m["hide_results"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	}
+	return m
+}
+
+// unmarshalPoll converts the map attribute of a poll.
+func unmarshalPoll(m map[string]*dynamodb.AttributeValue) *model.Poll {
+	p := &model.Poll{
+		ClosesAt: nanoValue(m["closes_at"]),
+	}
+	if v, ok := m["options"]; ok {
+		p.Options = make([]string, 0, len(v.L))
+		for _, o := range v.L {
+			p.Options = append(p.Options, aws.StringValue(o.S))
+		}
+	}
+	if v, ok := m["hide_results"]; ok {
+		p.HideResults = aws.BoolValue(v.BOOL)
+	}
+	return p
+}
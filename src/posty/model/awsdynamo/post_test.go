@@ -18,7 +18,9 @@ func TestUnmarshalPost(t *testing.T) {
 	items["id"] = &dynamodb.AttributeValue{S: aws.String("pid123")}
 	items["uid"] = &dynamodb.AttributeValue{S: aws.String("uid123")}
 	items["message"] = &dynamodb.AttributeValue{S: aws.String("message")}
-	items["username"] = &dynamodb.AttributeValue{S: aws.String("username")}
+	items["format"] = &dynamodb.AttributeValue{S: aws.String("markdown")}
+	items["tags"] = &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"go", "fun"})}
+	items["mentions"] = &dynamodb.AttributeValue{SS: aws.StringSlice([]string{"user"})}
 	items["created_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(ts.UnixNano(), 10))}
 	var p model.Post
 	err := unmarshalPost(&p, items)
@@ -28,7 +30,9 @@ func TestUnmarshalPost(t *testing.T) {
 	assert.Equal("pid123", p.ID)
 	assert.Equal("uid123", p.UID)
 	assert.Equal("message", p.Message)
-	assert.Equal("username", p.Username)
+	assert.Equal(model.FormatMarkdown, p.Format)
+	assert.Equal([]string{"go", "fun"}, p.Tags)
+	assert.Equal([]string{"user"}, p.Mentions)
 	assert.Equal(ts.UnixNano(), p.CreatedAt.UnixNano())
 }
 
@@ -38,7 +42,8 @@ func TestMarshalPost(t *testing.T) {
 	u.ID = "pid123"
 	u.UID = "uid123"
 	u.Message = "message"
-	u.Username = "username"
+	u.Format = model.FormatMarkdown
+	u.Tags = []string{"go", "fun"}
 	u.CreatedAt = time.Now().Add(-time.Hour)
 	m := make(map[string]*dynamodb.AttributeValue)
 	err := marshalPost(u, m)
@@ -75,6 +80,153 @@ func TestMarshalPost(t *testing.T) {
 	assert.Equal(u.ID, awsValueString("id"))
 	assert.Equal(u.UID, awsValueString("uid"))
 	assert.Equal(u.Message, awsValueString("message"))
-	assert.Equal(u.Username, awsValueString("username"))
+	assert.Equal(u.Format, awsValueString("format"))
+	assert.Equal([]string{"go", "fun"}, aws.StringValueSlice(m["tags"].SS))
+	_, ok := m["mentions"]
+	assert.False(ok, "Empty sets must be omitted")
+	_, ok = m["username"]
+	assert.False(ok, "Username must not be denormalised into posts")
 	assert.Equal(u.CreatedAt.UnixNano(), awsValueInt64("created_at"))
 }
+
+func TestMarshalPostAttachments(t *testing.T) {
+	assert := assert.New(t)
+	p := &model.Post{
+		ID: "pid123",
+		Attachments: []model.Attachment{
+			{ID: "a1", Name: "cat.png", ContentType: "image/png", Size: 1234, Width: 640, Height: 480, Thumbnail: true},
+			{ID: "a2", ContentType: "application/pdf", Size: 99},
+		},
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalPost(p, m); err != nil {
+		t.Fatalf("Error marshalling post: %s", err)
+	}
+	assert.Len(m["attachments"].L, 2)
+	_, ok := m["attachments"].L[1].M["width"]
+	assert.False(ok, "Dimensions of non images must be omitted")
+
+	var u model.Post
+	if err := unmarshalPost(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling post: %s", err)
+	}
+	assert.Equal(p.Attachments, u.Attachments)
+
+	m = make(map[string]*dynamodb.AttributeValue)
+	marshalPost(&model.Post{}, m)
+	_, ok = m["attachments"]
+	assert.False(ok, "Empty attachments must be omitted")
+}
+
+func TestMarshalPostPreview(t *testing.T) {
+	assert := assert.New(t)
+	p := &model.Post{
+		ID: "pid123",
+		Preview: &model.Preview{
+			URL:   "https://example.com",
+			Title: "Example",
+		},
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalPost(p, m); err != nil {
+		t.Fatalf("Error marshalling post: %s", err)
+	}
+	assert.Len(m["preview"].M, 2, "Empty fields must be omitted")
+	var u model.Post
+	if err := unmarshalPost(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling post: %s", err)
+	}
+	assert.Equal(p.Preview, u.Preview)
+}
+
+func TestMarshalPostPoll(t *testing.T) {
+	assert := assert.New(t)
+	p := &model.Post{
+		ID: "pid123",
+		Poll: &model.Poll{
+			Options:     []string{"yes", "no", "maybe"},
+			ClosesAt:    time.Unix(1448272067, 0),
+			HideResults: true,
+		},
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalPost(p, m))
+	assert.Len(m["poll"].M["options"].L, 3)
+	var u model.Post
+	assert.NoError(unmarshalPost(&u, m))
+	assert.Equal(p.Poll, u.Poll)
+
+	m = make(map[string]*dynamodb.AttributeValue)
+	p.Poll = &model.Poll{Options: []string{"yes", "no"}}
+	assert.NoError(marshalPost(p, m))
+	assert.Len(m["poll"].M, 1, "Empty fields must be omitted")
+	u = model.Post{}
+	assert.NoError(unmarshalPost(&u, m))
+	assert.Equal(p.Poll, u.Poll)
+}
+
+func TestMarshalPostDeleted(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalPost(&model.Post{ID: "pid123"}, m))
+	_, ok := m["deleted_at"]
+	assert.False(ok, "Posts which are not removed must omit the attribute")
+	p := &model.Post{ID: "pid123", DeletedAt: time.Unix(1448272067, 123)}
+	assert.NoError(marshalPost(p, m))
+	assert.Equal("1448272067000000123", *m["deleted_at"].N)
+	var u model.Post
+	assert.NoError(unmarshalPost(&u, m))
+	assert.Equal(p.DeletedAt, u.DeletedAt)
+}
+
+func TestMarshalPostSchedule(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalPost(&model.Post{ID: "pid123"}, m))
+	_, ok := m["publish_at"]
+	assert.False(ok, "Posts published on creation must omit the attribute")
+	_, ok = m["expires_at"]
+	assert.False(ok, "Posts which do not expire must omit the attribute")
+	p := &model.Post{ID: "pid123", PublishAt: time.Unix(1448272067, 0), ExpiresAt: time.Unix(1448275667, 0)}
+	assert.NoError(marshalPost(p, m))
+	assert.Equal("1448272067000000000", *m["publish_at"].N)
+	assert.Equal("1448275667000000000", *m["expires_at"].N)
+	var u model.Post
+	assert.NoError(unmarshalPost(&u, m))
+	assert.Equal(p.PublishAt, u.PublishAt)
+	assert.Equal(p.ExpiresAt, u.ExpiresAt)
+}
+
+func TestMarshalPostPinned(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalPost(&model.Post{ID: "pid123"}, m))
+	_, ok := m["pinned_at"]
+	assert.False(ok, "Posts which are not pinned must omit the attribute")
+	p := &model.Post{ID: "pid123", PinnedAt: time.Unix(1448272067, 0)}
+	assert.NoError(marshalPost(p, m))
+	assert.Equal("1448272067000000000", *m["pinned_at"].N)
+	var u model.Post
+	assert.NoError(unmarshalPost(&u, m))
+	assert.Equal(p.PinnedAt, u.PinnedAt)
+}
+
+func TestMarshalPostQuarantined(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalPost(&model.Post{ID: "pid123"}, m))
+	_, ok := m["quarantined"]
+	assert.False(ok, "Posts which are not quarantined must omit the attribute")
+	assert.NoError(marshalPost(&model.Post{ID: "pid123", Quarantined: true}, m))
+	var u model.Post
+	assert.NoError(unmarshalPost(&u, m))
+	assert.True(u.Quarantined)
+}
+
+func TestTerms(t *testing.T) {
+	p := &model.Post{
+		Tags:     []string{"go", "fun"},
+		Mentions: []string{"user"},
+	}
+	assert.Equal(t, []string{"#go", "#fun", "@user"}, terms(p))
+}
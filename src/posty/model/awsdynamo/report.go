@@ -0,0 +1,328 @@
+package awsdynamo
+
+import (
+	"errors"
+	"fmt"
+	"posty/model"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	uuid "github.com/satori/go.uuid"
+)
+
+var rlog *logrus.Entry
+
+func init() {
+	rlog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoReportPeer",
+	})
+}
+
+// DynamoReportPeer defines interaction with the report data backed by dynamodb.
+//
+// Reports are stored in the table `report` with the hash key `post_id` and the range key `uid`, which allows a single report per user and post.
+// The global secondary indexes `IDIndex` (hash key `id`) and `StatusIndex` (hash key `status`, range key `created_at`) project all attributes.
+// Audit entries are stored in the table `audit` with the hash key `wall_id` and the range key `created_at`.
+type DynamoReportPeer struct {
+	model *DynamoModel
+}
+
+// GetByID fetches the report identified by the id. Otherwise an error is returned.
+func (rp *DynamoReportPeer) GetByID(id string) (*model.Report, error) {
+	resp, err := rp.model.db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("report"),
+		IndexName:              aws.String("IDIndex"),
+		KeyConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(id),
+			},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) != 1 {
+		return nil, fmt.Errorf("Results: len(%d)", len(resp.Items))
+	}
+	r := &model.Report{
+		Peer: rp,
+	}
+	if err := unmarshalReport(r, resp.Items[0]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetByPost returns all reports of the post, oldest first.
+func (rp *DynamoReportPeer) GetByPost(postID string) ([]*model.Report, error) {
+	items, err := rp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("report"),
+		KeyConditionExpression: aws.String("post_id = :pid"),
+		ConsistentRead:         aws.Bool(true),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	reports := rp.unmarshalReports(items)
+	sort.Sort(model.ByCreatedAtASC(reports))
+	return reports, nil
+}
+
+// GetOpen returns all open reports, oldest first.
+func (rp *DynamoReportPeer) GetOpen() ([]*model.Report, error) {
+	items, err := rp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("report"),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(model.ReportOpen),
+			},
+		},
+		ScanIndexForward: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rp.unmarshalReports(items), nil
+}
+
+func (rp *DynamoReportPeer) unmarshalReports(items []map[string]*dynamodb.AttributeValue) []*model.Report {
+	reports := make([]*model.Report, 0, len(items))
+	for _, item := range items {
+		r := &model.Report{
+			Peer: rp,
+		}
+		if err := unmarshalReport(r, item); err != nil {
+			rlog.Warnf("Error unmarshal report: %#v", item)
+			continue
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// NewReport creates a new open report of the post by the user. The report is not inserted into the database until it is saved.
+func (rp *DynamoReportPeer) NewReport(postID, uid string) *model.Report {
+	return &model.Report{
+		Peer:      rp,
+		ID:        uuid.NewV4().String(),
+		PostID:    postID,
+		UID:       uid,
+		Status:    model.ReportOpen,
+		CreatedAt: time.Now(),
+	}
+}
+
+// SaveNew saves a newly created report. If the user already reported the post model.ErrAlreadyReported is returned.
+func (rp *DynamoReportPeer) SaveNew(r *model.Report) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalReport(r, items); err != nil {
+		return err
+	}
+	_, err := rp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("report"),
+		ConditionExpression: aws.String("attribute_not_exists(post_id)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAlreadyReported
+	}
+	return err
+}
+
+// Update saves the status of an existing report. If the report does not exist anymore an error is returned.
+func (rp *DynamoReportPeer) Update(r *model.Report) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalReport(r, items); err != nil {
+		return err
+	}
+	_, err := rp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("report"),
+		ConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(r.ID),
+			},
+		},
+	})
+	return err
+}
+
+// NewAuditEntry creates a new audit entry of a decision taken now. The entry is not inserted into the database until it is saved.
+func (rp *DynamoReportPeer) NewAuditEntry(moderatorID, action, postID string) *model.AuditEntry {
+	return &model.AuditEntry{
+		ID:          uuid.NewV4().String(),
+		ModeratorID: moderatorID,
+		Action:      action,
+		PostID:      postID,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// SaveAuditEntry appends the entry to the audit log.
+func (rp *DynamoReportPeer) SaveAuditEntry(e *model.AuditEntry) error {
+	items := map[string]*dynamodb.AttributeValue{
+		"wall_id": {S: aws.String("1")},
+	}
+	if err := marshalAuditEntry(e, items); err != nil {
+		return err
+	}
+	_, err := rp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:      items,
+		TableName: aws.String("audit"),
+	})
+	return err
+}
+
+// GetAuditEntries returns the audit log, newest first.
+func (rp *DynamoReportPeer) GetAuditEntries() ([]*model.AuditEntry, error) {
+	items, err := rp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("audit"),
+		KeyConditionExpression: aws.String("wall_id = :wid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String("1"),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*model.AuditEntry, 0, len(items))
+	for _, item := range items {
+		e := &model.AuditEntry{}
+		if err := unmarshalAuditEntry(e, item); err != nil {
+			rlog.Warnf("Error unmarshal audit entry: %#v", item)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// nanoAttribute converts a timestamp to a number attribute in nanoseconds.
+func nanoAttribute(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano(), 10))}
+}
+
+// nanoValue parses a number attribute in nanoseconds, the zero time is returned for missing or invalid attributes.
+func nanoValue(v *dynamodb.AttributeValue) time.Time {
+	if v == nil || v.N == nil {
+		return time.Time{}
+	}
+	ts64, err := strconv.ParseInt(*v.N, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ts64)
+}
+
+// marshalReport builds an aws AttributeValue data structure for the given report.
+func marshalReport(r *model.Report, items map[string]*dynamodb.AttributeValue) error {
+	if r == nil {
+		return errors.New("Undefined report")
+	}
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(r.ID)}
+	items["post_id"] = &dynamodb.AttributeValue{S: aws.String(r.PostID)}
+	items["uid"] = &dynamodb.AttributeValue{S: aws.String(r.UID)}
+	items["status"] = &dynamodb.AttributeValue{S: aws.String(r.Status)}
+	if r.Reason != "" {
+		items["reason"] = &dynamodb.AttributeValue{S: aws.String(r.Reason)}
+	}
+	if r.ResolvedBy != "" {
+		items["resolved_by"] = &dynamodb.AttributeValue{S: aws.String(r.ResolvedBy)}
+	}
+	if !r.ResolvedAt.IsZero() {
+		items["resolved_at"] = nanoAttribute(r.ResolvedAt)
+	}
+	items["created_at"] = nanoAttribute(r.CreatedAt)
+	return nil
+}
+
+// unmarshalReport builds a report from the given aws dataset.
+func unmarshalReport(r *model.Report, items map[string]*dynamodb.AttributeValue) error {
+	if r == nil {
+		return errors.New("Undefined report")
+	}
+	if items["id"] == nil || items["post_id"] == nil {
+		return errors.New("Fields 'id' or 'post_id' nil")
+	}
+	r.ID = aws.StringValue(items["id"].S)
+	r.PostID = aws.StringValue(items["post_id"].S)
+	if v, ok := items["uid"]; ok {
+		r.UID = aws.StringValue(v.S)
+	}
+	if v, ok := items["status"]; ok {
+		r.Status = aws.StringValue(v.S)
+	}
+	if v, ok := items["reason"]; ok {
+		r.Reason = aws.StringValue(v.S)
+	}
+	if v, ok := items["resolved_by"]; ok {
+		r.ResolvedBy = aws.StringValue(v.S)
+	}
+	r.ResolvedAt = nanoValue(items["resolved_at"])
+	r.CreatedAt = nanoValue(items["created_at"])
+	return nil
+}
+
+// marshalAuditEntry builds an aws AttributeValue data structure for the given audit entry.
+func marshalAuditEntry(e *model.AuditEntry, items map[string]*dynamodb.AttributeValue) error {
+	if e == nil {
+		return errors.New("Undefined audit entry")
+	}
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(e.ID)}
+	items["action"] = &dynamodb.AttributeValue{S: aws.String(e.Action)}
+	items["post_id"] = &dynamodb.AttributeValue{S: aws.String(e.PostID)}
+	if e.ModeratorID != "" {
+		items["moderator_id"] = &dynamodb.AttributeValue{S: aws.String(e.ModeratorID)}
+	}
+	if len(e.ReportIDs) > 0 {
+		items["report_ids"] = &dynamodb.AttributeValue{SS: aws.StringSlice(e.ReportIDs)}
+	}
+	items["created_at"] = nanoAttribute(e.CreatedAt)
+	return nil
+}
+
+// unmarshalAuditEntry builds an audit entry from the given aws dataset.
+func unmarshalAuditEntry(e *model.AuditEntry, items map[string]*dynamodb.AttributeValue) error {
+	if e == nil {
+		return errors.New("Undefined audit entry")
+	}
+	if items["id"] == nil {
+		return errors.New("Field 'id' nil")
+	}
+	e.ID = aws.StringValue(items["id"].S)
+	if v, ok := items["action"]; ok {
+		e.Action = aws.StringValue(v.S)
+	}
+	if v, ok := items["post_id"]; ok {
+		e.PostID = aws.StringValue(v.S)
+	}
+	if v, ok := items["moderator_id"]; ok {
+		e.ModeratorID = aws.StringValue(v.S)
+	}
+	if v, ok := items["report_ids"]; ok {
+		e.ReportIDs = aws.StringValueSlice(v.SS)
+	}
+	e.CreatedAt = nanoValue(items["created_at"])
+	return nil
+}
@@ -0,0 +1,63 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalReport(t *testing.T) {
+	assert := assert.New(t)
+	r := &model.Report{
+		ID:         "rid123",
+		PostID:     "pid123",
+		UID:        "uid123",
+		Reason:     "spam",
+		Status:     model.ReportResolved,
+		ResolvedBy: "uid456",
+		ResolvedAt: time.Unix(1448272067, 123),
+		CreatedAt:  time.Unix(1448272000, 456),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalReport(r, m); err != nil {
+		t.Fatalf("Error marshalling report: %s", err)
+	}
+	assert.Equal("1448272000000000456", *m["created_at"].N)
+	var u model.Report
+	if err := unmarshalReport(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling report: %s", err)
+	}
+	assert.Equal(r, &u)
+
+	m = make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalReport(&model.Report{ID: "rid123", PostID: "pid123", Status: model.ReportOpen}, m))
+	for _, attr := range []string{"reason", "resolved_by", "resolved_at"} {
+		_, ok := m[attr]
+		assert.False(ok, "Empty attribute %s must be omitted", attr)
+	}
+	assert.Error(unmarshalReport(&u, map[string]*dynamodb.AttributeValue{}))
+}
+
+func TestMarshalAuditEntry(t *testing.T) {
+	assert := assert.New(t)
+	e := &model.AuditEntry{
+		ID:          "aid123",
+		ModeratorID: "uid456",
+		Action:      model.ActionResolve,
+		PostID:      "pid123",
+		ReportIDs:   []string{"rid1", "rid2"},
+		CreatedAt:   time.Unix(1448272067, 789),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalAuditEntry(e, m); err != nil {
+		t.Fatalf("Error marshalling audit entry: %s", err)
+	}
+	var u model.AuditEntry
+	if err := unmarshalAuditEntry(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling audit entry: %s", err)
+	}
+	assert.Equal(e, &u)
+}
@@ -4,11 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"posty/model"
+	"posty/tagging"
 	"strconv"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	uuid "github.com/satori/go.uuid"
 )
@@ -26,7 +28,7 @@ type DynamoUserPeer struct {
 	model *DynamoModel
 }
 
-// GetByID fetches a single user identified by the unique id. Otherwise an error is returned.
+// GetByID fetches a single user identified by the unique id. If it does not exist model.ErrNotFound is returned.
 func (p *DynamoUserPeer) GetByID(ID string) (*model.User, error) {
 	params := &dynamodb.GetItemInput{
 		Key: map[string]*dynamodb.AttributeValue{
@@ -42,6 +44,9 @@ func (p *DynamoUserPeer) GetByID(ID string) (*model.User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.Item == nil {
+		return nil, model.ErrNotFound
+	}
 
 	u := &model.User{
 		Peer: p,
@@ -53,6 +58,72 @@ func (p *DynamoUserPeer) GetByID(ID string) (*model.User, error) {
 	return u, nil
 }
 
+// GetByIDs fetches all users identified by the given ids using batch requests.
+// Unknown users are omitted, the order of the result is not defined.
+func (p *DynamoUserPeer) GetByIDs(ids []string) ([]*model.User, error) {
+	seen := make(map[string]bool, len(ids))
+	keys := make([]map[string]*dynamodb.AttributeValue, 0, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		keys = append(keys, map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		})
+	}
+	items, err := p.model.batchGet("user", keys, true)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*model.User, 0, len(items))
+	for _, item := range items {
+		u := &model.User{
+			Peer: p,
+		}
+		if err := unmarshalUser(u, item); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// GetPage returns up to limit users following the user id after, the order of the result is not defined.
+// The id to pass as after to get the next page is returned along with the users, it is empty after the last page.
+func (p *DynamoUserPeer) GetPage(after string, limit int) ([]*model.User, string, error) {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String("user"),
+		Limit:     aws.Int64(int64(limit)),
+	}
+	if after != "" {
+		params.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(after)},
+		}
+	}
+	resp, err := p.model.db.Scan(params)
+	if err != nil {
+		return nil, "", err
+	}
+	users := make([]*model.User, 0, len(resp.Items))
+	for _, item := range resp.Items {
+		u := &model.User{
+			Peer: p,
+		}
+		if err := unmarshalUser(u, item); err != nil {
+			return nil, "", err
+		}
+		users = append(users, u)
+	}
+	next := ""
+	if v, ok := resp.LastEvaluatedKey["id"]; ok {
+		next = aws.StringValue(v.S)
+	}
+	return users, next, nil
+}
+
 // GetByOAuthID returns a single user identified by the oauth id. Otherwise an error is returned.
 func (p *DynamoUserPeer) GetByOAuthID(ID string) (*model.User, error) {
 	params := &dynamodb.QueryInput{
@@ -86,6 +157,42 @@ func (p *DynamoUserPeer) GetByOAuthID(ID string) (*model.User, error) {
 	return u, nil
 }
 
+// GetByHandles returns the users mentioned by the given handles using the global secondary index `HandleIndex`.
+// Unknown handles are omitted, the order of the result is not defined.
+func (p *DynamoUserPeer) GetByHandles(handles []string) ([]*model.User, error) {
+	seen := make(map[string]bool, len(handles))
+	var users []*model.User
+	for _, handle := range handles {
+		if seen[handle] {
+			continue
+		}
+		seen[handle] = true
+		items, err := p.model.query(&dynamodb.QueryInput{
+			TableName:              aws.String("user"),
+			IndexName:              aws.String("HandleIndex"),
+			KeyConditionExpression: aws.String("handle = :handle"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":handle": {
+					S: aws.String(handle),
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			u := &model.User{
+				Peer: p,
+			}
+			if err := unmarshalUser(u, item); err != nil {
+				return nil, err
+			}
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
 // marshalUser buils an aws.AttributeValue data structure for the given user.
 func marshalUser(u *model.User, items map[string]*dynamodb.AttributeValue) error {
 	if u == nil {
@@ -99,6 +206,18 @@ func marshalUser(u *model.User, items map[string]*dynamodb.AttributeValue) error
 	if u.Username != "" {
 		items["username"] = &dynamodb.AttributeValue{S: aws.String(u.Username)}
 	}
+	if handle := tagging.Handle(u.Username); handle != "" {
+		items["handle"] = &dynamodb.AttributeValue{S: aws.String(handle)}
+	}
+	if len(u.Roles) > 0 {
+		items["roles"] = &dynamodb.AttributeValue{SS: aws.StringSlice(u.Roles)}
+	}
+	if len(u.Muted) > 0 {
+		items["muted_notifications"] = &dynamodb.AttributeValue{SS: aws.StringSlice(u.Muted)}
+	}
+	if u.FeedVersion > 0 {
+		items["feed_version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(u.FeedVersion))}
+	}
 	items["created_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(u.CreatedAt.Unix(), 10))}
 	items["lastlogin"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(u.LastLogin.Unix(), 10))}
 
@@ -130,6 +249,19 @@ func unmarshalUser(u *model.User, items map[string]*dynamodb.AttributeValue) err
 			u.Username = *v.S
 		}
 	}
+	if v, ok := items["roles"]; ok {
+		u.Roles = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := items["muted_notifications"]; ok {
+		u.Muted = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := items["feed_version"]; ok && v.N != nil {
+		version, err := strconv.Atoi(*v.N)
+		if err != nil {
+			ulog.Warnf("Unable to parse 'feed_version' on %s: %s", items["id"], err)
+		}
+		u.FeedVersion = version
+	}
 	if v, ok := items["lastlogin"]; ok {
 		if v.N != nil {
 			ts64, err := strconv.ParseInt(*v.N, 10, 64)
@@ -173,11 +305,15 @@ func (p *DynamoUserPeer) SaveNew(u *model.User) error {
 		return err
 	}
 	params := &dynamodb.PutItemInput{
-		Item:      items,
-		TableName: aws.String("user"),
+		Item:                items,
+		TableName:           aws.String("user"),
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
 	}
 	_, err = p.model.db.PutItem(params)
 
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
@@ -209,3 +345,85 @@ func (p *DynamoUserPeer) UpdateLastLogin(id string) error {
 	}
 	return nil
 }
+
+// UpdateRoles replaces the roles of the user identified by the given user id.
+// If the user does not exist an error is returned.
+func (p *DynamoUserPeer) UpdateRoles(id string, roles []string) error {
+	params := &dynamodb.UpdateItemInput{
+		TableName: aws.String("user"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:    aws.String("REMOVE #roles"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#roles": aws.String("roles"),
+		},
+	}
+	if len(roles) > 0 {
+		params.UpdateExpression = aws.String("SET #roles = :roles")
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":roles": {
+				SS: aws.StringSlice(roles),
+			},
+		}
+	}
+	_, err := p.model.db.UpdateItem(params)
+	return err
+}
+
+// UpdateMutedNotifications replaces the kinds of notifications the user identified by the given user id muted.
+// If the user does not exist an error is returned.
+func (p *DynamoUserPeer) UpdateMutedNotifications(id string, kinds []string) error {
+	params := &dynamodb.UpdateItemInput{
+		TableName: aws.String("user"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:    aws.String("REMOVE muted_notifications"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	}
+	if len(kinds) > 0 {
+		params.UpdateExpression = aws.String("SET muted_notifications = :kinds")
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":kinds": {
+				SS: aws.StringSlice(kinds),
+			},
+		}
+	}
+	_, err := p.model.db.UpdateItem(params)
+	return err
+}
+
+// RotateFeedVersion increments the feed version of the user identified by the given user id and returns the new version.
+// If the user does not exist an error is returned.
+func (p *DynamoUserPeer) RotateFeedVersion(id string) (int, error) {
+	resp, err := p.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String("user"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression:    aws.String("ADD feed_version :one"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+		ReturnValues: aws.String("UPDATED_NEW"),
+	})
+	if err != nil {
+		return 0, err
+	}
+	v, ok := resp.Attributes["feed_version"]
+	if !ok {
+		return 0, errors.New("Missing feed version")
+	}
+	return strconv.Atoi(aws.StringValue(v.N))
+}
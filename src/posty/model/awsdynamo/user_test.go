@@ -92,3 +92,35 @@ func TestNewUser(t *testing.T) {
 	assert.True(u.CreatedAt.Unix() > time.Now().Add(-time.Hour).Unix())
 	assert.True(u.CreatedAt.Unix() <= time.Now().Unix())
 }
+
+func TestMarshalUserRoles(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalUser(&model.User{ID: "uid123"}, m))
+	_, ok := m["roles"]
+	assert.False(ok, "Users without roles must omit the attribute")
+	assert.NoError(marshalUser(&model.User{ID: "uid123", Roles: []string{model.RoleModerator}}, m))
+	var u model.User
+	assert.NoError(unmarshalUser(&u, m))
+	assert.Equal([]string{model.RoleModerator}, u.Roles)
+	assert.True(u.HasRole(model.RoleModerator))
+	assert.False(u.HasRole("admin"))
+}
+
+func TestMarshalUserNotifications(t *testing.T) {
+	assert := assert.New(t)
+	m := make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalUser(&model.User{ID: "uid123"}, m))
+	_, ok := m["muted_notifications"]
+	assert.False(ok, "Users without muted notifications must omit the attribute")
+	_, ok = m["feed_version"]
+	assert.False(ok, "Users who never rotated their feed urls must omit the feed version")
+	_, ok = m["handle"]
+	assert.False(ok, "Users without username have no handle")
+	assert.NoError(marshalUser(&model.User{ID: "uid123", Username: "Benedikt Lang", Muted: []string{model.NotificationVote}, FeedVersion: 2}, m))
+	assert.Equal("benediktlang", aws.StringValue(m["handle"].S))
+	var u model.User
+	assert.NoError(unmarshalUser(&u, m))
+	assert.Equal([]string{model.NotificationVote}, u.Muted)
+	assert.Equal(2, u.FeedVersion)
+}
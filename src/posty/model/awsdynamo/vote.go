@@ -0,0 +1,149 @@
+package awsdynamo
+
+import (
+	"errors"
+	"posty/model"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var vlog *logrus.Entry
+
+func init() {
+	vlog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoVotePeer",
+	})
+}
+
+// DynamoVotePeer defines interaction with the votes of polls backed by dynamodb.
+//
+// Votes are stored in the table `vote` with the hash key `post_id` and the range key `uid`, which allows a single vote per user and poll.
+type DynamoVotePeer struct {
+	model *DynamoModel
+}
+
+// SaveVote saves the vote. If the user already voted in the poll model.ErrAlreadyVoted is returned.
+func (vp *DynamoVotePeer) SaveVote(v *model.Vote) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalVote(v, items); err != nil {
+		return err
+	}
+	_, err := vp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("vote"),
+		ConditionExpression: aws.String("attribute_not_exists(post_id)"),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrAlreadyVoted
+	}
+	return err
+}
+
+// GetVotes returns all votes in the poll of the post.
+func (vp *DynamoVotePeer) GetVotes(postID string) ([]*model.Vote, error) {
+	items, err := vp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("vote"),
+		KeyConditionExpression: aws.String("post_id = :pid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pid": {
+				S: aws.String(postID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	votes := make([]*model.Vote, 0, len(items))
+	for _, item := range items {
+		v := &model.Vote{}
+		if err := unmarshalVote(v, item); err != nil {
+			vlog.Warnf("Error unmarshal vote: %#v", item)
+			continue
+		}
+		votes = append(votes, v)
+	}
+	return votes, nil
+}
+
+// maxVoteQueries limits the queries GetVotesByPosts runs at the same time.
+const maxVoteQueries = 8
+
+// GetVotesByPosts returns the votes of the polls of the posts keyed by post id. The votes of each poll are queried,
+// up to maxVoteQueries at the same time, so a page of polls costs about the latency of a single query.
+// If any query fails an error is returned.
+func (vp *DynamoVotePeer) GetVotesByPosts(postIDs []string) (map[string][]*model.Vote, error) {
+	type result struct {
+		postID string
+		votes  []*model.Vote
+		err    error
+	}
+	ids := make(chan string)
+	results := make(chan result)
+	workers := maxVoteQueries
+	if len(postIDs) < workers {
+		workers = len(postIDs)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for id := range ids {
+				votes, err := vp.GetVotes(id)
+				results <- result{id, votes, err}
+			}
+		}()
+	}
+	go func() {
+		for _, id := range postIDs {
+			ids <- id
+		}
+		close(ids)
+	}()
+	res := make(map[string][]*model.Vote, len(postIDs))
+	var err error
+	for range postIDs {
+		r := <-results
+		if r.err != nil {
+			err = r.err
+			continue
+		}
+		res[r.postID] = r.votes
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// marshalVote builds an aws AttributeValue data structure for the given vote.
+func marshalVote(v *model.Vote, items map[string]*dynamodb.AttributeValue) error {
+	if v == nil {
+		return errors.New("Undefined vote")
+	}
+	items["post_id"] = &dynamodb.AttributeValue{S: aws.String(v.PostID)}
+	items["uid"] = &dynamodb.AttributeValue{S: aws.String(v.UID)}
+	items["option"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(v.Option))}
+	items["created_at"] = nanoAttribute(v.CreatedAt)
+	return nil
+}
+
+// unmarshalVote unmarshals a vote from the aws datastructure to `model.Vote`.
+func unmarshalVote(v *model.Vote, items map[string]*dynamodb.AttributeValue) error {
+	if v == nil {
+		return errors.New("Undefined vote")
+	}
+	if items["post_id"] == nil || items["uid"] == nil || items["option"] == nil || items["option"].N == nil {
+		return errors.New("Missing key attributes")
+	}
+	option, err := strconv.Atoi(*items["option"].N)
+	if err != nil {
+		return err
+	}
+	v.PostID = aws.StringValue(items["post_id"].S)
+	v.UID = aws.StringValue(items["uid"].S)
+	v.Option = option
+	v.CreatedAt = nanoValue(items["created_at"])
+	return nil
+}
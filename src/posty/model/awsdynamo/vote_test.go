@@ -0,0 +1,31 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalVote(t *testing.T) {
+	assert := assert.New(t)
+	v := &model.Vote{
+		PostID:    "pid123",
+		UID:       "uid123",
+		Option:    2,
+		CreatedAt: time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalVote(v, m); err != nil {
+		t.Fatalf("Error marshalling vote: %s", err)
+	}
+	assert.Equal("2", *m["option"].N)
+	var u model.Vote
+	if err := unmarshalVote(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling vote: %s", err)
+	}
+	assert.Equal(v, &u)
+	assert.Error(unmarshalVote(&u, map[string]*dynamodb.AttributeValue{}))
+}
@@ -0,0 +1,402 @@
+package awsdynamo
+
+import (
+	"errors"
+	"fmt"
+	"posty/model"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	uuid "github.com/satori/go.uuid"
+)
+
+var whlog *logrus.Entry
+
+func init() {
+	whlog = logrus.New().WithFields(logrus.Fields{
+		"env": "DynamoWebhookPeer",
+	})
+}
+
+// deliveryRetention is the time deliveries are kept as delivery log.
+const deliveryRetention = 30 * 24 * time.Hour
+
+// DynamoWebhookPeer defines interaction with webhooks and their deliveries backed by dynamodb.
+//
+// Webhooks are stored in the table `webhook` with the hash key `id`.
+// Deliveries are stored in the table `webhook_delivery` with the hash key `webhook_id` and the range key `created_at`.
+// The global secondary indexes `IDIndex` (hash key `id`) and `DueIndex` (hash key `status`, range key `next_attempt_at`)
+// project all attributes. `next_attempt_at` is only set while a delivery is pending, so only pending deliveries are part of `DueIndex`.
+// `expires_at` holds the unix time deliveries are no longer needed and should be enabled as TTL attribute of the table.
+type DynamoWebhookPeer struct {
+	model *DynamoModel
+}
+
+// NewWebhook creates a new webhook. The webhook is not inserted into the database until it is saved.
+func (wp *DynamoWebhookPeer) NewWebhook(url, secret string, events []string) *model.Webhook {
+	return &model.Webhook{
+		ID:        uuid.NewV4().String(),
+		URL:       url,
+		Secret:    secret,
+		Events:    events,
+		CreatedAt: time.Now(),
+	}
+}
+
+// SaveNew saves a new webhook.
+func (wp *DynamoWebhookPeer) SaveNew(h *model.Webhook) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalWebhook(h, items); err != nil {
+		return err
+	}
+	_, err := wp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("webhook"),
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	return err
+}
+
+// GetByID fetches the webhook identified by the id. Otherwise an error is returned.
+func (wp *DynamoWebhookPeer) GetByID(id string) (*model.Webhook, error) {
+	resp, err := wp.model.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String("webhook"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Item) == 0 {
+		return nil, fmt.Errorf("Webhook %s not found", id)
+	}
+	h := &model.Webhook{}
+	if err := unmarshalWebhook(h, resp.Item); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// GetAll returns all webhooks, the order of the result is not defined.
+func (wp *DynamoWebhookPeer) GetAll() ([]*model.Webhook, error) {
+	var hooks []*model.Webhook
+	params := &dynamodb.ScanInput{
+		TableName:      aws.String("webhook"),
+		ConsistentRead: aws.Bool(true),
+	}
+	for {
+		resp, err := wp.model.db.Scan(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Items {
+			h := &model.Webhook{}
+			if err := unmarshalWebhook(h, item); err != nil {
+				whlog.Warnf("Error unmarshal webhook: %#v", item)
+				continue
+			}
+			hooks = append(hooks, h)
+		}
+		if len(resp.LastEvaluatedKey) == 0 {
+			return hooks, nil
+		}
+		params.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// Remove removes the webhook. Its deliveries are kept until they expire.
+func (wp *DynamoWebhookPeer) Remove(h *model.Webhook) error {
+	_, err := wp.model.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String("webhook"),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {S: aws.String(h.ID)},
+		},
+	})
+	return err
+}
+
+// NewDelivery creates a new pending delivery of the event to the webhook which is due now.
+// The delivery is not inserted into the database until it is saved.
+func (wp *DynamoWebhookPeer) NewDelivery(h *model.Webhook, event string, payload []byte) *model.Delivery {
+	now := time.Now()
+	return &model.Delivery{
+		ID:            uuid.NewV4().String(),
+		WebhookID:     h.ID,
+		Event:         event,
+		Payload:       payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+}
+
+// SaveDelivery inserts the new delivery, existing deliveries are not replaced.
+func (wp *DynamoWebhookPeer) SaveDelivery(d *model.Delivery) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalDelivery(d, items); err != nil {
+		return err
+	}
+	_, err := wp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("webhook_delivery"),
+		ConditionExpression: aws.String("attribute_not_exists(webhook_id)"),
+	})
+	return err
+}
+
+// UpdateDelivery stores the outcome of an attempt of the delivery claimed until the given time.
+// The delivery is only replaced if it is still pending with the next attempt at the end of the claim, so an outcome saved after
+// the claim ran out does not overwrite the claim of another worker. ErrDeliveryClaimed is returned in this case.
+func (wp *DynamoWebhookPeer) UpdateDelivery(d *model.Delivery, claimedUntil time.Time) error {
+	items := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalDelivery(d, items); err != nil {
+		return err
+	}
+	_, err := wp.model.db.PutItem(&dynamodb.PutItemInput{
+		Item:                items,
+		TableName:           aws.String("webhook_delivery"),
+		ConditionExpression: aws.String("#s = :pending AND next_attempt_at = :claimed"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending": {S: aws.String(model.DeliveryPending)},
+			":claimed": nanoAttribute(claimedUntil),
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrDeliveryClaimed
+	}
+	return err
+}
+
+// GetDelivery fetches the delivery identified by the id. Otherwise an error is returned.
+func (wp *DynamoWebhookPeer) GetDelivery(id string) (*model.Delivery, error) {
+	resp, err := wp.model.db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("webhook_delivery"),
+		IndexName:              aws.String("IDIndex"),
+		KeyConditionExpression: aws.String("id = :id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":id": {
+				S: aws.String(id),
+			},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Items) != 1 {
+		return nil, fmt.Errorf("Results: len(%d)", len(resp.Items))
+	}
+	d := &model.Delivery{}
+	if err := unmarshalDelivery(d, resp.Items[0]); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetDeliveries returns up to limit deliveries of the webhook, newest first.
+func (wp *DynamoWebhookPeer) GetDeliveries(webhookID string, limit int) ([]*model.Delivery, error) {
+	resp, err := wp.model.db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String("webhook_delivery"),
+		KeyConditionExpression: aws.String("webhook_id = :wid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":wid": {
+				S: aws.String(webhookID),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalDeliveries(resp.Items), nil
+}
+
+// GetDueDeliveries returns the pending deliveries whose next attempt is due at the given time, oldest attempt first.
+func (wp *DynamoWebhookPeer) GetDueDeliveries(now time.Time) ([]*model.Delivery, error) {
+	items, err := wp.model.query(&dynamodb.QueryInput{
+		TableName:              aws.String("webhook_delivery"),
+		IndexName:              aws.String("DueIndex"),
+		KeyConditionExpression: aws.String("#s = :pending AND next_attempt_at <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pending": {S: aws.String(model.DeliveryPending)},
+			":now":     nanoAttribute(now),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalDeliveries(items), nil
+}
+
+// ClaimDelivery postpones the next attempt of the pending delivery until the given time.
+// The update is conditional on the next attempt stored in the database, so only one worker claims a delivery.
+// If another worker claimed or finished the delivery in the meantime model.ErrDeliveryClaimed is returned.
+func (wp *DynamoWebhookPeer) ClaimDelivery(d *model.Delivery, until time.Time) error {
+	_, err := wp.model.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String("webhook_delivery"),
+		Key:                 deliveryKey(d),
+		UpdateExpression:    aws.String("SET next_attempt_at = :until"),
+		ConditionExpression: aws.String("#s = :pending AND next_attempt_at = :next"),
+		ExpressionAttributeNames: map[string]*string{
+			"#s": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until":   nanoAttribute(until),
+			":pending": {S: aws.String(model.DeliveryPending)},
+			":next":    nanoAttribute(d.NextAttemptAt),
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ConditionalCheckFailedException" {
+		return model.ErrDeliveryClaimed
+	}
+	if err != nil {
+		return err
+	}
+	d.NextAttemptAt = until
+	return nil
+}
+
+// deliveryKey returns the primary key of the delivery.
+func deliveryKey(d *model.Delivery) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"webhook_id": {S: aws.String(d.WebhookID)},
+		"created_at": nanoAttribute(d.CreatedAt),
+	}
+}
+
+func unmarshalDeliveries(items []map[string]*dynamodb.AttributeValue) []*model.Delivery {
+	ds := make([]*model.Delivery, 0, len(items))
+	for _, item := range items {
+		d := &model.Delivery{}
+		if err := unmarshalDelivery(d, item); err != nil {
+			whlog.Warnf("Error unmarshal delivery: %#v", item)
+			continue
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// marshalWebhook builds an aws AttributeValue data structure for the given webhook.
+func marshalWebhook(h *model.Webhook, items map[string]*dynamodb.AttributeValue) error {
+	if h == nil {
+		return errors.New("Undefined webhook")
+	}
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(h.ID)}
+	items["url"] = &dynamodb.AttributeValue{S: aws.String(h.URL)}
+	items["secret"] = &dynamodb.AttributeValue{S: aws.String(h.Secret)}
+	items["events"] = &dynamodb.AttributeValue{SS: aws.StringSlice(h.Events)}
+	if h.CreatedBy != "" {
+		items["created_by"] = &dynamodb.AttributeValue{S: aws.String(h.CreatedBy)}
+	}
+	items["created_at"] = nanoAttribute(h.CreatedAt)
+	return nil
+}
+
+// unmarshalWebhook unmarshals a webhook from the aws datastructure to `model.Webhook`.
+func unmarshalWebhook(h *model.Webhook, items map[string]*dynamodb.AttributeValue) error {
+	if h == nil {
+		return errors.New("Undefined webhook")
+	}
+	if items["id"] == nil || items["url"] == nil {
+		return errors.New("Missing attributes")
+	}
+	h.ID = aws.StringValue(items["id"].S)
+	h.URL = aws.StringValue(items["url"].S)
+	if v, ok := items["secret"]; ok {
+		h.Secret = aws.StringValue(v.S)
+	}
+	if v, ok := items["events"]; ok {
+		h.Events = aws.StringValueSlice(v.SS)
+	}
+	if v, ok := items["created_by"]; ok {
+		h.CreatedBy = aws.StringValue(v.S)
+	}
+	h.CreatedAt = nanoValue(items["created_at"])
+	return nil
+}
+
+// marshalDelivery builds an aws AttributeValue data structure for the given delivery.
+func marshalDelivery(d *model.Delivery, items map[string]*dynamodb.AttributeValue) error {
+	if d == nil {
+		return errors.New("Undefined delivery")
+	}
+	items["webhook_id"] = &dynamodb.AttributeValue{S: aws.String(d.WebhookID)}
+	items["created_at"] = nanoAttribute(d.CreatedAt)
+	items["id"] = &dynamodb.AttributeValue{S: aws.String(d.ID)}
+	items["event"] = &dynamodb.AttributeValue{S: aws.String(d.Event)}
+	items["payload"] = &dynamodb.AttributeValue{B: d.Payload}
+	items["status"] = &dynamodb.AttributeValue{S: aws.String(d.Status)}
+	items["attempts"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(d.Attempts))}
+	if d.Status == model.DeliveryPending {
+		items["next_attempt_at"] = nanoAttribute(d.NextAttemptAt)
+	}
+	if !d.LastAttemptAt.IsZero() {
+		items["last_attempt_at"] = nanoAttribute(d.LastAttemptAt)
+	}
+	if d.ResponseCode != 0 {
+		items["response_code"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(d.ResponseCode))}
+	}
+	if d.Error != "" {
+		items["error"] = &dynamodb.AttributeValue{S: aws.String(d.Error)}
+	}
+	items["expires_at"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(d.CreatedAt.Add(deliveryRetention).Unix(), 10))}
+	return nil
+}
+
+// unmarshalDelivery unmarshals a delivery from the aws datastructure to `model.Delivery`.
+func unmarshalDelivery(d *model.Delivery, items map[string]*dynamodb.AttributeValue) error {
+	if d == nil {
+		return errors.New("Undefined delivery")
+	}
+	if items["webhook_id"] == nil || items["created_at"] == nil {
+		return errors.New("Missing key attributes")
+	}
+	d.WebhookID = aws.StringValue(items["webhook_id"].S)
+	d.CreatedAt = nanoValue(items["created_at"])
+	if v, ok := items["id"]; ok {
+		d.ID = aws.StringValue(v.S)
+	}
+	if v, ok := items["event"]; ok {
+		d.Event = aws.StringValue(v.S)
+	}
+	if v, ok := items["payload"]; ok {
+		d.Payload = v.B
+	}
+	if v, ok := items["status"]; ok {
+		d.Status = aws.StringValue(v.S)
+	}
+	d.Attempts = intValue(items["attempts"])
+	d.NextAttemptAt = nanoValue(items["next_attempt_at"])
+	d.LastAttemptAt = nanoValue(items["last_attempt_at"])
+	d.ResponseCode = intValue(items["response_code"])
+	if v, ok := items["error"]; ok {
+		d.Error = aws.StringValue(v.S)
+	}
+	return nil
+}
+
+// intValue parses a number attribute, 0 is returned for missing or invalid attributes.
+func intValue(v *dynamodb.AttributeValue) int {
+	if v == nil || v.N == nil {
+		return 0
+	}
+	i, err := strconv.Atoi(*v.N)
+	if err != nil {
+		return 0
+	}
+	return i
+}
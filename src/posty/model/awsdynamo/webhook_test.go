@@ -0,0 +1,67 @@
+package awsdynamo
+
+import (
+	"posty/model"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalWebhook(t *testing.T) {
+	assert := assert.New(t)
+	h := &model.Webhook{
+		ID:        "wid123",
+		URL:       "https://ci.example.com/hook",
+		Secret:    "secret",
+		Events:    []string{model.EventPostCreated, model.EventUserCreated},
+		CreatedBy: "uid123",
+		CreatedAt: time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalWebhook(h, m); err != nil {
+		t.Fatalf("Error marshalling webhook: %s", err)
+	}
+	var u model.Webhook
+	if err := unmarshalWebhook(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling webhook: %s", err)
+	}
+	assert.Equal(h, &u)
+	assert.Error(unmarshalWebhook(&u, map[string]*dynamodb.AttributeValue{}))
+}
+
+func TestMarshalDelivery(t *testing.T) {
+	assert := assert.New(t)
+	d := &model.Delivery{
+		ID:            "did123",
+		WebhookID:     "wid123",
+		Event:         model.EventPostDeleted,
+		Payload:       []byte(`{"event":"post.deleted"}`),
+		Status:        model.DeliveryPending,
+		Attempts:      2,
+		NextAttemptAt: time.Unix(1448272069, 0),
+		LastAttemptAt: time.Unix(1448272068, 0),
+		ResponseCode:  503,
+		Error:         "Unexpected status code 503",
+		CreatedAt:     time.Unix(1448272067, 123),
+	}
+	m := make(map[string]*dynamodb.AttributeValue)
+	if err := marshalDelivery(d, m); err != nil {
+		t.Fatalf("Error marshalling delivery: %s", err)
+	}
+	assert.Equal("1450864067", *m["expires_at"].N, "Deliveries expire after the retention")
+	var u model.Delivery
+	if err := unmarshalDelivery(&u, m); err != nil {
+		t.Fatalf("Error unmarshalling delivery: %s", err)
+	}
+	assert.Equal(d, &u)
+	assert.Equal(deliveryKey(d), map[string]*dynamodb.AttributeValue{"webhook_id": m["webhook_id"], "created_at": m["created_at"]})
+
+	d.Status = model.DeliverySucceeded
+	m = make(map[string]*dynamodb.AttributeValue)
+	assert.NoError(marshalDelivery(d, m))
+	_, ok := m["next_attempt_at"]
+	assert.False(ok, "Only pending deliveries are due")
+	assert.Error(unmarshalDelivery(&u, map[string]*dynamodb.AttributeValue{}))
+}
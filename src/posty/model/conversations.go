@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotParticipant is returned when accessing a conversation the user does not participate in.
+var ErrNotParticipant = errors.New("User does not participate in conversation")
+
+// ErrConversationChanged is returned when marking a conversation as read which received messages since it was fetched.
+var ErrConversationChanged = errors.New("Conversation changed")
+
+// ConversationPeer defines interactions with private one-to-one conversations and their messages.
+type ConversationPeer interface {
+	Open(uid, other string) (c *Conversation, created bool, err error)
+	Get(id, uid string) (*Conversation, error)
+	GetByUser(uid string) ([]*Conversation, error)
+	NewMessage(c *Conversation, text string) *Message
+	SaveMessage(c *Conversation, m *Message) error
+	GetMessages(conversationID string) ([]*Message, error)
+	// MarkRead resets the unread counter unless the conversation received messages since it was fetched,
+	// ErrConversationChanged is returned in this case.
+	MarkRead(c *Conversation) error
+}
+
+// Conversation is a private conversation between two users as seen by one of them.
+// Both participants share the id, but keep their own unread counter.
+type Conversation struct {
+	ID            string
+	UID           string // participant viewing the conversation
+	Other         string // the other participant
+	Unread        int    // messages of the other participant not read yet
+	LastMessageAt time.Time
+	CreatedAt     time.Time
+}
+
+// Message is a message sent to a conversation.
+type Message struct {
+	ID             string
+	ConversationID string
+	UID            string // sender
+	Text           string
+	CreatedAt      time.Time
+}
+
+// ConversationID returns the id of the conversation between the two users, it does not depend on their order.
+func ConversationID(uid, other string) string {
+	ids := []string{uid, other}
+	sort.Strings(ids)
+	return strings.Join(ids, ":")
+}
+
+// ByLastMessageAtDESC represents a sort interface for sorting conversations descending by the time of their last message.
+type ByLastMessageAtDESC []*Conversation
+
+// Len returns the amount of conversations
+func (o ByLastMessageAtDESC) Len() int { return len(o) }
+
+// Swap swaps two items in the slice
+func (o ByLastMessageAtDESC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// Less defines the comparator of conversations
+func (o ByLastMessageAtDESC) Less(i, j int) bool { return o[i].LastMessageAt.After(o[j].LastMessageAt) }
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversationID(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("uid123:uid456", ConversationID("uid123", "uid456"))
+	assert.Equal("uid123:uid456", ConversationID("uid456", "uid123"))
+}
+
+func TestSortByLastMessageAtDesc(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Unix(1448272067, 0)
+	cs := []*Conversation{{ID: "a", LastMessageAt: ts}, {ID: "b", LastMessageAt: ts.Add(time.Hour)}, {ID: "c"}}
+	sort.Sort(ByLastMessageAtDESC(cs))
+	assert.Equal("b", cs[0].ID)
+	assert.Equal("a", cs[1].ID)
+	assert.Equal("c", cs[2].ID)
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrJobClaimed is returned if another instance claimed the run of a job first.
+var ErrJobClaimed = errors.New("Job run claimed by another instance")
+
+// JobPeer records the runs of periodic jobs shared by all instances, e.g. sending digests.
+type JobPeer interface {
+	// LastRun returns the time of the last run of the job, the zero time if it never ran.
+	LastRun(name string) (time.Time, error)
+	// ClaimRun records a run of the job at now if its last run is still last, otherwise ErrJobClaimed is returned.
+	ClaimRun(name string, last, now time.Time) error
+}
@@ -1,7 +1,22 @@
 package model
 
-// Model defines a basic model consisting of two entities `post` and `user`.
+import "errors"
+
+// ErrNotFound is returned when fetching an entity which does not exist.
+var ErrNotFound = errors.New("Not found")
+
+// ErrAlreadyExists is returned when saving a new entity whose key exists already.
+var ErrAlreadyExists = errors.New("Already exists")
+
+// Model defines a basic model consisting of the entities `post`, `user`, `report`, `vote`, `conversation`, `notification`, `webhook`, `incoming webhook` and `job`.
 type Model interface {
 	PostPeer() PostPeer
 	UserPeer() UserPeer
+	ReportPeer() ReportPeer
+	VotePeer() VotePeer
+	ConversationPeer() ConversationPeer
+	NotificationPeer() NotificationPeer
+	WebhookPeer() WebhookPeer
+	IncomingWebhookPeer() IncomingWebhookPeer
+	JobPeer() JobPeer
 }
@@ -0,0 +1,54 @@
+package model
+
+import "time"
+
+// Kinds of notifications.
+const (
+	// NotificationMention notifies an user mentioned in a new post.
+	NotificationMention = "mention"
+	// NotificationVote notifies the author of a poll about a new vote.
+	NotificationVote = "vote"
+	// NotificationMentionEmail emails an user mentioned in a new post.
+	NotificationMentionEmail = "mention_email"
+	// NotificationDigest emails a daily summary of new posts.
+	NotificationDigest = "digest"
+)
+
+// NotificationKinds lists all kinds of notifications users can mute.
+var NotificationKinds = []string{NotificationMention, NotificationVote, NotificationMentionEmail, NotificationDigest}
+
+// ValidNotificationKind returns true if the kind is a known kind of notification.
+func ValidNotificationKind(kind string) bool {
+	for _, k := range NotificationKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationPeer defines interactions with the notifications of users.
+type NotificationPeer interface {
+	NewNotification(uid, kind, actorID, postID string) *Notification
+	SaveNew(n *Notification) error
+	GetByUser(uid string, limit int) ([]*Notification, error)
+	CountUnread(uid string) (int, error)
+	MarkRead(n *Notification) error
+	RemoveByPost(postID string) error
+}
+
+// Notification tells an user about an action of another user concerning a post.
+type Notification struct {
+	ID        string
+	UID       string // recipient
+	Kind      string // one of NotificationKinds
+	ActorID   string // user causing the notification
+	PostID    string
+	CreatedAt time.Time
+	ReadAt    time.Time
+}
+
+// Unread returns true if the recipient did not read the notification yet.
+func (n *Notification) Unread() bool {
+	return n.ReadAt.IsZero()
+}
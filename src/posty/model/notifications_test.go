@@ -0,0 +1,27 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserNotifies(t *testing.T) {
+	assert := assert.New(t)
+	u := &User{}
+	assert.True(u.Notifies(NotificationMention))
+	u.Muted = []string{NotificationVote}
+	assert.True(u.Notifies(NotificationMention))
+	assert.False(u.Notifies(NotificationVote))
+}
+
+func TestNotificationUnread(t *testing.T) {
+	assert := assert.New(t)
+	n := &Notification{}
+	assert.True(n.Unread())
+	n.ReadAt = time.Unix(1448272067, 0)
+	assert.False(n.Unread())
+	assert.True(ValidNotificationKind(NotificationMention))
+	assert.False(ValidNotificationKind("reply"))
+}
@@ -1,25 +1,182 @@
 package model
 
-import "time"
+import (
+	"errors"
+	"time"
+)
+
+// ErrPinLimitReached is returned when pinning a post while the maximum number of posts is pinned.
+var ErrPinLimitReached = errors.New("Too many pinned posts")
+
+// ErrAlreadyAnnounced is returned when marking a scheduled post as announced which was announced before.
+var ErrAlreadyAnnounced = errors.New("Post already announced")
+
+// ErrAttachmentClaimed is returned when claiming an attachment which was claimed by another post.
+var ErrAttachmentClaimed = errors.New("Attachment already claimed")
 
 // PostPeer defines interactions with the post data.
 type PostPeer interface {
+	// GetByID returns the post identified by the id unless it was removed, ErrNotFound is returned if it does not exist.
 	GetByID(id string) (*Post, error)
 	GetPosts() ([]*Post, error)
+	// GetPage returns up to limit posts created after the cursor after, oldest first, whether they are live, scheduled, expired or removed.
+	// The cursor of the next page is returned along with the posts, it is empty after the last page.
+	GetPage(after string, limit int) ([]*Post, string, error)
+	QueryPosts(q PostQuery) ([]*Post, error)
+	// GetPublished returns the scheduled posts published after since until until which are live at until.
+	GetPublished(since, until time.Time) ([]*Post, error)
+	// GetDue returns the scheduled posts published until now which were not announced yet.
+	GetDue(now time.Time) ([]*Post, error)
+	// MarkAnnounced records that the scheduled post was announced, ErrAlreadyAnnounced is returned if it was announced before.
+	MarkAnnounced(p *Post) error
+	GetPostsByTag(tag string) ([]*Post, error)
+	GetPostsByMention(handle string) ([]*Post, error)
 	NewPost(uid string) *Post
+	// SaveNew saves a new post, ErrAlreadyExists is returned if the post exists.
 	SaveNew(p *Post) error
+	SetPreview(p *Post, pv *Preview) error
+	SetHidden(p *Post, hidden bool) error
+	// Pin pins the post unless max posts are pinned already, ErrPinLimitReached is returned in this case.
+	Pin(p *Post, max int) error
+	Unpin(p *Post) error
+	// ClaimAttachment attaches the uploaded attachment to the post, ErrAttachmentClaimed is returned if another post claimed it first.
+	ClaimAttachment(id, postID string) error
+	// ReleaseAttachment releases the claim of the post, e.g. if the post could not be saved.
+	ReleaseAttachment(id, postID string) error
+	// RepairTerms indexes the tags and mentions of saved posts which could not be indexed yet and returns their number.
+	RepairTerms() (int, error)
 	Remove(p *Post) error
+	Restore(p *Post) error
+	GetDeletedByID(id string) (*Post, error)
+	GetDeletedPosts(before time.Time) ([]*Post, error)
+	Purge(p *Post) error
+}
+
+// PostQuery restricts the posts returned by a query. Zero values do not restrict the result.
+type PostQuery struct {
+	// Author restricts the result to posts of the user id
+	Author string
+	// Since restricts the result to posts created at or after the timestamp
+	Since time.Time
+	// Until restricts the result to posts created at or before the timestamp
+	Until time.Time
+	// Scheduled restricts the result to posts scheduled for later publication, otherwise only live posts are returned
+	Scheduled bool
+	// Pinned restricts the result to pinned posts
+	Pinned bool
+	// Expired restricts the result to expired posts, e.g. to export them
+	Expired bool
+}
+
+// Matches returns true if the post satisfies the query.
+func (q PostQuery) Matches(p *Post) bool {
+	if q.Author != "" && p.UID != q.Author {
+		return false
+	}
+	if !q.Since.IsZero() && p.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && p.CreatedAt.After(q.Until) {
+		return false
+	}
+	if q.Pinned && p.PinnedAt.IsZero() {
+		return false
+	}
+	now := time.Now()
+	if q.Scheduled {
+		return p.Scheduled(now)
+	}
+	if q.Expired {
+		return p.Expired(now)
+	}
+	return p.Live(now)
+}
+
+// Message formats of posts.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+// ValidFormat returns true if the format is a known message format.
+func ValidFormat(format string) bool {
+	return format == FormatPlain || format == FormatMarkdown
 }
 
 // Post represents a users post send to the board
 type Post struct {
-	ID        string
-	UID       string
-	Username  string
-	Message   string
-	CreatedAt time.Time
-	IsNew     bool
-	Peer      PostPeer
+	ID          string
+	UID         string
+	Message     string
+	Format      string   // format of the message, FormatPlain if empty
+	Tags        []string // lowercase hashtags of the message without `#`
+	Mentions    []string // lowercase handles mentioned in the message without `@`
+	Attachments []Attachment
+	Preview     *Preview // preview of the first link in the message, nil until it was fetched
+	Poll        *Poll    // nil unless the post is a poll
+	Quarantined bool     // quarantined posts are only visible to their author
+	Hidden      bool     // hidden posts were reported or hidden by a moderator and are only visible to their author
+	CreatedAt   time.Time
+	PublishAt   time.Time // the post is only visible to its author until then, zero if published on creation
+	ExpiresAt   time.Time // the post is only visible to its author afterwards, zero if it does not expire
+	PinnedAt    time.Time // zero unless a moderator pinned the post to the top of the wall
+	DeletedAt   time.Time // zero unless the post was removed and can still be restored
+	IsNew       bool
+	Peer        PostPeer
+}
+
+// Attachment describes a file attached to a post, the content is kept in a blob store.
+type Attachment struct {
+	ID          string
+	Name        string // file name given by the uploader
+	ContentType string // sniffed content type
+	Size        int64
+	Width       int  // width of images in pixels
+	Height      int  // height of images in pixels
+	Thumbnail   bool // true if a thumbnail was generated
+}
+
+// Poll turns a post into a poll, the message of the post is the question.
+type Poll struct {
+	Options     []string
+	ClosesAt    time.Time // no votes are accepted afterwards, zero if the poll never closes
+	HideResults bool      // results are only shown to users who voted until the poll closes
+}
+
+// Closed returns true if the poll closed at or before now.
+func (p *Poll) Closed(now time.Time) bool {
+	return !p.ClosesAt.IsZero() && !p.ClosesAt.After(now)
+}
+
+// Preview describes the web page linked in a post.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Scheduled returns true if the post is scheduled to be published after now.
+func (p *Post) Scheduled(now time.Time) bool {
+	return p.PublishAt.After(now)
+}
+
+// PublishedAt returns the time the post goes live, the publish time of scheduled posts, otherwise the creation time.
+func (p *Post) PublishedAt() time.Time {
+	if p.PublishAt.IsZero() {
+		return p.CreatedAt
+	}
+	return p.PublishAt
+}
+
+// Expired returns true if the post expired at or before now.
+func (p *Post) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !p.ExpiresAt.After(now)
+}
+
+// Live returns true if the post is published and not expired at now.
+func (p *Post) Live(now time.Time) bool {
+	return !p.Scheduled(now) && !p.Expired(now)
 }
 
 // SaveNew saves a new post to the model.
@@ -38,3 +195,45 @@ func (o ByCreatedAtDESC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
 
 // Less defines the comparator of posts
 func (o ByCreatedAtDESC) Less(i, j int) bool { return o[i].CreatedAt.After(o[j].CreatedAt) }
+
+// ByPublishedAtDESC represents a sort interface for sorting Posts descending by the time they were published
+type ByPublishedAtDESC []*Post
+
+// Len returns the amount of posts
+func (o ByPublishedAtDESC) Len() int { return len(o) }
+
+// Swap swaps two items in the slice
+func (o ByPublishedAtDESC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// Less defines the comparator of posts
+func (o ByPublishedAtDESC) Less(i, j int) bool { return o[i].PublishedAt().After(o[j].PublishedAt()) }
+
+// ByPublishAtASC represents a sort interface for sorting Posts ascending by PublishAt
+type ByPublishAtASC []*Post
+
+// Len returns the amount of posts
+func (o ByPublishAtASC) Len() int { return len(o) }
+
+// Swap swaps two items in the slice
+func (o ByPublishAtASC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// Less defines the comparator of posts
+func (o ByPublishAtASC) Less(i, j int) bool { return o[i].PublishAt.Before(o[j].PublishAt) }
+
+// ByPinnedFirst represents a sort interface moving pinned posts to the front, the most recently pinned first.
+// Use it with sort.Stable to keep the order of the other posts.
+type ByPinnedFirst []*Post
+
+// Len returns the amount of posts
+func (o ByPinnedFirst) Len() int { return len(o) }
+
+// Swap swaps two items in the slice
+func (o ByPinnedFirst) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// Less defines the comparator of posts
+func (o ByPinnedFirst) Less(i, j int) bool {
+	if o[j].PinnedAt.IsZero() {
+		return !o[i].PinnedAt.IsZero()
+	}
+	return o[i].PinnedAt.After(o[j].PinnedAt)
+}
@@ -23,3 +23,76 @@ func TestSortByCreatedAtDesc(t *testing.T) {
 		last = p
 	}
 }
+
+func TestSortByPinnedFirst(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Unix(1448272067, 0)
+	posts := []*Post{
+		{ID: "a"},
+		{ID: "b", PinnedAt: ts},
+		{ID: "c"},
+		{ID: "d", PinnedAt: ts.Add(time.Second)},
+		{ID: "e"},
+	}
+	sort.Stable(ByPinnedFirst(posts))
+	var ids []string
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	assert.Equal([]string{"d", "b", "a", "c", "e"}, ids)
+}
+
+func TestPostQueryMatches(t *testing.T) {
+	assert := assert.New(t)
+	ts := time.Unix(1448272067, 0)
+	p := &Post{UID: "uid123", CreatedAt: ts}
+	assert.True(PostQuery{}.Matches(p))
+	assert.True(PostQuery{Author: "uid123"}.Matches(p))
+	assert.False(PostQuery{Author: "uid456"}.Matches(p))
+	assert.True(PostQuery{Since: ts, Until: ts}.Matches(p))
+	assert.False(PostQuery{Since: ts.Add(time.Second)}.Matches(p))
+	assert.False(PostQuery{Until: ts.Add(-time.Second)}.Matches(p))
+	assert.False(PostQuery{Scheduled: true}.Matches(p))
+	assert.False(PostQuery{Expired: true}.Matches(p))
+	assert.False(PostQuery{Pinned: true}.Matches(p))
+	p.PinnedAt = ts
+	assert.True(PostQuery{Pinned: true}.Matches(p))
+
+	p.PublishAt = time.Now().Add(time.Hour)
+	assert.False(PostQuery{}.Matches(p))
+	assert.True(PostQuery{Scheduled: true}.Matches(p))
+	p.PublishAt = time.Time{}
+	p.ExpiresAt = time.Now().Add(-time.Second)
+	assert.False(PostQuery{}.Matches(p))
+	assert.False(PostQuery{Scheduled: true}.Matches(p))
+	assert.True(PostQuery{Expired: true}.Matches(p))
+}
+
+func TestPostLive(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	p := &Post{}
+	assert.True(p.Live(now))
+	p.PublishAt = now
+	assert.False(p.Scheduled(now))
+	assert.True(p.Live(now))
+	p.PublishAt = now.Add(time.Second)
+	assert.True(p.Scheduled(now))
+	assert.False(p.Live(now))
+	p.PublishAt = time.Time{}
+	p.ExpiresAt = now.Add(time.Second)
+	assert.False(p.Expired(now))
+	assert.True(p.Live(now))
+	p.ExpiresAt = now
+	assert.True(p.Expired(now))
+	assert.False(p.Live(now))
+}
+
+func TestPostPublishedAt(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	p := &Post{CreatedAt: now}
+	assert.Equal(now, p.PublishedAt())
+	p.PublishAt = now.Add(time.Hour)
+	assert.Equal(now.Add(time.Hour), p.PublishedAt(), "Scheduled posts are published at the publish time")
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAlreadyReported is returned if an user reports the same post twice.
+var ErrAlreadyReported = errors.New("Post already reported by user")
+
+// ReportPeer defines interactions with reports of posts and the audit log of moderation decisions.
+type ReportPeer interface {
+	GetByID(id string) (*Report, error)
+	GetByPost(postID string) ([]*Report, error)
+	GetOpen() ([]*Report, error)
+	NewReport(postID, uid string) *Report
+	SaveNew(r *Report) error
+	Update(r *Report) error
+	NewAuditEntry(moderatorID, action, postID string) *AuditEntry
+	SaveAuditEntry(e *AuditEntry) error
+	GetAuditEntries() ([]*AuditEntry, error)
+}
+
+// Status of reports.
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// Report is an user flagging a post. Every user can report a post only once.
+type Report struct {
+	ID         string
+	PostID     string
+	UID        string // user who reported the post
+	Reason     string
+	Status     string
+	ResolvedBy string // moderator who resolved or dismissed the report
+	ResolvedAt time.Time
+	CreatedAt  time.Time
+	Peer       ReportPeer
+}
+
+// Moderation actions recorded in the audit log.
+const (
+	ActionAutoHide = "auto_hide"
+	ActionResolve  = "resolve"
+	ActionDismiss  = "dismiss"
+)
+
+// AuditEntry records a moderation decision. Decisions taken automatically have no moderator.
+type AuditEntry struct {
+	ID          string
+	ModeratorID string
+	Action      string
+	PostID      string
+	ReportIDs   []string // reports closed by the decision
+	CreatedAt   time.Time
+}
+
+// ByCreatedAtASC sorts reports oldest first.
+type ByCreatedAtASC []*Report
+
+// Len returns the amount of reports
+func (o ByCreatedAtASC) Len() int { return len(o) }
+
+// Swap swaps two items in the slice
+func (o ByCreatedAtASC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+// Less defines the comparator of reports
+func (o ByCreatedAtASC) Less(i, j int) bool { return o[i].CreatedAt.Before(o[j].CreatedAt) }
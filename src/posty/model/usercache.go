@@ -0,0 +1,118 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultUserCacheSize is the number of users cached by default.
+const DefaultUserCacheSize = 10000
+
+// UserCache caches users of an UserPeer in-process for a limited time.
+// At most MaxSize users are kept, expired users are evicted when they are read or to make room for new users.
+// It is safe for concurrent use.
+type UserCache struct {
+	Peer    UserPeer
+	TTL     time.Duration
+	MaxSize int
+
+	mu      sync.Mutex
+	entries map[string]userCacheEntry
+	now     func() time.Time
+}
+
+type userCacheEntry struct {
+	user    *User
+	expires time.Time
+}
+
+// NewUserCache creates a new cache in front of the given peer. Entries expire after ttl, at most maxSize users are cached.
+func NewUserCache(peer UserPeer, ttl time.Duration, maxSize int) *UserCache {
+	return &UserCache{
+		Peer:    peer,
+		TTL:     ttl,
+		MaxSize: maxSize,
+		entries: make(map[string]userCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// GetByIDs returns the users identified by the given ids mapped by their id.
+// Users not present in the cache are fetched from the peer using a single batch lookup.
+// Unknown users are not part of the result.
+func (c *UserCache) GetByIDs(ids []string) (map[string]*User, error) {
+	users := make(map[string]*User, len(ids))
+	var missing []string
+
+	c.mu.Lock()
+	now := c.now()
+	for _, id := range ids {
+		if _, ok := users[id]; ok {
+			continue
+		}
+		if e, ok := c.entries[id]; ok {
+			if now.Before(e.expires) {
+				users[id] = e.user
+				continue
+			}
+			delete(c.entries, id)
+		}
+		users[id] = nil
+		missing = append(missing, id)
+	}
+	c.mu.Unlock()
+
+	if len(missing) > 0 {
+		fetched, err := c.Peer.GetByIDs(missing)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		now := c.now()
+		c.evict(len(fetched), now)
+		expires := now.Add(c.TTL)
+		for _, u := range fetched {
+			if _, ok := c.entries[u.ID]; ok || len(c.entries) < c.MaxSize {
+				c.entries[u.ID] = userCacheEntry{
+					user:    u,
+					expires: expires,
+				}
+			}
+			users[u.ID] = u
+		}
+		c.mu.Unlock()
+	}
+
+	for id, u := range users {
+		if u == nil {
+			delete(users, id)
+		}
+	}
+	return users, nil
+}
+
+// evict makes room for n new entries if the cache is full. Expired entries are removed first, then arbitrary entries.
+// The caller must hold c.mu.
+func (c *UserCache) evict(n int, now time.Time) {
+	if len(c.entries)+n <= c.MaxSize {
+		return
+	}
+	for id, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	for id := range c.entries {
+		if len(c.entries)+n <= c.MaxSize {
+			break
+		}
+		delete(c.entries, id)
+	}
+}
+
+// Invalidate removes the user identified by id from the cache.
+func (c *UserCache) Invalidate(id string) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.mu.Unlock()
+}
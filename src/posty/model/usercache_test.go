@@ -0,0 +1,111 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockUserPeer struct {
+	UserPeer
+	getByIDsFn func(ids []string) ([]*User, error)
+}
+
+func (m *mockUserPeer) GetByIDs(ids []string) ([]*User, error) {
+	return m.getByIDsFn(ids)
+}
+
+func TestUserCache(t *testing.T) {
+	assert := assert.New(t)
+	var calls [][]string
+	peer := &mockUserPeer{
+		getByIDsFn: func(ids []string) ([]*User, error) {
+			calls = append(calls, ids)
+			var users []*User
+			for _, id := range ids {
+				if id == "unknown" {
+					continue
+				}
+				users = append(users, &User{ID: id, Username: "name-" + id})
+			}
+			return users, nil
+		},
+	}
+	ts := time.Unix(1448272067, 0)
+	c := NewUserCache(peer, time.Minute, 10)
+	c.now = func() time.Time { return ts }
+
+	users, err := c.GetByIDs([]string{"uid1", "uid2", "uid1", "unknown"})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	assert.Len(users, 2)
+	assert.Equal("name-uid1", users["uid1"].Username)
+	assert.Equal("name-uid2", users["uid2"].Username)
+	assert.Equal([][]string{{"uid1", "uid2", "unknown"}}, calls, "Expected a single batch without duplicates")
+
+	// Served from cache
+	users, err = c.GetByIDs([]string{"uid2", "uid3"})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	assert.Len(users, 2)
+	assert.Equal([]string{"uid3"}, calls[1], "Only uncached users should be fetched")
+
+	// Expired
+	ts = ts.Add(2 * time.Minute)
+	_, err = c.GetByIDs([]string{"uid1"})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	assert.Equal([]string{"uid1"}, calls[2], "Expired users should be fetched again")
+	_, ok := c.entries["uid3"]
+	assert.True(ok)
+
+	// Invalidated
+	c.Invalidate("uid1")
+	_, err = c.GetByIDs([]string{"uid1"})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	assert.Len(calls, 4)
+}
+
+func TestUserCacheEviction(t *testing.T) {
+	assert := assert.New(t)
+	peer := &mockUserPeer{
+		getByIDsFn: func(ids []string) ([]*User, error) {
+			var users []*User
+			for _, id := range ids {
+				users = append(users, &User{ID: id})
+			}
+			return users, nil
+		},
+	}
+	ts := time.Unix(1448272067, 0)
+	c := NewUserCache(peer, time.Minute, 3)
+	c.now = func() time.Time { return ts }
+
+	_, err := c.GetByIDs([]string{"uid1", "uid2"})
+	assert.NoError(err)
+	ts = ts.Add(2 * time.Minute)
+	_, err = c.GetByIDs([]string{"uid3", "uid4"})
+	assert.NoError(err)
+	assert.Len(c.entries, 2, "Expired users are evicted to make room")
+	_, ok := c.entries["uid3"]
+	assert.True(ok)
+
+	users, err := c.GetByIDs([]string{"uid5", "uid6", "uid7", "uid8"})
+	assert.NoError(err)
+	assert.Len(users, 4, "Users are returned even if they are not cached")
+	assert.Len(c.entries, 3, "The cache never exceeds its size")
+
+	ts = ts.Add(2 * time.Minute)
+	_, err = c.GetByIDs([]string{"uid5", "uid6", "uid7"})
+	assert.NoError(err)
+	assert.Len(c.entries, 3)
+	for id := range c.entries {
+		assert.Contains([]string{"uid5", "uid6", "uid7"}, id, "Expired users are evicted when they are read")
+	}
+}
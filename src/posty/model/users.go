@@ -4,22 +4,63 @@ import "time"
 
 // UserPeer defines interactions with the user data.
 type UserPeer interface {
+	// GetByID returns the user identified by the id, ErrNotFound is returned if it does not exist.
 	GetByID(id string) (*User, error)
+	GetByIDs(ids []string) ([]*User, error)
+	GetPage(after string, limit int) ([]*User, string, error)
 	GetByOAuthID(id string) (*User, error)
 	UpdateLastLogin(id string) error
+	UpdateRoles(id string, roles []string) error
+	UpdateMutedNotifications(id string, kinds []string) error
+	RotateFeedVersion(id string) (int, error)
+	GetByHandles(handles []string) ([]*User, error)
 	NewUser() *User
+	// SaveNew saves a new user, ErrAlreadyExists is returned if a user with the id exists.
 	SaveNew(user *User) error
 }
 
 // User represents an user in the model.
 type User struct {
-	ID        string
-	OAuthID   string
-	Email     string
-	Username  string
-	Peer      UserPeer
-	CreatedAt time.Time
-	LastLogin time.Time
+	ID          string
+	OAuthID     string
+	Email       string
+	Username    string
+	Roles       []string // privileges of the user, e.g. RoleModerator
+	Muted       []string // kinds of notifications the user does not want to receive, see NotificationKinds
+	FeedVersion int      // part of the tokens of the feed urls of the user, rotating it revokes the issued urls
+	Peer        UserPeer
+	CreatedAt   time.Time
+	LastLogin   time.Time
+}
+
+// Roles of users.
+const (
+	// RoleModerator allows to handle reports of posts.
+	RoleModerator = "moderator"
+	// RoleAdmin allows to configure webhooks.
+	RoleAdmin = "admin"
+	// RoleBot marks users posting on behalf of incoming webhooks.
+	RoleBot = "bot"
+)
+
+// HasRole returns true if the user was granted the role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Notifies returns true if the user wants to receive notifications of the kind.
+func (u *User) Notifies(kind string) bool {
+	for _, k := range u.Muted {
+		if k == kind {
+			return false
+		}
+	}
+	return true
 }
 
 // SaveNew saves a new user to the model.
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAlreadyVoted is returned if an user votes twice in the same poll.
+var ErrAlreadyVoted = errors.New("User already voted in poll")
+
+// VotePeer defines interactions with the votes of polls.
+type VotePeer interface {
+	SaveVote(v *Vote) error
+	GetVotes(postID string) ([]*Vote, error)
+	// GetVotesByPosts returns the votes of the polls of the posts, keyed by post id.
+	GetVotesByPosts(postIDs []string) (map[string][]*Vote, error)
+}
+
+// Vote is the choice of an user in the poll of a post. Every user can vote only once per poll.
+type Vote struct {
+	PostID    string
+	UID       string
+	Option    int // index of the chosen option
+	CreatedAt time.Time
+}
+
+// Tally counts the votes for each of the n options of a poll, votes for unknown options are ignored.
+func Tally(votes []*Vote, n int) []int {
+	counts := make([]int, n)
+	for _, v := range votes {
+		if v.Option >= 0 && v.Option < n {
+			counts[v.Option]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTally(t *testing.T) {
+	assert := assert.New(t)
+	votes := []*Vote{{Option: 0}, {Option: 2}, {Option: 2}, {Option: 3}, {Option: -1}}
+	assert.Equal([]int{1, 0, 2}, Tally(votes, 3))
+	assert.Equal([]int{0, 0}, Tally(nil, 2))
+}
+
+func TestPollClosed(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	p := &Poll{}
+	assert.False(p.Closed(now))
+	p.ClosesAt = now.Add(time.Second)
+	assert.False(p.Closed(now))
+	p.ClosesAt = now
+	assert.True(p.Closed(now))
+}
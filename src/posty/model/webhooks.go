@@ -0,0 +1,114 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Events webhooks can subscribe to.
+const (
+	EventPostCreated = "post.created"
+	EventPostDeleted = "post.deleted"
+	EventUserCreated = "user.created"
+)
+
+// WebhookEvents lists all events webhooks can subscribe to.
+var WebhookEvents = []string{EventPostCreated, EventPostDeleted, EventUserCreated}
+
+// ValidWebhookEvent returns true if the event is a known webhook event.
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrDeliveryClaimed is returned if a delivery was claimed by another worker in the meantime.
+var ErrDeliveryClaimed = errors.New("Delivery already claimed")
+
+// WebhookPeer defines interactions with webhook subscriptions and their deliveries.
+type WebhookPeer interface {
+	NewWebhook(url, secret string, events []string) *Webhook
+	SaveNew(h *Webhook) error
+	GetByID(id string) (*Webhook, error)
+	GetAll() ([]*Webhook, error)
+	Remove(h *Webhook) error
+	NewDelivery(h *Webhook, event string, payload []byte) *Delivery
+	// SaveDelivery inserts the new delivery, existing deliveries are not replaced.
+	SaveDelivery(d *Delivery) error
+	GetDelivery(id string) (*Delivery, error)
+	// GetDeliveries returns up to limit deliveries of the webhook, newest first.
+	GetDeliveries(webhookID string, limit int) ([]*Delivery, error)
+	// GetDueDeliveries returns the pending deliveries whose next attempt is due at the given time.
+	GetDueDeliveries(now time.Time) ([]*Delivery, error)
+	// ClaimDelivery postpones the next attempt of the pending delivery until the given time,
+	// ErrDeliveryClaimed is returned if the next attempt changed since the delivery was fetched.
+	ClaimDelivery(d *Delivery, until time.Time) error
+	// UpdateDelivery stores the outcome of an attempt of the delivery claimed until the given time,
+	// ErrDeliveryClaimed is returned if the claim was lost in the meantime.
+	UpdateDelivery(d *Delivery, claimedUntil time.Time) error
+}
+
+// Webhook subscribes an url to events. Deliveries are signed using the secret.
+type Webhook struct {
+	ID        string
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedBy string // admin who created the webhook
+	CreatedAt time.Time
+}
+
+// Subscribes returns true if the webhook subscribed to the event.
+func (h *Webhook) Subscribes(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Status of deliveries.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is a single event sent to a webhook. Failed attempts are retried until the delivery succeeds or is given up.
+type Delivery struct {
+	ID            string
+	WebhookID     string
+	Event         string
+	Payload       []byte
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time // only set while the delivery is pending
+	LastAttemptAt time.Time
+	ResponseCode  int    // http status code of the last attempt, 0 if no response was received
+	Error         string // why the last attempt failed
+	CreatedAt     time.Time
+}
+
+// IncomingWebhookPeer defines interactions with the incoming webhooks external systems post to the wall with.
+type IncomingWebhookPeer interface {
+	NewIncomingWebhook(name, botID, tokenHash string) *IncomingWebhook
+	SaveNew(h *IncomingWebhook) error
+	GetByID(id string) (*IncomingWebhook, error)
+	GetAll() ([]*IncomingWebhook, error)
+	Remove(h *IncomingWebhook) error
+}
+
+// IncomingWebhook lets an external system post to the wall as a bot user.
+// Requests are authenticated by a secret token, only its hash is stored.
+type IncomingWebhook struct {
+	ID        string
+	Name      string
+	BotID     string // user the posts are attributed to
+	TokenHash string // hex encoded SHA-256 of the token
+	CreatedBy string // admin who created the webhook
+	CreatedAt time.Time
+}
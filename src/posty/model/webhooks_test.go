@@ -0,0 +1,16 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSubscribes(t *testing.T) {
+	assert := assert.New(t)
+	h := &Webhook{Events: []string{EventPostCreated}}
+	assert.True(h.Subscribes(EventPostCreated))
+	assert.False(h.Subscribes(EventPostDeleted))
+	assert.True(ValidWebhookEvent(EventUserCreated))
+	assert.False(ValidWebhookEvent("post.updated"))
+}
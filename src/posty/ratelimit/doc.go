@@ -0,0 +1,6 @@
+// Package ratelimit limits the rate of events per key using token buckets.
+//
+// A bucket holds up to Limit.Burst tokens and is refilled continuously by Burst tokens per Limit.Period.
+// Every event takes a token, events are rejected while the bucket is empty.
+// Buckets are kept by a Store, MemoryStore for single instances and DynamoStore for deployments with multiple instances.
+package ratelimit
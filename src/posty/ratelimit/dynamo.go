@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// dynamoRetries is the number of attempts to update a bucket which is modified concurrently.
+const dynamoRetries = 5
+
+// ErrConflict is returned if a bucket could not be updated because of concurrent updates.
+var ErrConflict = errors.New("ratelimit: concurrent update of bucket")
+
+// DynamoStore keeps the buckets in a dynamodb table shared by all instances.
+//
+// The table has the hash key `key` (S). Buckets are updated optimistically using the `version` attribute.
+// `expires_at` holds the unix time a bucket is full again and can be used as TTL attribute of the table to remove unused buckets.
+type DynamoStore struct {
+	db    dynamodbiface.DynamoDBAPI
+	table string
+	now   func() time.Time
+}
+
+// NewDynamoStoreFromSession creates a store using the table.
+func NewDynamoStoreFromSession(sess *session.Session, table string) *DynamoStore {
+	return NewDynamoStore(dynamodb.New(sess), table)
+}
+
+// NewDynamoStore creates a store using the client and table.
+func NewDynamoStore(db dynamodbiface.DynamoDBAPI, table string) *DynamoStore {
+	return &DynamoStore{
+		db:    db,
+		table: table,
+		now:   time.Now,
+	}
+}
+
+// Take takes a token of the bucket identified by key, see Store.
+func (s *DynamoStore) Take(key string, l Limit) (bool, time.Duration, error) {
+	if !l.Enabled() {
+		return true, 0, nil
+	}
+	for i := 0; i < dynamoRetries; i++ {
+		ok, retry, err := s.take(key, l)
+		if err == ErrConflict {
+			continue
+		}
+		return ok, retry, err
+	}
+	return false, 0, ErrConflict
+}
+
+func (s *DynamoStore) take(key string, l Limit) (bool, time.Duration, error) {
+	resp, err := s.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	var b bucket
+	var version int64
+	if resp.Item != nil {
+		if b, version, err = unmarshalBucket(resp.Item); err != nil {
+			return false, 0, err
+		}
+	}
+	now := s.now()
+	ok, retry := b.take(l, now)
+	item := map[string]*dynamodb.AttributeValue{
+		"key":        {S: aws.String(key)},
+		"tokens":     {N: aws.String(strconv.FormatFloat(b.tokens, 'f', -1, 64))},
+		"updated_at": {N: aws.String(strconv.FormatInt(b.updated.UnixNano(), 10))},
+		"expires_at": {N: aws.String(strconv.FormatInt(b.full(l).Unix()+1, 10))},
+		"version":    {N: aws.String(strconv.FormatInt(version+1, 10))},
+	}
+	params := &dynamodb.PutItemInput{
+		TableName:           aws.String(s.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#k)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#k": aws.String("key"),
+		},
+	}
+	if resp.Item != nil {
+		params.ConditionExpression = aws.String("version = :version")
+		params.ExpressionAttributeNames = nil
+		params.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": {N: aws.String(strconv.FormatInt(version, 10))},
+		}
+	}
+	if _, err := s.db.PutItem(params); err != nil {
+		if aerr, isAWS := err.(awserr.Error); isAWS && aerr.Code() == "ConditionalCheckFailedException" {
+			return false, 0, ErrConflict
+		}
+		return false, 0, err
+	}
+	return ok, retry, nil
+}
+
+// unmarshalBucket reads the bucket and its version from an item.
+func unmarshalBucket(item map[string]*dynamodb.AttributeValue) (bucket, int64, error) {
+	var b bucket
+	if item["tokens"] == nil || item["updated_at"] == nil || item["version"] == nil {
+		return b, 0, errors.New("ratelimit: invalid bucket item")
+	}
+	tokens, err := strconv.ParseFloat(aws.StringValue(item["tokens"].N), 64)
+	if err != nil {
+		return b, 0, err
+	}
+	updated, err := strconv.ParseInt(aws.StringValue(item["updated_at"].N), 10, 64)
+	if err != nil {
+		return b, 0, err
+	}
+	version, err := strconv.ParseInt(aws.StringValue(item["version"].N), 10, 64)
+	if err != nil {
+		return b, 0, err
+	}
+	b.tokens = tokens
+	b.updated = time.Unix(0, updated)
+	return b, version, nil
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockDynamo stores items by key in memory and evaluates the conditions used by DynamoStore, unused methods of the interface panic.
+type mockDynamo struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+	// beforePut is called before every put, it can modify the items to simulate concurrent updates
+	beforePut func()
+}
+
+func (m *mockDynamo) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[*in.Key["key"].S]}, nil
+}
+
+func (m *mockDynamo) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if m.beforePut != nil {
+		m.beforePut()
+	}
+	key := *in.Item["key"].S
+	old, exists := m.items[key]
+	failed := awserr.New("ConditionalCheckFailedException", "The conditional request failed", nil)
+	switch *in.ConditionExpression {
+	case "attribute_not_exists(#k)":
+		if exists {
+			return nil, failed
+		}
+	case "version = :version":
+		if !exists || *old["version"].N != *in.ExpressionAttributeValues[":version"].N {
+			return nil, failed
+		}
+	}
+	m.items[key] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestDynamoStore(t *testing.T) {
+	m := &mockDynamo{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	s := NewDynamoStore(m, "ratelimit")
+	testStore(t, s, func(now time.Time) {
+		s.now = func() time.Time { return now }
+	})
+	assert.Equal(t, "1448275728", *m.items["a"]["expires_at"].N, "Bucket must expire when it is full again")
+}
+
+func TestDynamoStoreConflict(t *testing.T) {
+	assert := assert.New(t)
+	m := &mockDynamo{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	s := NewDynamoStore(m, "ratelimit")
+	l := Limit{Burst: 10, Period: time.Minute}
+	s.Take("a", l)
+
+	// A concurrent update between read and write forces a retry
+	conflicts := 1
+	m.beforePut = func() {
+		if conflicts > 0 {
+			conflicts--
+			m.items["a"]["version"] = &dynamodb.AttributeValue{N: aws.String("100")}
+		}
+	}
+	ok, _, err := s.Take("a", l)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal("101", *m.items["a"]["version"].N)
+
+	version := 200
+	m.beforePut = func() {
+		version++
+		m.items["a"]["version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
+	}
+	_, _, err = s.Take("a", l)
+	assert.Equal(ErrConflict, err)
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// cleanupInterval is the minimum time between removals of full buckets.
+const cleanupInterval = time.Minute
+
+// MemoryStore keeps the buckets in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	cleaned time.Time
+	now     func() time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	limit Limit
+}
+
+// NewMemoryStore creates an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token of the bucket identified by key, see Store.
+func (s *MemoryStore) Take(key string, l Limit) (bool, time.Duration, error) {
+	if !l.Enabled() {
+		return true, 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.cleanup(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+	b.limit = l
+	ok, retry := b.take(l, now)
+	return ok, retry, nil
+}
+
+// cleanup removes buckets which are full again, they behave like new buckets.
+func (s *MemoryStore) cleanup(now time.Time) {
+	if now.Sub(s.cleaned) < cleanupInterval {
+		return
+	}
+	s.cleaned = now
+	for key, b := range s.buckets {
+		if !b.full(b.limit).After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Burst events per Period.
+type Limit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// ParseLimit parses limits of the form `10/1m`, i.e. 10 events per minute.
+// An empty string or `0` disables the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return Limit{}, errors.New("limit must be of the form events/period, e.g. 10/1m")
+	}
+	burst, err := strconv.Atoi(s[:i])
+	if err != nil || burst < 0 {
+		return Limit{}, errors.New("invalid number of events: " + s[:i])
+	}
+	period, err := time.ParseDuration(s[i+1:])
+	if err != nil || period <= 0 {
+		return Limit{}, errors.New("invalid period: " + s[i+1:])
+	}
+	return Limit{Burst: burst, Period: period}, nil
+}
+
+// Enabled returns false for the zero limit which allows all events.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Period > 0
+}
+
+// String formats the limit as parsed by ParseLimit.
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "0"
+	}
+	return strconv.Itoa(l.Burst) + "/" + l.Period.String()
+}
+
+// Store keeps the token buckets.
+type Store interface {
+	// Take takes a token of the bucket identified by key.
+	// If the bucket is empty it returns false and the time until the next token is available.
+	Take(key string, l Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// bucket is the state of a token bucket at a point in time.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket until now and takes a token if one is available.
+// A new bucket (zero updated time) starts full.
+func (b *bucket) take(l Limit, now time.Time) (bool, time.Duration) {
+	rate := float64(l.Burst) / float64(l.Period)
+	if b.updated.IsZero() {
+		b.tokens = float64(l.Burst)
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += float64(elapsed) * rate
+	}
+	if b.tokens > float64(l.Burst) {
+		b.tokens = float64(l.Burst)
+	}
+	if now.After(b.updated) {
+		b.updated = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, roundMillis((1 - b.tokens) / rate)
+}
+
+// roundMillis converts nanoseconds to a duration rounded to milliseconds, hiding floating point errors.
+func roundMillis(ns float64) time.Duration {
+	return time.Duration(ns/float64(time.Millisecond)+0.5) * time.Millisecond
+}
+
+// full returns the time the bucket is completely refilled, it can be forgotten afterwards.
+func (b *bucket) full(l Limit) time.Time {
+	rate := float64(l.Burst) / float64(l.Period)
+	return b.updated.Add(roundMillis((float64(l.Burst) - b.tokens) / rate))
+}
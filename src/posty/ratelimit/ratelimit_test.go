@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLimit(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		in    string
+		limit Limit
+		err   bool
+	}{
+		{"10/1m", Limit{Burst: 10, Period: time.Minute}, false},
+		{" 3/10s ", Limit{Burst: 3, Period: 10 * time.Second}, false},
+		{"", Limit{}, false},
+		{"0", Limit{}, false},
+		{"10", Limit{}, true},
+		{"x/1m", Limit{}, true},
+		{"-1/1m", Limit{}, true},
+		{"10/0s", Limit{}, true},
+		{"10/minute", Limit{}, true},
+	}
+	for _, test := range tests {
+		l, err := ParseLimit(test.in)
+		if test.err {
+			assert.Error(err, test.in)
+			continue
+		}
+		assert.NoError(err, test.in)
+		assert.Equal(test.limit, l, test.in)
+	}
+	assert.Equal("10/1m0s", Limit{Burst: 10, Period: time.Minute}.String())
+	assert.Equal("0", Limit{}.String())
+}
+
+// testStore runs the same token bucket scenario against a store whose clock is controlled by the returned function.
+func testStore(t *testing.T, s Store, setNow func(time.Time)) {
+	assert := assert.New(t)
+	start := time.Unix(1448272067, 0)
+	l := Limit{Burst: 2, Period: time.Minute}
+	setNow(start)
+	for i := 0; i < 2; i++ {
+		ok, _, err := s.Take("a", l)
+		assert.NoError(err)
+		assert.True(ok, "Bucket must start full")
+	}
+	ok, retry, err := s.Take("a", l)
+	assert.NoError(err)
+	assert.False(ok, "Bucket must be empty")
+	assert.Equal(30*time.Second, retry)
+
+	ok, _, _ = s.Take("b", l)
+	assert.True(ok, "Buckets must be independent")
+
+	setNow(start.Add(20 * time.Second))
+	ok, retry, _ = s.Take("a", l)
+	assert.False(ok)
+	assert.Equal(10*time.Second, retry)
+
+	setNow(start.Add(30 * time.Second))
+	ok, _, _ = s.Take("a", l)
+	assert.True(ok, "Bucket must be refilled")
+	ok, _, _ = s.Take("a", l)
+	assert.False(ok)
+
+	setNow(start.Add(time.Hour))
+	for i := 0; i < 2; i++ {
+		ok, _, _ = s.Take("a", l)
+		assert.True(ok, "Bucket must not be refilled above the burst")
+	}
+	ok, _, _ = s.Take("a", l)
+	assert.False(ok)
+
+	ok, _, err = s.Take("a", Limit{})
+	assert.NoError(err)
+	assert.True(ok, "Disabled limits allow all events")
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	testStore(t, s, func(now time.Time) {
+		s.now = func() time.Time { return now }
+	})
+}
+
+func TestMemoryStoreCleanup(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMemoryStore()
+	now := time.Unix(1448272067, 0)
+	s.now = func() time.Time { return now }
+	l := Limit{Burst: 1, Period: time.Second}
+	s.Take("a", l)
+	s.Take("b", Limit{Burst: 1, Period: time.Hour})
+	assert.Len(s.buckets, 2)
+	now = now.Add(2 * cleanupInterval)
+	s.Take("c", l)
+	assert.Len(s.buckets, 2, "Full buckets must be removed")
+	_, ok := s.buckets["a"]
+	assert.False(ok)
+}
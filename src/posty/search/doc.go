@@ -0,0 +1,2 @@
+// Package search provides full-text search over posts using pluggable indexes.
+package search
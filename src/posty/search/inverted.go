@@ -0,0 +1,138 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// InvertedIndex is an in-process Index mapping terms to documents.
+// Query terms match all indexed terms they are a prefix of, so results are available while the user types.
+type InvertedIndex struct {
+	mu    sync.RWMutex
+	terms map[string]map[string]struct{}
+	docs  map[string][]string
+	// sorted contains all terms in order, nil if it needs to be rebuilt
+	sorted []string
+}
+
+// NewInvertedIndex creates a new empty index.
+func NewInvertedIndex() *InvertedIndex {
+	return &InvertedIndex{
+		terms: make(map[string]map[string]struct{}),
+		docs:  make(map[string][]string),
+	}
+}
+
+// Add indexes the text of the document identified by id.
+func (idx *InvertedIndex) Add(id string, text string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+	terms := unique(Tokenize(text))
+	for _, t := range terms {
+		docs, ok := idx.terms[t]
+		if !ok {
+			docs = make(map[string]struct{})
+			idx.terms[t] = docs
+			idx.sorted = nil
+		}
+		docs[id] = struct{}{}
+	}
+	idx.docs[id] = terms
+	return nil
+}
+
+// Remove removes the document identified by id.
+func (idx *InvertedIndex) Remove(id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+	return nil
+}
+
+func (idx *InvertedIndex) remove(id string) {
+	for _, t := range idx.docs[id] {
+		docs := idx.terms[t]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(idx.terms, t)
+			idx.sorted = nil
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Search returns the ids of all documents matching all terms of the query. The order of the result is not defined.
+// An empty query matches no documents.
+func (idx *InvertedIndex) Search(query string) ([]string, error) {
+	terms := unique(Tokenize(query))
+	if len(terms) == 0 {
+		return []string{}, nil
+	}
+	idx.mu.Lock()
+	if idx.sorted == nil {
+		idx.sorted = make([]string, 0, len(idx.terms))
+		for t := range idx.terms {
+			idx.sorted = append(idx.sorted, t)
+		}
+		sort.Strings(idx.sorted)
+	}
+	idx.mu.Unlock()
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var result map[string]struct{}
+	for _, t := range terms {
+		matches := idx.prefixMatches(t)
+		if result == nil {
+			result = matches
+			continue
+		}
+		for id := range result {
+			if _, ok := matches[id]; !ok {
+				delete(result, id)
+			}
+		}
+	}
+	ids := make([]string, 0, len(result))
+	for id := range result {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// prefixMatches returns all documents containing a term starting with prefix.
+func (idx *InvertedIndex) prefixMatches(prefix string) map[string]struct{} {
+	matches := make(map[string]struct{})
+	sorted := idx.sorted
+	if sorted == nil {
+		// Index changed in between, fall back to a full scan
+		for t, docs := range idx.terms {
+			if strings.HasPrefix(t, prefix) {
+				for id := range docs {
+					matches[id] = struct{}{}
+				}
+			}
+		}
+		return matches
+	}
+	for i := sort.SearchStrings(sorted, prefix); i < len(sorted) && strings.HasPrefix(sorted[i], prefix); i++ {
+		for id := range idx.terms[sorted[i]] {
+			matches[id] = struct{}{}
+		}
+	}
+	return matches
+}
+
+func unique(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	u := terms[:0]
+	for _, t := range terms {
+		if !seen[t] {
+			seen[t] = true
+			u = append(u, t)
+		}
+	}
+	return u
+}
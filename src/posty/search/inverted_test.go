@@ -0,0 +1,69 @@
+package search
+
+import (
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func search(t *testing.T, idx Index, q string) []string {
+	ids, err := idx.Search(q)
+	if err != nil {
+		t.Fatalf("Error searching %q: %s", q, err)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"hello", "wörld", "42", "go"}, Tokenize("Hello, Wörld! 42 #go"))
+	assert.Len(Tokenize(" .,;"), 0)
+}
+
+func TestInvertedIndex(t *testing.T) {
+	assert := assert.New(t)
+	idx := NewInvertedIndex()
+	idx.Add("p1", "The quick brown fox")
+	idx.Add("p2", "The lazy dog")
+	idx.Add("p3", "Quick, quick dog!")
+
+	assert.Equal([]string{"p1", "p2"}, search(t, idx, "the"))
+	assert.Equal([]string{"p1", "p3"}, search(t, idx, "QUICK"))
+	assert.Equal([]string{"p3"}, search(t, idx, "quick dog"))
+	assert.Equal([]string{"p2", "p3"}, search(t, idx, "do"), "Prefix match")
+	assert.Equal([]string{}, search(t, idx, "cat"))
+	assert.Equal([]string{}, search(t, idx, ""))
+
+	// Replace
+	idx.Add("p2", "A lazy cat")
+	assert.Equal([]string{"p3"}, search(t, idx, "dog"))
+	assert.Equal([]string{"p2"}, search(t, idx, "cat"))
+
+	// Remove
+	idx.Remove("p3")
+	assert.Equal([]string{"p1"}, search(t, idx, "quick"))
+	assert.Equal([]string{}, search(t, idx, "dog"))
+	idx.Remove("unknown")
+}
+
+func TestInvertedIndexConcurrent(t *testing.T) {
+	idx := NewInvertedIndex()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := string(rune('a' + i))
+			for j := 0; j < 100; j++ {
+				idx.Add(id, "concurrent message")
+				idx.Search("conc")
+				idx.Remove(id)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, search(t, idx, "concurrent"), 0)
+}
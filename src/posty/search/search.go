@@ -0,0 +1,24 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Index defines a full-text index of documents identified by an id.
+// Implementations must be safe for concurrent use.
+type Index interface {
+	// Add indexes the text of the document identified by id, replacing previously indexed text.
+	Add(id string, text string) error
+	// Remove removes the document identified by id from the index.
+	Remove(id string) error
+	// Search returns the ids of all documents matching every term of the query.
+	Search(query string) ([]string, error)
+}
+
+// Tokenize splits text into lowercase terms consisting of letters and digits.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -0,0 +1,87 @@
+// Package ssrf guards outgoing requests to user supplied urls against server-side request forgery.
+//
+// Dialers only connect to allowed addresses, by default public unicast addresses. The address is checked right before
+// connecting, after the host name was resolved, so redirects and DNS rebinding can not be used to reach internal services.
+package ssrf
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned if a host resolves to an address which is not allowed.
+var ErrBlockedAddress = errors.New("Address not allowed")
+
+// blockedNets lists the address ranges which are not public.
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",       // current network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade nat
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // ietf protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"64:ff9b::/96",    // ipv4 translation
+	"100::/64",        // discard
+	"2001::/32",       // teredo, tunnels to an embedded ipv4 address
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, tunnels to an embedded ipv4 address
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link-local
+	"ff00::/8",        // multicast
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// PublicIP returns true if the address is a public unicast address.
+// IPv4-mapped IPv6 addresses are checked as IPv4 addresses.
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Dialer returns a dialer which only connects to allowed addresses. Every address is checked right before
+// the connection is made, so the check applies to the address actually dialed and not to an earlier resolution of the host.
+func Dialer(allow func(net.IP) bool, timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,42 @@
+package ssrf
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicIP(t *testing.T) {
+	assert := assert.New(t)
+	for _, ip := range []string{"8.8.8.8", "1.1.1.1", "2a00:1450:4001:80b::200e"} {
+		assert.True(PublicIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "172.31.255.255", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "224.0.0.1", "::1", "::", "fd00::1", "fe80::1", "::ffff:127.0.0.1", "::ffff:10.0.0.1", "2002:7f00:1::", "2002:a9fe:a9fe::1", "2001:0:4136:e378:8000:63bf:80ff:fffe"} {
+		assert.False(PublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestDialer(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+
+	_, err := Dialer(PublicIP, time.Second).Dial("tcp", addr)
+	if assert.Error(err) {
+		assert.Contains(err.Error(), ErrBlockedAddress.Error(), "Loopback must be blocked")
+	}
+	var checked []string
+	conn, err := Dialer(func(ip net.IP) bool {
+		checked = append(checked, ip.String())
+		return ip.IsLoopback()
+	}, time.Second).Dial("tcp", addr)
+	if assert.NoError(err) {
+		conn.Close()
+	}
+	assert.Equal([]string{"127.0.0.1"}, checked, "The dialed address is checked")
+}
@@ -0,0 +1,2 @@
+// Package tagging parses hashtags and mentions from post messages and computes trending tags.
+package tagging
@@ -0,0 +1,72 @@
+package tagging
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Parse extracts all hashtags (`#tag`) and mentions (`@handle`) from a message.
+// Tags and handles are lowercased and returned without the prefix in order of their first appearance.
+// A prefix only starts a tag or mention at the beginning of the message or after a character which is not part of a word,
+// so email addresses and anchors like `a#b` are not matched.
+func Parse(msg string) (tags []string, mentions []string) {
+	runes := []rune(msg)
+	seenTags := make(map[string]bool)
+	seenMentions := make(map[string]bool)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '#' && r != '@' {
+			continue
+		}
+		if i > 0 && isWordRune(runes[i-1]) {
+			continue
+		}
+		j := i + 1
+		for j < len(runes) && (isWordRune(runes[j]) || (r == '@' && isHandleSeparator(runes[j]))) {
+			j++
+		}
+		word := string(runes[i+1 : j])
+		if r == '@' {
+			word = strings.TrimRight(word, ".-")
+		}
+		word = strings.ToLower(word)
+		i = j - 1
+		if word == "" {
+			continue
+		}
+		if r == '#' && !seenTags[word] {
+			seenTags[word] = true
+			tags = append(tags, word)
+		}
+		if r == '@' && !seenMentions[word] {
+			seenMentions[word] = true
+			mentions = append(mentions, word)
+		}
+	}
+	return tags, mentions
+}
+
+// Handle derives the handle used to mention an user from the username.
+// All characters not allowed in mentions are dropped, e.g. `Benedikt Lang` is mentioned as `@benediktlang`.
+func Handle(username string) string {
+	var handle []rune
+	for _, r := range strings.ToLower(username) {
+		if isWordRune(r) || isHandleSeparator(r) {
+			handle = append(handle, r)
+		}
+	}
+	return strings.Trim(string(handle), ".-")
+}
+
+// NormalizeTag normalizes a tag given by the user, e.g. as url parameter, to the stored format.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+func isHandleSeparator(r rune) bool {
+	return r == '.' || r == '-'
+}
@@ -0,0 +1,37 @@
+package tagging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		msg      string
+		tags     []string
+		mentions []string
+	}{
+		{"no tags here", nil, nil},
+		{"#Go is #fun, #go!", []string{"go", "fun"}, nil},
+		{"Hey @Benedikt.Lang. and @anna-m, see #release_2", []string{"release_2"}, []string{"benedikt.lang", "anna-m"}},
+		{"mail me@example.com or visit a#b", nil, nil},
+		{"(#tag) [@user]", []string{"tag"}, []string{"user"}},
+		{"# @ #! @.", nil, nil},
+		{"#über @jürgen", []string{"über"}, []string{"jürgen"}},
+	}
+	for _, test := range tests {
+		tags, mentions := Parse(test.msg)
+		assert.Equal(test.tags, tags, test.msg)
+		assert.Equal(test.mentions, mentions, test.msg)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("benediktlang", Handle("Benedikt Lang"))
+	assert.Equal("anna-m", Handle("Anna-M."))
+	assert.Equal("jürgen_1", Handle("Jürgen_1!"))
+	assert.Equal("go", NormalizeTag("#Go"))
+}
@@ -0,0 +1,113 @@
+package tagging
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagCount represents how often a tag was used.
+type TagCount struct {
+	Tag   string
+	Count int
+}
+
+// Trending counts the usage of tags over a sliding window using buckets of a fixed resolution.
+// It is safe for concurrent use.
+type Trending struct {
+	Window     time.Duration
+	Resolution time.Duration
+
+	mu      sync.Mutex
+	buckets map[int64]map[string]int
+}
+
+// NewTrending creates a new counter over the given window with a resolution of one minute.
+func NewTrending(window time.Duration) *Trending {
+	return &Trending{
+		Window:     window,
+		Resolution: time.Minute,
+		buckets:    make(map[int64]map[string]int),
+	}
+}
+
+func (t *Trending) bucket(ts time.Time) int64 {
+	return ts.UnixNano() / int64(t.Resolution)
+}
+
+// Record counts each of the tags used at the given time once.
+func (t *Trending) Record(tags []string, ts time.Time) {
+	if len(tags) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.bucket(ts)
+	counts, ok := t.buckets[b]
+	if !ok {
+		counts = make(map[string]int)
+		t.buckets[b] = counts
+	}
+	for _, tag := range tags {
+		counts[tag]++
+	}
+}
+
+// Forget reverts a previous Record, e.g. if the post was removed.
+func (t *Trending) Forget(tags []string, ts time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts, ok := t.buckets[t.bucket(ts)]
+	if !ok {
+		return
+	}
+	for _, tag := range tags {
+		if counts[tag] > 1 {
+			counts[tag]--
+		} else {
+			delete(counts, tag)
+		}
+	}
+}
+
+// Top returns up to n tags used most within the window ending at now, ordered by count and name.
+// Buckets outside the window are discarded.
+func (t *Trending) Top(n int, now time.Time) []TagCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	oldest := t.bucket(now.Add(-t.Window))
+	newest := t.bucket(now)
+	sums := make(map[string]int)
+	for b, counts := range t.buckets {
+		if b < oldest {
+			delete(t.buckets, b)
+			continue
+		}
+		if b > newest {
+			continue
+		}
+		for tag, c := range counts {
+			sums[tag] += c
+		}
+	}
+	top := make([]TagCount, 0, len(sums))
+	for tag, c := range sums {
+		top = append(top, TagCount{Tag: tag, Count: c})
+	}
+	sort.Sort(byCountDESC(top))
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+type byCountDESC []TagCount
+
+func (o byCountDESC) Len() int      { return len(o) }
+func (o byCountDESC) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+func (o byCountDESC) Less(i, j int) bool {
+	if o[i].Count != o[j].Count {
+		return o[i].Count > o[j].Count
+	}
+	return o[i].Tag < o[j].Tag
+}
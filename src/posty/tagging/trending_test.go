@@ -0,0 +1,30 @@
+package tagging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrending(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	tr := NewTrending(time.Hour)
+	tr.Record([]string{"old"}, now.Add(-2*time.Hour))
+	tr.Record([]string{"go", "fun"}, now.Add(-30*time.Minute))
+	tr.Record([]string{"go"}, now.Add(-time.Minute))
+	tr.Record([]string{"news"}, now)
+	tr.Record([]string{"news"}, now)
+	tr.Record([]string{"go"}, now)
+
+	assert.Equal([]TagCount{{"go", 3}, {"news", 2}, {"fun", 1}}, tr.Top(10, now))
+	assert.Equal([]TagCount{{"go", 3}}, tr.Top(1, now))
+
+	tr.Forget([]string{"news"}, now)
+	assert.Equal([]TagCount{{"go", 3}, {"fun", 1}, {"news", 1}}, tr.Top(10, now))
+
+	// Window slides
+	assert.Equal([]TagCount{{"go", 2}, {"news", 1}}, tr.Top(10, now.Add(50*time.Minute)))
+	assert.Len(tr.Top(10, now.Add(2*time.Hour)), 0)
+}
@@ -0,0 +1,97 @@
+// Package thumbnail creates downscaled previews of images.
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	// register decoders of supported image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+)
+
+// ErrTooLarge is returned if the image has more pixels than allowed.
+var ErrTooLarge = errors.New("Image too large")
+
+// Decode decodes a gif, jpeg or png image of at most maxPixels pixels.
+// The image size is checked before the image is decoded to protect against decompression bombs,
+// a decoded image needs up to 8 bytes per pixel.
+// The reader must support reading the data twice, e.g. a *bytes.Reader.
+func Decode(r io.ReadSeeker, maxPixels int) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, ErrTooLarge
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Scale downscales the image to fit into a square of size, keeping the aspect ratio.
+// Each pixel of the thumbnail is the average of the source pixels it covers. Smaller images are not upscaled.
+func Scale(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	if w == b.Dx() && h == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+		return dst
+	}
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			dst.SetNRGBA(x, y, average(img, x0, y0, max(x1, x0+1), max(y1, y0+1)))
+		}
+	}
+	return dst
+}
+
+// average returns the average color of the rectangle, colors are weighted by their alpha.
+func average(img image.Image, x0, y0, x1, y1 int) color.NRGBA {
+	var r, g, b, a, n uint64
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+			n++
+		}
+	}
+	if a == 0 {
+		return color.NRGBA{}
+	}
+	// premultiplied sums divided by the alpha sum yield the straight color
+	return color.NRGBA{
+		R: uint8(r * 0xff / a),
+		G: uint8(g * 0xff / a),
+		B: uint8(b * 0xff / a),
+		A: uint8(a / n >> 8),
+	}
+}
+
+// Encode writes the thumbnail as png.
+func Encode(w io.Writer, img image.Image) error {
+	return png.Encode(w, img)
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
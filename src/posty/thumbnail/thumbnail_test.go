@@ -0,0 +1,69 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScale(t *testing.T) {
+	assert := assert.New(t)
+	img := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			c := color.NRGBA{A: 0xff}
+			if x%2 == 0 {
+				c.R = 0xff
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	th := Scale(img, 100)
+	assert.Equal(image.Rect(0, 0, 100, 50), th.Bounds())
+	c := th.NRGBAAt(10, 10)
+	assert.InDelta(0x7f, int(c.R), 1, "Pixels must be averaged")
+	assert.Equal(uint8(0xff), c.A)
+
+	tall := Scale(image.NewNRGBA(image.Rect(0, 0, 10, 1000)), 100)
+	assert.Equal(image.Rect(0, 0, 1, 100), tall.Bounds())
+
+	small := Scale(image.NewNRGBA(image.Rect(5, 5, 25, 15)), 100)
+	assert.Equal(image.Rect(0, 0, 20, 10), small.Bounds(), "Small images must not be upscaled")
+}
+
+func TestScaleTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+	c := Scale(img, 1).NRGBAAt(0, 0)
+	assert.Equal(t, color.NRGBA{R: 0xff, A: 0x0f}, c)
+}
+
+func TestDecode(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 3, 2)))
+	img, err := Decode(bytes.NewReader(buf.Bytes()), 6)
+	if assert.NoError(err) {
+		assert.Equal(image.Rect(0, 0, 3, 2), img.Bounds())
+	}
+
+	buf.Reset()
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	b := buf.Bytes()
+	// patch the IHDR dimensions to 10000x10000 without providing the pixel data
+	copy(b[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10})
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	_, err = Decode(bytes.NewReader(b), 50*1000*1000)
+	assert.Equal(ErrTooLarge, err)
+	_, err = Decode(bytes.NewReader(buf.Bytes()), 5)
+	assert.Equal(ErrTooLarge, err)
+
+	_, err = Decode(bytes.NewReader([]byte("no image")), 6)
+	assert.Error(err)
+}
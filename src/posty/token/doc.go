@@ -0,0 +1,6 @@
+// Package token signs the tokens of links which can not rely on the session, e.g. unsubscribe links of emails and
+// the urls of private feeds.
+//
+// A Signer is created for one purpose by NewSigner, tokens of other purposes are rejected even if they were signed
+// using the same key.
+package token
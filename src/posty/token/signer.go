@@ -0,0 +1,63 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned if a token was not signed by the signer or is malformed.
+var ErrInvalid = errors.New("Invalid token")
+
+// Signer creates and verifies tokens of a purpose.
+// A token contains its fields, e.g. the user and the kind of email, and is authenticated by a HMAC-SHA256 of the
+// purpose and the fields using the key.
+type Signer struct {
+	key     []byte
+	purpose string
+}
+
+// NewSigner creates a signer of tokens of the purpose using the key.
+func NewSigner(key []byte, purpose string) *Signer {
+	return &Signer{
+		key:     key,
+		purpose: purpose,
+	}
+}
+
+func (s *Signer) mac(payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(s.purpose + "\x00" + payload))
+	return h.Sum(nil)
+}
+
+// Token returns the token of the fields. Fields must not be empty or contain null bytes.
+func (s *Signer) Token(fields ...string) string {
+	payload := strings.Join(fields, "\x00")
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(s.mac(payload))
+}
+
+// Verify returns the fields of the token.
+func (s *Signer) Verify(token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, s.mac(string(payload))) {
+		return nil, ErrInvalid
+	}
+	fields := strings.Split(string(payload), "\x00")
+	for _, f := range fields {
+		if f == "" {
+			return nil, ErrInvalid
+		}
+	}
+	return fields, nil
+}
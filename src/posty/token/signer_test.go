@@ -0,0 +1,38 @@
+package token
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	assert := assert.New(t)
+	s := NewSigner([]byte("secret"), "feed")
+	token := s.Token("uid123", "posts")
+	assert.NotContains(token, "/")
+	fields, err := s.Verify(token)
+	assert.NoError(err)
+	assert.Equal([]string{"uid123", "posts"}, fields)
+	assert.Equal("dWlkMTIzAHBvc3Rz.", token[:17], "The format of tokens must not change, issued links must stay valid")
+
+	other := NewSigner([]byte("other"), "feed")
+	_, err = other.Verify(token)
+	assert.Equal(ErrInvalid, err, "Tokens of other keys must be rejected")
+	forged := s.Token("uid456", "posts")
+	parts := strings.Split(token, ".")
+	_, err = s.Verify(strings.Split(forged, ".")[0] + "." + parts[1])
+	assert.Equal(ErrInvalid, err, "Payloads must not be exchanged")
+	unsubscribe := NewSigner([]byte("secret"), "unsubscribe").Token("uid123", "posts")
+	_, err = s.Verify(unsubscribe)
+	assert.Equal(ErrInvalid, err, "Tokens of other purposes signed with the same key must be rejected")
+	for _, invalid := range []string{"", "abc", "a.b.c", "!!.!!", s.Token("uid123", ""), s.Token("")} {
+		_, err = s.Verify(invalid)
+		assert.Equal(ErrInvalid, err, invalid)
+	}
+
+	fields, err = s.Verify(s.Token("uid123", "posts", "2"))
+	assert.NoError(err)
+	assert.Equal([]string{"uid123", "posts", "2"}, fields)
+}
@@ -0,0 +1,4 @@
+// Package unfurl fetches link previews from OpenGraph and oEmbed metadata of web pages.
+//
+// The fetcher only connects to public addresses, it dials using the guard of package ssrf.
+package unfurl
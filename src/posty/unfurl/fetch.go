@@ -0,0 +1,348 @@
+package unfurl
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"posty/ssrf"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Preview describes a linked web page.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// Options configure a fetcher, zero values are replaced by the defaults.
+type Options struct {
+	// Timeout of a single fetch including redirects, default 5s
+	Timeout time.Duration
+	// MaxBytes limits the bytes read of a response, default 512KiB
+	MaxBytes int64
+	// MaxRedirects limits the number of redirects followed, default 3
+	MaxRedirects int
+	// CacheSize limits the number of cached previews, default 1000
+	CacheSize int
+	// CacheTTL is the time previews and failures are cached, default 1h
+	CacheTTL time.Duration
+	// Allow decides whether an address may be connected to, default ssrf.PublicIP
+	Allow func(net.IP) bool
+}
+
+// maxTextLen limits the runes of titles and descriptions.
+const maxTextLen = 300
+
+// ErrNoPreview is returned if a page does not contain any preview metadata.
+var ErrNoPreview = errors.New("No preview metadata")
+
+// Fetcher fetches previews of web pages. Results are cached, it is safe for concurrent use.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+	ttl      time.Duration
+	size     int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]*list.Element
+	lru   *list.List
+}
+
+type cacheEntry struct {
+	url     string
+	preview *Preview
+	err     error
+	expires time.Time
+}
+
+// NewFetcher creates a fetcher using the options.
+func NewFetcher(opts Options) *Fetcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 512 << 10
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = 3
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = 1000
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = time.Hour
+	}
+	if opts.Allow == nil {
+		opts.Allow = ssrf.PublicIP
+	}
+	dialer := ssrf.Dialer(opts.Allow, opts.Timeout)
+	maxRedirects := opts.MaxRedirects
+	return &Fetcher{
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   opts.Timeout,
+				ResponseHeaderTimeout: opts.Timeout,
+				DisableKeepAlives:     true,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return errors.New("Too many redirects")
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxBytes: opts.MaxBytes,
+		ttl:      opts.CacheTTL,
+		size:     opts.CacheSize,
+		now:      time.Now,
+		cache:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// checkURL only allows http and https urls without credentials.
+func checkURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return fmt.Errorf("Unsupported url %q", u.String())
+	}
+	return nil
+}
+
+// Cached returns the cached preview of the url if there is one.
+func (f *Fetcher) Cached(rawurl string) (*Preview, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	el, ok := f.cache[rawurl]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if f.now().After(e.expires) || e.err != nil {
+		return nil, false
+	}
+	f.lru.MoveToFront(el)
+	return e.preview, true
+}
+
+// Fetch returns the preview of the web page. Results and failures are cached.
+func (f *Fetcher) Fetch(rawurl string) (*Preview, error) {
+	f.mu.Lock()
+	if el, ok := f.cache[rawurl]; ok {
+		e := el.Value.(*cacheEntry)
+		if !f.now().After(e.expires) {
+			f.lru.MoveToFront(el)
+			f.mu.Unlock()
+			return e.preview, e.err
+		}
+		f.lru.Remove(el)
+		delete(f.cache, rawurl)
+	}
+	f.mu.Unlock()
+
+	p, err := f.fetch(rawurl)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if el, ok := f.cache[rawurl]; ok {
+		f.lru.Remove(el)
+	}
+	f.cache[rawurl] = f.lru.PushFront(&cacheEntry{url: rawurl, preview: p, err: err, expires: f.now().Add(f.ttl)})
+	for f.lru.Len() > f.size {
+		el := f.lru.Back()
+		f.lru.Remove(el)
+		delete(f.cache, el.Value.(*cacheEntry).url)
+	}
+	return p, err
+}
+
+// get requests the url and returns the body limited to maxBytes.
+func (f *Fetcher) get(u *url.URL, accept string) (*http.Response, io.Reader, error) {
+	if err := checkURL(u); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "posty-unfurl/1.0")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("Unexpected status %s", resp.Status)
+	}
+	return resp, io.LimitReader(resp.Body, f.maxBytes), nil
+}
+
+func (f *Fetcher) fetch(rawurl string) (*Preview, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := f.get(u, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != "text/html" && ct != "application/xhtml+xml" {
+		return nil, fmt.Errorf("Unsupported content type %q", ct)
+	}
+	m := parseMeta(body)
+	// resolve relative urls against the final url after redirects
+	base := resp.Request.URL
+	p := &Preview{
+		URL:         rawurl,
+		Title:       first(m.og["title"], m.title),
+		Description: first(m.og["description"], m.description),
+		ImageURL:    resolve(base, m.og["image"]),
+	}
+	if (p.Title == "" || p.ImageURL == "") && m.oembed != "" {
+		if o, err := f.oembed(base, m.oembed); err == nil {
+			p.Title = first(p.Title, o.Title)
+			if p.ImageURL == "" {
+				p.ImageURL = resolve(base, o.ThumbnailURL)
+			}
+		}
+	}
+	p.Title, p.Description = cleanText(p.Title), cleanText(p.Description)
+	if p.Title == "" && p.Description == "" && p.ImageURL == "" {
+		return nil, ErrNoPreview
+	}
+	return p, nil
+}
+
+type oembedResponse struct {
+	Title        string `json:"title"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// oembed fetches the oEmbed json discovered in the page.
+func (f *Fetcher) oembed(base *url.URL, href string) (*oembedResponse, error) {
+	u, err := base.Parse(href)
+	if err != nil {
+		return nil, err
+	}
+	resp, body, err := f.get(u, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var o oembedResponse
+	if err := json.NewDecoder(body).Decode(&o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+type pageMeta struct {
+	title       string
+	description string
+	og          map[string]string
+	oembed      string
+}
+
+// parseMeta extracts the title, meta description, OpenGraph properties and the oEmbed discovery link of a html document.
+// Parsing stops at the body.
+func parseMeta(r io.Reader) *pageMeta {
+	m := &pageMeta{og: make(map[string]string)}
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return m
+		case html.TextToken:
+			if inTitle && m.title == "" {
+				m.title = string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			attr := func(k string) string {
+				for _, a := range t.Attr {
+					if a.Key == k {
+						return a.Val
+					}
+				}
+				return ""
+			}
+			switch t.Data {
+			case "body":
+				return m
+			case "title":
+				inTitle = true
+			case "meta":
+				if prop := attr("property"); strings.HasPrefix(prop, "og:") {
+					if k := prop[3:]; m.og[k] == "" {
+						m.og[k] = attr("content")
+					}
+				} else if attr("name") == "description" && m.description == "" {
+					m.description = attr("content")
+				}
+			case "link":
+				if attr("rel") == "alternate" && attr("type") == "application/json+oembed" && m.oembed == "" {
+					m.oembed = attr("href")
+				}
+			}
+		}
+	}
+}
+
+// resolve resolves a possibly relative http or https url, other urls are dropped.
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || checkURL(u) != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// cleanText collapses whitespace, removes control characters and truncates the text.
+func cleanText(s string) string {
+	s = strings.Join(strings.FieldsFunc(s, unicode.IsSpace), " ")
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if utf8.RuneCountInString(s) > maxTextLen {
+		s = string([]rune(s)[:maxTextLen-1]) + "…"
+	}
+	return s
+}
+
+func first(vs ...string) string {
+	for _, v := range vs {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package unfurl
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var urlRe = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// FirstURL returns the first http or https url of the text, trailing punctuation is not part of the url.
+func FirstURL(text string) string {
+	u := urlRe.FindString(text)
+	return strings.TrimRight(u, ".,:;!?)'*_~")
+}
+
+type job struct {
+	id  string
+	url string
+}
+
+// Worker fetches previews in the background and passes them to the done function.
+// Failed fetches are logged and dropped.
+type Worker struct {
+	fetcher *Fetcher
+	done    func(id string, p *Preview)
+	jobs    chan job
+	wg      sync.WaitGroup
+}
+
+// NewWorker starts n goroutines fetching previews. At most queue jobs are buffered.
+func NewWorker(f *Fetcher, n, queue int, done func(id string, p *Preview)) *Worker {
+	w := &Worker{
+		fetcher: f,
+		done:    done,
+		jobs:    make(chan job, queue),
+	}
+	for i := 0; i < n; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+func (w *Worker) run() {
+	defer w.wg.Done()
+	for j := range w.jobs {
+		p, err := w.fetcher.Fetch(j.url)
+		if err != nil {
+			log.Infof("Could not fetch preview of %s: %s", j.url, err)
+			continue
+		}
+		w.done(j.id, p)
+	}
+}
+
+// Cached returns the cached preview of the url if there is one.
+func (w *Worker) Cached(url string) (*Preview, bool) {
+	return w.fetcher.Cached(url)
+}
+
+// Enqueue schedules fetching the preview of the url for the id.
+// It does not block, false is returned if the queue is full and the job was dropped.
+func (w *Worker) Enqueue(id, url string) bool {
+	select {
+	case w.jobs <- job{id: id, url: url}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting jobs and waits until all queued jobs are done.
+func (w *Worker) Close() {
+	close(w.jobs)
+	w.wg.Wait()
+}
@@ -0,0 +1,49 @@
+package unfurl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstURL(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("https://example.com/a?b=c", FirstURL("see https://example.com/a?b=c. and http://other.com"))
+	assert.Equal("http://example.com", FirstURL("(http://example.com)"))
+	assert.Equal("", FirstURL("no links, ftp://example.com"))
+}
+
+func TestWorker(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/empty" {
+			return
+		}
+		fmt.Fprint(w, `<title>`+r.URL.Path+`</title>`)
+	}))
+	defer ts.Close()
+	var mu sync.Mutex
+	results := make(map[string]string)
+	w := NewWorker(NewFetcher(Options{Allow: allowLoopback}), 2, 10, func(id string, p *Preview) {
+		mu.Lock()
+		results[id] = p.Title
+		mu.Unlock()
+	})
+	assert.True(w.Enqueue("1", ts.URL+"/one"))
+	assert.True(w.Enqueue("2", ts.URL+"/two"))
+	assert.True(w.Enqueue("3", ts.URL+"/empty"))
+	w.Close()
+	assert.Equal(map[string]string{"1": "/one", "2": "/two"}, results)
+	p, ok := w.Cached(ts.URL + "/one")
+	assert.True(ok)
+	assert.Equal("/one", p.Title)
+
+	full := NewWorker(nil, 0, 1, nil)
+	assert.True(full.Enqueue("1", "http://example.com"))
+	assert.False(full.Enqueue("2", "http://example.com"), "Jobs must be dropped if the queue is full")
+}
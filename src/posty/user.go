@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"posty/model"
+	"posty/model/awsdynamo"
+)
+
+// runUserAddRole runs the `user add-role` subcommand granting a role to an user, e.g. to set up the first admin.
+//
+//	posty user add-role <user-id> <role>
+func runUserAddRole(args []string) int {
+	fs := flag.NewFlagSet("user add-role", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !configLoaded() {
+		return 1
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: posty user add-role <user-id> <role>")
+		return 2
+	}
+	id, role := fs.Arg(0), fs.Arg(1)
+	if role != model.RoleModerator && role != model.RoleAdmin {
+		fmt.Fprintf(os.Stderr, "Unknown role '%s', must be %s or %s\n", role, model.RoleModerator, model.RoleAdmin)
+		return 2
+	}
+	peer := awsdynamo.NewModelFromSession(newAWSSession()).UserPeer()
+	u, err := peer.GetByID(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not get user %s: %s\n", id, err)
+		return 1
+	}
+	if u.HasRole(role) {
+		fmt.Fprintf(os.Stderr, "User %s (%s) already has the role %s\n", u.ID, u.Username, role)
+		return 0
+	}
+	if err := peer.UpdateRoles(u.ID, append(u.Roles, role)); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not update roles of user %s: %s\n", u.ID, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Granted role %s to user %s (%s)\n", role, u.ID, u.Username)
+	return 0
+}
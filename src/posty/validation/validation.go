@@ -0,0 +1,110 @@
+// Package validation normalises and validates text submitted by users.
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Rules defines the constraints of a text field.
+type Rules struct {
+	// MinLength is the minimum length in runes after normalisation
+	MinLength int
+	// MaxLength is the maximum length in runes after normalisation, 0 means unlimited
+	MaxLength int
+	// BannedWords are rejected if they appear as whole words, case-insensitively
+	BannedWords []string
+}
+
+// Error describes a violated rule. Code is one of `too_short`, `too_long` and `banned_word`.
+type Error struct {
+	Code   string
+	Title  string
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return e.Title + ": " + e.Detail
+}
+
+// Normalize converts the text to unicode normalization form C, unifies line breaks,
+// strips control characters except newlines and tabs as well as bidirectional formatting characters
+// and trims surrounding whitespace.
+func Normalize(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = norm.NFC.String(s)
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if r == utf8.RuneError || unicode.IsControl(r) || isBidiControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
+
+// isBidiControl returns true for the embedding, override and isolate characters which can be used to disguise text.
+func isBidiControl(r rune) bool {
+	return r >= '‪' && r <= '‮' || r >= '⁦' && r <= '⁩'
+}
+
+// Check normalises the value and validates it against the rules.
+// It returns the normalised value and all violated rules.
+func (r *Rules) Check(value string) (string, []*Error) {
+	value = Normalize(value)
+	var errs []*Error
+	n := utf8.RuneCountInString(value)
+	if n < r.MinLength {
+		errs = append(errs, &Error{
+			Code:   "too_short",
+			Title:  "Too short",
+			Detail: "Must be at least " + strconv.Itoa(r.MinLength) + " characters long",
+		})
+	}
+	if r.MaxLength > 0 && n > r.MaxLength {
+		errs = append(errs, &Error{
+			Code:   "too_long",
+			Title:  "Too long",
+			Detail: "Must not be longer than " + strconv.Itoa(r.MaxLength) + " characters",
+		})
+	}
+	if w := r.bannedWord(value); w != "" {
+		errs = append(errs, &Error{
+			Code:   "banned_word",
+			Title:  "Banned word",
+			Detail: "Must not contain '" + w + "'",
+		})
+	}
+	return value, errs
+}
+
+// bannedWord returns the first banned word contained in the text.
+// Words are compared in compatibility form, so lookalikes like fullwidth letters match too.
+func (r *Rules) bannedWord(text string) string {
+	if len(r.BannedWords) == 0 {
+		return ""
+	}
+	banned := make(map[string]string, len(r.BannedWords))
+	for _, w := range r.BannedWords {
+		banned[fold(w)] = w
+	}
+	words := strings.FieldsFunc(fold(text), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c) && !unicode.IsMark(c)
+	})
+	for _, w := range words {
+		if orig, ok := banned[w]; ok {
+			return orig
+		}
+	}
+	return ""
+}
+
+func fold(s string) string {
+	return strings.ToLower(norm.NFKC.String(s))
+}
@@ -0,0 +1,58 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	assert := assert.New(t)
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"  hello\r\nworld \t", "hello\nworld"},
+		{"é", "é"},
+		{"a\x00b\x1bc\u0085d\u007f", "abcd"},
+		{"user‮gnp.exe", "usergnp.exe"},
+		{"tab\tstays", "tab\tstays"},
+		{"\xffbroken", "broken"},
+		{"👩‍💻", "👩‍💻"},
+	}
+	for _, test := range tests {
+		assert.Equal(test.out, Normalize(test.in), "%q", test.in)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	assert := assert.New(t)
+	r := &Rules{MinLength: 3, MaxLength: 6, BannedWords: []string{"Spam"}}
+
+	v, errs := r.Check(" äöü ")
+	assert.Equal("äöü", v)
+	assert.Empty(errs, "Length must be counted in runes")
+
+	_, errs = r.Check("ab\x00\x00")
+	if assert.Len(errs, 1) {
+		assert.Equal("too_short", errs[0].Code)
+	}
+	_, errs = r.Check("abcdefg")
+	if assert.Len(errs, 1) {
+		assert.Equal("too_long", errs[0].Code)
+	}
+	for _, s := range []string{"SPAM", "a spam", "ｓｐａｍ!"} {
+		_, errs = r.Check(s)
+		if assert.Len(errs, 1, s) {
+			assert.Equal("banned_word", errs[0].Code)
+			assert.Equal("Must not contain 'Spam'", errs[0].Detail)
+		}
+	}
+	_, errs = r.Check("spams")
+	assert.Empty(errs, "Only whole words are banned")
+
+	_, errs = (&Rules{MinLength: 10, BannedWords: []string{"x"}}).Check("x")
+	assert.Len(errs, 2, "All violations must be returned")
+	_, errs = (&Rules{}).Check("unlimited")
+	assert.Empty(errs)
+}
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"posty/model"
+	"posty/ssrf"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Options configure a dispatcher, zero values are replaced by the defaults.
+type Options struct {
+	// Timeout of a single attempt, default 10s
+	Timeout time.Duration
+	// MaxAttempts after which a delivery is given up, default 8
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it doubles after every failed attempt, default 30s
+	Backoff time.Duration
+	// MaxBackoff limits the delay between attempts, default 1h
+	MaxBackoff time.Duration
+	// Allow decides whether an address may be connected to, default ssrf.PublicIP
+	Allow func(net.IP) bool
+}
+
+// Payload is the JSON body of delivery requests.
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// maxResponseBytes limits the bytes read of a response.
+const maxResponseBytes = 64 << 10
+
+// Dispatcher stores events as deliveries of the subscribed webhooks and sends them. It is safe for concurrent use.
+type Dispatcher struct {
+	peer        model.WebhookPeer
+	client      *http.Client
+	timeout     time.Duration
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+	wake        chan struct{}
+}
+
+// NewDispatcher creates a dispatcher storing deliveries using the peer.
+func NewDispatcher(peer model.WebhookPeer, opts Options) *Dispatcher {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	if opts.Allow == nil {
+		opts.Allow = ssrf.PublicIP
+	}
+	dialer := ssrf.Dialer(opts.Allow, opts.Timeout)
+	return &Dispatcher{
+		peer: peer,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			Transport: &http.Transport{
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   opts.Timeout,
+				ResponseHeaderTimeout: opts.Timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout:     opts.Timeout,
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.Backoff,
+		maxBackoff:  opts.MaxBackoff,
+		now:         time.Now,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Publish stores a delivery of the event for every webhook subscribed to it. They are sent by Run.
+// Data is the payload of the event, it must be serializable to JSON.
+func (d *Dispatcher) Publish(event string, data interface{}) error {
+	hooks, err := d.peer.GetAll()
+	if err != nil {
+		return err
+	}
+	var subscribed []*model.Webhook
+	for _, h := range hooks {
+		if h.Subscribes(event) {
+			subscribed = append(subscribed, h)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(&Payload{
+		Event:     event,
+		CreatedAt: d.now().Unix(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	var failed error
+	for _, h := range subscribed {
+		if err := d.peer.SaveDelivery(d.peer.NewDelivery(h, event, payload)); err != nil {
+			failed = fmt.Errorf("Could not save delivery to webhook %s: %s", h.ID, err)
+		}
+	}
+	d.notify()
+	return failed
+}
+
+// Redeliver stores a new delivery of the payload of a previous delivery to the webhook.
+func (d *Dispatcher) Redeliver(h *model.Webhook, previous *model.Delivery) (*model.Delivery, error) {
+	del := d.peer.NewDelivery(h, previous.Event, previous.Payload)
+	if err := d.peer.SaveDelivery(del); err != nil {
+		return nil, err
+	}
+	d.notify()
+	return del, nil
+}
+
+// notify wakes up Run without blocking.
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends due deliveries every interval and whenever deliveries were published. It never returns.
+func (d *Dispatcher) Run(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		if _, err := d.DeliverDue(); err != nil {
+			log.Warnf("Could not send webhook deliveries: %s", err)
+		}
+		select {
+		case <-tick.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue sends all deliveries which are due and returns the number of attempts.
+// Every delivery is claimed right before it is sent, so instances sharing the deliveries do not send it twice.
+// The outcome is only saved while the claim holds, a late outcome does not overwrite the claim of another instance.
+func (d *Dispatcher) DeliverDue() (int, error) {
+	due, err := d.peer.GetDueDeliveries(d.now())
+	if err != nil {
+		return 0, err
+	}
+	hooks := make(map[string]*model.Webhook)
+	attempts := 0
+	for _, del := range due {
+		// claim for twice the timeout of an attempt, crashed instances release the delivery when the claim runs out
+		claimedUntil := d.now().Add(2 * d.timeout)
+		err := d.peer.ClaimDelivery(del, claimedUntil)
+		if err == model.ErrDeliveryClaimed {
+			continue
+		}
+		if err != nil {
+			log.Warnf("Could not claim delivery %s: %s", del.ID, err)
+			continue
+		}
+		h, ok := hooks[del.WebhookID]
+		if !ok {
+			h, err = d.peer.GetByID(del.WebhookID)
+			if err != nil {
+				h = nil
+			}
+			hooks[del.WebhookID] = h
+		}
+		if h == nil {
+			del.Status = model.DeliveryFailed
+			del.Error = "Webhook removed"
+		} else {
+			d.attempt(h, del)
+			attempts++
+		}
+		err = d.peer.UpdateDelivery(del, claimedUntil)
+		if err == model.ErrDeliveryClaimed {
+			log.Warnf("Claim of delivery %s ran out before the attempt was saved", del.ID)
+		} else if err != nil {
+			log.Warnf("Could not save delivery %s: %s", del.ID, err)
+		}
+	}
+	return attempts, nil
+}
+
+// attempt sends the delivery to the webhook and updates the delivery with the outcome.
+// Failed deliveries are scheduled for another attempt until the maximum number of attempts is reached.
+func (d *Dispatcher) attempt(h *model.Webhook, del *model.Delivery) {
+	del.Attempts++
+	del.LastAttemptAt = d.now()
+	del.ResponseCode = 0
+	err := d.send(h, del)
+	if err == nil {
+		del.Status = model.DeliverySucceeded
+		del.Error = ""
+		return
+	}
+	del.Error = err.Error()
+	if del.Attempts >= d.maxAttempts {
+		del.Status = model.DeliveryFailed
+		log.Infof("Gave up delivery %s to webhook %s: %s", del.ID, h.ID, err)
+		return
+	}
+	del.NextAttemptAt = del.LastAttemptAt.Add(d.delay(del.Attempts))
+}
+
+// send posts the payload of the delivery to the url of the webhook, any status code but 2xx is an error.
+func (d *Dispatcher) send(h *model.Webhook, del *model.Delivery) error {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "posty-webhook")
+	req.Header.Set(EventHeader, del.Event)
+	req.Header.Set(DeliveryHeader, del.ID)
+	req.Header.Set(SignatureHeader, Sign(h.Secret, del.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	del.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Unexpected status code " + resp.Status)
+	}
+	return nil
+}
+
+// delay returns the backoff after the given number of failed attempts.
+func (d *Dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		return d.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"posty/model"
+	"posty/ssrf"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memPeer keeps webhooks and deliveries in memory.
+type memPeer struct {
+	hooks      map[string]*model.Webhook
+	deliveries []*model.Delivery
+	now        func() time.Time
+}
+
+func (m *memPeer) NewWebhook(url, secret string, events []string) *model.Webhook {
+	return &model.Webhook{ID: "wid" + strconv.Itoa(len(m.hooks)+1), URL: url, Secret: secret, Events: events}
+}
+
+func (m *memPeer) SaveNew(h *model.Webhook) error {
+	m.hooks[h.ID] = h
+	return nil
+}
+
+func (m *memPeer) GetByID(id string) (*model.Webhook, error) {
+	h, ok := m.hooks[id]
+	if !ok {
+		return nil, model.ErrDeliveryClaimed
+	}
+	return h, nil
+}
+
+func (m *memPeer) GetAll() ([]*model.Webhook, error) {
+	var res []*model.Webhook
+	for _, h := range m.hooks {
+		res = append(res, h)
+	}
+	return res, nil
+}
+
+func (m *memPeer) Remove(h *model.Webhook) error {
+	delete(m.hooks, h.ID)
+	return nil
+}
+
+func (m *memPeer) NewDelivery(h *model.Webhook, event string, payload []byte) *model.Delivery {
+	return &model.Delivery{
+		ID:            "did" + strconv.Itoa(len(m.deliveries)+1),
+		WebhookID:     h.ID,
+		Event:         event,
+		Payload:       payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: m.now(),
+		CreatedAt:     m.now(),
+	}
+}
+
+func (m *memPeer) SaveDelivery(d *model.Delivery) error {
+	for _, e := range m.deliveries {
+		if e.ID == d.ID {
+			return errors.New("Delivery exists")
+		}
+	}
+	c := *d
+	m.deliveries = append(m.deliveries, &c)
+	return nil
+}
+
+func (m *memPeer) UpdateDelivery(d *model.Delivery, claimedUntil time.Time) error {
+	for i, e := range m.deliveries {
+		if e.ID == d.ID {
+			if e.Status != model.DeliveryPending || !e.NextAttemptAt.Equal(claimedUntil) {
+				return model.ErrDeliveryClaimed
+			}
+			c := *d
+			m.deliveries[i] = &c
+			return nil
+		}
+	}
+	return model.ErrDeliveryClaimed
+}
+
+func (m *memPeer) GetDelivery(id string) (*model.Delivery, error) {
+	for _, d := range m.deliveries {
+		if d.ID == id {
+			c := *d
+			return &c, nil
+		}
+	}
+	return nil, model.ErrDeliveryClaimed
+}
+
+func (m *memPeer) GetDeliveries(webhookID string, limit int) ([]*model.Delivery, error) {
+	var res []*model.Delivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(res) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			c := *m.deliveries[i]
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (m *memPeer) GetDueDeliveries(now time.Time) ([]*model.Delivery, error) {
+	var res []*model.Delivery
+	for _, d := range m.deliveries {
+		if d.Status == model.DeliveryPending && !d.NextAttemptAt.After(now) {
+			c := *d
+			res = append(res, &c)
+		}
+	}
+	return res, nil
+}
+
+func (m *memPeer) ClaimDelivery(d *model.Delivery, until time.Time) error {
+	for _, e := range m.deliveries {
+		if e.ID == d.ID {
+			if e.Status != model.DeliveryPending || !e.NextAttemptAt.Equal(d.NextAttemptAt) {
+				return model.ErrDeliveryClaimed
+			}
+			e.NextAttemptAt = until
+			d.NextAttemptAt = until
+			return nil
+		}
+	}
+	return model.ErrDeliveryClaimed
+}
+
+func TestDispatcher(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	peer := &memPeer{hooks: make(map[string]*model.Webhook), now: func() time.Time { return now }}
+	status := http.StatusOK
+	var received []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	chat := peer.NewWebhook(srv.URL+"/chat", "chatsecret", []string{model.EventPostCreated})
+	peer.SaveNew(chat)
+	ci := peer.NewWebhook(srv.URL+"/ci", "cisecret", []string{model.EventUserCreated})
+	peer.SaveNew(ci)
+	d := NewDispatcher(peer, Options{MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: 90 * time.Second, Allow: allowLoopback})
+	d.now = func() time.Time { return now }
+
+	assert.NoError(d.Publish(model.EventPostCreated, map[string]string{"id": "pid1"}))
+	if !assert.Len(peer.deliveries, 1, "Only subscribed webhooks get deliveries") {
+		return
+	}
+	n, err := d.DeliverDue()
+	assert.NoError(err)
+	assert.Equal(1, n)
+	if assert.Len(received, 1) {
+		r := received[0]
+		assert.Equal("/chat", r.URL.Path)
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		assert.Equal(model.EventPostCreated, r.Header.Get(EventHeader))
+		assert.Equal("did1", r.Header.Get(DeliveryHeader))
+		assert.True(Verify("chatsecret", bodies[0], r.Header.Get(SignatureHeader)), "Deliveries are signed with the secret of the webhook")
+		var p Payload
+		assert.NoError(json.Unmarshal(bodies[0], &p))
+		assert.Equal(model.EventPostCreated, p.Event)
+		assert.Equal(now.Unix(), p.CreatedAt)
+		assert.Equal(map[string]interface{}{"id": "pid1"}, p.Data)
+	}
+	del := peer.deliveries[0]
+	assert.Equal(model.DeliverySucceeded, del.Status)
+	assert.Equal(1, del.Attempts)
+	assert.Equal(http.StatusOK, del.ResponseCode)
+	n, _ = d.DeliverDue()
+	assert.Equal(0, n, "Succeeded deliveries are not sent again")
+
+	// Failed attempts are retried with exponential backoff until they are given up
+	status = http.StatusServiceUnavailable
+	assert.NoError(d.Publish(model.EventUserCreated, map[string]string{"id": "uid1"}))
+	n, _ = d.DeliverDue()
+	assert.Equal(1, n)
+	del = peer.deliveries[1]
+	assert.Equal(model.DeliveryPending, del.Status)
+	assert.Equal(http.StatusServiceUnavailable, del.ResponseCode)
+	assert.Equal("Unexpected status code 503 Service Unavailable", del.Error)
+	assert.Equal(now.Add(time.Minute), del.NextAttemptAt)
+	n, _ = d.DeliverDue()
+	assert.Equal(0, n, "Retries wait for the backoff")
+	now = now.Add(time.Minute)
+	d.DeliverDue()
+	del = peer.deliveries[1]
+	assert.Equal(2, del.Attempts)
+	assert.Equal(now.Add(90*time.Second), del.NextAttemptAt, "Backoff is limited by MaxBackoff")
+	now = now.Add(90 * time.Second)
+	d.DeliverDue()
+	del = peer.deliveries[1]
+	assert.Equal(3, del.Attempts)
+	assert.Equal(model.DeliveryFailed, del.Status)
+	assert.Len(received, 4)
+
+	// Redeliveries send the same payload again
+	status = http.StatusNoContent
+	again, err := d.Redeliver(ci, del)
+	assert.NoError(err)
+	assert.NotEqual(del.ID, again.ID)
+	d.DeliverDue()
+	assert.Equal(model.DeliverySucceeded, peer.deliveries[2].Status)
+	assert.Equal(bodies[1], bodies[4])
+
+	// Deliveries of removed webhooks are given up
+	d.Publish(model.EventPostCreated, nil)
+	peer.Remove(chat)
+	n, _ = d.DeliverDue()
+	assert.Equal(0, n)
+	assert.Equal(model.DeliveryFailed, peer.deliveries[3].Status)
+	assert.Equal("Webhook removed", peer.deliveries[3].Error)
+}
+
+// racingPeer lets another instance claim the due deliveries right after they were fetched.
+type racingPeer struct {
+	*memPeer
+}
+
+func (m *racingPeer) GetDueDeliveries(now time.Time) ([]*model.Delivery, error) {
+	due, err := m.memPeer.GetDueDeliveries(now)
+	for _, d := range due {
+		c := *d
+		m.memPeer.ClaimDelivery(&c, now.Add(time.Minute))
+	}
+	return due, err
+}
+
+func TestDispatcherClaims(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	peer := &memPeer{hooks: make(map[string]*model.Webhook), now: func() time.Time { return now }}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	h := peer.NewWebhook(srv.URL, "secret", []string{model.EventPostDeleted})
+	peer.SaveNew(h)
+	d := NewDispatcher(&racingPeer{peer}, Options{Allow: allowLoopback})
+	d.now = func() time.Time { return now }
+	assert.NoError(d.Publish(model.EventPostDeleted, nil))
+	n, err := d.DeliverDue()
+	assert.NoError(err)
+	assert.Equal(0, n)
+	assert.Equal(0, calls, "Deliveries claimed by another instance are not sent")
+	assert.Equal(model.DeliveryPending, peer.deliveries[0].Status)
+}
+
+// claimingPeer records the claims of deliveries.
+type claimingPeer struct {
+	*memPeer
+	claims []time.Time
+}
+
+func (m *claimingPeer) ClaimDelivery(d *model.Delivery, until time.Time) error {
+	m.claims = append(m.claims, until)
+	return m.memPeer.ClaimDelivery(d, until)
+}
+
+func TestDispatcherClaimTimes(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	clock := func() time.Time { return now }
+	peer := &memPeer{hooks: make(map[string]*model.Webhook), now: clock}
+	var stolen bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// every attempt takes most of the timeout
+		now = now.Add(8 * time.Second)
+		if r.URL.Path == "/stolen" {
+			// the claim ran out and another instance claimed the delivery
+			for _, d := range peer.deliveries {
+				if d.WebhookID == r.URL.Query().Get("id") {
+					c := *d
+					stolen = peer.ClaimDelivery(&c, now.Add(time.Minute)) == nil
+				}
+			}
+		}
+	}))
+	defer srv.Close()
+	h := peer.NewWebhook(srv.URL, "secret", []string{model.EventPostDeleted})
+	peer.SaveNew(h)
+	cp := &claimingPeer{memPeer: peer}
+	d := NewDispatcher(cp, Options{Timeout: 10 * time.Second, Allow: allowLoopback})
+	d.now = clock
+	start := now
+	assert.NoError(d.Publish(model.EventPostDeleted, nil))
+	assert.NoError(d.Publish(model.EventPostDeleted, nil))
+	n, err := d.DeliverDue()
+	assert.NoError(err)
+	assert.Equal(2, n)
+	assert.Equal([]time.Time{start.Add(20 * time.Second), start.Add(28 * time.Second)}, cp.claims, "Claims start when the delivery is attempted")
+
+	late := peer.NewWebhook(srv.URL+"/stolen?id=wid2", "secret", []string{model.EventUserCreated})
+	peer.SaveNew(late)
+	assert.NoError(d.Publish(model.EventUserCreated, nil))
+	d.DeliverDue()
+	assert.True(stolen)
+	if del := peer.deliveries[2]; assert.Equal("wid2", del.WebhookID) {
+		assert.Equal(model.DeliveryPending, del.Status, "The outcome of an attempt must not overwrite the claim of another instance")
+		assert.Equal(0, del.Attempts)
+		assert.Equal(now.Add(time.Minute), del.NextAttemptAt)
+	}
+}
+
+// allowLoopback allows connections to the local test servers only.
+func allowLoopback(ip net.IP) bool {
+	return ip.IsLoopback()
+}
+
+func TestDispatcherBlocked(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1448272067, 0)
+	peer := &memPeer{hooks: make(map[string]*model.Webhook), now: func() time.Time { return now }}
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer srv.Close()
+	peer.SaveNew(peer.NewWebhook(srv.URL, "secret", []string{model.EventPostDeleted}))
+	d := NewDispatcher(peer, Options{})
+	d.now = func() time.Time { return now }
+	assert.NoError(d.Publish(model.EventPostDeleted, nil))
+	n, err := d.DeliverDue()
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal(0, calls, "Deliveries to internal addresses must be blocked by default")
+	assert.Contains(peer.deliveries[0].Error, ssrf.ErrBlockedAddress.Error())
+}
+
+func TestDispatcherDelay(t *testing.T) {
+	assert := assert.New(t)
+	d := NewDispatcher(&memPeer{}, Options{})
+	assert.Equal(30*time.Second, d.delay(1))
+	assert.Equal(time.Minute, d.delay(2))
+	assert.Equal(16*time.Minute, d.delay(6))
+	assert.Equal(time.Hour, d.delay(8))
+	assert.Equal(time.Hour, d.delay(100))
+}
@@ -0,0 +1,7 @@
+// Package webhook delivers events to the urls of webhook subscriptions.
+//
+// Events are stored as deliveries by the model.WebhookPeer before they are sent, so they survive restarts and are
+// shared by all instances. The Dispatcher sends due deliveries, failed attempts are retried with exponential backoff
+// until MaxAttempts is reached. Every request is signed with the secret of the webhook, receivers check the
+// signature using Verify. Like link previews, deliveries are only sent to public addresses.
+package webhook
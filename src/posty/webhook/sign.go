@@ -0,0 +1,35 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Headers of delivery requests.
+const (
+	// EventHeader names the event of the delivery, e.g. post.created.
+	EventHeader = "X-Posty-Event"
+	// DeliveryHeader holds the id of the delivery, it is the same for all attempts.
+	DeliveryHeader = "X-Posty-Delivery"
+	// SignatureHeader holds the signature of the body, see Sign.
+	SignatureHeader = "X-Posty-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// Sign returns the signature of the body using the secret: `sha256=` followed by the hex encoded HMAC-SHA256.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the signature is the signature of the body using the secret.
+func Verify(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, body)))
+}
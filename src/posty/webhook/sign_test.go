@@ -0,0 +1,18 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{"event":"post.created"}`)
+	sig := Sign("secret", body)
+	assert.Equal("sha256=5bfab6fc075cfd13eb347eb022171d1fd85adce64716a0568bf15f9feb55c258", sig)
+	assert.True(Verify("secret", body, sig))
+	assert.False(Verify("other", body, sig))
+	assert.False(Verify("secret", []byte(`{"event":"post.deleted"}`), sig))
+	assert.False(Verify("secret", body, sig[len("sha256="):]), "Signatures must be prefixed")
+}